@@ -36,6 +36,7 @@ import (
 	"github.com/pelican/wings/remote"
 	"github.com/pelican/wings/router"
 	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
 	"github.com/pelican/wings/sftp"
 	"github.com/pelican/wings/system"
 )
@@ -317,6 +318,17 @@ func rootCmdRun(cmd *cobra.Command, _ []string) {
 		}
 	}()
 
+	// Record any backups stored on this node from before they were tracked, so
+	// that they are included in the retention policy and storage limit. This
+	// happens before any backups can be started.
+	if err := backup.RecordExistingBackups(); err != nil {
+		log.WithField("error", err).Error("failed to record existing backups")
+	}
+
+	// Pick up any uploads of backups to S3 which were interrupted by Wings being
+	// stopped, now that the servers they belong to have been loaded.
+	manager.ResumeBackupUploads()
+
 	if s, err := cron.Scheduler(cmd.Context(), manager); err != nil {
 		log.WithField("error", err).Fatal("failed to initialize cron system")
 	} else {
@@ -155,6 +155,14 @@ type SystemConfiguration struct {
 	// should be created. This supports environments running docker-in-docker.
 	TmpDirectory string `default:"/tmp/pelican" json:"-" yaml:"tmp_directory"`
 
+	// Directory where thumbnails of images in server directories are cached, so
+	// that they do not count towards the disk space used by the server.
+	ThumbnailDirectory string `default:"/var/lib/pelican/thumbnails" json:"-" yaml:"thumbnail_directory"`
+
+	// The maximum size of the thumbnail cache in MiB, the least recently used thumbnails
+	// are removed once it grows beyond this. Set to 0 to not limit the size of the cache.
+	ThumbnailCacheSize int64 `default:"512" json:"-" yaml:"thumbnail_cache_size"`
+
 	// The user that should own all of the server files, and be used for containers.
 	Username string `default:"pelican" yaml:"username"`
 
@@ -213,6 +221,43 @@ type SystemConfiguration struct {
 	// disk usage is not a concern.
 	DiskCheckInterval int64 `default:"150" yaml:"disk_check_interval"`
 
+	// DiskUsageTracker configures how the disk usage of a server is kept up to date between
+	// the checks performed every DiskCheckInterval seconds. When quotas are enabled the usage
+	// is always read from the project quota accounting of the filesystem instead.
+	DiskUsageTracker struct {
+		// Mode controls how disk usage is tracked. "walk" walks the entire server directory
+		// whenever the disk check interval elapses. "inotify" walks the directory once and then
+		// watches every directory within it for changes, only re-reading those which change.
+		// Each directory uses an inotify watch, so fs.inotify.max_user_watches may need to be
+		// raised on nodes with a large number of files. If a watch cannot be added Wings will
+		// fall back to walking the directory for that server.
+		Mode string `default:"walk" yaml:"mode"`
+
+		// RescanInterval is the number of seconds between full walks of a server directory when
+		// using the "inotify" mode, this corrects any drift in the tracked usage. Set to 0 to
+		// disable the rescans.
+		RescanInterval int64 `default:"21600" yaml:"rescan_interval"`
+	} `yaml:"disk_usage_tracker"`
+
+	// DiskThresholds configures the warnings sent as a server approaches its disk space limit,
+	// and how long it may remain over the limit before it is stopped.
+	DiskThresholds struct {
+		// Warnings is the list of percentages of the disk space limit at which a server is warned
+		// through its console, the websocket and the activity log. Each warning is only sent once,
+		// until the usage of the server falls back below that percentage.
+		Warnings []int `default:"[80, 95]" yaml:"warnings"`
+
+		// GracePeriod is the number of seconds a server is allowed to remain over its disk space
+		// limit before it is stopped, writes are still allowed during this time so that the server
+		// is able to save its data. Set to 0 to stop servers as soon as they exceed the limit.
+		GracePeriod int64 `default:"0" yaml:"grace_period"`
+
+		// GraceLimit is the number of MiB a server may write beyond its disk space limit during
+		// the grace period, writes that would go over it are refused. Set to 0 to refuse every
+		// write over the limit while still allowing the server to run until the period ends.
+		GraceLimit int64 `default:"1024" yaml:"grace_limit"`
+	} `yaml:"disk_thresholds"`
+
 	// Quotas define is quota management is enabled on the Data directory
 	Quotas struct {
 		Enabled bool `json:"enabled" yaml:"enabled" default:"false"`
@@ -280,15 +325,113 @@ type Backups struct {
 	// Defaults to 0 (unlimited)
 	WriteLimit int `default:"0" yaml:"write_limit"`
 
+	// Compression is the algorithm used to compress local and S3 backups, either
+	// "gzip" or "zstd". Backups are restored using whichever algorithm they were
+	// created with, regardless of this setting.
+	//
+	// Defaults to "gzip"
+	Compression string `default:"gzip" yaml:"compression"`
+
 	// CompressionLevel determines how much backups created by wings should be compressed.
 	//
-	// "none" -> no compression will be applied
-	// "best_speed" -> uses gzip level 1 for fast speed
-	// "best_compression" -> uses gzip level 9 for minimal disk space useage
+	// "none" -> no compression will be applied, zstd uses its fastest level instead
+	// "best_speed" -> uses gzip level 1 or the fastest zstd level for fast speed
+	// "default" -> uses gzip level 6 or the default zstd level
+	// "best_compression" -> uses gzip level 9 or the best zstd level for minimal disk space useage
 	//
 	// Defaults to "best_speed" (level 1)
 	CompressionLevel string `default:"best_speed" yaml:"compression_level"`
 
+	// CompressionThreads is the number of threads used to compress a single
+	// archive.
+	//
+	// Defaults to 1
+	CompressionThreads int `default:"1" yaml:"compression_threads"`
+
+	// EncryptionKey is a base64 encoded 256-bit key used to encrypt local and S3
+	// backups with AES-256-GCM before they are written to the disk. The Panel may
+	// send a key for a specific server which is used instead. Backups created
+	// while this is empty are not encrypted, and can still be restored once it is
+	// set. Deduplicated backups cannot be created while a key is set, since
+	// their chunks are shared between backups and are not encrypted.
+	EncryptionKey string `yaml:"encryption_key"`
+
+	// ChunkStorage is where the chunks of deduplicated backups are stored,
+	// either "local" to store them in the backup directory, or "s3" to store
+	// them in the object storage configured on the Panel using URLs presigned
+	// by it. Chunks in object storage are only shared between the backups of
+	// the same server. The manifests of deduplicated backups are always stored
+	// on this node, and backups are restored from wherever their chunks were
+	// stored when they were created.
+	//
+	// Defaults to "local"
+	ChunkStorage string `default:"local" yaml:"chunk_storage"`
+
+	// S3Streaming uploads S3 backups while they are being created, rather than
+	// writing the entire backup to the disk before uploading it. Only the parts
+	// currently being filled and uploaded are kept, in memory or on the disk
+	// depending on S3StreamMemory.
+	//
+	// Defaults to false
+	S3Streaming bool `default:"false" yaml:"s3_streaming"`
+
+	// S3StreamMemory is the most memory, in MiB, used to hold the parts of a
+	// backup being streamed to S3. If the parts requested by the Panel are too
+	// large to fit they are held in the backup directory instead.
+	//
+	// Defaults to 256
+	S3StreamMemory int `default:"256" yaml:"s3_stream_memory"`
+
+	// S3UploadConcurrency is the number of parts of a backup which are uploaded
+	// to S3 at the same time. Uploads which are interrupted by Wings stopping are
+	// resumed from the last uploaded part once it starts again.
+	//
+	// Defaults to 4
+	S3UploadConcurrency int `default:"4" yaml:"s3_upload_concurrency"`
+
+	// VerifyInterval is how often, in hours, every backup stored on this machine
+	// is read in its entirety to verify that it is still intact. The result of
+	// each verification is reported to the Panel. S3 backups are only verified
+	// when requested by the Panel.
+	//
+	// Defaults to 0 (disabled)
+	VerifyInterval int `default:"0" yaml:"verify_interval"`
+
+	// MaxStorage is the most disk space, in MiB, that everything in the backup
+	// directory may use. Once it is exceeded the oldest backups stored on this
+	// node are removed until it is no longer exceeded. Backups of servers which
+	// no longer exist on this node count towards the limit but are only removed
+	// if RemoveOrphanedBackups is enabled. Backups which would be stored on this
+	// node are not created while the limit is reached.
+	//
+	// Defaults to 0 (unlimited)
+	MaxStorage int64 `default:"0" yaml:"max_storage"`
+
+	// Retention determines which backups stored on this node are kept for each
+	// server, any other backups are removed.
+	Retention BackupRetention `yaml:"retention"`
+
+	// RemoveOrphanedBackups removes backups stored on this node that belong to
+	// servers which no longer exist on it. When disabled they are only logged,
+	// since backups are intentionally kept on server deletion when
+	// RemoveBackupsOnServerDelete is disabled. A server must have been missing
+	// for several runs over at least an hour, and the Panel must confirm it
+	// was deleted, before its backups are removed.
+	//
+	// Defaults to false
+	RemoveOrphanedBackups bool `default:"false" yaml:"remove_orphaned_backups"`
+
+	// PruneInterval is how often, in minutes, backups stored on this node are
+	// checked against the retention policy, storage limit and for orphaned
+	// backups, and expired safety snapshots are removed. Nothing is checked if
+	// none of them are configured.
+	//
+	// Defaults to 15
+	PruneInterval int `default:"15" yaml:"prune_interval"`
+
+	// SafetySnapshots takes a snapshot of the files of a server before they are
+	// removed by a reinstall or by restoring a backup over them.
+	SafetySnapshots SafetySnapshots `yaml:"safety_snapshots"`
 
 	// RestoreHostAllowlist allows backup restore downloads to connect to otherwise blocked
 	// private/internal destinations. Entries may be hostnames, IP addresses, or CIDR ranges.
@@ -298,6 +441,49 @@ type Backups struct {
 	RemoveBackupsOnServerDelete bool `default:"true" yaml:"remove_backups_on_server_delete"`
 }
 
+// BackupRetention is the retention policy for backups stored on this node. A
+// backup is kept if any of the rules keep it, and if every rule is 0 all
+// backups are kept.
+type BackupRetention struct {
+	// KeepLast is the number of most recent backups of each server to keep.
+	KeepLast int `default:"0" yaml:"keep_last"`
+
+	// KeepDaily is the number of days to keep the most recent backup of, for
+	// the most recent days each server has backups on.
+	KeepDaily int `default:"0" yaml:"keep_daily"`
+
+	// KeepWeekly is the number of weeks to keep the most recent backup of, for
+	// the most recent weeks each server has backups in.
+	KeepWeekly int `default:"0" yaml:"keep_weekly"`
+}
+
+// SafetySnapshots configures the snapshots taken of the files of a server
+// before an operation that removes them, which can be restored if the
+// operation was a mistake.
+type SafetySnapshots struct {
+	// Enabled takes a snapshot before a server is reinstalled or a backup is
+	// restored with its files being removed first. The operation fails if the
+	// snapshot cannot be taken.
+	//
+	// Defaults to false
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// Adapter is how snapshots are stored, either "dedup" to store them as
+	// deduplicated backups which only store the files that changed since the
+	// last snapshot or backup, or "wings" to store them as local backups.
+	// Snapshots are always stored as local backups while EncryptionKey is set,
+	// since deduplicated backups cannot be encrypted.
+	//
+	// Defaults to "dedup"
+	Adapter string `default:"dedup" yaml:"adapter"`
+
+	// Retention is the number of hours a snapshot is kept before it is removed,
+	// which happens every PruneInterval. A value of 0 or less uses the default.
+	//
+	// Defaults to 24
+	Retention int `default:"24" yaml:"retention"`
+}
+
 type Transfers struct {
 	// DownloadLimit imposes a Network I/O read limit when downloading a transfer archive.
 	//
@@ -307,6 +493,13 @@ type Transfers struct {
 	// Defaults to 0 (unlimited)
 	DownloadLimit int `default:"0" yaml:"download_limit"`
 
+	// Compression is the algorithm used to compress the archive sent to the
+	// node a server is being transferred to, either "gzip" or "zstd". Only use
+	// "zstd" once every node is able to receive it.
+	//
+	// Defaults to "gzip"
+	Compression string `default:"gzip" yaml:"compression"`
+
 	// StoragePool configures whether this node participates in a shared storage pool.
 	StoragePool StoragePoolConfiguration `yaml:"storage_pool"`
 }
@@ -687,6 +880,11 @@ func ConfigureDirectories() error {
 		return err
 	}
 
+	log.WithField("path", _config.System.ThumbnailDirectory).Debug("ensuring thumbnail cache directory exists")
+	if err := os.MkdirAll(_config.System.ThumbnailDirectory, 0o700); err != nil {
+		return err
+	}
+
 	log.WithField("path", _config.System.User.Passwd.Directory).Debug("ensuring passwd directory exists")
 	if err := os.MkdirAll(_config.System.User.Passwd.Directory, 0o700); err != nil {
 		return err
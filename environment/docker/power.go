@@ -345,3 +345,20 @@ func (e *Environment) Terminate(ctx context.Context, signal string) error {
 		}
 	}
 }
+
+// Pause freezes every process in the container using the cgroup freezer, the
+// container keeps running and its state is not changed.
+func (e *Environment) Pause(ctx context.Context) error {
+	if err := e.client.ContainerPause(ctx, e.Id); err != nil {
+		return errors.Wrap(err, "environment/docker: failed to pause container")
+	}
+	return nil
+}
+
+// Unpause resumes every process in a container that was paused.
+func (e *Environment) Unpause(ctx context.Context) error {
+	if err := e.client.ContainerUnpause(ctx, e.Id); err != nil {
+		return errors.Wrap(err, "environment/docker: failed to unpause container")
+	}
+	return nil
+}
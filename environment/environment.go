@@ -73,6 +73,14 @@ type ProcessEnvironment interface {
 	// is a no-op if the server is already stopped.
 	Terminate(ctx context.Context, signal string) error
 
+	// Pause suspends every process of a running server instance without stopping
+	// it, until Unpause is called. The state of the server is not changed while
+	// it is paused.
+	Pause(ctx context.Context) error
+
+	// Unpause resumes the processes of a server instance suspended by Pause.
+	Unpause(ctx context.Context) error
+
 	// Destroys the environment removing any containers that were created (in Docker
 	// environments at least).
 	Destroy() error
@@ -55,6 +55,10 @@ type Limits struct {
 	// The amount of disk space in mebibytes that a server is allowed to use.
 	DiskSpace int64 `json:"disk_space"`
 
+	// The number of files and directories that a server is allowed to have, a
+	// value of 0 means there is no limit.
+	InodeLimit int64 `json:"inode_limit"`
+
 	// Sets which CPU threads can be used by the docker instance.
 	Threads string `json:"threads"`
 
@@ -0,0 +1,213 @@
+// Package acl implements the file permissions granted to a user by the Panel,
+// which may be limited to specific paths within a server. The same policy is
+// enforced for SFTP connections, HTTP file requests and signed tokens.
+package acl
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"emperror.dev/errors"
+)
+
+const (
+	FileRead        = "file.read"
+	FileReadContent = "file.read-content"
+	FileCreate      = "file.create"
+	FileUpdate      = "file.update"
+	FileDelete      = "file.delete"
+
+	// All is granted to users who may do anything, such as the server owner
+	// and administrators.
+	All = "*"
+)
+
+// ErrPermissionDenied is returned when a policy does not allow an action.
+var ErrPermissionDenied = errors.Sentinel("acl: permission denied")
+
+// Grant gives a set of permissions on every path matching Path, along with
+// everything beneath those paths. Path is matched one segment at a time using
+// the same syntax as path.Match, with the addition of a "**" segment that
+// matches any number of segments. For example "/" grants access to the entire
+// server, and "/plugins/**" to the plugins directory and all of its contents.
+type Grant struct {
+	Path        string   `json:"path"`
+	Permissions []string `json:"permissions"`
+}
+
+// ParseGrants parses a JSON encoded list of grants, returning an error if any
+// of the paths are not a valid pattern.
+func ParseGrants(data string) ([]Grant, error) {
+	var grants []Grant
+	if err := json.Unmarshal([]byte(data), &grants); err != nil {
+		return nil, errors.Wrap(err, "acl: failed to parse grants")
+	}
+	for _, g := range grants {
+		for _, s := range split(g.Path) {
+			if _, err := path.Match(s, ""); err != nil {
+				return nil, errors.Wrapf(err, "acl: invalid grant path %q", g.Path)
+			}
+		}
+	}
+	return grants, nil
+}
+
+type compiledGrant struct {
+	pattern     []string
+	permissions []string
+}
+
+// Policy determines which file permissions a user has for a given path.
+type Policy struct {
+	permissions []string
+	grants      []compiledGrant
+}
+
+// New returns a policy for a user with the given flat permissions and path
+// scoped grants. When there are no grants the flat permissions apply to every
+// path on the server, otherwise only the grants are used for file access.
+func New(permissions []string, grants []Grant) *Policy {
+	p := &Policy{permissions: permissions}
+	for _, g := range grants {
+		p.grants = append(p.grants, compiledGrant{pattern: split(g.Path), permissions: g.Permissions})
+	}
+	return p
+}
+
+// Scoped returns true if the policy is limited to specific paths.
+func (p *Policy) Scoped() bool {
+	return p != nil && len(p.grants) > 0
+}
+
+// Can returns true if the policy allows the permission on the given path. A
+// nil policy allows nothing.
+func (p *Policy) Can(permission string, name string) bool {
+	if p == nil {
+		return false
+	}
+	if len(p.grants) == 0 {
+		return has(p.permissions, permission)
+	}
+	segments := split(name)
+	for _, g := range p.grants {
+		if has(g.permissions, permission) && covers(g.pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visible returns true if the path can be read, or if it is a directory that
+// leads to a path that a grant applies to. This allows users to navigate to
+// the directories they have access to without being able to see anything else
+// along the way.
+func (p *Policy) Visible(name string) bool {
+	if p.Can(FileRead, name) {
+		return true
+	}
+	if p == nil {
+		return false
+	}
+	segments := split(name)
+	for _, g := range p.grants {
+		if has(g.permissions, FileRead) && leadsTo(g.pattern, segments) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanLink returns true if a symlink at the given path pointing to target would
+// not give access to anything beyond what the policy already allows on target.
+func (p *Policy) CanLink(name string, target string) bool {
+	if !p.Scoped() {
+		return true
+	}
+	for _, permission := range []string{FileRead, FileReadContent, FileCreate, FileUpdate, FileDelete} {
+		if p.Can(permission, name) && !p.Can(permission, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// Check returns ErrPermissionDenied unless the policy allows the permission on
+// every one of the given paths.
+func (p *Policy) Check(permission string, names ...string) error {
+	for _, name := range names {
+		if !p.Can(permission, name) {
+			return errors.WithStack(ErrPermissionDenied)
+		}
+	}
+	return nil
+}
+
+func has(permissions []string, permission string) bool {
+	for _, v := range permissions {
+		if v == permission || v == All {
+			return true
+		}
+	}
+	return false
+}
+
+// split returns the cleaned segments of a path, the root has none.
+func split(name string) []string {
+	name = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(name)), "/")
+	if name == "" {
+		return nil
+	}
+	return strings.Split(name, "/")
+}
+
+// covers returns true if the pattern matches the path or any of its parents.
+func covers(pattern, segments []string) bool {
+	for i := len(segments); i >= 0; i-- {
+		if match(pattern, segments[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if the pattern matches exactly the given path.
+func match(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if match(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(segments) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return len(segments) == 0
+}
+
+// leadsTo returns true if the path is a parent of something that the pattern
+// could match.
+func leadsTo(pattern, segments []string) bool {
+	for len(segments) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+			return false
+		}
+		pattern, segments = pattern[1:], segments[1:]
+	}
+	return true
+}
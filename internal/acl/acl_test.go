@@ -0,0 +1,103 @@
+package acl_test
+
+import (
+	"testing"
+
+	"emperror.dev/errors"
+	"github.com/franela/goblin"
+
+	"github.com/pelican/wings/internal/acl"
+)
+
+func TestPolicy(t *testing.T) {
+	g := goblin.Goblin(t)
+
+	g.Describe("Policy", func() {
+		g.It("applies flat permissions to every path", func() {
+			p := acl.New([]string{acl.FileRead}, nil)
+			g.Assert(p.Scoped()).IsFalse()
+			g.Assert(p.Can(acl.FileRead, "/")).IsTrue()
+			g.Assert(p.Can(acl.FileRead, "/plugins/test.jar")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins/test.jar")).IsFalse()
+		})
+
+		g.It("allows everything with the wildcard permission", func() {
+			p := acl.New([]string{acl.All}, nil)
+			g.Assert(p.Can(acl.FileDelete, "/server.properties")).IsTrue()
+		})
+
+		g.It("denies everything when nil", func() {
+			var p *acl.Policy
+			g.Assert(p.Can(acl.FileRead, "/")).IsFalse()
+			g.Assert(p.Visible("/")).IsFalse()
+		})
+
+		g.It("limits grants to the matching paths", func() {
+			p := acl.New([]string{acl.All}, []acl.Grant{
+				{Path: "/", Permissions: []string{acl.FileRead, acl.FileReadContent}},
+				{Path: "/plugins/**", Permissions: []string{acl.All}},
+			})
+			g.Assert(p.Scoped()).IsTrue()
+			g.Assert(p.Can(acl.FileReadContent, "/server.properties")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/server.properties")).IsFalse()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins/Essentials/config.yml")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins/../server.properties")).IsFalse()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins-old/test.jar")).IsFalse()
+		})
+
+		g.It("matches wildcards within a segment", func() {
+			p := acl.New(nil, []acl.Grant{{Path: "/plugins/*.jar", Permissions: []string{acl.FileDelete}}})
+			g.Assert(p.Can(acl.FileDelete, "plugins/test.jar")).IsTrue()
+			g.Assert(p.Can(acl.FileDelete, "/plugins/test.yml")).IsFalse()
+			g.Assert(p.Can(acl.FileDelete, "/plugins")).IsFalse()
+		})
+
+		g.It("matches any number of segments", func() {
+			p := acl.New(nil, []acl.Grant{{Path: "/**/config.yml", Permissions: []string{acl.FileUpdate}}})
+			g.Assert(p.Can(acl.FileUpdate, "/config.yml")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins/Essentials/config.yml")).IsTrue()
+			g.Assert(p.Can(acl.FileUpdate, "/plugins/Essentials/userdata.yml")).IsFalse()
+		})
+
+		g.It("makes the parents of granted paths visible", func() {
+			p := acl.New(nil, []acl.Grant{{Path: "/plugins/Essentials", Permissions: []string{acl.FileRead}}})
+			g.Assert(p.Visible("/")).IsTrue()
+			g.Assert(p.Visible("/plugins")).IsTrue()
+			g.Assert(p.Visible("/plugins/Essentials/config.yml")).IsTrue()
+			g.Assert(p.Visible("/plugins/WorldEdit")).IsFalse()
+			g.Assert(p.Visible("/world")).IsFalse()
+			g.Assert(p.Can(acl.FileRead, "/plugins")).IsFalse()
+		})
+
+		g.It("does not allow links to paths with fewer permissions", func() {
+			p := acl.New(nil, []acl.Grant{
+				{Path: "/", Permissions: []string{acl.FileRead}},
+				{Path: "/plugins", Permissions: []string{acl.All}},
+			})
+			g.Assert(p.CanLink("/plugins/world", "/world")).IsFalse()
+			g.Assert(p.CanLink("/world-link", "/plugins/world")).IsTrue()
+			g.Assert(acl.New([]string{acl.FileCreate}, nil).CanLink("/plugins/world", "/world")).IsTrue()
+		})
+
+		g.It("checks every path", func() {
+			p := acl.New(nil, []acl.Grant{{Path: "/plugins", Permissions: []string{acl.FileDelete}}})
+			g.Assert(p.Check(acl.FileDelete, "/plugins/a", "/plugins/b")).IsNil()
+			err := p.Check(acl.FileDelete, "/plugins/a", "/world")
+			g.Assert(errors.Is(err, acl.ErrPermissionDenied)).IsTrue()
+		})
+	})
+
+	g.Describe("ParseGrants", func() {
+		g.It("parses a list of grants", func() {
+			grants, err := acl.ParseGrants(`[{"path":"/plugins/**","permissions":["*"]}]`)
+			g.Assert(err).IsNil()
+			g.Assert(grants).Equal([]acl.Grant{{Path: "/plugins/**", Permissions: []string{acl.All}}})
+		})
+
+		g.It("rejects invalid patterns", func() {
+			_, err := acl.ParseGrants(`[{"path":"/plugins/[","permissions":["*"]}]`)
+			g.Assert(err).IsNotNil()
+		})
+	})
+}
@@ -0,0 +1,26 @@
+package cron
+
+import (
+	"context"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/system"
+)
+
+type backupPruneCron struct {
+	mu      *system.AtomicBool
+	manager *server.Manager
+}
+
+// Run removes the backups stored on this node which are no longer kept by the
+// configured retention policy and storage limit.
+func (bc *backupPruneCron) Run(ctx context.Context) error {
+	if !bc.mu.SwapIf(true) {
+		return errors.WithStack(ErrCronRunning)
+	}
+	defer bc.mu.Store(false)
+
+	return bc.manager.PruneBackups(ctx)
+}
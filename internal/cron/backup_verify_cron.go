@@ -0,0 +1,66 @@
+package cron
+
+import (
+	"context"
+	"os"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
+	"github.com/pelican/wings/system"
+)
+
+type backupVerifyCron struct {
+	mu      *system.AtomicBool
+	manager *server.Manager
+}
+
+// Run verifies every backup stored on this machine against the checksum it had
+// when it was created, one backup at a time, starting with the backups which
+// have gone the longest without being verified. The result of each is reported
+// to the Panel by the server the backup belongs to.
+func (bc *backupVerifyCron) Run(ctx context.Context) error {
+	if !bc.mu.SwapIf(true) {
+		return errors.WithStack(ErrCronRunning)
+	}
+	defer bc.mu.Store(false)
+
+	var checksums []models.BackupChecksum
+	if tx := database.Instance().WithContext(ctx).Order("verified_at").Find(&checksums); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	for _, c := range checksums {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s, ok := bc.manager.Get(c.Server)
+		if !ok {
+			continue
+		}
+		l := s.Log().WithField("backup", c.Backup)
+		b, err := backup.LocateStored(c)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				l.WithField("error", err).Warn("failed to locate backup to verify")
+				continue
+			}
+			// The backup was removed without going through Wings, so there is
+			// nothing left to verify.
+			l.Warn("backup to verify is no longer on the disk, forgetting its checksum")
+			if tx := database.Instance().Delete(&c); tx.Error != nil {
+				return errors.WithStack(tx.Error)
+			}
+			continue
+		}
+		if _, err := s.VerifyBackup(ctx, b, nil, c.Checksum); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			l.WithField("error", err).Warn("failed to verify backup")
+		}
+	}
+	return nil
+}
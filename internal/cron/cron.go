@@ -90,5 +90,56 @@ func Scheduler(ctx context.Context, m *server.Manager) (gocron.Scheduler, error)
 		return nil, errors.Wrap(err, "cron: failed to create sftp job")
 	}
 
+	// Backup verification job
+	if hours := config.Get().System.Backups.VerifyInterval; hours > 0 {
+		verify := backupVerifyCron{
+			mu:      system.NewAtomicBool(false),
+			manager: m,
+		}
+		_, err = s.NewJob(
+			gocron.DurationJob(time.Duration(hours)*time.Hour),
+			gocron.NewTask(func() {
+				l.WithField("cron", "backup_verify").Debug("verifying stored backups")
+				if err := verify.Run(ctx); err != nil {
+					if errors.Is(err, ErrCronRunning) {
+						l.WithField("cron", "backup_verify").Warn("backup verification process is already running, skipping...")
+					} else {
+						l.WithField("cron", "backup_verify").WithField("error", err).Error("backup verification process failed to execute")
+					}
+				}
+			}),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "cron: failed to create backup verification job")
+		}
+	}
+
+	// Backup retention job
+	b := config.Get().System.Backups
+	retention := b.Retention.KeepLast > 0 || b.Retention.KeepDaily > 0 || b.Retention.KeepWeekly > 0
+	if (retention || b.MaxStorage > 0 || b.RemoveOrphanedBackups || b.SafetySnapshots.Enabled) && b.PruneInterval > 0 {
+		prune := backupPruneCron{
+			mu:      system.NewAtomicBool(false),
+			manager: m,
+		}
+		_, err = s.NewJob(
+			gocron.DurationJob(time.Duration(b.PruneInterval)*time.Minute),
+			gocron.NewTask(func() {
+				l.WithField("cron", "backup_prune").Debug("pruning stored backups")
+				if err := prune.Run(ctx); err != nil {
+					if errors.Is(err, ErrCronRunning) {
+						l.WithField("cron", "backup_prune").Warn("backup pruning process is already running, skipping...")
+					} else {
+						l.WithField("cron", "backup_prune").WithField("error", err).Error("backup pruning process failed to execute")
+					}
+				}
+			}),
+			gocron.WithStartAt(gocron.WithStartImmediately()),
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "cron: failed to create backup pruning job")
+		}
+	}
+
 	return s, nil
 }
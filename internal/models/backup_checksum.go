@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+)
+
+// BackupChecksum is the checksum of a backup stored on this machine taken when
+// the backup was created, so that the backup can later be verified against it.
+type BackupChecksum struct {
+	ID int `gorm:"primaryKey;not null"`
+	// Backup is the UUID of the backup.
+	Backup string `gorm:"type:uuid;uniqueIndex;not null"`
+	// Server is the UUID of the server the backup belongs to.
+	Server string `gorm:"type:uuid;not null"`
+	// Adapter is the adapter the backup was created with, which is needed to
+	// find the backup on the disk again.
+	Adapter  string `gorm:"not null"`
+	Checksum string `gorm:"not null"`
+	// VerifiedAt is when the backup was last verified, or nil if it has never
+	// been verified.
+	VerifiedAt *time.Time
+	CreatedAt  time.Time `gorm:"not null"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+)
+
+// BackupUploadPart is a part of a backup which has been uploaded to S3.
+type BackupUploadPart struct {
+	PartNumber int    `json:"part_number"`
+	ETag       string `json:"etag"`
+}
+
+// BackupUpload tracks a multipart upload of a backup to S3 so that it can be
+// resumed from the parts that were already uploaded if Wings is stopped before
+// the upload completes.
+type BackupUpload struct {
+	ID int `gorm:"primaryKey;not null"`
+	// Backup is the UUID of the backup being uploaded.
+	Backup string `gorm:"type:uuid;uniqueIndex;not null"`
+	// Server is the UUID of the server the backup belongs to.
+	Server string `gorm:"type:uuid;not null"`
+	// Format is the archive format of the backup, which is needed to find the
+	// archive on the disk again.
+	Format string `gorm:"not null"`
+	// Size is the size of the archive being uploaded, if the archive on the disk
+	// no longer matches this size the upload cannot be resumed.
+	Size     int64 `gorm:"not null"`
+	PartSize int64 `gorm:"not null"`
+	// URLs are the presigned URLs returned by the Panel for each part of the
+	// upload, in order.
+	URLs []string `gorm:"serializer:json;not null"`
+	// Parts are the parts which have been uploaded so far.
+	Parts     []BackupUploadPart `gorm:"serializer:json"`
+	CreatedAt time.Time          `gorm:"not null"`
+}
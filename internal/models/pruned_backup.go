@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// PrunedBackup is a backup removed from this machine by the retention policy
+// or storage limit which the Panel could not be notified of yet. The Panel is
+// notified of it again the next time backups are pruned.
+type PrunedBackup struct {
+	ID int `gorm:"primaryKey;not null"`
+	// Backup is the UUID of the backup which was removed.
+	Backup    string    `gorm:"type:uuid;uniqueIndex;not null"`
+	CreatedAt time.Time `gorm:"not null"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Snapshot is a backup of a server taken by Wings before an operation which
+// removes the files of the server, so that they can be recovered if the
+// operation was a mistake. Snapshots are not known to the Panel and are removed
+// once they expire.
+type Snapshot struct {
+	ID int `gorm:"primaryKey;not null" json:"-"`
+	// Uuid is the UUID of the backup the snapshot is stored as.
+	Uuid string `gorm:"type:uuid;uniqueIndex;not null" json:"uuid"`
+	// Server is the UUID of the server the snapshot was taken of.
+	Server string `gorm:"type:uuid;not null" json:"server"`
+	// Adapter is the adapter of the backup the snapshot is stored as.
+	Adapter string `gorm:"not null" json:"adapter"`
+	// Reason is the operation the snapshot was taken before.
+	Reason    string    `gorm:"not null" json:"reason"`
+	Size      int64     `gorm:"not null" json:"size"`
+	CreatedAt time.Time `gorm:"not null" json:"created_at"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
@@ -54,6 +54,13 @@ func (p *Progress) Write(v []byte) (int, error) {
 	return n, nil
 }
 
+// Add increments the progress without writing anything to the writer. This is
+// used when progress is being tracked in a unit other than bytes, such as the
+// number of files processed.
+func (p *Progress) Add(n uint64) {
+	atomic.AddUint64(&p.written, n)
+}
+
 // Progress returns a formatted progress string for the current progress.
 func (p *Progress) Progress(width int) string {
 	// current = 100 (Progress, dynamic)
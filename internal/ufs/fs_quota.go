@@ -31,6 +31,14 @@ type Quota struct {
 	//
 	// If usage is set to `-1`, it hasn't been calculated yet.
 	usage atomic.Int64
+
+	// fileLimit is the maximum number of files, directories and other entries
+	// that may exist within the filesystem. A limit of `0` disables any file
+	// limit checking.
+	fileLimit atomic.Int64
+
+	// files is the current number of entries within the filesystem.
+	files atomic.Int64
 }
 
 // NewQuota creates a new Quota filesystem using an existing UnixFS and a limit.
@@ -129,6 +137,55 @@ func (fs *Quota) CanFit(size int64) bool {
 	return size <= limit-usage
 }
 
+// FileLimit returns the file limit of the filesystem.
+func (fs *Quota) FileLimit() int64 {
+	return fs.fileLimit.Load()
+}
+
+// SetFileLimit sets the file limit of the filesystem.
+func (fs *Quota) SetFileLimit(newLimit int64) int64 {
+	return fs.fileLimit.Swap(newLimit)
+}
+
+// Files returns the current number of entries within the filesystem.
+func (fs *Quota) Files() int64 {
+	return fs.files.Load()
+}
+
+// SetFiles updates the total number of entries within the filesystem.
+func (fs *Quota) SetFiles(newFiles int64) int64 {
+	return fs.files.Swap(newFiles)
+}
+
+// AddFiles adds `i` to the tracked number of entries, the total is never
+// allowed to drop below zero.
+func (fs *Quota) AddFiles(i int64) int64 {
+	for {
+		files := fs.Files()
+		next := files + i
+		if next < 0 {
+			next = 0
+		}
+		if fs.files.CompareAndSwap(files, next) {
+			return next
+		}
+	}
+}
+
+// CanFitFiles checks if the given number of new entries can be created in the
+// filesystem without exceeding the file limit of the filesystem.
+func (fs *Quota) CanFitFiles(n int64) bool {
+	limit := fs.FileLimit()
+	if limit <= 0 || n <= 0 {
+		return true
+	}
+	files := fs.Files()
+	if files >= limit {
+		return false
+	}
+	return n <= limit-files
+}
+
 // Remove removes the named file or (empty) directory.
 //
 // If there is an error, it will be of type [*PathError].
@@ -139,6 +196,7 @@ func (fs *Quota) Remove(name string) error {
 	if err != nil {
 		return err
 	}
+	fs.AddFiles(-1)
 
 	// Don't reduce the quota's usage as `name` is not a regular file.
 	if !s.Mode().IsRegular() {
@@ -186,5 +244,9 @@ func (fs *Quota) unlinkat(dirfd int, name string, flags int) error {
 			fs.Add(-s.Size())
 		}
 	}
-	return fs.UnixFS.unlinkat(dirfd, name, flags)
+	if err := fs.UnixFS.unlinkat(dirfd, name, flags); err != nil {
+		return err
+	}
+	fs.AddFiles(-1)
+	return nil
 }
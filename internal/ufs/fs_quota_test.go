@@ -44,4 +44,34 @@ func TestQuotaAddClampsSubtractionAtZero(t *testing.T) {
 	if got := q.Add(-10); got != 0 {
 		t.Fatalf("expected usage to clamp at zero, got %d", got)
 	}
-}
\ No newline at end of file
+}
+
+func TestQuotaCanFitFiles(t *testing.T) {
+	q := NewQuota(nil, 0)
+	if !q.CanFitFiles(math.MaxInt64) {
+		t.Fatal("expected files to fit without a limit")
+	}
+
+	q.SetFileLimit(10)
+	q.SetFiles(8)
+	if !q.CanFitFiles(2) {
+		t.Fatal("expected files up to the limit to fit")
+	}
+	if q.CanFitFiles(3) {
+		t.Fatal("expected files over the limit to be rejected")
+	}
+
+	q.SetFiles(12)
+	if q.CanFitFiles(1) {
+		t.Fatal("expected files to be rejected when already over the limit")
+	}
+}
+
+func TestQuotaAddFilesClampsAtZero(t *testing.T) {
+	q := NewQuota(nil, 0)
+	q.SetFiles(1)
+
+	if got := q.AddFiles(-2); got != 0 {
+		t.Fatalf("expected file count to clamp at zero, got %d", got)
+	}
+}
@@ -500,6 +500,38 @@ func (fs *UnixFS) Rename(oldpath, newpath string) error {
 	return nil
 }
 
+// Replace atomically replaces the regular file at newpath with the one at
+// oldpath. Unlike Rename, newpath must already exist, anything opening it will
+// see either the old or the new file and never a partially written one.
+func (fs *UnixFS) Replace(oldpath, newpath string) error {
+	olddirfd, oldname, closeFd, err := fs.safePath(oldpath)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	newdirfd, newname, closeFd2, err := fs.safePath(newpath)
+	defer closeFd2()
+	if err != nil {
+		return err
+	}
+	for _, st := range []struct {
+		dirfd int
+		name  string
+	}{{olddirfd, oldname}, {newdirfd, newname}} {
+		info, err := fs.Lstatat(st.dirfd, st.name)
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return &PathError{Op: "replace", Path: st.name, Err: ErrNotRegular}
+		}
+	}
+	if err := unix.Renameat(olddirfd, oldname, newdirfd, newname); err != nil {
+		return &LinkError{Op: "replace", Old: oldpath, New: newpath, Err: err}
+	}
+	return nil
+}
+
 // Stat returns a FileInfo describing the named file.
 //
 // If there is an error, it will be of type *PathError.
@@ -754,6 +786,80 @@ func (fs *UnixFS) SafePath(path string) (int, string, func(), error) {
 	return fs.safePath(path)
 }
 
+// RealPath returns the location of path relative to the root of the filesystem
+// once every symlink along it has been followed, the same way the kernel would
+// from inside the server. Absolute link targets are resolved from the root of
+// the filesystem. Elements of the path that do not exist yet are joined onto
+// the deepest parent that does, so this can be used for a file that is about
+// to be created.
+func (fs *UnixFS) RealPath(path string) (string, error) {
+	name, err := fs.unsafePath(path)
+	if err != nil {
+		return "", err
+	}
+	resolved := "/"
+	pending := strings.Split(name, "/")
+	for links := 0; len(pending) > 0; {
+		element := pending[0]
+		pending = pending[1:]
+		if element == "" || element == "." {
+			continue
+		}
+		if element == ".." {
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, element)
+		st, err := fs.Lstat(next)
+		if err != nil {
+			if !errors.Is(err, ErrNotExist) {
+				return "", err
+			}
+			return filepath.Join(append([]string{next}, pending...)...), nil
+		}
+		if st.Mode()&ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		// Same limit as the kernel uses when following links.
+		if links++; links > 40 {
+			return "", &PathError{Op: "realpath", Path: path, Err: ErrBadPathResolution}
+		}
+		target, err := fs.readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+	return resolved, nil
+}
+
+// readlink returns the target of the symlink at the given path.
+func (fs *UnixFS) readlink(name string) (string, error) {
+	dirfd, name, closeFd, err := fs.safePath(name)
+	defer closeFd()
+	if err != nil {
+		return "", err
+	}
+	for size := 128; ; size *= 2 {
+		b := make([]byte, size)
+		var n int
+		if err := ignoringEINTR(func() error {
+			var err error
+			n, err = unix.Readlinkat(dirfd, name, b)
+			return err
+		}); err != nil {
+			return "", ensurePathError(err, "readlinkat", name)
+		}
+		if n < size {
+			return string(b[:n]), nil
+		}
+	}
+}
+
 func (fs *UnixFS) safePath(path string) (dirfd int, file string, closeFd func(), err error) {
 	// Default closeFd to a NO-OP.
 	closeFd = func() {}
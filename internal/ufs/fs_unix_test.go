@@ -586,6 +586,57 @@ func TestUnixFS_Rename(t *testing.T) {
 	})
 }
 
+func TestUnixFS_Replace(t *testing.T) {
+	t.Parallel()
+	fs, err := newTestUnixFS()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer fs.Cleanup()
+
+	for name, content := range map[string]string{"old": "old content", "new": "new content"} {
+		if err := os.WriteFile(filepath.Join(fs.Root, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+			return
+		}
+	}
+	if err := fs.Mkdir("directory", 0o755); err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	t.Run("replace directory", func(t *testing.T) {
+		if err := fs.Replace("new", "directory"); !errors.Is(err, ufs.ErrNotRegular) {
+			t.Errorf("expected a not regular error, but got: %v", err)
+		}
+	})
+
+	t.Run("replace missing file", func(t *testing.T) {
+		if err := fs.Replace("new", "missing"); !errors.Is(err, ufs.ErrNotExist) {
+			t.Errorf("expected a not exist error, but got: %v", err)
+		}
+	})
+
+	t.Run("replace file", func(t *testing.T) {
+		if err := fs.Replace("new", "old"); err != nil {
+			t.Errorf("expected no error, but got: %v", err)
+			return
+		}
+		b, err := os.ReadFile(filepath.Join(fs.Root, "old"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(b) != "new content" {
+			t.Errorf("expected the file to be replaced, but got: %q", b)
+		}
+		if _, err := os.Lstat(filepath.Join(fs.Root, "new")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected the replacement to no longer exist, but got: %v", err)
+		}
+	})
+}
+
 func TestUnixFS_Stat(t *testing.T) {
 	t.Parallel()
 	fs, err := newTestUnixFS()
@@ -610,6 +661,55 @@ func TestUnixFS_Lstat(t *testing.T) {
 	// TODO: implement
 }
 
+func TestUnixFS_RealPath(t *testing.T) {
+	t.Parallel()
+	fs, err := newTestUnixFS()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	defer fs.Cleanup()
+
+	if err := fs.Mkdir("config", 0o755); err != nil {
+		t.Fatal(err)
+		return
+	}
+	if err := fs.Symlink("config", "plugins"); err != nil {
+		t.Fatal(err)
+		return
+	}
+	if err := fs.Symlink("../../plugins/../missing", "config/dangling"); err != nil {
+		t.Fatal(err)
+		return
+	}
+	if err := fs.Symlink("loop", "loop"); err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	for p, expected := range map[string]string{
+		"/":                 "/",
+		"config":            "/config",
+		"plugins":           "/config",
+		"/plugins/new/file": "/config/new/file",
+		"plugins/../a.txt":  "/a.txt",
+		"plugins/dangling":  "/missing",
+	} {
+		actual, err := fs.RealPath(p)
+		if err != nil {
+			t.Errorf("expected no error resolving %s, but got: %v", p, err)
+			continue
+		}
+		if actual != expected {
+			t.Errorf("expected %s to resolve to %s, but got %s", p, expected, actual)
+		}
+	}
+
+	if _, err := fs.RealPath("loop/file"); !errors.Is(err, ufs.ErrBadPathResolution) {
+		t.Errorf("expected a symlink loop to be refused, but got: %v", err)
+	}
+}
+
 func TestUnixFS_Symlink(t *testing.T) {
 	t.Parallel()
 	fs, err := newTestUnixFS()
@@ -29,6 +29,10 @@ type Client interface {
 	SetArchiveStatus(ctx context.Context, uuid string, successful bool) error
 	SetBackupStatus(ctx context.Context, backup string, data BackupRequest) error
 	SendRestorationStatus(ctx context.Context, backup string, successful bool) error
+	SendBackupVerification(ctx context.Context, backup string, data BackupVerificationRequest) error
+	SendPrunedBackups(ctx context.Context, backups []string) error
+	GetBackupChunkURLs(ctx context.Context, server string, method string, chunks []string) (map[string]string, error)
+	GetBackupChunks(ctx context.Context, server string) ([]BackupChunk, error)
 	SetInstallationStatus(ctx context.Context, uuid string, data InstallStatusRequest) error
 	SetTransferStatus(ctx context.Context, uuid string, successful bool) error
 	ValidateSftpCredentials(ctx context.Context, request SftpAuthRequest) (SftpAuthResponse, error)
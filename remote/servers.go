@@ -180,6 +180,61 @@ func (c *client) SendRestorationStatus(ctx context.Context, backup string, succe
 	return nil
 }
 
+// SendBackupVerification notifies the Panel of the result of verifying that a
+// stored backup is still intact.
+func (c *client) SendBackupVerification(ctx context.Context, backup string, data BackupVerificationRequest) error {
+	resp, err := c.Post(ctx, fmt.Sprintf("/backups/%s/verify", backup), data)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// SendPrunedBackups notifies the Panel of backups that were removed by this
+// node to enforce its backup retention policy and storage limit.
+func (c *client) SendPrunedBackups(ctx context.Context, backups []string) error {
+	resp, err := c.Post(ctx, "/backups/pruned", d{"backups": backups})
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// GetBackupChunkURLs returns a presigned URL for each of the given chunks of
+// the deduplicated backups of a server stored in object storage, which can be
+// used to make a request with the given HTTP method for that chunk.
+func (c *client) GetBackupChunkURLs(ctx context.Context, server string, method string, chunks []string) (map[string]string, error) {
+	var data BackupChunkURLsResponse
+	res, err := c.Post(ctx, fmt.Sprintf("/servers/%s/backups/chunks", server), d{"method": method, "chunks": chunks})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := res.BindJSON(&data); err != nil {
+		return nil, err
+	}
+	return data.URLs, nil
+}
+
+// GetBackupChunks returns every chunk of the deduplicated backups of a server
+// stored in object storage.
+func (c *client) GetBackupChunks(ctx context.Context, server string) ([]BackupChunk, error) {
+	var data struct {
+		Chunks []BackupChunk `json:"chunks"`
+	}
+	res, err := c.Get(ctx, fmt.Sprintf("/servers/%s/backups/chunks", server), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := res.BindJSON(&data); err != nil {
+		return nil, err
+	}
+	return data.Chunks, nil
+}
+
 // SendActivityLogs sends activity logs back to the Panel for processing.
 func (c *client) SendActivityLogs(ctx context.Context, activity []models.Activity) error {
 	resp, err := c.Post(ctx, "/activity", d{"data": activity})
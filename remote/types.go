@@ -8,6 +8,7 @@ import (
 	"github.com/apex/log"
 	"github.com/goccy/go-json"
 
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/parser"
 )
 
@@ -89,6 +90,9 @@ type SftpAuthResponse struct {
 	Server      string   `json:"server"`
 	User        string   `json:"user"`
 	Permissions []string `json:"permissions"`
+	// Grants limits the file permissions of the user to specific paths within
+	// the server, when empty the permissions apply to the entire server.
+	Grants []acl.Grant `json:"grants"`
 }
 
 type OutputLineMatcher struct {
@@ -157,6 +161,18 @@ type BackupRemoteUploadResponse struct {
 	PartSize int64    `json:"part_size"`
 }
 
+// BackupChunkURLsResponse is the presigned URL for each requested chunk of
+// a deduplicated backup, by the identifier of the chunk.
+type BackupChunkURLsResponse struct {
+	URLs map[string]string `json:"urls"`
+}
+
+// BackupChunk is a chunk of a deduplicated backup stored in object storage.
+type BackupChunk struct {
+	ID   string `json:"id"`
+	Size int64  `json:"size"`
+}
+
 type BackupPart struct {
 	ETag       string `json:"etag"`
 	PartNumber int    `json:"part_number"`
@@ -170,6 +186,20 @@ type BackupRequest struct {
 	Parts        []BackupPart `json:"parts"`
 }
 
+// BackupVerificationRequest is the result of verifying a stored backup. The
+// checksum is the checksum of the backup as it was read while verifying it.
+type BackupVerificationRequest struct {
+	Successful bool `json:"successful"`
+	// ChecksumVerified is false if the backup had no checksum to compare with,
+	// meaning only the files in it were found to be readable.
+	ChecksumVerified bool   `json:"checksum_verified"`
+	Checksum         string `json:"checksum"`
+	ChecksumType     string `json:"checksum_type"`
+	Size             int64  `json:"size"`
+	Files            int    `json:"files"`
+	Error            string `json:"error,omitempty"`
+}
+
 type InstallStatusRequest struct {
 	Successful bool `json:"successful"`
 	Reinstall  bool `json:"reinstall"`
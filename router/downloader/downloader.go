@@ -18,6 +18,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/progress"
 	"github.com/pelican/wings/server"
 )
 
@@ -100,6 +101,7 @@ const (
 	ErrInternalResolution = errors.Sentinel("downloader: destination resolves to internal network location")
 	ErrInvalidIPAddress   = errors.Sentinel("downloader: invalid IP address")
 	ErrDownloadFailed     = errors.Sentinel("downloader: download request failed")
+	ErrInvalidFileName    = errors.Sentinel("downloader: invalid file name")
 )
 
 const defaultMaxRedirects = 10
@@ -121,6 +123,16 @@ type DownloadRequest struct {
 	URL       *url.URL
 	FileName  string
 	UseHeader bool
+	// Authorize is called with the path the file will be written to once it
+	// is known, which may depend on the response. The download is aborted if
+	// it returns an error.
+	Authorize func(p string) error
+}
+
+// ValidFileName returns whether the name can be used for a downloaded file,
+// which must be written directly within the requested directory.
+func ValidFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\")
 }
 
 type Download struct {
@@ -130,6 +142,7 @@ type Download struct {
 	req        DownloadRequest
 	server     *server.Server
 	progress   float64
+	tracker    *progress.Progress
 	cancelFunc *context.CancelFunc
 }
 
@@ -281,12 +294,26 @@ func (dl *Download) Execute() error {
 		}
 	}
 
+	// The name may come from the response, so it cannot be trusted to stay
+	// within the directory the download was requested for.
+	if !ValidFileName(dl.path) {
+		return errors.WithStack(ErrInvalidFileName)
+	}
 	p := dl.Path()
+	if dl.req.Authorize != nil {
+		if err := dl.req.Authorize(p); err != nil {
+			return err
+		}
+	}
 	dl.server.Log().WithField("path", p).Debug("writing remote file to disk")
 
 	// Write the file while tracking the progress, Write will check that the
 	// size of the file won't exceed the disk limit.
 	r := io.TeeReader(res.Body, dl.counter(res.ContentLength))
+	if dl.tracker != nil {
+		dl.tracker.SetTotal(uint64(res.ContentLength))
+		r = io.TeeReader(r, dl.tracker)
+	}
 	if err := dl.server.Filesystem().Write(p, r, res.ContentLength, 0o644); err != nil {
 		return errors.WrapIf(err, "downloader: failed to write file to server directory")
 	}
@@ -302,6 +329,12 @@ func (dl *Download) Cancel() {
 	instance.remove(dl.Identifier)
 }
 
+// TrackProgress sets a progress tracker that is updated with the number of bytes
+// written as the download is executed. This must be called before Execute.
+func (dl *Download) TrackProgress(p *progress.Progress) {
+	dl.tracker = p
+}
+
 // BelongsTo checks if the given download belongs to the provided server.
 func (dl *Download) BelongsTo(s *server.Server) bool {
 	return dl.server.ID() == s.ID()
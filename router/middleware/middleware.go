@@ -13,6 +13,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/remote"
 	"github.com/pelican/wings/server"
 	"github.com/pelican/wings/system"
@@ -189,6 +190,25 @@ func RequireAuthorization() gin.HandlerFunc {
 	}
 }
 
+// AttachGrants parses the path scoped grants the Panel sends in the X-Access-Grants
+// header for requests made on behalf of a user, and sets the policy for the user
+// into the request context. Requests without the header are not limited to any
+// specific paths, since the Panel has already checked the permissions of the user.
+func AttachGrants() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var grants []acl.Grant
+		if v := c.GetHeader("X-Access-Grants"); v != "" {
+			var err error
+			if grants, err = acl.ParseGrants(v); err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The access grants provided in the request are not valid."})
+				return
+			}
+		}
+		c.Set("policy", acl.New([]string{acl.All}, grants))
+		c.Next()
+	}
+}
+
 // RemoteDownloadEnabled checks if remote downloads are enabled for this instance
 // and if not aborts the request.
 func RemoteDownloadEnabled() gin.HandlerFunc {
@@ -223,6 +243,15 @@ func ExtractServer(c *gin.Context) *server.Server {
 	return v.(*server.Server)
 }
 
+// ExtractPolicy returns the file access policy for the request, if no policy has
+// been attached to the request one allowing everything is returned.
+func ExtractPolicy(c *gin.Context) *acl.Policy {
+	if v, ok := c.Get("policy"); ok {
+		return v.(*acl.Policy)
+	}
+	return acl.New([]string{acl.All}, nil)
+}
+
 // ExtractApiClient returns the API client defined for the routes.
 func ExtractApiClient(c *gin.Context) remote.Client {
 	if v, ok := c.Get("api_client"); ok {
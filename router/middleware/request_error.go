@@ -10,6 +10,7 @@ import (
 	"github.com/apex/log"
 	"github.com/gin-gonic/gin"
 
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/server"
 	"github.com/pelican/wings/server/filesystem"
 )
@@ -122,6 +123,9 @@ func (re *RequestError) asFilesystemError() (int, string) {
 		strings.Contains(err.Error(), "resolves to a location outside the server root") {
 		return http.StatusNotFound, "The requested resources was not found on the system."
 	}
+	if errors.Is(err, acl.ErrPermissionDenied) {
+		return http.StatusForbidden, "You do not have permission to perform this action on the requested path."
+	}
 	if filesystem.IsErrorCode(err, filesystem.ErrCodeDenylistFile) || strings.Contains(err.Error(), "filesystem: file access prohibited") {
 		return http.StatusForbidden, "This file cannot be modified: present in egg denylist."
 	}
@@ -131,6 +135,9 @@ func (re *RequestError) asFilesystemError() (int, string) {
 	if filesystem.IsErrorCode(err, filesystem.ErrCodeDiskSpace) || strings.Contains(err.Error(), "filesystem: not enough disk space") {
 		return http.StatusBadRequest, "There is not enough disk space available to perform that action."
 	}
+	if filesystem.IsErrorCode(err, filesystem.ErrCodeFileLimit) || strings.Contains(err.Error(), "filesystem: file limit reached") {
+		return http.StatusBadRequest, "This server has reached the maximum number of files it is allowed to have."
+	}
 	if strings.HasSuffix(err.Error(), "file name too long") {
 		return http.StatusBadRequest, "Cannot perform that action: file name is too long."
 	}
@@ -47,6 +47,7 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 	// These routes use signed URLs to validate access to the resource being requested.
 	router.GET("/download/backup", getDownloadBackup)
 	router.GET("/download/file", getDownloadFile)
+	router.GET("/download/directory", getDownloadDirectory)
 	router.POST("/upload/file", postServerUploadFiles)
 
 	// This route is special it sits above all the other requests because we are
@@ -100,30 +101,58 @@ func Configure(m *wserver.Manager, client remote.Client) *gin.Engine {
 		server.DELETE("deleteAllBackups", deleteAllServerBackups)
 
 		files := server.Group("/files")
+		files.Use(middleware.AttachGrants())
 		{
 			files.GET("/contents", getServerFileContents)
 			files.GET("/list-directory", getServerListDirectory)
+			files.GET("/disk-usage", getServerDiskUsage)
 			files.PUT("/rename", putServerRenameFiles)
 			files.POST("/copy", postServerCopyFile)
 			files.POST("/write", postServerWriteFile)
+			files.POST("/diff", postServerDiffFiles)
+			files.POST("/patch", postServerPatchFile)
+			files.POST("/checksum", postServerChecksumFiles)
 			files.POST("/create-directory", postServerCreateDirectory)
 			files.POST("/delete", postServerDeleteFiles)
 			files.POST("/compress", postServerCompressFiles)
 			files.POST("/decompress", postServerDecompressFiles)
+			files.GET("/list-archive", getServerListArchive)
+			files.POST("/decompress-entries", postServerDecompressEntries)
 			files.POST("/chmod", postServerChmodFile)
 			files.GET("/search", getFilesBySearch)
+			files.GET("/tail", getServerTailFile)
+			files.GET("/thumbnail", getServerThumbnail)
+			files.GET("/image-info", getServerImageInfo)
 
 			files.GET("/pull", middleware.RemoteDownloadEnabled(), getServerPullingFiles)
 			files.POST("/pull", middleware.RemoteDownloadEnabled(), postServerPullRemoteFile)
 			files.DELETE("/pull/:download", middleware.RemoteDownloadEnabled(), deleteServerPullRemoteFile)
 		}
 
+		jobs := server.Group("/jobs")
+		{
+			jobs.GET("", getServerJobs)
+			jobs.GET("/:job", getServerJob)
+			jobs.DELETE("/:job", deleteServerJob)
+		}
+
 		backup := server.Group("/backup")
 		{
 			backup.POST("", postServerBackup)
 			backup.POST("/:backup/restore", postServerRestoreBackup)
+			backup.POST("/:backup/files", postServerListBackupFiles)
+			backup.POST("/:backup/restore-files", postServerRestoreBackupFiles)
+			backup.POST("/:backup/download-file", postServerDownloadBackupFile)
+			backup.POST("/:backup/verify", postServerVerifyBackup)
 			backup.DELETE("/:backup", deleteServerBackup)
 		}
+
+		snapshots := server.Group("/snapshots")
+		{
+			snapshots.GET("", getServerSnapshots)
+			snapshots.POST("/:snapshot/restore", postServerRestoreSnapshot)
+			snapshots.DELETE("/:snapshot", deleteServerSnapshot)
+		}
 	}
 
 	return router
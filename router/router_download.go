@@ -3,16 +3,22 @@ package router
 import (
 	"bufio"
 	"errors"
+	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/router/middleware"
 	"github.com/pelican/wings/router/tokens"
 	"github.com/pelican/wings/server/backup"
+	"github.com/pelican/wings/server/filesystem"
 )
 
 // Handle a download request for a server backup.
@@ -44,6 +50,18 @@ func getDownloadBackup(c *gin.Context) {
 
 	// Locate the backup on the local disk.
 	b, st, err := backup.LocateLocal(client, token.BackupUuid, token.ServerUuid)
+	if errors.Is(err, os.ErrNotExist) {
+		// Deduplicated backups are stored as chunks, so they are put back
+		// together as they are sent.
+		if d, _, derr := backup.LocateDedup(client, token.BackupUuid, token.ServerUuid); derr == nil {
+			c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(d.Identifier()+".tar.gz"))
+			c.Header("Content-Type", "application/octet-stream")
+			if err := d.Stream(c.Request.Context(), c.Writer); err != nil {
+				middleware.ExtractLogger(c).WithField("error", err).Error("failed to stream deduplicated backup")
+			}
+			return
+		}
+	}
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
@@ -65,6 +83,44 @@ func getDownloadBackup(c *gin.Context) {
 	}
 	defer f.Close()
 
+	// Encrypted backups are decrypted as they are sent when the key is known to
+	// this node or given by the token, otherwise they are sent as they are
+	// stored.
+	key, ok := parseBackupEncryptionKey(c, token.EncryptionKey)
+	if !ok {
+		return
+	}
+	if key == nil {
+		key, _ = backup.ParseEncryptionKey(config.Get().System.Backups.EncryptionKey)
+	}
+	if key != nil {
+		r, encrypted, err := backup.NewDecryptReader(f, key)
+		if err != nil {
+			abortWithBackupFileError(c, err)
+			return
+		}
+		if encrypted {
+			// The first segment is decrypted before anything is sent, so that a
+			// wrong key is reported as an error rather than as a download that
+			// stops right after it started.
+			br := bufio.NewReader(r)
+			if _, err := br.Peek(1); err != nil && err != io.EOF {
+				abortWithBackupFileError(c, err)
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
+			c.Header("Content-Type", "application/octet-stream")
+			if _, err := br.WriteTo(c.Writer); err != nil {
+				middleware.ExtractLogger(c).WithField("error", err).Error("failed to decrypt backup for download")
+			}
+			return
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
+
 	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
 	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(st.Name()))
 	c.Header("Content-Type", "application/octet-stream")
@@ -93,6 +149,10 @@ func getDownloadFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if !token.Allows(acl.FileReadContent, token.FilePath) {
+		middleware.CaptureAndAbort(c, acl.ErrPermissionDenied)
+		return
+	}
 
 	f, st, err := s.Filesystem().File(token.FilePath)
 	if err != nil {
@@ -113,3 +173,77 @@ func getDownloadFile(c *gin.Context) {
 
 	_, _ = bufio.NewReader(f).WriteTo(c.Writer)
 }
+
+// Handles streaming an entire directory of a server to the client as a single
+// archive. The archive is generated on the fly and never touches the disk, so
+// it does not count against the server's disk space.
+func getDownloadDirectory(c *gin.Context) {
+	manager := middleware.ExtractManager(c)
+	token := tokens.DirectoryPayload{}
+	if err := tokens.ParseToken([]byte(c.Query("token")), &token); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	s, ok := manager.Get(token.ServerUuid)
+	if !ok || token.Denylisted() || !token.IsUniqueRequest() || !token.HasScope(tokens.DirectoryDownload) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
+	format, err := filesystem.ParseArchiveFormat(token.Format)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	dir := strings.TrimPrefix(filepath.Clean("/"+token.DirectoryPath), "/")
+	if err := s.Filesystem().IsIgnored(dir); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if !token.Allows(acl.FileReadContent, dir) {
+		middleware.CaptureAndAbort(c, acl.ErrPermissionDenied)
+		return
+	}
+
+	st, err := s.Filesystem().Stat(dir)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if !st.IsDir() {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested resource was not found on this server.",
+		})
+		return
+	}
+
+	ignored, err := s.ServerwideIgnoredFiles()
+	if err != nil {
+		s.Log().WithField("error", err).Warn("failed to get server-wide ignored files")
+	}
+
+	name := st.Name()
+	if dir == "" {
+		name = s.ID()
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(name+format.Extension()))
+	c.Header("Content-Type", format.ContentType())
+
+	a := &filesystem.Archive{
+		Filesystem:        s.Filesystem(),
+		Format:            format,
+		ExcludeDenylisted: true,
+		Ignore:            ignored,
+		BaseDirectory:     dir,
+	}
+	if err := a.Stream(c.Request.Context(), c.Writer); err != nil {
+		// The headers have already been sent at this point, so the best we can do
+		// is log the error and cut the response short.
+		s.Log().WithField("error", err).WithField("directory", dir).Warn("failed to stream directory archive to client")
+	}
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/router/tokens"
+	wserver "github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestGetDownloadBackupDecryptsWithTokenKey(t *testing.T) {
+	previous := config.Get()
+	t.Cleanup(func() {
+		config.Set(previous)
+	})
+	next := *previous
+	next.System.RootDirectory = t.TempDir()
+	next.System.BackupDirectory = t.TempDir()
+	config.Set(&next)
+	if err := database.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+
+	serverDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("motd=hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := backupTestRemoteClient{}
+	s, err := wserver.New(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Config().Uuid = "7c1a4f6e-1b0f-4f3e-9e5a-2d8c6b4a1f00"
+	defer s.CtxCancel()
+	manager := wserver.NewEmptyManager(client)
+	manager.Add(s)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	backupID := "33333333-3333-3333-3333-333333333333"
+	b := backup.NewLocal(client, backupID, s.ID(), "")
+	b.SetEncryptionKey(key)
+	if _, err := b.Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	download := func(key []byte) *httptest.ResponseRecorder {
+		payload := tokens.BackupPayload{
+			Payload: jwt.Payload{
+				ExpirationTime: jwt.NumericDate(time.Now().Add(time.Minute)),
+				// Issued times are sent in seconds, so the token is issued a
+				// second from now to not be issued before the tokens were loaded.
+				IssuedAt: jwt.NumericDate(time.Now().Add(time.Second)),
+			},
+			ServerUuid:    s.ID(),
+			UserUuid:      "user",
+			BackupUuid:    backupID,
+			UniqueId:      uuid.NewString(),
+			EncryptionKey: base64.StdEncoding.EncodeToString(key),
+			Scoped:        tokens.Scoped{Scope: string(tokens.BackupDownload)},
+		}
+		token, err := jwt.Sign(payload, config.GetJwtAlgorithm())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		gin.SetMode(gin.TestMode)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/download/backup?token="+url.QueryEscape(string(token)), nil)
+		c.Set("manager", manager)
+		c.Set("api_client", client)
+		c.Set("logger", log.WithField("test", t.Name()))
+		getDownloadBackup(c)
+		return w
+	}
+
+	w := download(bytes.Repeat([]byte{2}, 32))
+	if w.Code != http.StatusBadRequest || w.Header().Get("Content-Disposition") != "" {
+		t.Fatalf("expected a wrong key to be rejected before the download starts, got status %d", w.Code)
+	}
+
+	w = download(key)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected the backup to be downloaded, got status %d body %s", w.Code, w.Body.String())
+	}
+	if _, encrypted, err := backup.NewDecryptReader(bytes.NewReader(w.Body.Bytes()), nil); encrypted || err != nil {
+		t.Fatalf("expected the downloaded backup to be decrypted: %v", err)
+	}
+}
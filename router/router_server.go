@@ -253,6 +253,11 @@ func deleteServer(c *gin.Context) {
 		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server install log during deletion process")
 	}
 
+	// Remove any thumbnails cached for images in the server files.
+	if err := s.Filesystem().RemoveThumbnails(); err != nil {
+		log.WithFields(log.Fields{"server_id": ID, "error": err}).Warn("failed to remove server thumbnails during deletion process")
+	}
+
 	// Remove all server backups unless config setting is specified
 	if config.Get().System.Backups.RemoveBackupsOnServerDelete == true {
 		if err := s.RemoveAllServerBackups(); err != nil {
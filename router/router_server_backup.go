@@ -3,6 +3,7 @@ package router
 import (
 	"context"
 	stderrors "errors"
+	"io"
 	"mime"
 	"net"
 	"net/http"
@@ -18,6 +19,7 @@ import (
 	"github.com/google/uuid"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
 	"github.com/pelican/wings/router/middleware"
 	"github.com/pelican/wings/server"
 	"github.com/pelican/wings/server/backup"
@@ -44,10 +46,17 @@ func postServerBackup(c *gin.Context) {
 		Adapter backup.AdapterType `json:"adapter"`
 		Uuid    string             `json:"uuid"`
 		Ignore  string             `json:"ignore"`
+		// An optional key to encrypt this backup with, instead of the key
+		// configured for the node.
+		EncryptionKey string `json:"encryption_key"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
+	key, ok := parseBackupEncryptionKey(c, data.EncryptionKey)
+	if !ok {
+		return
+	}
 
 	backupUuid, ok := parseBackupUuid(c, data.Uuid)
 	if !ok {
@@ -59,10 +68,33 @@ func postServerBackup(c *gin.Context) {
 		adapter = backup.NewLocal(client, backupUuid, s.ID(), data.Ignore)
 	case backup.S3BackupAdapter:
 		adapter = backup.NewS3(client, backupUuid, s.ID(), data.Ignore)
+	case backup.DedupBackupAdapter:
+		// The chunks of deduplicated backups are shared between backups and
+		// cannot be encrypted.
+		if key != nil || config.Get().System.Backups.EncryptionKey != "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Deduplicated backups cannot be created while backups are encrypted."})
+			return
+		}
+		adapter = backup.NewDedup(client, backupUuid, s.ID(), data.Ignore)
 	default:
 		middleware.CaptureAndAbort(c, errors.New("router/backups: provided adapter is not valid: "+string(data.Adapter)))
 		return
 	}
+	if key != nil {
+		adapter.SetEncryptionKey(key)
+	}
+	// Backups stored on this node are not started once the backup storage limit
+	// is reached, rather than being pruned right after they are created.
+	if data.Adapter != backup.S3BackupAdapter {
+		if err := middleware.ExtractManager(c).EnsureBackupStorage(c.Request.Context()); err != nil {
+			if errors.Is(err, server.ErrBackupStorageFull) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The backup storage limit of this node has been reached."})
+				return
+			}
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
 
 	// Attach the server ID and the request ID to the adapter log context for easier
 	// parsing in the logs.
@@ -83,7 +115,8 @@ func postServerBackup(c *gin.Context) {
 // postServerRestoreBackup handles restoring a backup for a server by downloading
 // or finding the given backup on the system and then unpacking the archive into
 // the server's data directory. If the TruncateDirectory field is provided and
-// is true all of the files will be deleted for the server.
+// is true all of the files will be deleted for the server once it has stopped,
+// after taking a safety snapshot of them if enabled.
 //
 // This endpoint will block until the backup is fully restored allowing for a
 // spinner to be displayed in the Panel UI effectively.
@@ -95,15 +128,22 @@ func postServerRestoreBackup(c *gin.Context) {
 	logger := middleware.ExtractLogger(c)
 
 	var data struct {
-		Adapter           backup.AdapterType `binding:"required,oneof=wings s3" json:"adapter"`
+		Adapter           backup.AdapterType `binding:"required,oneof=wings s3 dedup" json:"adapter"`
 		TruncateDirectory bool               `json:"truncate_directory"`
 		// A UUID is always required for this endpoint, however the download URL
 		// is only present when the given adapter type is s3.
 		DownloadUrl string `json:"download_url"`
+		// The key the backup was encrypted with, if it was not encrypted with
+		// the key configured for the node.
+		EncryptionKey string `json:"encryption_key"`
 	}
 	if err := c.BindJSON(&data); err != nil {
 		return
 	}
+	key, ok := parseBackupEncryptionKey(c, data.EncryptionKey)
+	if !ok {
+		return
+	}
 	backupUuid, ok := parseBackupUuid(c, c.Param("backup"))
 	if !ok {
 		return
@@ -129,25 +169,26 @@ func postServerRestoreBackup(c *gin.Context) {
 	}()
 
 	logger.Info("processing server backup restore request")
-	if data.TruncateDirectory {
-		logger.Info("received \"truncate_directory\" flag in request: deleting server files")
-		if err := s.Filesystem().TruncateRootDirectory(); err != nil {
-			middleware.CaptureAndAbort(c, err)
-			return
-		}
-	}
 
-	// Now that we've cleaned up the data directory if necessary, grab the backup file
-	// and attempt to restore it into the server directory.
-	if data.Adapter == backup.LocalBackupAdapter {
-		b, _, err := backup.LocateLocal(client, backupUuid, s.ID())
+	// Grab the backup file and attempt to restore it into the server directory.
+	if data.Adapter == backup.LocalBackupAdapter || data.Adapter == backup.DedupBackupAdapter {
+		var b backup.BackupInterface
+		var err error
+		if data.Adapter == backup.DedupBackupAdapter {
+			b, _, err = backup.LocateDedup(client, backupUuid, s.ID())
+		} else {
+			b, _, err = backup.LocateLocal(client, backupUuid, s.ID())
+		}
 		if err != nil {
 			middleware.CaptureAndAbort(c, err)
 			return
 		}
+		if key != nil {
+			b.SetEncryptionKey(key)
+		}
 		go func(s *server.Server, b backup.BackupInterface, logger *log.Entry) {
-			logger.Info("starting restoration process for server backup using local driver")
-			if err := s.RestoreBackup(b, nil); err != nil {
+			logger.WithField("adapter", data.Adapter).Info("starting restoration process for server backup using local driver")
+			if err := s.RestoreBackup(b, nil, data.TruncateDirectory); err != nil {
 				logger.WithField("error", err).Error("failed to restore local backup to server")
 			}
 			s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from local backup.")
@@ -162,7 +203,6 @@ func postServerRestoreBackup(c *gin.Context) {
 
 	// Since this is not a local backup we need to stream the archive and then
 	// parse over the contents as we go in order to restore it to the server.
-	httpClient := backupRestoreHttpClient()
 	logger.Info("downloading backup from remote location...")
 	// TODO: this will hang if there is an issue. We can't use c.Request.Context() (or really any)
 	//  since it will be canceled when the request is closed which happens quickly since we push
@@ -170,38 +210,37 @@ func postServerRestoreBackup(c *gin.Context) {
 	//
 	// For now I'm just using the server context so at least the request is canceled if
 	// the server gets deleted.
-	req, err := http.NewRequestWithContext(s.Context(), http.MethodGet, data.DownloadUrl, nil)
-	if err != nil {
-		middleware.CaptureAndAbort(c, err)
-		return
+	open := func() (io.ReadCloser, error) {
+		res, err := openRemoteBackup(s.Context(), data.DownloadUrl)
+		if err != nil {
+			return nil, err
+		}
+		return res.Body, nil
 	}
-	res, err := httpClient.Do(req)
-	if err != nil {
-		var downloadErr backupDownloadError
-		if stderrors.As(err, &downloadErr) {
-			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": downloadErr.Error()})
+	// When a safety snapshot is taken the download is only started once it has
+	// been taken, since the link could expire while the snapshot is being taken.
+	// Otherwise it is started right away so that a bad link is reported in the
+	// response.
+	var res *http.Response
+	if !data.TruncateDirectory || !config.Get().System.Backups.SafetySnapshots.Enabled {
+		if res, ok = downloadRemoteBackup(s.Context(), c, data.DownloadUrl); !ok {
 			return
 		}
-		middleware.CaptureAndAbort(c, err)
-		return
-	}
-	if res.StatusCode != http.StatusOK {
-		_ = res.Body.Close()
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The provided backup link returned an invalid response status: " + res.Status})
-		return
-	}
-	// Don't allow content types that we know are going to give us problems.
-	if !isSupportedBackupRestoreContentType(res.Header.Get("Content-Type")) {
-		_ = res.Body.Close()
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
-			"error": "The provided backup link is not a supported content type. \"" + res.Header.Get("Content-Type") + "\" is not application/x-gzip.",
-		})
-		return
+		open = func() (io.ReadCloser, error) {
+			return res.Body, nil
+		}
 	}
 
 	go func(s *server.Server, uuid string, logger *log.Entry) {
 		logger.Info("starting restoration process for server backup using S3 driver")
-		if err := s.RestoreBackup(backup.NewS3(client, uuid, s.ID(), ""), res.Body); err != nil {
+		if res != nil {
+			defer res.Body.Close()
+		}
+		b := backup.NewS3(client, uuid, s.ID(), "")
+		if key != nil {
+			b.SetEncryptionKey(key)
+		}
+		if err := s.RestoreBackup(b, open, data.TruncateDirectory); err != nil {
 			logger.WithField("error", errors.WithStack(err)).Error("failed to restore remote S3 backup to server")
 		}
 		s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from S3 backup.")
@@ -214,16 +253,58 @@ func postServerRestoreBackup(c *gin.Context) {
 	c.Status(http.StatusAccepted)
 }
 
-// deleteServerBackup deletes a local backup of a server. If the backup is not
-// found on the machine just return a 404 error. The service calling this
-// endpoint can make its own decisions as to how it wants to handle that
+// downloadRemoteBackup starts downloading a backup from the given URL, which
+// must already have been validated. If the download cannot be started the
+// request is aborted and false is returned.
+func downloadRemoteBackup(ctx context.Context, c *gin.Context, url string) (*http.Response, bool) {
+	res, err := openRemoteBackup(ctx, url)
+	if err != nil {
+		var downloadErr backupDownloadError
+		if stderrors.As(err, &downloadErr) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": downloadErr.Error()})
+			return nil, false
+		}
+		middleware.CaptureAndAbort(c, err)
+		return nil, false
+	}
+	return res, true
+}
+
+// openRemoteBackup starts downloading a backup from the given URL, which must
+// already have been validated. A backupDownloadError is returned if the link
+// itself is the problem.
+func openRemoteBackup(ctx context.Context, url string) (*http.Response, error) {
+	httpClient := backupRestoreHttpClient()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		_ = res.Body.Close()
+		return nil, backupDownloadError("The provided backup link returned an invalid response status: " + res.Status)
+	}
+	// Don't allow content types that we know are going to give us problems.
+	if !isSupportedBackupRestoreContentType(res.Header.Get("Content-Type")) {
+		_ = res.Body.Close()
+		return nil, backupDownloadError("The provided backup link is not a supported content type. \"" + res.Header.Get("Content-Type") + "\" is not application/x-gzip or application/zstd.")
+	}
+	return res, nil
+}
+
+// deleteServerBackup deletes a local or deduplicated backup of a server. If the
+// backup is not found on the machine just return a 404 error. The service calling
+// this endpoint can make its own decisions as to how it wants to handle that
 // response.
 func deleteServerBackup(c *gin.Context) {
 	backupUuid, ok := parseBackupUuid(c, c.Param("backup"))
 	if !ok {
 		return
 	}
-	b, _, err := backup.LocateLocal(middleware.ExtractApiClient(c), backupUuid, middleware.ExtractServer(c).ID())
+	b, err := locateStoredBackup(middleware.ExtractApiClient(c), backupUuid, middleware.ExtractServer(c).ID())
 	if err != nil {
 		// Just return from the function at this point if the backup was not located.
 		if errors.Is(err, os.ErrNotExist) {
@@ -245,6 +326,32 @@ func deleteServerBackup(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// locateStoredBackup finds a backup of a server that is stored on this machine,
+// which is either a local backup or a deduplicated backup.
+func locateStoredBackup(client remote.Client, uuid string, sid string) (backup.BackupInterface, error) {
+	b, _, err := backup.LocateLocal(client, uuid, sid)
+	if err == nil {
+		return b, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	d, _, err := backup.LocateDedup(client, uuid, sid)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func parseBackupEncryptionKey(c *gin.Context, value string) ([]byte, bool) {
+	key, err := backup.ParseEncryptionKey(value)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The encryption key must be 32 bytes encoded as base64."})
+		return nil, false
+	}
+	return key, true
+}
+
 func parseBackupUuid(c *gin.Context, value string) (string, bool) {
 	parsed, err := uuid.Parse(value)
 	if err == nil && len(value) == len(parsed.String()) && parsed.String() == strings.ToLower(value) {
@@ -361,7 +468,7 @@ func isSupportedBackupRestoreContentType(value string) bool {
 		mediaType = strings.TrimSpace(value)
 	}
 	switch strings.ToLower(mediaType) {
-	case "application/x-gzip", "application/gzip":
+	case "application/x-gzip", "application/gzip", "application/zstd", "application/x-zstd":
 		return true
 	default:
 		return false
@@ -0,0 +1,252 @@
+package router
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pelican/wings/internal/progress"
+	"github.com/pelican/wings/router/middleware"
+	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
+)
+
+// backupSource describes where a backup that is being browsed is stored.
+type backupSource struct {
+	Adapter backup.AdapterType `binding:"required,oneof=wings s3 dedup" json:"adapter"`
+	// The download URL is only required when the adapter is s3, since the backup
+	// is read from S3 every time it is browsed.
+	DownloadUrl string `json:"download_url"`
+	// The key the backup was encrypted with, if it was not encrypted with the
+	// key configured for the node.
+	EncryptionKey string `json:"encryption_key"`
+}
+
+// openBackupSource finds the backup being browsed, starting to download it
+// with the given context if it is stored in S3, in which case the returned
+// reader must be closed by the caller. If the backup cannot be found the
+// request is aborted and false is returned.
+func openBackupSource(ctx context.Context, c *gin.Context, src backupSource) (backup.BackupInterface, io.ReadCloser, bool) {
+	s := middleware.ExtractServer(c)
+	client := middleware.ExtractApiClient(c)
+	key, ok := parseBackupEncryptionKey(c, src.EncryptionKey)
+	if !ok {
+		return nil, nil, false
+	}
+	backupUuid, ok := parseBackupUuid(c, c.Param("backup"))
+	if !ok {
+		return nil, nil, false
+	}
+
+	var b backup.BackupInterface
+	var r io.ReadCloser
+	switch src.Adapter {
+	case backup.S3BackupAdapter:
+		if src.DownloadUrl == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The download_url field is required when the backup adapter is set to S3."})
+			return nil, nil, false
+		}
+		if err := validateBackupDownloadUrl(src.DownloadUrl); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, nil, false
+		}
+		res, ok := downloadRemoteBackup(ctx, c, src.DownloadUrl)
+		if !ok {
+			return nil, nil, false
+		}
+		b, r = backup.NewS3(client, backupUuid, s.ID(), ""), res.Body
+	case backup.DedupBackupAdapter:
+		d, _, err := backup.LocateDedup(client, backupUuid, s.ID())
+		if err != nil {
+			abortWithBackupFileError(c, err)
+			return nil, nil, false
+		}
+		b = d
+	default:
+		l, _, err := backup.LocateLocal(client, backupUuid, s.ID())
+		if err != nil {
+			abortWithBackupFileError(c, err)
+			return nil, nil, false
+		}
+		b = l
+	}
+	if key != nil {
+		b.SetEncryptionKey(key)
+	}
+	return b, r, true
+}
+
+// abortWithBackupFileError aborts the request with the error returned while
+// reading the contents of a backup.
+func abortWithBackupFileError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested backup or file within it was not found on this server."})
+	case errors.Is(err, backup.ErrMissingEncryptionKey), errors.Is(err, backup.ErrDecryptionFailed):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		middleware.CaptureAndAbort(c, err)
+	}
+}
+
+// postServerListBackupFiles returns every file and directory within a backup,
+// or only those beneath the given directory. The backup is read as it is
+// listed and is never extracted.
+func postServerListBackupFiles(c *gin.Context) {
+	var data struct {
+		backupSource
+		Directory string `json:"directory"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+	b, r, ok := openBackupSource(c.Request.Context(), c, data.backupSource)
+	if !ok {
+		return
+	}
+	if r != nil {
+		defer r.Close()
+	}
+	entries, err := backup.ListFiles(c.Request.Context(), b, r, data.Directory)
+	if err != nil {
+		abortWithBackupFileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+// postServerRestoreBackupFiles restores only the chosen files and directories
+// from a backup, either back to their original location or into the given
+// destination directory.
+func postServerRestoreBackupFiles(c *gin.Context) {
+	var data struct {
+		backupSource
+		Files       []string `json:"files"`
+		Destination string   `json:"destination"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+	if len(data.Files) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No files to restore from the backup were provided.",
+		})
+		return
+	}
+	for _, f := range data.Files {
+		if backup.CleanEntryName(f) == "" {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"error": "The path " + strconv.Quote(f) + " is not a valid path within the backup.",
+			})
+			return
+		}
+	}
+
+	s := middleware.ExtractServer(c)
+	// The backup is read using the server context since restoring it may
+	// continue in the background after this request has completed.
+	b, r, ok := openBackupSource(s.Context(), c, data.backupSource)
+	if !ok {
+		return
+	}
+	logger := middleware.ExtractLogger(c).WithField("backup", b.Identifier())
+	run := func(ctx context.Context, p *progress.Progress) (interface{}, error) {
+		if r != nil {
+			defer r.Close()
+		}
+		logger.WithField("files", data.Files).WithField("destination", data.Destination).Info("restoring files from server backup")
+		return nil, s.RestoreBackupFiles(ctx, b, r, data.Files, data.Destination, p)
+	}
+	if startServerJob(c, s, server.JobRestore, run) {
+		if c.IsAborted() && r != nil {
+			_ = r.Close()
+		}
+		return
+	}
+	if _, err := run(c.Request.Context(), progress.NewProgress(0)); err != nil {
+		abortWithBackupFileError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// postServerVerifyBackup reads the entire backup to confirm that it is still
+// intact, responding with the result which is also reported to the Panel.
+func postServerVerifyBackup(c *gin.Context) {
+	var data struct {
+		backupSource
+		// The checksum the backup had when it was created. Backups stored on this
+		// machine are verified against the checksum recorded when they were
+		// created if one is not provided.
+		Checksum string `json:"checksum"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	s := middleware.ExtractServer(c)
+	b, r, ok := openBackupSource(s.Context(), c, data.backupSource)
+	if !ok {
+		return
+	}
+	middleware.ExtractLogger(c).WithField("backup", b.Identifier()).Info("verifying server backup")
+	run := func(ctx context.Context, _ *progress.Progress) (interface{}, error) {
+		if r != nil {
+			defer r.Close()
+		}
+		return s.VerifyBackup(ctx, b, r, data.Checksum)
+	}
+	if startServerJob(c, s, server.JobVerify, run) {
+		if c.IsAborted() && r != nil {
+			_ = r.Close()
+		}
+		return
+	}
+	res, err := run(c.Request.Context(), progress.NewProgress(0))
+	if err != nil {
+		abortWithBackupFileError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// postServerDownloadBackupFile sends the contents of a single file within a
+// backup.
+func postServerDownloadBackupFile(c *gin.Context) {
+	var data struct {
+		backupSource
+		File string `binding:"required" json:"file"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+	b, r, ok := openBackupSource(c.Request.Context(), c, data.backupSource)
+	if !ok {
+		return
+	}
+	if r != nil {
+		defer r.Close()
+	}
+	err := backup.ExtractFile(c.Request.Context(), b, r, data.File, func(info fs.FileInfo, fr io.Reader) error {
+		c.Header("Content-Length", strconv.Itoa(int(info.Size())))
+		c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(backup.CleanEntryName(data.File))))
+		c.Header("Content-Type", "application/octet-stream")
+		c.Status(http.StatusOK)
+		_, err := io.Copy(c.Writer, fr)
+		return err
+	})
+	if err != nil {
+		if c.Writer.Written() {
+			middleware.ExtractLogger(c).WithField("error", err).Error("failed to send file from backup")
+			return
+		}
+		abortWithBackupFileError(c, err)
+	}
+}
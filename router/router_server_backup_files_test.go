@@ -0,0 +1,78 @@
+package router
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+)
+
+func TestPostServerListBackupFilesFromS3(t *testing.T) {
+	setBackupRestoreAllowlist(t, []string{"127.0.0.1"})
+
+	var archive bytes.Buffer
+	gw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gw)
+	for name, content := range map[string]string{"server.properties": "motd=hello", "world/level.dat": "level"} {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-gzip")
+		_, _ = w.Write(archive.Bytes())
+	}))
+	defer remote.Close()
+
+	backupID := "11111111-1111-1111-1111-111111111111"
+	c, w, s := newBackupRestoreContext(t, backupTestRemoteClient{}, backupID, fmt.Sprintf(`{"adapter":"s3","download_url":%q,"directory":"world"}`, remote.URL))
+	defer s.CtxCancel()
+
+	postServerListBackupFiles(c)
+
+	if c.Writer.Status() != http.StatusOK {
+		t.Fatalf("expected backup files to be listed, got status %d body %s", c.Writer.Status(), w.Body.String())
+	}
+	var entries []struct {
+		Name string `json:"name"`
+		Size int64  `json:"size"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "world/level.dat" || entries[0].Size != 5 {
+		t.Fatalf("unexpected entries: %s", w.Body.String())
+	}
+}
+
+func TestPostServerRestoreBackupFilesRejectsInvalidPaths(t *testing.T) {
+	for _, body := range []string{
+		`{"adapter":"wings"}`,
+		`{"adapter":"wings","files":["../../etc/passwd"]}`,
+		`{"adapter":"wings","files":["/"]}`,
+	} {
+		c, w, s := newBackupRestoreContext(t, backupTestRemoteClient{}, "11111111-1111-1111-1111-111111111111", body)
+
+		postServerRestoreBackupFiles(c)
+		s.CtxCancel()
+
+		if c.Writer.Status() != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "error") {
+			t.Fatalf("expected %s to be rejected, got status %d body %s", body, c.Writer.Status(), w.Body.String())
+		}
+	}
+}
@@ -67,6 +67,22 @@ func (c backupTestRemoteClient) SendRestorationStatus(_ context.Context, backup
 	return nil
 }
 
+func (c backupTestRemoteClient) SendBackupVerification(context.Context, string, remote.BackupVerificationRequest) error {
+	return nil
+}
+
+func (c backupTestRemoteClient) SendPrunedBackups(context.Context, []string) error {
+	return nil
+}
+
+func (c backupTestRemoteClient) GetBackupChunkURLs(context.Context, string, string, []string) (map[string]string, error) {
+	return nil, nil
+}
+
+func (c backupTestRemoteClient) GetBackupChunks(context.Context, string) ([]remote.BackupChunk, error) {
+	return nil, nil
+}
+
 func (c backupTestRemoteClient) SetInstallationStatus(context.Context, string, remote.InstallStatusRequest) error {
 	return nil
 }
@@ -136,6 +152,10 @@ func (backupTestEnvironment) Uptime(context.Context) (int64, error) { return 0,
 
 func (backupTestEnvironment) SetLogCallback(func([]byte)) {}
 
+func (backupTestEnvironment) Pause(context.Context) error { return nil }
+
+func (backupTestEnvironment) Unpause(context.Context) error { return nil }
+
 func newBackupRestoreContext(t *testing.T, client backupTestRemoteClient, backupID string, body string) (*gin.Context, *httptest.ResponseRecorder, *wserver.Server) {
 	t.Helper()
 
@@ -19,7 +19,9 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/internal/progress"
 	"github.com/pelican/wings/internal/ufs"
 	"github.com/pelican/wings/router/downloader"
 	"github.com/pelican/wings/router/middleware"
@@ -28,10 +30,18 @@ import (
 	"github.com/pelican/wings/server/filesystem"
 )
 
+// Files larger than this are never converted between text encodings, since that
+// requires holding the entire file in memory.
+const maxTextConversionSize = 32 * 1024 * 1024
+
 // getServerFileContents returns the contents of a file on the server.
 func getServerFileContents(c *gin.Context) {
 	s := middleware.ExtractServer(c)
 	p := strings.TrimLeft(c.Query("file"), "/")
+	if err := middleware.ExtractPolicy(c).Check(acl.FileReadContent, p); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
 	if err := s.Filesystem().IsIgnored(p); err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -64,6 +74,33 @@ func getServerFileContents(c *gin.Context) {
 	}
 
 	c.Header("X-Mime-Type", st.Mimetype)
+
+	// Report the encoding and line endings of the file so that they can be shown to
+	// the user, and decode the file to UTF-8 when requested so that it can be edited.
+	format, err := s.Filesystem().TextFormat(p)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Header("X-File-Encoding", format.Encoding)
+	if format.LineEnding != "" {
+		c.Header("X-Line-Ending", format.LineEnding)
+	}
+	if decode, _ := strconv.ParseBool(c.Query("decode")); decode && c.Query("download") == "" && st.Size() <= maxTextConversionSize {
+		b, err := io.ReadAll(io.LimitReader(f, st.Size()))
+		if err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		if b, err = format.Decode(b); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		c.Header("Content-Length", strconv.Itoa(len(b)))
+		_, _ = c.Writer.Write(b)
+		return
+	}
+
 	c.Header("Content-Length", strconv.Itoa(int(st.Size())))
 	// If a download parameter is included in the URL go ahead and attach the necessary headers
 	// so that the file can be downloaded.
@@ -94,6 +131,11 @@ func getServerFileContents(c *gin.Context) {
 func getServerListDirectory(c *gin.Context) {
 	s := middleware.ExtractServer(c)
 	dir := c.Query("directory")
+	policy := middleware.ExtractPolicy(c)
+	if !policy.Visible(dir) {
+		middleware.CaptureAndAbort(c, acl.ErrPermissionDenied)
+		return
+	}
 	if stats, err := s.Filesystem().ListDirectory(dir); err != nil {
 		// If the error is that the folder does not exist return a 404.
 		if errors.Is(err, os.ErrNotExist) {
@@ -104,10 +146,93 @@ func getServerListDirectory(c *gin.Context) {
 		}
 		middleware.CaptureAndAbort(c, err)
 	} else {
+		// Only return the entries the user is able to see when their access is
+		// limited to specific paths.
+		if policy.Scoped() {
+			visible := make([]filesystem.Stat, 0, len(stats))
+			for _, st := range stats {
+				if policy.Visible(path.Join(dir, st.Name())) {
+					visible = append(visible, st)
+				}
+			}
+			stats = visible
+		}
+		// Checksums are only included when asked for, since every file in the
+		// directory has to be read to compute them.
+		if checksums, _ := strconv.ParseBool(c.Query("checksums")); checksums {
+			algorithm := c.DefaultQuery("algorithm", filesystem.ChecksumSHA256)
+			for i, st := range stats {
+				if !st.Mode().IsRegular() {
+					continue
+				}
+				// A checksum gives away the contents of small files, so it is only
+				// included for files the user would be able to read.
+				p := path.Join(dir, st.Name())
+				if s.Filesystem().IsIgnored(p) != nil || policy.Check(acl.FileReadContent, p) != nil {
+					continue
+				}
+				sum, err := s.Filesystem().Checksum(c.Request.Context(), p, algorithm)
+				if err != nil {
+					if errors.Is(err, filesystem.ErrUnknownChecksum) {
+						c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+							"error": "The requested checksum algorithm is not supported.",
+						})
+						return
+					}
+					// The file may have been removed since the directory was read.
+					if errors.Is(err, os.ErrNotExist) {
+						continue
+					}
+					middleware.CaptureAndAbort(c, err)
+					return
+				}
+				stats[i].Checksum = sum
+			}
+		}
 		c.JSON(http.StatusOK, stats)
 	}
 }
 
+// Returns a breakdown of the disk space used by a directory for a server, by
+// default this will return the two largest levels of directories beneath it.
+func getServerDiskUsage(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "2"))
+	if err != nil || depth < 1 || depth > 10 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The depth must be a number between 1 and 10.",
+		})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "25"))
+	if err != nil || limit < 1 || limit > 250 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The limit must be a number between 1 and 250.",
+		})
+		return
+	}
+
+	dir := c.DefaultQuery("directory", "/")
+	if err := middleware.ExtractPolicy(c).Check(acl.FileRead, dir); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	tree, err := s.Filesystem().UsageTree(dir, depth, limit)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": "The requested directory was not found on the server.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tree)
+}
+
 type renameFile struct {
 	To   string `json:"to"`
 	From string `json:"from"`
@@ -132,41 +257,55 @@ func putServerRenameFiles(c *gin.Context) {
 		})
 		return
 	}
-
-	g, ctx := errgroup.WithContext(c.Request.Context())
-	// Loop over the array of files passed in and perform the move or rename action against each.
 	for _, p := range data.Files {
-		pf := path.Join(data.Root, p.From)
-		pt := path.Join(data.Root, p.To)
+		if err := middleware.ExtractPolicy(c).Check(acl.FileUpdate, path.Join(data.Root, p.From), path.Join(data.Root, p.To)); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
 
-		g.Go(func() error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				fs := s.Filesystem()
-				// Ignore renames on a file that is on the denylist (both as the rename from or
-				// the rename to value).
-				if err := fs.IsIgnored(pf, pt); err != nil {
-					return err
-				}
-				if err := fs.Rename(pf, pt); err != nil {
-					// Return nil if the error is an is not exists.
-					if errors.Is(err, os.ErrNotExist) {
-						s.Log().WithField("error", err).
-							WithField("from_path", pf).
-							WithField("to_path", pt).
-							Warn("failed to rename: source or target does not exist")
-						return nil
+	run := func(ctx context.Context, prog *progress.Progress) (interface{}, error) {
+		prog.SetTotal(uint64(len(data.Files)))
+		g, ctx := errgroup.WithContext(ctx)
+		// Loop over the array of files passed in and perform the move or rename action against each.
+		for _, p := range data.Files {
+			pf := path.Join(data.Root, p.From)
+			pt := path.Join(data.Root, p.To)
+
+			g.Go(func() error {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+					defer prog.Add(1)
+					fs := s.Filesystem()
+					// Ignore renames on a file that is on the denylist (both as the rename from or
+					// the rename to value).
+					if err := fs.IsIgnored(pf, pt); err != nil {
+						return err
 					}
-					return err
+					if err := fs.Rename(pf, pt); err != nil {
+						// Return nil if the error is an is not exists.
+						if errors.Is(err, os.ErrNotExist) {
+							s.Log().WithField("error", err).
+								WithField("from_path", pf).
+								WithField("to_path", pt).
+								Warn("failed to rename: source or target does not exist")
+							return nil
+						}
+						return err
+					}
+					return nil
 				}
-				return nil
-			}
-		})
+			})
+		}
+		return nil, g.Wait()
+	}
+	if startServerJob(c, s, server.JobRename, run) {
+		return
 	}
 
-	if err := g.Wait(); err != nil {
+	if _, err := run(c.Request.Context(), progress.NewProgress(0)); err != nil {
 		if errors.Is(err, os.ErrExist) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"error": "Cannot move or rename file, destination already exists.",
@@ -181,12 +320,14 @@ func putServerRenameFiles(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
-// Copies a server file.
+// Copies a server file or directory. If no destination is provided the copy is
+// created alongside the original with a " copy" suffix.
 func postServerCopyFile(c *gin.Context) {
 	s := ExtractServer(c)
 
 	var data struct {
-		Location string `json:"location"`
+		Location    string `json:"location"`
+		Destination string `json:"destination"`
 	}
 	// BindJSON sends 400 if the request fails, all we need to do is return
 	if err := c.BindJSON(&data); err != nil {
@@ -197,7 +338,44 @@ func postServerCopyFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
-	if err := s.Filesystem().Copy(data.Location); err != nil {
+	// Copies without a destination are created in the same directory as the original.
+	dest := data.Destination
+	if dest == "" {
+		dest = path.Dir(path.Clean("/" + data.Location))
+	}
+	policy := middleware.ExtractPolicy(c)
+	if err := policy.Check(acl.FileReadContent, data.Location); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := policy.Check(acl.FileCreate, dest); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	run := func(ctx context.Context, prog *progress.Progress) (interface{}, error) {
+		if data.Destination == "" {
+			prog.SetTotal(1)
+			defer prog.Add(1)
+			return nil, s.Filesystem().Copy(data.Location)
+		}
+		return nil, s.Filesystem().CopyTo(ctx, data.Location, data.Destination, prog)
+	}
+	if startServerJob(c, s, server.JobCopy, run) {
+		return
+	}
+	if _, err := run(c.Request.Context(), progress.NewProgress(0)); err != nil {
+		if errors.Is(err, filesystem.ErrCopyIntoSelf) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Cannot copy a directory into itself.",
+			})
+			return
+		}
+		if errors.Is(err, os.ErrExist) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Cannot copy file, destination already exists.",
+			})
+			return
+		}
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
@@ -224,6 +402,12 @@ func postServerDeleteFiles(c *gin.Context) {
 		})
 		return
 	}
+	for _, p := range data.Files {
+		if err := middleware.ExtractPolicy(c).Check(acl.FileDelete, path.Join(data.Root, p)); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
 
 	g, ctx := errgroup.WithContext(context.Background())
 
@@ -262,6 +446,10 @@ func postServerWriteFile(c *gin.Context) {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if err := middleware.ExtractPolicy(c).Check(writePermission(s, f), f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
 
 	// A content length of -1 means the actual length is unknown.
 	if c.Request.ContentLength == -1 {
@@ -271,13 +459,40 @@ func postServerWriteFile(c *gin.Context) {
 		return
 	}
 
-	if err := s.Filesystem().Write(f, c.Request.Body, c.Request.ContentLength, 0o644); err != nil {
+	// Text is converted to the encoding and line endings of the file being replaced,
+	// unless others are requested. Anything too large to be converted in memory is
+	// written exactly as it was sent.
+	var err error
+	if c.Request.ContentLength <= maxTextConversionSize {
+		var b []byte
+		b, err = io.ReadAll(io.LimitReader(c.Request.Body, c.Request.ContentLength))
+		if err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		err = s.Filesystem().WriteText(f, b, c.Query("encoding"), c.Query("line_ending"), 0o644)
+	} else {
+		err = s.Filesystem().Write(f, c.Request.Body, c.Request.ContentLength, 0o644)
+	}
+	if err != nil {
 		if filesystem.IsErrorCode(err, filesystem.ErrCodeIsDirectory) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"error": "Cannot write file, name conflicts with an existing directory by the same name.",
 			})
 			return
 		}
+		if errors.Is(err, filesystem.ErrUnknownEncoding) || errors.Is(err, filesystem.ErrUnknownLineEnding) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The requested encoding or line ending is not supported.",
+			})
+			return
+		}
+		if errors.Is(err, filesystem.ErrUnencodableText) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The file contains characters that cannot be saved in the requested encoding.",
+			})
+			return
+		}
 
 		middleware.CaptureAndAbort(c, err)
 		return
@@ -286,6 +501,338 @@ func postServerWriteFile(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// Returns a unified diff between two files on a server, or between a file and the
+// content sent in the request if no second file is given. An empty response means
+// that there are no differences.
+func postServerDiffFiles(c *gin.Context) {
+	s := ExtractServer(c)
+
+	var data struct {
+		From    string  `json:"from"`
+		To      string  `json:"to"`
+		Content *string `json:"content"`
+		Context *int    `json:"context"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+	if data.From == "" || (data.To == "") == (data.Content == nil) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "A file must be compared with either another file or the provided content.",
+		})
+		return
+	}
+	lines := 3
+	if data.Context != nil && *data.Context >= 0 {
+		lines = *data.Context
+	}
+
+	paths := []string{data.From}
+	if data.To != "" {
+		paths = append(paths, data.To)
+	}
+	for _, p := range paths {
+		if err := s.Filesystem().IsIgnored(p); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
+	if err := middleware.ExtractPolicy(c).Check(acl.FileReadContent, paths...); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	var d string
+	var err error
+	if data.Content != nil {
+		d, err = s.Filesystem().DiffWith(data.From, []byte(*data.Content), lines)
+	} else {
+		d, err = s.Filesystem().Diff(data.From, data.To, lines)
+	}
+	if err != nil {
+		if errors.Is(err, filesystem.ErrDiffTooLarge) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The file is too large to be compared.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	c.Data(http.StatusOK, "text/x-diff; charset=utf-8", []byte(d))
+}
+
+// Applies the unified diff in the body of the request to a file on a server. The file
+// is only changed if every part of the diff applies cleanly.
+func postServerPatchFile(c *gin.Context) {
+	s := ExtractServer(c)
+
+	f := "/" + strings.TrimLeft(c.Query("file"), "/")
+	if err := s.Filesystem().IsIgnored(f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := middleware.ExtractPolicy(c).Check(acl.FileUpdate, f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(c.Request.Body, filesystem.MaxDiffSize+1))
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if len(patch) > filesystem.MaxDiffSize {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": "The patch is too large to be applied.",
+		})
+		return
+	}
+
+	if err := s.Filesystem().Patch(f, patch); err != nil {
+		switch {
+		case errors.Is(err, filesystem.ErrPatchConflict):
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": "The patch does not apply to the current contents of the file.",
+			})
+		case errors.Is(err, filesystem.ErrInvalidPatch):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The patch is not a valid unified diff.",
+			})
+		case errors.Is(err, filesystem.ErrDiffTooLarge):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The file is too large to be patched.",
+			})
+		case filesystem.IsErrorCode(err, filesystem.ErrCodeIsDirectory):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "Cannot patch a directory.",
+			})
+		default:
+			middleware.CaptureAndAbort(c, err)
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Returns the checksums of one or more files on a server. Files which cannot be hashed,
+// such as those that do not exist or are directories, are returned with an error rather
+// than failing the entire request.
+func postServerChecksumFiles(c *gin.Context) {
+	s := ExtractServer(c)
+
+	var data struct {
+		Root      string   `json:"root"`
+		Files     []string `json:"files"`
+		Algorithm string   `json:"algorithm"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+	if len(data.Files) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No files were specified to checksum.",
+		})
+		return
+	}
+	if data.Algorithm == "" {
+		data.Algorithm = filesystem.ChecksumSHA256
+	}
+
+	policy := middleware.ExtractPolicy(c)
+	for _, p := range data.Files {
+		p = path.Join(data.Root, p)
+		if err := s.Filesystem().IsIgnored(p); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		if err := policy.Check(acl.FileReadContent, p); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
+
+	type result struct {
+		File     string `json:"file"`
+		Checksum string `json:"checksum,omitempty"`
+		Error    string `json:"error,omitempty"`
+	}
+	results := make([]result, 0, len(data.Files))
+	for _, f := range data.Files {
+		sum, err := s.Filesystem().Checksum(c.Request.Context(), path.Join(data.Root, f), data.Algorithm)
+		switch {
+		case err == nil:
+			results = append(results, result{File: f, Checksum: sum})
+		case errors.Is(err, filesystem.ErrUnknownChecksum):
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The requested checksum algorithm is not supported.",
+			})
+			return
+		case errors.Is(err, os.ErrNotExist):
+			results = append(results, result{File: f, Error: "The file does not exist."})
+		case filesystem.IsErrorCode(err, filesystem.ErrCodeIsDirectory), errors.Is(err, ufs.ErrNotRegular):
+			results = append(results, result{File: f, Error: "Only regular files can be checksummed."})
+		default:
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"algorithm": strings.ToLower(data.Algorithm),
+		"files":     results,
+	})
+}
+
+// Returns the last lines of a log file on a server. When following the file the
+// lines are streamed as server-sent events as they are written, which can be used
+// by clients that are unable to use the websocket. If no file is given the first
+// log file declared by the egg is used.
+func getServerTailFile(c *gin.Context) {
+	s := ExtractServer(c)
+
+	f := c.Query("file")
+	if f == "" {
+		if logs := s.LogFiles(); len(logs) > 0 {
+			f = logs[0]
+		}
+	}
+	if f == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "No file was specified to tail.",
+		})
+		return
+	}
+	f = "/" + strings.TrimLeft(f, "/")
+	if err := s.Filesystem().IsIgnored(f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := middleware.ExtractPolicy(c).Check(acl.FileReadContent, f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	lines, err := strconv.Atoi(c.DefaultQuery("lines", "100"))
+	if err != nil || lines < 0 || lines > filesystem.MaxTailLines {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The number of lines must be between 0 and 1000.",
+		})
+		return
+	}
+
+	if follow, _ := strconv.ParseBool(c.Query("follow")); !follow {
+		l, err := s.Filesystem().LastLines(f, lines)
+		if err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"lines": l})
+		return
+	}
+
+	ch, err := s.Filesystem().Tail(c.Request.Context(), f, lines)
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Header("Cache-Control", "no-cache")
+	// Stop any proxy in front of Wings from holding on to the events.
+	c.Header("X-Accel-Buffering", "no")
+	c.Stream(func(w io.Writer) bool {
+		ev, ok := <-ch
+		if !ok {
+			return false
+		}
+		c.SSEvent("output", ev)
+		return true
+	})
+}
+
+// imageFile returns the image file requested by the client, ensuring the token is
+// allowed to read it.
+func imageFile(c *gin.Context) (string, bool) {
+	s := ExtractServer(c)
+	f := c.Query("file")
+	if f == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "No file was specified.",
+		})
+		return "", false
+	}
+	f = "/" + strings.TrimLeft(f, "/")
+	if err := s.Filesystem().IsIgnored(f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return "", false
+	}
+	if err := middleware.ExtractPolicy(c).Check(acl.FileReadContent, f); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return "", false
+	}
+	return f, true
+}
+
+// abortImageError aborts the request with an error returned while reading an image.
+func abortImageError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, filesystem.ErrUnsupportedImage):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The file is not a supported image.",
+		})
+	case errors.Is(err, filesystem.ErrImageTooLarge):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The image is too large to create a thumbnail of.",
+		})
+	default:
+		middleware.CaptureAndAbort(c, err)
+	}
+}
+
+// Returns a thumbnail of an image on a server, scaled down to fit within the
+// requested size. The format and dimensions of the original image are returned
+// in headers.
+func getServerThumbnail(c *gin.Context) {
+	s := ExtractServer(c)
+	f, ok := imageFile(c)
+	if !ok {
+		return
+	}
+	size, err := strconv.Atoi(c.DefaultQuery("size", "256"))
+	if err != nil || size < 1 || size > filesystem.MaxThumbnailSize {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The thumbnail size must be between 1 and 1024.",
+		})
+		return
+	}
+
+	t, err := s.Filesystem().Thumbnail(c.Request.Context(), f, size)
+	if err != nil {
+		abortImageError(c, err)
+		return
+	}
+	c.Header("X-Image-Format", t.Image.Format)
+	c.Header("X-Image-Width", strconv.Itoa(t.Image.Width))
+	c.Header("X-Image-Height", strconv.Itoa(t.Image.Height))
+	c.Header("Cache-Control", "private, max-age=60")
+	c.Data(http.StatusOK, t.Mimetype, t.Data)
+}
+
+// Returns the format and dimensions of an image on a server.
+func getServerImageInfo(c *gin.Context) {
+	s := ExtractServer(c)
+	f, ok := imageFile(c)
+	if !ok {
+		return
+	}
+	info, err := s.Filesystem().ImageInfo(f)
+	if err != nil {
+		abortImageError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
 // Returns all of the currently in-progress file downloads and their current download
 // progress. The progress is also pushed out via a websocket event allowing you to just
 // call this once to get current downloads, and then listen to targeted websocket events
@@ -342,13 +889,30 @@ func postServerPullRemoteFile(c *gin.Context) {
 		return
 	}
 
+	if data.FileName != "" && !downloader.ValidFileName(data.FileName) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "The file name must not contain a path separator.",
+		})
+		return
+	}
+	// The name of the file may only be known once the download has started, so
+	// the path it is written to is checked again before writing it.
+	policy := middleware.ExtractPolicy(c)
+	authorize := func(p string) error {
+		if err := s.Filesystem().IsIgnored(p); err != nil {
+			return err
+		}
+		return policy.Check(writePermission(s, p), p)
+	}
 	dl := downloader.New(s, downloader.DownloadRequest{
 		Directory: data.RootPath,
 		URL:       u,
 		FileName:  data.FileName,
 		UseHeader: data.UseHeader,
+		Authorize: authorize,
 	})
-	if err := s.Filesystem().IsIgnored(dl.Path()); err != nil {
+	if err := authorize(dl.Path()); err != nil {
+		dl.Cancel()
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
@@ -363,11 +927,26 @@ func postServerPullRemoteFile(c *gin.Context) {
 		return nil
 	}
 	if !data.Foreground {
-		go func() {
-			_ = download()
-		}()
+		// The download is tracked as a job so that it is reported alongside any other
+		// background file operations, but it still keeps its own identifier for use
+		// with the existing pull endpoints.
+		j, err := s.Jobs().Start(server.JobPull, func(ctx context.Context, p *progress.Progress) (interface{}, error) {
+			stop := context.AfterFunc(ctx, dl.Cancel)
+			defer stop()
+			dl.TrackProgress(p)
+			if err := download(); err != nil {
+				return nil, err
+			}
+			return s.Filesystem().Stat(dl.Path())
+		})
+		if err != nil {
+			dl.Cancel()
+			abortWithJobError(c, err)
+			return
+		}
 		c.JSON(http.StatusAccepted, gin.H{
 			"identifier": dl.Identifier,
+			"job":        j,
 		})
 		return
 	}
@@ -407,6 +986,11 @@ func postServerCreateDirectory(c *gin.Context) {
 		return
 	}
 
+	if err := middleware.ExtractPolicy(c).Check(acl.FileCreate, path.Join(data.Path, data.Name)); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
 	if err := s.Filesystem().CreateDirectory(data.Name, data.Path); err != nil {
 		if errors.Is(err, ufs.ErrNotDirectory) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
@@ -452,6 +1036,31 @@ func postServerCompressFiles(c *gin.Context) {
 		return
 	}
 
+	policy := middleware.ExtractPolicy(c)
+	for _, p := range data.Files {
+		if err := policy.Check(acl.FileReadContent, path.Join(data.RootPath, p)); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
+	// The archive is always created directly within the root directory, under
+	// a generated name unless one is given.
+	target := data.RootPath
+	if data.Name != "" {
+		if data.Name == "." || data.Name == ".." || strings.ContainsAny(data.Name, "/\\") {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The archive name must not contain a path separator.",
+			})
+			return
+		}
+		ext, _ := filesystem.ArchiveExtension(data.Extension)
+		target = path.Join(data.RootPath, data.Name+ext)
+	}
+	if err := policy.Check(acl.FileCreate, target); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+
 	if !s.Filesystem().HasSpaceAvailable(true) {
 		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
 			"error": "This server does not have enough available disk space to generate a compressed archive.",
@@ -462,16 +1071,24 @@ func postServerCompressFiles(c *gin.Context) {
 	// The extention comes from the panel
 	// Supported are: zip, tar.gz, tar.bz2, tar.xz
 	// No need to check if it is empty or wrong as if data.Extention is wrong the function falls back to tar.gz
-	f, mimetype, err := s.Filesystem().CompressFiles(data.RootPath, data.Name, data.Files, data.Extension)
+	run := func(ctx context.Context, p *progress.Progress) (interface{}, error) {
+		f, mimetype, err := s.Filesystem().CompressFiles(ctx, data.RootPath, data.Name, data.Files, data.Extension, p)
+		if err != nil {
+			return nil, err
+		}
+		return &filesystem.Stat{FileInfo: f, Mimetype: mimetype}, nil
+	}
+	if startServerJob(c, s, server.JobCompress, run) {
+		return
+	}
+
+	st, err := run(c.Request.Context(), nil)
 	if err != nil {
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, &filesystem.Stat{
-		FileInfo: f,
-		Mimetype: mimetype,
-	})
+	c.JSON(http.StatusOK, st)
 }
 
 // postServerDecompressFiles receives the HTTP request and starts the process
@@ -487,24 +1104,118 @@ func postServerDecompressFiles(c *gin.Context) {
 	}
 
 	s := middleware.ExtractServer(c)
+	policy := middleware.ExtractPolicy(c)
+	if err := policy.Check(acl.FileReadContent, path.Join(data.RootPath, data.File)); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := policy.Check(acl.FileCreate, data.RootPath); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
 	lg := middleware.ExtractLogger(c).WithFields(log.Fields{"root_path": data.RootPath, "file": data.File})
-	lg.Debug("checking if space is available for file decompression")
-	err := s.Filesystem().SpaceAvailableForDecompression(context.Background(), data.RootPath, data.File)
-	if err != nil {
+	run := func(ctx context.Context, p *progress.Progress) (interface{}, error) {
+		lg.Debug("checking if space is available for file decompression")
+		if err := s.Filesystem().SpaceAvailableForDecompression(ctx, data.RootPath, data.File); err != nil {
+			return nil, err
+		}
+		lg.Info("starting file decompression")
+		return nil, s.Filesystem().DecompressFile(ctx, data.RootPath, data.File, p)
+	}
+	if startServerJob(c, s, server.JobDecompress, run) {
+		return
+	}
+
+	if _, err := run(context.Background(), nil); err != nil {
 		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
 			lg.WithField("error", err).Warn("failed to decompress file: unknown archive format")
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The archive provided is in a format Wings does not understand."})
 			return
 		}
+		// If the file is busy for some reason just return a nicer error to the user since there is not
+		// much we specifically can do. They'll need to stop the running server process in order to overwrite
+		// a file like this.
+		if strings.Contains(err.Error(), "text file busy") {
+			lg.WithField("error", errors.WithStackIf(err)).Warn("failed to decompress file: text file busy")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "One or more files this archive is attempting to overwrite are currently in use by another process. Please try again.",
+			})
+			return
+		}
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// getServerListArchive returns all the entries contained within an archive on
+// the server without extracting it.
+func getServerListArchive(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	if err := middleware.ExtractPolicy(c).Check(acl.FileReadContent, path.Join(c.Query("root"), c.Query("file"))); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	entries, err := s.Filesystem().ListArchive(c.Request.Context(), c.Query("root"), c.Query("file"))
+	if err != nil {
+		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The archive provided is in a format Wings does not understand."})
+			return
+		}
 		middleware.CaptureAndAbort(c, err)
 		return
 	}
+	if entries == nil {
+		entries = []filesystem.ArchiveEntry{}
+	}
+	c.JSON(http.StatusOK, entries)
+}
 
-	lg.Info("starting file decompression")
-	if err := s.Filesystem().DecompressFile(context.Background(), data.RootPath, data.File); err != nil {
-		// If the file is busy for some reason just return a nicer error to the user since there is not
-		// much we specifically can do. They'll need to stop the running server process in order to overwrite
-		// a file like this.
+// postServerDecompressEntries extracts only the requested entries of an archive
+// on the server into the provided destination directory.
+func postServerDecompressEntries(c *gin.Context) {
+	var data struct {
+		RootPath    string   `json:"root"`
+		File        string   `json:"file"`
+		Destination string   `json:"destination"`
+		Entries     []string `json:"entries"`
+	}
+	if err := c.BindJSON(&data); err != nil {
+		return
+	}
+
+	if len(data.Entries) == 0 {
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"error": "No archive entries to extract were provided.",
+		})
+		return
+	}
+
+	s := middleware.ExtractServer(c)
+	policy := middleware.ExtractPolicy(c)
+	if err := policy.Check(acl.FileReadContent, path.Join(data.RootPath, data.File)); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	if err := policy.Check(acl.FileCreate, data.Destination); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	lg := middleware.ExtractLogger(c).WithFields(log.Fields{"root_path": data.RootPath, "file": data.File, "destination": data.Destination})
+	run := func(ctx context.Context, _ *progress.Progress) (interface{}, error) {
+		lg.Info("starting partial file decompression")
+		return nil, s.Filesystem().ExtractArchiveEntries(ctx, data.RootPath, data.File, data.Destination, data.Entries)
+	}
+	if startServerJob(c, s, server.JobDecompress, run) {
+		return
+	}
+
+	if _, err := run(context.Background(), nil); err != nil {
+		if filesystem.IsErrorCode(err, filesystem.ErrCodeUnknownArchive) {
+			lg.WithField("error", err).Warn("failed to decompress file: unknown archive format")
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "The archive provided is in a format Wings does not understand."})
+			return
+		}
 		if strings.Contains(err.Error(), "text file busy") {
 			lg.WithField("error", errors.WithStackIf(err)).Warn("failed to decompress file: text file busy")
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
@@ -544,37 +1255,51 @@ func postServerChmodFile(c *gin.Context) {
 		})
 		return
 	}
+	for _, p := range data.Files {
+		if err := middleware.ExtractPolicy(c).Check(acl.FileUpdate, path.Join(data.Root, p.File)); err != nil {
+			middleware.CaptureAndAbort(c, err)
+			return
+		}
+	}
 
-	g, ctx := errgroup.WithContext(context.Background())
+	run := func(ctx context.Context, prog *progress.Progress) (interface{}, error) {
+		prog.SetTotal(uint64(len(data.Files)))
+		g, ctx := errgroup.WithContext(ctx)
+
+		// Loop over the array of files passed in and perform the move or rename action against each.
+		for _, p := range data.Files {
+			g.Go(func() error {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				default:
+					defer prog.Add(1)
+					mode, err := strconv.ParseUint(p.Mode, 8, 32)
+					if err != nil {
+						return errInvalidFileMode
+					}
 
-	// Loop over the array of files passed in and perform the move or rename action against each.
-	for _, p := range data.Files {
-		g.Go(func() error {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-				mode, err := strconv.ParseUint(p.Mode, 8, 32)
-				if err != nil {
-					return errInvalidFileMode
-				}
+					if err := s.Filesystem().Chmod(path.Join(data.Root, p.File), os.FileMode(mode)); err != nil {
+						// Return nil if the error is an is not exists.
+						// NOTE: os.IsNotExist() does not work if the error is wrapped.
+						if errors.Is(err, os.ErrNotExist) {
+							return nil
+						}
 
-				if err := s.Filesystem().Chmod(path.Join(data.Root, p.File), os.FileMode(mode)); err != nil {
-					// Return nil if the error is an is not exists.
-					// NOTE: os.IsNotExist() does not work if the error is wrapped.
-					if errors.Is(err, os.ErrNotExist) {
-						return nil
+						return err
 					}
 
-					return err
+					return nil
 				}
-
-				return nil
-			}
-		})
+			})
+		}
+		return nil, g.Wait()
+	}
+	if startServerJob(c, s, server.JobChmod, run) {
+		return
 	}
 
-	if err := g.Wait(); err != nil {
+	if _, err := run(context.Background(), progress.NewProgress(0)); err != nil {
 		if errors.Is(err, errInvalidFileMode) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"error": "Invalid file mode.",
@@ -623,6 +1348,13 @@ func postServerUploadFiles(c *gin.Context) {
 	}
 
 	directory := c.Query("directory")
+	for _, header := range headers {
+		p := filepath.Join(directory, header.Filename)
+		if !token.Allows(writePermission(s, p), p) {
+			middleware.CaptureAndAbort(c, acl.ErrPermissionDenied)
+			return
+		}
+	}
 
 	maxFileSize := config.Get().Api.UploadLimit
 	maxFileSizeBytes := maxFileSize * 1024 * 1024
@@ -668,3 +1400,12 @@ func handleFileUpload(p string, s *server.Server, header *multipart.FileHeader)
 	}
 	return nil
 }
+
+// writePermission returns the permission required to write to the given path,
+// which depends on whether or not there is already a file there.
+func writePermission(s *server.Server, p string) string {
+	if _, err := s.Filesystem().Stat(p); err == nil {
+		return acl.FileUpdate
+	}
+	return acl.FileCreate
+}
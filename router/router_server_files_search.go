@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/internal/ufs"
 	"github.com/pelican/wings/router/middleware"
 	"github.com/pelican/wings/server"
@@ -62,11 +63,15 @@ func searchDirectory(s *server.Server, dir string, patternLower string, depth in
 		return
 	}
 
+	policy := middleware.ExtractPolicy(c)
 	for _, fileInfo := range stats {
 		fileName := fileInfo.Name()
 		fileType := fileInfo.Mimetype
 		fileNameLower := strings.ToLower(fileName)
 		fullPath := filepath.Join(dir, fileName)
+		if !policy.Visible(fullPath) {
+			continue
+		}
 
 		// Store directories separately
 		if fileType == "inode/directory" {
@@ -118,6 +123,11 @@ func getFilesBySearch(c *gin.Context) {
 		return
 	}
 
+	if !middleware.ExtractPolicy(c).Visible(dir) {
+		middleware.CaptureAndAbort(c, acl.ErrPermissionDenied)
+		return
+	}
+
 	// Prepare slices to store matched stats and directories
 	matchedEntries := []filesystem.Stat{}
 	matchedDirectories := []string{}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"net/http"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pelican/wings/router/middleware"
+	"github.com/pelican/wings/server"
+)
+
+// startServerJob starts the given function as a background job for the server
+// if the request was made with "?background=true", responding with the job that
+// was created. If the request did not ask for a background job false is returned
+// and nothing is done, leaving the caller to run the function itself.
+func startServerJob(c *gin.Context, s *server.Server, t server.JobType, fn server.JobFunc) bool {
+	if c.Query("background") != "true" {
+		return false
+	}
+	j, err := s.Jobs().Start(t, fn)
+	if err != nil {
+		abortWithJobError(c, err)
+		return true
+	}
+	c.JSON(http.StatusAccepted, gin.H{"job": j})
+	return true
+}
+
+// abortWithJobError aborts the request with the error returned when starting a
+// background job.
+func abortWithJobError(c *gin.Context, err error) {
+	if errors.Is(err, server.ErrTooManyJobs) {
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error": "This server has reached its limit of running background file operations. Please wait for one to complete before trying again.",
+		})
+		return
+	}
+	middleware.CaptureAndAbort(c, err)
+}
+
+// getServerJobs returns all the background jobs that are running or have
+// recently finished for the server.
+func getServerJobs(c *gin.Context) {
+	s := ExtractServer(c)
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": s.Jobs().List(),
+	})
+}
+
+// getServerJob returns the current state of a single background job.
+func getServerJob(c *gin.Context) {
+	s := ExtractServer(c)
+	j, ok := s.Jobs().Get(c.Param("job"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested job was not found.",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, j)
+}
+
+// deleteServerJob cancels a running background job. Jobs that have already
+// finished are left untouched.
+func deleteServerJob(c *gin.Context) {
+	s := ExtractServer(c)
+	j, ok := s.Jobs().Get(c.Param("job"))
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "The requested job was not found.",
+		})
+		return
+	}
+	j.Cancel()
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"emperror.dev/errors"
+	"github.com/gin-gonic/gin"
+
+	"github.com/pelican/wings/internal/progress"
+	"github.com/pelican/wings/router/middleware"
+	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
+)
+
+// getServerSnapshots returns the safety snapshots taken of a server's files
+// which have not expired yet, most recent first.
+func getServerSnapshots(c *gin.Context) {
+	snapshots, err := backup.Snapshots(middleware.ExtractServer(c).ID())
+	if err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// postServerRestoreSnapshot replaces every file of the server with the files
+// of one of its snapshots, stopping the server first.
+func postServerRestoreSnapshot(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	id, ok := parseBackupUuid(c, c.Param("snapshot"))
+	if !ok {
+		return
+	}
+	if _, _, err := backup.LocateSnapshot(s.ID(), id); err != nil {
+		abortWithSnapshotError(c, err)
+		return
+	}
+	if s.IsRestoring() {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "This server is already being restored."})
+		return
+	}
+
+	middleware.ExtractLogger(c).WithField("snapshot", id).Info("restoring server from snapshot")
+	s.SetRestoring(true)
+	run := func(ctx context.Context, _ *progress.Progress) (interface{}, error) {
+		defer s.SetRestoring(false)
+		if err := s.RestoreSnapshot(ctx, id); err != nil {
+			return nil, err
+		}
+		s.Events().Publish(server.DaemonMessageEvent, "Completed server restoration from snapshot.")
+		s.Events().Publish(server.BackupRestoreCompletedEvent, "")
+		return nil, nil
+	}
+	if startServerJob(c, s, server.JobRestore, run) {
+		if c.IsAborted() {
+			s.SetRestoring(false)
+		}
+		return
+	}
+	if _, err := run(c.Request.Context(), progress.NewProgress(0)); err != nil {
+		abortWithSnapshotError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// deleteServerSnapshot deletes a snapshot of a server before it expires.
+func deleteServerSnapshot(c *gin.Context) {
+	s := middleware.ExtractServer(c)
+	id, ok := parseBackupUuid(c, c.Param("snapshot"))
+	if !ok {
+		return
+	}
+	snapshot, _, err := backup.LocateSnapshot(s.ID(), id)
+	if err != nil {
+		abortWithSnapshotError(c, err)
+		return
+	}
+	if err := backup.RemoveSnapshot(*snapshot); err != nil {
+		middleware.CaptureAndAbort(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// abortWithSnapshotError aborts the request with the error returned while
+// finding or restoring a snapshot.
+func abortWithSnapshotError(c *gin.Context, err error) {
+	if errors.Is(err, os.ErrNotExist) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "The requested snapshot was not found on this server."})
+		return
+	}
+	middleware.CaptureAndAbort(c, err)
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/pelican/wings/router/middleware"
 	"github.com/pelican/wings/router/tokens"
 	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/backup"
 	"github.com/pelican/wings/server/installer"
 	"github.com/pelican/wings/server/transfer"
 )
@@ -305,6 +306,15 @@ out:
 
 				trnsfr.Log().WithField("backup", backupName).Debug("backup streamed to disk successfully")
 
+			case strings.HasPrefix(name, "chunk_"):
+				// Chunks of deduplicated backups are verified against their
+				// identifier, which is the checksum of their contents.
+				id := strings.TrimPrefix(name, "chunk_")
+				if err := backup.ReceiveChunk(ctx, id, p); err != nil {
+					middleware.CaptureAndAbort(c, fmt.Errorf("failed to receive backup chunk %s: %w", id, err))
+					return
+				}
+
 			case strings.HasPrefix(name, "checksum_backup_"):
 				backupName := strings.TrimPrefix(name, "checksum_backup_")
 				trnsfr.Log().WithField("backup", backupName).Debug("received backup checksum")
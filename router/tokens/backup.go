@@ -11,6 +11,9 @@ type BackupPayload struct {
 	UserUuid   string `json:"user_uuid"`
 	BackupUuid string `json:"backup_uuid"`
 	UniqueId   string `json:"unique_id"`
+	// EncryptionKey is the key the backup was encrypted with, if it was not
+	// encrypted with the key configured for the node.
+	EncryptionKey string `json:"encryption_key,omitempty"`
 	Scoped
 }
 
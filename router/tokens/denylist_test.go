@@ -46,6 +46,11 @@ func payloadsIssuedAt(issuedAt time.Time, serverUUID, userUUID string) map[strin
 			ServerUuid: serverUUID,
 			UserUuid:   userUUID,
 		},
+		"directory download": &DirectoryPayload{
+			Payload:    payload(),
+			ServerUuid: serverUUID,
+			UserUuid:   userUUID,
+		},
 		"backup download": &BackupPayload{
 			Payload:    payload(),
 			ServerUuid: serverUUID,
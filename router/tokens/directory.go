@@ -0,0 +1,39 @@
+package tokens
+
+import (
+	"github.com/gbrlsnchs/jwt/v3"
+)
+
+// DirectoryPayload is used to authorize streaming an entire directory of a
+// server to a client as a single archive.
+type DirectoryPayload struct {
+	jwt.Payload
+	DirectoryPath string `json:"directory_path"`
+	// Format is the archive format to stream the directory as, either "zip"
+	// or "tar.gz". Defaults to "tar.gz" when empty.
+	Format     string `json:"format"`
+	ServerUuid string `json:"server_uuid"`
+	UserUuid   string `json:"user_uuid"`
+	UniqueId   string `json:"unique_id"`
+	Scoped
+	PathScoped
+}
+
+// Returns the JWT payload.
+func (p *DirectoryPayload) GetPayload() *jwt.Payload {
+	return &p.Payload
+}
+
+// Determines if this JWT is valid for the given request cycle. If the
+// unique ID passed in the token has already been seen before this will
+// return false. This allows us to use this JWT as a one-time token that
+// validates all of the request.
+func (p *DirectoryPayload) IsUniqueRequest() bool {
+	return getTokenStore().IsValidToken(p.UniqueId)
+}
+
+// Denylisted returns true if this token was issued before the user's access to
+// the server was revoked.
+func (p *DirectoryPayload) Denylisted() bool {
+	return isDenylisted(&p.Payload, p.ServerUuid, p.UserUuid)
+}
@@ -2,16 +2,19 @@ package tokens
 
 import (
 	"strings"
+
+	"github.com/pelican/wings/internal/acl"
 )
 
 type JwtScope string
 
 const (
-	Websocket      = JwtScope("websocket")
-	FileUpload     = JwtScope("file-upload")
-	FileDownload   = JwtScope("file-download")
-	BackupDownload = JwtScope("backup-download")
-	ServerTransfer = JwtScope("transfer")
+	Websocket         = JwtScope("websocket")
+	FileUpload        = JwtScope("file-upload")
+	FileDownload      = JwtScope("file-download")
+	DirectoryDownload = JwtScope("directory-download")
+	BackupDownload    = JwtScope("backup-download")
+	ServerTransfer    = JwtScope("transfer")
 )
 
 type Scoped struct {
@@ -31,3 +34,27 @@ func (s Scoped) HasScope(scope JwtScope) bool {
 
 	return false
 }
+
+// PathScoped is embedded in tokens which act on files for a user, it holds the
+// grants limiting the user to specific paths when the Panel provides them.
+type PathScoped struct {
+	Grants []acl.Grant `json:"grants,omitempty"`
+}
+
+// Allows returns true if the token allows the permission on every one of the
+// given paths. Tokens without any grants are not limited to specific paths.
+func (s PathScoped) Allows(permission string, paths ...string) bool {
+	if len(s.Grants) == 0 {
+		return true
+	}
+	return acl.New(nil, s.Grants).Check(permission, paths...) == nil
+}
+
+// Visible returns true if the token allows the given path to be seen, either
+// because it is granted or because it leads to something that is.
+func (s PathScoped) Visible(p string) bool {
+	if len(s.Grants) == 0 {
+		return true
+	}
+	return acl.New(nil, s.Grants).Visible(p)
+}
@@ -11,6 +11,7 @@ type UploadPayload struct {
 	UserUuid   string `json:"user_uuid"`
 	UniqueId   string `json:"unique_id"`
 	Scoped
+	PathScoped
 }
 
 // Returns the JWT payload.
@@ -83,6 +83,7 @@ type WebsocketPayload struct {
 	jwt.Payload
 	sync.RWMutex
 	Scoped
+	PathScoped
 
 	UserUUID    string   `json:"user_uuid"`
 	ServerUUID  string   `json:"server_uuid"`
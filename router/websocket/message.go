@@ -11,6 +11,12 @@ const (
 	SendServerLogsEvent        = "send logs"
 	SendCommandEvent           = "send command"
 	SendStatsEvent             = "send stats"
+	WatchFilesEvent            = "watch files"
+	UnwatchFilesEvent          = "unwatch files"
+	FileChangesEvent           = "file changes"
+	TailFileEvent              = "tail file"
+	UntailFileEvent            = "untail file"
+	TailOutputEvent            = "tail output"
 	ErrorEvent                 = "daemon error"
 	JwtErrorEvent              = "jwt error"
 	ThrottledEvent             = Event("throttled")
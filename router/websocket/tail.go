@@ -0,0 +1,118 @@
+package websocket
+
+import (
+	"context"
+	"path"
+	"strconv"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+
+	"github.com/pelican/wings/internal/acl"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// The number of files that can be followed by a single connection at once.
+const maxTailsPerConnection = 8
+
+// The number of lines sent from the end of a file when it starts being followed,
+// if the client does not ask for a specific number.
+const defaultTailLines = 100
+
+// tailFile starts following a file in the server directory, sending the lines
+// written to it over the socket until it is untailed or the connection is closed.
+// The arguments are the file and optionally how many lines from the end of it to
+// send first. If no file is given every log file declared by the egg is followed.
+func (h *Handler) tailFile(ctx context.Context, args []string) error {
+	var files []string
+	requested := len(args) > 0 && args[0] != ""
+	if requested {
+		files = []string{args[0]}
+	} else {
+		files = h.server.LogFiles()
+	}
+	if len(files) == 0 {
+		return errors.New("websocket: no file was provided to tail")
+	}
+	lines := defaultTailLines
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return errors.New("websocket: the number of lines must be a positive number")
+		}
+		lines = min(n, filesystem.MaxTailLines)
+	}
+
+	for _, f := range files {
+		f = path.Clean("/" + f)
+		if err := h.server.Filesystem().IsIgnored(f); err != nil {
+			// A denied log file declared by the egg shouldn't stop the rest of
+			// them from being followed.
+			if !requested {
+				continue
+			}
+			return err
+		}
+		if !h.GetJwt().Allows(acl.FileReadContent, f) {
+			continue
+		}
+		if err := h.startTail(ctx, f, lines); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Handler) startTail(ctx context.Context, file string, lines int) error {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	if cancel, ok := h.tails[file]; ok {
+		cancel()
+		delete(h.tails, file)
+	}
+	if len(h.tails) >= maxTailsPerConnection {
+		return errors.New("websocket: too many files are being tailed by this connection")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := h.server.Filesystem().Tail(ctx, file, lines)
+	if err != nil {
+		cancel()
+		return err
+	}
+	h.tails[file] = cancel
+
+	go func() {
+		defer cancel()
+		for ev := range ch {
+			// The token may have been replaced with one that can no longer read
+			// the file since it started being followed.
+			if j := h.GetJwt(); j == nil || !j.Allows(acl.FileReadContent, file) {
+				continue
+			}
+			b, err := json.Marshal(struct {
+				File string `json:"file"`
+				filesystem.TailEvent
+			}{File: file, TailEvent: ev})
+			if err != nil {
+				continue
+			}
+			if err := h.SendJson(Message{Event: TailOutputEvent, Args: []string{string(b)}}); err != nil {
+				h.Logger().WithField("error", err).Debug("failed to send tail output over websocket")
+			}
+		}
+	}()
+	return nil
+}
+
+// untailFile stops following the given file, or every file being followed if
+// none is given.
+func (h *Handler) untailFile(file string) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	for p, cancel := range h.tails {
+		if file == "" || p == path.Clean("/"+file) {
+			cancel()
+			delete(h.tails, p)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package websocket
+
+import (
+	"context"
+	"path"
+	"strconv"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+
+	"github.com/pelican/wings/internal/acl"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// watchFiles starts watching a directory of the server for changes, sending them
+// over the socket until the directory is unwatched or the connection is closed. The
+// arguments are the directory and optionally how many levels beneath it to watch.
+// Watching a directory that is already being watched replaces the existing watch.
+func (h *Handler) watchFiles(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "" {
+		return errors.New("websocket: no directory was provided to watch")
+	}
+	dir := path.Clean("/" + args[0])
+	depth := 1
+	if len(args) > 1 {
+		d, err := strconv.Atoi(args[1])
+		if err != nil {
+			return errors.New("websocket: watch depth must be a number")
+		}
+		depth = d
+	}
+	if err := h.server.Filesystem().IsIgnored(dir); err != nil {
+		return err
+	}
+	if !h.GetJwt().Visible(dir) {
+		return errors.WithStack(acl.ErrPermissionDenied)
+	}
+
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	// The previous watch has to have fully stopped before starting the new one,
+	// otherwise it would still count towards the limit of watches on the server.
+	if w, ok := h.watches[dir]; ok {
+		w.stop()
+		delete(h.watches, dir)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := h.server.Filesystem().Watch(ctx, dir, depth)
+	if err != nil {
+		cancel()
+		return err
+	}
+	w := &fileWatch{cancel: cancel, done: make(chan struct{})}
+	h.watches[dir] = w
+
+	go func() {
+		defer cancel()
+		for changes := range ch {
+			h.sendFileChanges(changes)
+		}
+		// The channel is closed once the watch has ended, either because it was
+		// stopped or because the directory is gone, so it is no longer needed.
+		close(w.done)
+		h.watchMu.Lock()
+		if h.watches[dir] == w {
+			delete(h.watches, dir)
+		}
+		h.watchMu.Unlock()
+	}()
+	return nil
+}
+
+// unwatchFiles stops watching the given directory, or every directory being
+// watched if none is given.
+func (h *Handler) unwatchFiles(dir string) {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	for p, w := range h.watches {
+		if dir == "" || p == path.Clean("/"+dir) {
+			w.cancel()
+			delete(h.watches, p)
+		}
+	}
+}
+
+// fileWatch is a directory being watched by the connection.
+type fileWatch struct {
+	cancel context.CancelFunc
+	// done is closed once the watch has stopped and no longer counts towards
+	// the limit of watches on the server.
+	done chan struct{}
+}
+
+// stop stops the watch and waits for it to end.
+func (w *fileWatch) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// sendFileChanges sends the changes which the token is allowed to see over the
+// socket. This is checked for every batch since the token may have been replaced
+// with one that has access to different paths since the watch started.
+func (h *Handler) sendFileChanges(changes []filesystem.FileChange) {
+	j := h.GetJwt()
+	if j == nil {
+		return
+	}
+	visible := make([]filesystem.FileChange, 0, len(changes))
+	for _, c := range changes {
+		// Anything renamed to or from a path that cannot be seen looks the same
+		// as it being created or deleted.
+		if c.From != "" && !j.Visible(c.From) {
+			c.Action, c.From = filesystem.FileChangeCreate, ""
+		}
+		if !j.Visible(c.Path) {
+			if c.From == "" {
+				continue
+			}
+			c.Action, c.Path, c.From = filesystem.FileChangeDelete, c.From, ""
+		}
+		visible = append(visible, c)
+	}
+	if len(visible) == 0 {
+		return
+	}
+	b, err := json.Marshal(visible)
+	if err != nil {
+		return
+	}
+	if err := h.SendJson(Message{Event: FileChangesEvent, Args: []string{string(b)}}); err != nil {
+		h.Logger().WithField("error", err).Debug("failed to send file changes over websocket")
+	}
+}
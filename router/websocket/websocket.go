@@ -36,6 +36,9 @@ const (
 	PermissionReceiveInstall   = "admin.websocket.install"
 	PermissionReceiveTransfer  = "admin.websocket.transfer"
 	PermissionReceiveBackups   = "backup.read"
+	PermissionReceiveJobs      = "file.read"
+	PermissionWatchFiles       = "file.read"
+	PermissionTailFiles        = "file.read-content"
 )
 
 type Handler struct {
@@ -46,6 +49,10 @@ type Handler struct {
 	ra           server.RequestActivity
 	uuid         uuid.UUID
 	limiter      *LimiterBucket
+
+	watchMu sync.Mutex
+	watches map[string]*fileWatch
+	tails   map[string]context.CancelFunc
 }
 
 var (
@@ -121,6 +128,8 @@ func GetHandler(s *server.Server, w http.ResponseWriter, r *http.Request, c *gin
 		ra:         s.NewRequestActivity("", c.ClientIP()),
 		uuid:       u,
 		limiter:    NewLimiter(),
+		watches:    make(map[string]*fileWatch),
+		tails:      make(map[string]context.CancelFunc),
 	}, nil
 }
 
@@ -155,12 +164,32 @@ func (h *Handler) SendJson(v Message) error {
 
 		// If the user does not have permission to see backup events, do not emit
 		// them over the socket.
-		if strings.HasPrefix(string(v.Event), server.BackupCompletedEvent) {
+		if strings.HasPrefix(string(v.Event), server.BackupCompletedEvent) || strings.HasPrefix(string(v.Event), server.BackupProgressEvent) {
 			if !j.HasPermission(PermissionReceiveBackups) {
 				return nil
 			}
 		}
 
+		// Background file jobs can include file names in their results, so only
+		// send them to users who are able to read files.
+		if v.Event == server.JobProgressEvent || v.Event == server.JobCompletedEvent {
+			if !j.HasPermission(PermissionReceiveJobs) {
+				return nil
+			}
+		}
+
+		if v.Event == FileChangesEvent {
+			if !j.HasPermission(PermissionWatchFiles) {
+				return nil
+			}
+		}
+
+		if v.Event == TailOutputEvent {
+			if !j.HasPermission(PermissionTailFiles) {
+				return nil
+			}
+		}
+
 		// If we are sending transfer output, only send it to the user if they have the required permissions.
 		if v.Event == server.TransferLogsEvent {
 			if !j.HasPermission(PermissionReceiveTransfer) {
@@ -425,6 +454,32 @@ func (h *Handler) HandleInbound(ctx context.Context, m Message) error {
 				Args:  []string{string(b)},
 			})
 
+			return nil
+		}
+	case WatchFilesEvent:
+		{
+			if !h.GetJwt().HasPermission(PermissionWatchFiles) {
+				return nil
+			}
+
+			return h.watchFiles(ctx, m.Args)
+		}
+	case UnwatchFilesEvent:
+		{
+			h.unwatchFiles(strings.Join(m.Args, ""))
+			return nil
+		}
+	case TailFileEvent:
+		{
+			if !h.GetJwt().HasPermission(PermissionTailFiles) {
+				return nil
+			}
+
+			return h.tailFile(ctx, m.Args)
+		}
+	case UntailFileEvent:
+		{
+			h.untailFile(strings.Join(m.Args, ""))
 			return nil
 		}
 	case SendCommandEvent:
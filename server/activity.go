@@ -18,9 +18,12 @@ const (
 	ActivitySftpCreate          = models.Event("server:sftp.create")
 	ActivitySftpCreateDirectory = models.Event("server:sftp.create-directory")
 	ActivitySftpRename          = models.Event("server:sftp.rename")
+	ActivitySftpCopy            = models.Event("server:sftp.copy")
 	ActivitySftpDelete          = models.Event("server:sftp.delete")
 	ActivityFileUploaded        = models.Event("server:file.uploaded")
 	ActivityServerCrashed       = models.Event("server:crashed")
+	ActivityDiskThreshold       = models.Event("server:disk.threshold")
+	ActivityBackupVerified      = models.Event("server:backup.verify")
 )
 
 // RequestActivity is a wrapper around a LoggedEvent that is able to track additional request
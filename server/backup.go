@@ -1,9 +1,11 @@
 package server
 
 import (
+	"context"
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"time"
 
 	"emperror.dev/errors"
@@ -11,6 +13,8 @@ import (
 	"github.com/docker/docker/client"
 
 	"github.com/pelican/wings/environment"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/internal/progress"
 	"github.com/pelican/wings/remote"
 	"github.com/pelican/wings/server/backup"
 )
@@ -33,8 +37,9 @@ func (s *Server) notifyPanelOfBackup(uuid string, ad *backup.ArchiveDetails, suc
 	return nil
 }
 
-// Get all of the ignored files for a server based on its .pelicanignore file in the root.
-func (s *Server) getServerwideIgnoredFiles() (string, error) {
+// ServerwideIgnoredFiles returns all of the ignored files for a server based on
+// its .pelicanignore file in the root.
+func (s *Server) ServerwideIgnoredFiles() (string, error) {
 	f, st, err := s.Filesystem().File(".pelicanignore")
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -60,14 +65,33 @@ func (s *Server) getServerwideIgnoredFiles() (string, error) {
 func (s *Server) Backup(b backup.BackupInterface) error {
 	ignored := b.Ignored()
 	if b.Ignored() == "" {
-		if i, err := s.getServerwideIgnoredFiles(); err != nil {
+		if i, err := s.ServerwideIgnoredFiles(); err != nil {
 			log.WithField("server", s.ID()).WithField("error", err).Warn("failed to get server-wide ignored files")
 		} else {
 			ignored = i
 		}
 	}
 
+	b.SetProgressCallback(func(uploaded int64, total int64) {
+		s.Events().Publish(BackupProgressEvent+":"+b.Identifier(), map[string]interface{}{
+			"uuid":     b.Identifier(),
+			"uploaded": uploaded,
+			"total":    total,
+		})
+	})
+
+	// The hooks are finished as soon as the files of the server have been
+	// archived, rather than waiting for the backup to finish uploading. They
+	// are not run at all when an interrupted upload is resumed, since the files
+	// were already archived.
+	hooks := &backupHookRun{s: s}
+	if r, ok := b.(interface{ ResumesUpload() bool }); !ok || !r.ResumesUpload() {
+		hooks = s.runPreBackupHooks(s.Context())
+	}
+	b.SetArchivedCallback(hooks.finish)
+
 	ad, err := b.Generate(s.Context(), s.Filesystem(), ignored)
+	hooks.finish()
 	if err != nil {
 		if err := s.notifyPanelOfBackup(b.Identifier(), &backup.ArchiveDetails{}, false); err != nil {
 			s.Log().WithFields(log.Fields{
@@ -84,6 +108,7 @@ func (s *Server) Backup(b backup.BackupInterface) error {
 			"checksum":      "",
 			"checksum_type": "sha1",
 			"file_size":     0,
+			"hook_errors":   hooks.Errors(),
 		})
 
 		return errors.WrapIf(err, "backup: error while generating server backup")
@@ -108,27 +133,83 @@ func (s *Server) Backup(b backup.BackupInterface) error {
 		"checksum":      ad.Checksum,
 		"checksum_type": "sha1",
 		"file_size":     ad.Size,
+		"hook_errors":   hooks.Errors(),
 	})
 
 	return nil
 }
 
+// ResumeBackupUploads resumes uploading any backups to S3 which were interrupted
+// by Wings stopping before the upload completed. Uploads of backups belonging to
+// servers which no longer exist on this node are discarded.
+func (m *Manager) ResumeBackupUploads() {
+	uploads, err := backup.ResumableUploads()
+	if err != nil {
+		log.WithField("error", err).Error("failed to retrieve interrupted backup uploads")
+		return
+	}
+	for _, u := range uploads {
+		s, ok := m.Get(u.Server)
+		if !ok {
+			if err := backup.AbandonUpload(u); err != nil {
+				log.WithField("backup", u.Backup).WithField("error", err).Warn("failed to discard backup upload for missing server")
+			}
+			continue
+		}
+		s.Log().WithField("backup", u.Backup).Info("resuming interrupted upload of backup")
+		go func(b backup.BackupInterface) {
+			if err := s.Backup(b); err != nil {
+				s.Log().WithField("backup", b.Identifier()).WithField("error", err).Error("failed to resume upload of backup")
+			}
+		}(backup.NewS3(m.client, u.Backup, u.Server, ""))
+	}
+}
+
+// VerifyBackup reads the entire backup to confirm that it is still intact, and
+// reports the result to the Panel and as an activity of the server. A backup
+// which is not intact is described by the returned result, an error is only
+// returned if the backup could not be verified at all.
+func (s *Server) VerifyBackup(ctx context.Context, b backup.BackupInterface, reader io.Reader, checksum string) (remote.BackupVerificationRequest, error) {
+	res, err := backup.Verify(ctx, b, reader, checksum)
+	if res == nil {
+		return remote.BackupVerificationRequest{}, err
+	}
+	req := res.ToRequest(err)
+	logger := s.Log().WithField("backup", b.Identifier())
+	if err != nil {
+		logger.WithField("error", err).Warn("backup failed verification")
+	} else if !res.ChecksumVerified {
+		logger.WithField("files", res.Files).Warn("verified files of backup are readable, but there is no checksum to verify the backup with")
+	} else {
+		logger.WithField("files", res.Files).Info("verified backup is intact")
+	}
+
+	if err := s.client.SendBackupVerification(s.Context(), b.Identifier(), req); err != nil {
+		logger.WithField("error", err).Warn("failed to notify panel of backup verification")
+	}
+	s.SaveActivity(s.NewRequestActivity("", "127.0.0.1"), ActivityBackupVerified, models.ActivityMeta{
+		"backup":            b.Identifier(),
+		"successful":        req.Successful,
+		"checksum":          req.Checksum,
+		"checksum_verified": req.ChecksumVerified,
+		"files":             req.Files,
+		"error":             req.Error,
+	})
+	return req, nil
+}
+
 // RestoreBackup calls the Restore function on the provided backup. Once this
 // restoration is completed an event is emitted to the websocket to notify the
 // Panel that is has been completed.
 //
 // In addition to the websocket event an API call is triggered to notify the
-// Panel of the new state.
-func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (err error) {
+// Panel of the new state. If truncate is true every file of the server is
+// deleted before the backup is restored, after taking a safety snapshot of them
+// if enabled. Backups which are not stored on this machine are read from the
+// reader returned by open, which is only called once the snapshot was taken.
+func (s *Server) RestoreBackup(b backup.BackupInterface, open func() (io.ReadCloser, error), truncate bool) (err error) {
 	s.Config().SetSuspended(true)
-	// Local backups will not pass a reader through to this function, so check first
-	// to make sure it is a valid reader before trying to close it.
-	defer func() {
-		s.Config().SetSuspended(false)
-		if reader != nil {
-			_ = reader.Close()
-		}
-	}()
+	defer s.Config().SetSuspended(false)
 	// Send an API call to the Panel as soon as this function is done running so that
 	// the Panel is informed of the restoration status of this backup.
 	defer func() {
@@ -137,32 +218,93 @@ func (s *Server) RestoreBackup(b backup.BackupInterface, reader io.ReadCloser) (
 		}
 	}()
 
+	if err = s.waitForRestoreStop(); err != nil {
+		return err
+	}
+	if truncate {
+		if err = s.TakeSafetySnapshot(s.Context(), SnapshotRestore); err != nil {
+			return errors.WrapIf(err, "server/backup: restore")
+		}
+	}
+
+	// Taking the snapshot could take longer than a download of the backup would
+	// stay open for, so the backup is only opened now. It is opened before any
+	// files are deleted so that a backup which cannot be opened leaves the
+	// server as it was.
+	var reader io.ReadCloser
+	if open != nil {
+		if reader, err = open(); err != nil {
+			return errors.WrapIf(err, "server/backup: restore: failed to open backup")
+		}
+		defer reader.Close()
+	}
+
+	if truncate {
+		s.Log().Info("deleting server files before restoring backup")
+		if err = s.Filesystem().TruncateRootDirectory(); err != nil {
+			return errors.WrapIf(err, "server/backup: restore: failed to delete server files")
+		}
+	}
+
+	// Attempt to restore the backup to the server by running through each entry
+	// in the file one at a time and writing them to the disk.
+	s.Log().Debug("starting file writing process for backup restoration")
+	err = b.Restore(s.Context(), reader, s.restoreFile)
+
+	return errors.WithStackIf(err)
+}
+
+// waitForRestoreStop waits for the server to stop before it is restored.
+func (s *Server) waitForRestoreStop() error {
 	// Don't try to restore the server until we have completely stopped the running
 	// instance, otherwise you'll likely hit all types of write errors due to the
 	// server being suspended.
 	if s.Environment.State() != environment.ProcessOfflineState {
-		if err = s.Environment.WaitForStop(s.Context(), 2*time.Minute, false); err != nil {
+		if err := s.Environment.WaitForStop(s.Context(), 2*time.Minute, false); err != nil {
 			if !client.IsErrNotFound(err) {
 				return errors.WrapIf(err, "server/backup: restore: failed to wait for container stop")
 			}
 		}
 	}
+	return nil
+}
 
-	// Attempt to restore the backup to the server by running through each entry
-	// in the file one at a time and writing them to the disk.
-	s.Log().Debug("starting file writing process for backup restoration")
-	err = b.Restore(s.Context(), reader, func(file string, info fs.FileInfo, r io.ReadCloser) error {
+// restoreFile writes a file from a backup being restored to the disk.
+func (s *Server) restoreFile(file string, info fs.FileInfo, r io.ReadCloser) error {
+	defer r.Close()
+	s.Events().Publish(DaemonMessageEvent, "(restoring): "+file)
+	// TODO: since this will be called a lot, it may be worth adding an optimized
+	// Write with Chtimes method to the UnixFS that is able to re-use the
+	// same dirfd and file name.
+	if err := s.Filesystem().Write(file, r, info.Size(), info.Mode()); err != nil {
+		return err
+	}
+	atime := info.ModTime()
+	return s.Filesystem().Chtimes(file, atime, atime)
+}
+
+// RestoreBackupFiles restores only the given files and directories from the
+// backup, writing them beneath the destination directory or back to where they
+// were originally if no destination is provided. Unlike a full restoration the
+// server is left running, since only the chosen files are replaced.
+func (s *Server) RestoreBackupFiles(ctx context.Context, b backup.BackupInterface, reader io.Reader, paths []string, destination string, p *progress.Progress) error {
+	err := backup.RestoreFiles(ctx, b, reader, paths, func(file string, info fs.FileInfo, r io.ReadCloser) error {
 		defer r.Close()
-		s.Events().Publish(DaemonMessageEvent, "(restoring): "+file)
-		// TODO: since this will be called a lot, it may be worth adding an optimized
-		// Write with Chtimes method to the UnixFS that is able to re-use the
-		// same dirfd and file name.
-		if err := s.Filesystem().Write(file, r, info.Size(), info.Mode()); err != nil {
+		target := path.Join(destination, file)
+		if info.IsDir() {
+			return s.Filesystem().CreateDirectory(path.Base(target), path.Dir(target))
+		}
+		// Links within the backup are not restored, since they could point to
+		// anywhere by the time they are restored.
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		s.Events().Publish(DaemonMessageEvent, "(restoring): "+target)
+		if err := s.Filesystem().Write(target, io.TeeReader(r, p), info.Size(), info.Mode()); err != nil {
 			return err
 		}
 		atime := info.ModTime()
-		return s.Filesystem().Chtimes(file, atime, atime)
+		return s.Filesystem().Chtimes(target, atime, atime)
 	})
-
 	return errors.WithStackIf(err)
 }
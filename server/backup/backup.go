@@ -1,6 +1,8 @@
 package backup
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
@@ -13,6 +15,7 @@ import (
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/google/uuid"
+	"github.com/juju/ratelimit"
 	"github.com/mholt/archives"
 	"golang.org/x/sync/errgroup"
 
@@ -21,10 +24,40 @@ import (
 	"github.com/pelican/wings/server/filesystem"
 )
 
-var format = archives.CompressedArchive{
-	Compression: archives.Gz{},
-	Archival:    archives.Tar{},
-	Extraction:  archives.Tar{},
+// zstdMagic is the start of every zstd compressed backup.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// backupFormats are the formats local and S3 backups can be created in.
+var backupFormats = []filesystem.ArchiveFormat{filesystem.ArchiveFormatTarGz, filesystem.ArchiveFormatTarZstd}
+
+// StoredBackupID returns the UUID of the backup stored in a file with the given
+// name, which is either the archive of a local backup in any of the formats
+// backups are created in or the manifest of a deduplicated backup.
+func StoredBackupID(name string) (string, bool) {
+	id := ""
+	if IsManifest(name) {
+		id = strings.TrimSuffix(name, manifestExtension)
+	} else {
+		for _, f := range backupFormats {
+			if strings.HasSuffix(name, f.Extension()) {
+				id = strings.TrimSuffix(name, f.Extension())
+				break
+			}
+		}
+	}
+	parsed, err := uuid.Parse(id)
+	if err != nil || parsed.String() != id {
+		return "", false
+	}
+	return id, true
+}
+
+// configuredFormat returns the format new backups are created in.
+func configuredFormat() filesystem.ArchiveFormat {
+	if config.Get().System.Backups.Compression == "zstd" {
+		return filesystem.ArchiveFormatTarZstd
+	}
+	return filesystem.ArchiveFormatTarGz
 }
 
 type AdapterType string
@@ -32,16 +65,31 @@ type AdapterType string
 const (
 	LocalBackupAdapter AdapterType = "wings"
 	S3BackupAdapter    AdapterType = "s3"
+	DedupBackupAdapter AdapterType = "dedup"
 )
 
 // RestoreCallback is a generic restoration callback that exists for both local
 // and remote backups allowing the files to be restored.
 type RestoreCallback func(file string, info fs.FileInfo, r io.ReadCloser) error
 
+// ProgressCallback is called periodically while a backup is being uploaded
+// with the number of bytes uploaded so far and the total size of the backup.
+type ProgressCallback func(uploaded int64, total int64)
+
 // noinspection GoNameStartsWithPackageName
 type BackupInterface interface {
 	// SetClient sets the API request client on the backup interface.
 	SetClient(remote.Client)
+	// SetEncryptionKey sets the key used to encrypt and decrypt the backup,
+	// overriding the key configured for the node.
+	SetEncryptionKey([]byte)
+	// SetProgressCallback sets the function called with the progress of the
+	// backup while it is being uploaded to a remote destination.
+	SetProgressCallback(ProgressCallback)
+	// SetArchivedCallback sets the function called once every file of the
+	// server has been read into the backup, which may be before the backup has
+	// finished uploading to a remote destination.
+	SetArchivedCallback(func())
 	// Identifier returns the UUID of this backup as tracked by the panel
 	// instance.
 	Identifier() string
@@ -85,15 +133,127 @@ type Backup struct {
 	// compatible with a standard .gitignore structure.
 	Ignore string `json:"ignore"`
 
-	client     remote.Client
-	adapter    AdapterType
-	logContext map[string]interface{}
+	client        remote.Client
+	adapter       AdapterType
+	format        filesystem.ArchiveFormat
+	logContext    map[string]interface{}
+	encryptionKey []byte
+	progress      ProgressCallback
+	archived      func()
 }
 
 func (b *Backup) SetClient(c remote.Client) {
 	b.client = c
 }
 
+func (b *Backup) SetEncryptionKey(key []byte) {
+	b.encryptionKey = key
+}
+
+func (b *Backup) SetProgressCallback(fn ProgressCallback) {
+	b.progress = fn
+}
+
+func (b *Backup) SetArchivedCallback(fn func()) {
+	b.archived = fn
+}
+
+// archiveCompleted calls the archived callback, if one is set, once the files
+// of the server are no longer being read.
+func (b *Backup) archiveCompleted() {
+	if b.archived != nil {
+		b.archived()
+	}
+}
+
+// key returns the key the backup is encrypted with, or nil if backups are not
+// encrypted.
+func (b *Backup) key() ([]byte, error) {
+	if b.encryptionKey != nil {
+		return b.encryptionKey, nil
+	}
+	return ParseEncryptionKey(config.Get().System.Backups.EncryptionKey)
+}
+
+// createArchive writes the archive to the given path, encrypting it as it is
+// written if an encryption key is set. The checksum and size of the backup are
+// taken from this file, so they always describe the encrypted backup.
+func (b *Backup) createArchive(ctx context.Context, a *filesystem.Archive, p string) error {
+	key, err := b.key()
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return a.Create(ctx, p)
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
+		w = ratelimit.Writer(f, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
+	}
+	ew, err := newEncryptWriter(w, key)
+	if err != nil {
+		return err
+	}
+	if err := a.Stream(ctx, ew); err != nil {
+		return err
+	}
+	if err := ew.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// openArchive returns the uncompressed tarball read from r, decrypting it first
+// if it is encrypted.
+func (b *Backup) openArchive(r io.Reader) (io.ReadCloser, error) {
+	key, err := b.key()
+	if err != nil {
+		return nil, err
+	}
+	r, _, err = NewDecryptReader(r, key)
+	if err != nil {
+		return nil, err
+	}
+	// Backups are restored using the compression they were created with, which
+	// may not be what is configured now.
+	br := bufio.NewReader(r)
+	var compression archives.Decompressor = archives.Gz{}
+	if magic, _ := br.Peek(len(zstdMagic)); bytes.Equal(magic, zstdMagic) {
+		compression = archives.Zstd{}
+	}
+	return compression.OpenReader(br)
+}
+
+// extract calls the callback for every file in the compressed tarball read
+// from r, decrypting it first if it is encrypted.
+func (b *Backup) extract(ctx context.Context, r io.Reader, callback RestoreCallback) error {
+	rc, err := b.openArchive(r)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return extractTar(ctx, rc, callback)
+}
+
+// extractTar calls the callback for every file in the tarball read from r.
+func extractTar(ctx context.Context, r io.Reader, callback RestoreCallback) error {
+	return archives.Tar{}.Extract(ctx, r, func(ctx context.Context, f archives.FileInfo) error {
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		return callback(f.NameInArchive, f.FileInfo, r)
+	})
+}
+
 func (b *Backup) Identifier() string {
 	return b.Uuid
 }
@@ -123,7 +283,7 @@ func (b *Backup) Path() string {
 	if err != nil {
 		identifier = path.Base(b.Identifier())
 	}
-	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), identifier+".tar.gz")
+	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), identifier+b.format.Extension())
 }
 
 // Size returns the size of the generated backup.
@@ -144,9 +304,14 @@ func (b *Backup) Checksum() ([]byte, error) {
 	if err := b.validateIdentifier(); err != nil {
 		return nil, err
 	}
+	return checksumFile(b.Path())
+}
+
+// checksumFile returns the SHA1 checksum of the file at the given path.
+func checksumFile(p string) ([]byte, error) {
 	h := sha1.New()
 
-	f, err := os.Open(b.Path())
+	f, err := os.Open(p)
 	if err != nil {
 		return nil, err
 	}
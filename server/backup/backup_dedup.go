@@ -0,0 +1,477 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/juju/ratelimit"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/pgzip"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+const manifestExtension = ".manifest.gz"
+
+// ErrDedupEncrypted is returned when creating a deduplicated backup while
+// backups are encrypted, since chunks are shared between backups and are not
+// encrypted.
+var ErrDedupEncrypted = errors.Sentinel("backup: deduplicated backups cannot be created while backups are encrypted")
+
+// IsManifest returns whether a file with the given name is the manifest of a
+// deduplicated backup.
+func IsManifest(name string) bool {
+	return strings.HasSuffix(name, manifestExtension)
+}
+
+// manifest lists the files in a deduplicated backup along with the chunks
+// their contents are made up of.
+type manifest struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	// StoredSize is the total size of every unique chunk the backup references,
+	// as stored in the chunk store.
+	StoredSize int64 `json:"stored_size"`
+	// Storage is where the chunks of the backup are stored, backups without
+	// one are stored in the local chunk store.
+	Storage string         `json:"storage,omitempty"`
+	Files   []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Name     string    `json:"name"`
+	Typeflag byte      `json:"type"`
+	Mode     int64     `json:"mode"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Linkname string    `json:"linkname,omitempty"`
+	Chunks   []string  `json:"chunks,omitempty"`
+}
+
+func (f *manifestFile) header() *tar.Header {
+	return &tar.Header{
+		Name:     f.Name,
+		Typeflag: f.Typeflag,
+		Mode:     f.Mode,
+		Size:     f.Size,
+		ModTime:  f.ModTime,
+		Linkname: f.Linkname,
+	}
+}
+
+func readManifest(p string) (*manifest, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	var m manifest
+	if err := json.NewDecoder(gr).Decode(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func writeManifest(p string, m *manifest) error {
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	gw := gzip.NewWriter(f)
+	if err := json.NewEncoder(gw).Encode(m); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := gw.Close(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// DedupBackup is a backup that splits the server files into content-defined
+// chunks which are stored only once, no matter how many backups contain them.
+// Each backup only stores a manifest of its files and the chunks that make
+// them up, so a backup of a server that has barely changed takes up very
+// little space.
+//
+// Chunks are not removed along with a backup, they are removed once no
+// backup references them by PruneChunks, or by pruneServerChunks for chunks
+// stored in object storage.
+type DedupBackup struct {
+	Backup
+	// storage is where the chunks of the backup are stored when it is created.
+	storage string
+}
+
+var _ BackupInterface = (*DedupBackup)(nil)
+
+func NewDedup(client remote.Client, uuid string, suuid string, ignore string) *DedupBackup {
+	b := &DedupBackup{
+		Backup: Backup{
+			client:     client,
+			Uuid:       uuid,
+			ServerUuid: suuid,
+			Ignore:     ignore,
+			adapter:    DedupBackupAdapter,
+		},
+		storage: chunkStorageLocal,
+	}
+	// Chunks can only be stored in object storage through the Panel.
+	if config.Get().System.Backups.ChunkStorage == chunkStorageS3 && client != nil {
+		b.storage = chunkStorageS3
+	}
+	return b
+}
+
+// chunkStore returns the store for chunks kept in the given storage.
+func (b *DedupBackup) chunkStore(storage string) (ChunkStore, error) {
+	switch storage {
+	case "", chunkStorageLocal:
+		return NewLocalChunkStore(chunkDirectory()), nil
+	case chunkStorageS3:
+		if b.client == nil {
+			return nil, errors.New("backup: chunks stored in object storage cannot be accessed without the panel")
+		}
+		return NewS3ChunkStore(b.client, b.ServerId()), nil
+	default:
+		return nil, errors.New("backup: unknown chunk storage: " + storage)
+	}
+}
+
+// LocateDedup finds the deduplicated backup for a server and returns
+// information about its manifest.
+func LocateDedup(client remote.Client, uuid string, suuid string) (*DedupBackup, os.FileInfo, error) {
+	b := NewDedup(client, uuid, suuid, "")
+	if err := b.validateIdentifier(); err != nil {
+		return nil, nil, err
+	}
+	st, err := os.Stat(b.Path())
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.IsDir() {
+		return nil, nil, errors.New("invalid manifest, is directory")
+	}
+	return b, st, nil
+}
+
+// Path returns the path to the manifest of the backup.
+func (b *DedupBackup) Path() string {
+	identifier, err := b.normalizedIdentifier()
+	if err != nil {
+		identifier = path.Base(b.Identifier())
+	}
+	return path.Join(config.Get().System.BackupDirectory, b.ServerId(), identifier+manifestExtension)
+}
+
+// Checksum returns the SHA1 checksum of the manifest of the backup. Since the
+// manifest contains the checksum of every chunk this covers the contents of
+// the entire backup.
+func (b *DedupBackup) Checksum() ([]byte, error) {
+	if err := b.validateIdentifier(); err != nil {
+		return nil, err
+	}
+	return checksumFile(b.Path())
+}
+
+// Size returns the size of the manifest and every chunk the backup references.
+func (b *DedupBackup) Size() (int64, error) {
+	if err := b.validateIdentifier(); err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(b.Path())
+	if err != nil {
+		return 0, err
+	}
+	m, err := readManifest(b.Path())
+	if err != nil {
+		return 0, err
+	}
+	return st.Size() + m.StoredSize, nil
+}
+
+// Details returns the checksum and size of the backup.
+func (b *DedupBackup) Details(_ context.Context, parts []remote.BackupPart) (*ArchiveDetails, error) {
+	sum, err := b.Checksum()
+	if err != nil {
+		return nil, err
+	}
+	size, err := b.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &ArchiveDetails{Checksum: hex.EncodeToString(sum), ChecksumType: "sha1", Size: size, Parts: parts}, nil
+}
+
+// Remove removes the manifest of the backup and schedules any chunks that are
+// no longer referenced to be removed.
+func (b *DedupBackup) Remove() error {
+	if err := b.validateIdentifier(); err != nil {
+		return err
+	}
+	// A manifest which cannot be read can still be removed, its chunks are
+	// then treated as being stored locally.
+	m, _ := readManifest(b.Path())
+	if err := os.Remove(b.Path()); err != nil {
+		return err
+	}
+	b.forgetChecksum()
+	if m != nil && m.Storage == chunkStorageS3 {
+		b.schedulePruneServerChunks()
+	} else {
+		SchedulePrune()
+	}
+	if d, err := os.ReadDir(filepath.Dir(b.Path())); err == nil && len(d) == 0 {
+		return os.Remove(filepath.Dir(b.Path()))
+	}
+	return nil
+}
+
+// WithLogContext attaches additional context to the log output for this backup.
+func (b *DedupBackup) WithLogContext(c map[string]interface{}) {
+	b.logContext = c
+}
+
+// Generate splits every file of the server into chunks, storing any that are
+// not already stored, and writes the manifest of the backup.
+func (b *DedupBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*ArchiveDetails, error) {
+	if err := b.validateIdentifier(); err != nil {
+		return nil, err
+	}
+
+	if key, err := b.key(); err != nil {
+		return nil, err
+	} else if key != nil {
+		return nil, errors.WithStack(ErrDedupEncrypted)
+	}
+
+	b.log().WithField("path", b.Path()).Info("creating deduplicated backup for server")
+	if err := os.MkdirAll(filepath.Dir(b.Path()), 0o700); err != nil {
+		return nil, err
+	}
+
+	if err := b.generateManifest(ctx, fsys, ignore); err != nil {
+		// Chunks may have been stored for a backup that will never reference
+		// them.
+		if b.storage == chunkStorageS3 {
+			b.schedulePruneServerChunks()
+		} else {
+			SchedulePrune()
+		}
+		return nil, err
+	}
+
+	ad, err := b.Details(ctx, nil)
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to get archive details for deduplicated backup")
+	}
+	b.recordChecksum(ad.Checksum)
+	return ad, nil
+}
+
+// generateManifest stores the chunks of the backup and writes its manifest.
+// Chunks cannot be pruned until the manifest referencing them is on the disk,
+// otherwise a prune would see them as unreferenced and remove them.
+func (b *DedupBackup) generateManifest(ctx context.Context, fsys *filesystem.Filesystem, ignore string) error {
+	chunkLock.RLock()
+	defer chunkLock.RUnlock()
+
+	m, err := b.generate(ctx, fsys, ignore)
+	b.archiveCompleted()
+	if err != nil {
+		return err
+	}
+	if err := writeManifest(b.Path(), m); err != nil {
+		return errors.WrapIf(err, "backup: failed to write backup manifest")
+	}
+	b.log().WithField("files", len(m.Files)).Info("created backup successfully")
+	return nil
+}
+
+// generate stores every chunk of the backup that is not already stored,
+// returning the manifest of the backup. The caller must hold chunkLock.
+func (b *DedupBackup) generate(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*manifest, error) {
+	// The archive is read back as it is written, this way the files of the
+	// server are walked the same way, and with the same ignored files, as every
+	// other type of backup.
+	a := &filesystem.Archive{
+		Filesystem: fsys,
+		Format:     filesystem.ArchiveFormatTar,
+		Ignore:     ignore,
+	}
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		_ = pw.CloseWithError(a.Stream(ctx, pw))
+	}()
+
+	var r io.Reader = pr
+	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
+		r = ratelimit.Reader(pr, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
+	}
+
+	store, err := b.chunkStore(b.storage)
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{Version: 1, CreatedAt: time.Now().UTC()}
+	if b.storage != chunkStorageLocal {
+		m.Storage = b.storage
+	}
+	seen := make(map[string]struct{})
+	c := newChunker()
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		f := manifestFile{
+			Name:     h.Name,
+			Typeflag: h.Typeflag,
+			Mode:     h.Mode,
+			Size:     h.Size,
+			ModTime:  h.ModTime,
+			Linkname: h.Linkname,
+		}
+		if h.Typeflag == tar.TypeReg {
+			c.Reset(tr)
+			for {
+				chunk, err := c.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				id := chunkID(chunk)
+				f.Chunks = append(f.Chunks, id)
+				if _, ok := seen[id]; ok {
+					continue
+				}
+				seen[id] = struct{}{}
+				size, err := store.Stat(ctx, id)
+				if errors.Is(err, os.ErrNotExist) {
+					size, err = store.Put(ctx, id, chunk)
+				}
+				if err != nil {
+					return nil, errors.WrapIf(err, "backup: failed to store chunk")
+				}
+				m.StoredSize += size
+			}
+		}
+		m.Files = append(m.Files, f)
+	}
+	return m, nil
+}
+
+// Restore calls the callback for every file in the backup, with a reader that
+// returns its contents from the stored chunks.
+func (b *DedupBackup) Restore(ctx context.Context, _ io.Reader, callback RestoreCallback) error {
+	if err := b.validateIdentifier(); err != nil {
+		return err
+	}
+	m, err := readManifest(b.Path())
+	if err != nil {
+		return err
+	}
+	store, err := b.chunkStore(m.Storage)
+	if err != nil {
+		return err
+	}
+	var limit *ratelimit.Bucket
+	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
+		limit = ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit)
+	}
+	for _, f := range m.Files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var r io.Reader = &chunkReader{ctx: ctx, store: store, chunks: f.Chunks}
+		if limit != nil {
+			r = ratelimit.Reader(r, limit)
+		}
+		if err := callback(f.Name, f.header().FileInfo(), io.NopCloser(r)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stream writes the backup to w as a gzipped tarball, in the same format as a
+// local backup.
+func (b *DedupBackup) Stream(ctx context.Context, w io.Writer) error {
+	if err := b.validateIdentifier(); err != nil {
+		return err
+	}
+	m, err := readManifest(b.Path())
+	if err != nil {
+		return err
+	}
+	store, err := b.chunkStore(m.Storage)
+	if err != nil {
+		return err
+	}
+	gw, _ := pgzip.NewWriterLevel(w, pgzip.BestSpeed)
+	tw := tar.NewWriter(gw)
+	for _, f := range m.Files {
+		if err := tw.WriteHeader(f.header()); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, &chunkReader{ctx: ctx, store: store, chunks: f.Chunks}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// chunkReader reads the contents of a file from the chunks it is made up of.
+type chunkReader struct {
+	ctx    context.Context
+	store  ChunkStore
+	chunks []string
+	buf    *bytes.Reader
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for r.buf == nil || r.buf.Len() == 0 {
+		if len(r.chunks) == 0 {
+			return 0, io.EOF
+		}
+		data, err := r.store.Get(r.ctx, r.chunks[0])
+		if err != nil {
+			return 0, errors.WrapIf(err, "backup: failed to read chunk "+r.chunks[0])
+		}
+		r.chunks = r.chunks[1:]
+		r.buf = bytes.NewReader(data)
+	}
+	return r.buf.Read(p)
+}
@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestChunkerSplitsContent(t *testing.T) {
+	data := make([]byte, 12*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	split := func(r io.Reader) [][]byte {
+		c := newChunker()
+		c.Reset(r)
+		var chunks [][]byte
+		for {
+			chunk, err := c.Next()
+			if err == io.EOF {
+				return chunks
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			chunks = append(chunks, append([]byte(nil), chunk...))
+		}
+	}
+
+	chunks := split(bytes.NewReader(data))
+	if len(chunks) < 3 {
+		t.Fatalf("expected content to be split into multiple chunks, got %d", len(chunks))
+	}
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("expected chunks to add up to the original content")
+	}
+	for i, c := range chunks {
+		if len(c) > maxChunkSize || (i < len(chunks)-1 && len(c) < minChunkSize) {
+			t.Fatalf("chunk %d has invalid size %d", i, len(c))
+		}
+	}
+
+	// The chunks must not depend on how the content happens to be read.
+	if got := split(iotest.HalfReader(bytes.NewReader(data))); len(got) != len(chunks) {
+		t.Fatalf("expected %d chunks when reading in small pieces, got %d", len(chunks), len(got))
+	}
+
+	// Inserting data at the start of the content should only change the
+	// chunks around it.
+	shifted := split(bytes.NewReader(append([]byte("inserted"), data...)))
+	ids := make(map[string]bool)
+	for _, c := range chunks {
+		ids[chunkID(c)] = true
+	}
+	var shared int
+	for _, c := range shifted {
+		if ids[chunkID(c)] {
+			shared++
+		}
+	}
+	if shared < len(chunks)-2 {
+		t.Fatalf("expected most chunks to be shared after inserting data, got %d of %d", shared, len(chunks))
+	}
+}
+
+func TestDedupBackup(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+		},
+	})
+
+	world := make([]byte, 8*1024*1024)
+	rand.New(rand.NewSource(2)).Read(world)
+	write := func(name string, b []byte) {
+		if err := os.WriteFile(filepath.Join(serverDir, name), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("world.dat", world)
+	write("server.properties", []byte("motd=hello"))
+	write("ignored.log", []byte("ignored"))
+
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	countChunks := func() int {
+		var n int
+		if err := NewLocalChunkStore(chunkDirectory()).Walk(context.Background(), func(string) error {
+			n++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	first := NewDedup(nil, "11111111-1111-1111-1111-111111111111", suuid, "")
+	ad, err := first.Generate(context.Background(), fsys, "*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ad.Checksum == "" || ad.Size <= 0 {
+		t.Fatalf("expected backup details to be returned, got %+v", ad)
+	}
+	initial := countChunks()
+
+	// Changing a small part of a file should only store the chunks around the
+	// change.
+	copy(world[4*1024*1024:], "changed")
+	write("world.dat", world)
+	second := NewDedup(nil, "22222222-2222-2222-2222-222222222222", suuid, "")
+	if _, err := second.Generate(context.Background(), fsys, "*.log"); err != nil {
+		t.Fatal(err)
+	}
+	if added := countChunks() - initial; added < 1 || added > 2 {
+		t.Fatalf("expected one or two chunks to be added, got %d", added)
+	}
+
+	restored := make(map[string][]byte)
+	if err := first.Restore(context.Background(), nil, func(file string, info fs.FileInfo, r io.ReadCloser) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if int64(len(b)) != info.Size() {
+			t.Fatalf("expected %s to be %d bytes, got %d", file, info.Size(), len(b))
+		}
+		restored[file] = b
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || string(restored["server.properties"]) != "motd=hello" {
+		t.Fatalf("unexpected restored files: %v", restored)
+	}
+	if bytes.Equal(restored["world.dat"], world) || !bytes.Equal(restored["world.dat"][:4*1024*1024], world[:4*1024*1024]) {
+		t.Fatal("expected the first backup to restore the original world")
+	}
+
+	// Chunks are only removed once no backup references them.
+	if err := os.Remove(first.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PruneChunks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := second.Stream(context.Background(), &b); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(second.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PruneChunks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := countChunks(); n != 0 {
+		t.Fatalf("expected every chunk to be pruned, got %d", n)
+	}
+}
+
+func TestTransferChunks(t *testing.T) {
+	source, target := t.TempDir(), t.TempDir()
+	serverDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("motd=hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useBackupDirectory := func(dir string) {
+		config.Set(&config.Configuration{
+			AuthenticationToken: "test-token",
+			System:              config.SystemConfiguration{BackupDirectory: dir},
+		})
+	}
+
+	useBackupDirectory(source)
+	b := NewDedup(nil, "88888888-8888-8888-8888-888888888888", "e3b5a1c4-9f0d-4b7e-8a2c-6d1f0e9b7a53", "")
+	if _, err := b.Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := StoredBackupID(filepath.Base(b.Path())); !ok || id != b.Identifier() {
+		t.Fatalf("expected the manifest to be recognised as a stored backup, got %q", id)
+	}
+	ids, err := ManifestChunks(b.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected the backup to reference a single chunk, got %d", len(ids))
+	}
+	f, err := OpenChunk(ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := io.ReadAll(f)
+	_ = f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	useBackupDirectory(target)
+	if err := ReceiveChunk(context.Background(), ids[0], bytes.NewReader(stored[:len(stored)/2])); err == nil {
+		t.Fatal("expected a truncated chunk to be rejected")
+	}
+	if err := ReceiveChunk(context.Background(), ids[0], bytes.NewReader(stored)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := NewLocalChunkStore(chunkDirectory()).Get(context.Background(), ids[0])
+	if err != nil || string(data) == "" {
+		t.Fatalf("expected the received chunk to be stored: %v", err)
+	}
+}
+
+func TestDedupBackupRefusesEncryption(t *testing.T) {
+	root := t.TempDir()
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System:              config.SystemConfiguration{BackupDirectory: root},
+	})
+	fsys, err := filesystem.New(t.TempDir(), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewDedup(nil, "99999999-9999-9999-9999-999999999999", "e3b5a1c4-9f0d-4b7e-8a2c-6d1f0e9b7a53", "")
+	b.SetEncryptionKey(make([]byte, 32))
+	if _, err := b.Generate(context.Background(), fsys, ""); !errors.Is(err, ErrDedupEncrypted) {
+		t.Fatalf("expected an encrypted deduplicated backup to be refused, got %v", err)
+	}
+	if _, err := os.Stat(chunkDirectory()); !os.IsNotExist(err) {
+		t.Fatal("expected no chunks to be stored")
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"emperror.dev/errors"
 	"github.com/juju/ratelimit"
-	"github.com/mholt/archives"
 
 	"github.com/pelican/wings/config"
 	"github.com/pelican/wings/remote"
@@ -29,6 +28,7 @@ func NewLocal(client remote.Client, uuid string, suuid string, ignore string) *L
 			ServerUuid: suuid,
 			Ignore:     ignore,
 			adapter:    LocalBackupAdapter,
+			format:     configuredFormat(),
 		},
 	}
 }
@@ -40,7 +40,16 @@ func LocateLocal(client remote.Client, uuid string, suuid string) (*LocalBackup,
 	if err := b.validateIdentifier(); err != nil {
 		return nil, nil, err
 	}
-	st, err := os.Stat(b.Path())
+	// The backup may have been created with a different compression than the
+	// one that is configured now.
+	var st os.FileInfo
+	var err error
+	for _, f := range backupFormats {
+		b.format = f
+		if st, err = os.Stat(b.Path()); !errors.Is(err, os.ErrNotExist) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, nil, err
 	}
@@ -61,6 +70,7 @@ func (b *LocalBackup) Remove() error {
 	if err != nil {
 		return err
 	}
+	b.forgetChecksum()
 	d, err := os.ReadDir(filepath.Dir(b.Path()))
 	if err != nil {
 		return err
@@ -87,6 +97,7 @@ func (b *LocalBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem,
 	}
 	a := &filesystem.Archive{
 		Filesystem: fsys,
+		Format:     b.format,
 		Ignore:     ignore,
 	}
 
@@ -97,7 +108,9 @@ func (b *LocalBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem,
 			return nil, err
 		}
 	}
-	if err := a.Create(ctx, b.Path()); err != nil {
+	err := b.createArchive(ctx, a, b.Path())
+	b.archiveCompleted()
+	if err != nil {
 		return nil, err
 	}
 	b.log().Info("created backup successfully")
@@ -106,6 +119,7 @@ func (b *LocalBackup) Generate(ctx context.Context, fsys *filesystem.Filesystem,
 	if err != nil {
 		return nil, errors.WrapIf(err, "backup: failed to get archive details for local backup")
 	}
+	b.recordChecksum(ad.Checksum)
 	return ad, nil
 }
 
@@ -127,16 +141,5 @@ func (b *LocalBackup) Restore(ctx context.Context, _ io.Reader, callback Restore
 	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
 		reader = ratelimit.Reader(f, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
 	}
-	if err := format.Extract(ctx, reader, func(ctx context.Context, f archives.FileInfo) error {
-		r, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-
-		return callback(f.NameInArchive, f.FileInfo, r)
-	}); err != nil {
-		return err
-	}
-	return nil
+	return b.extract(ctx, reader, callback)
 }
@@ -13,13 +13,17 @@ import (
 	"emperror.dev/errors"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/juju/ratelimit"
-	"github.com/mholt/archives"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/models"
 	"github.com/pelican/wings/remote"
 	"github.com/pelican/wings/server/filesystem"
 )
 
+// ErrUploadURLRejected is returned when S3 rejects the URL a part of a backup
+// was being uploaded to, such as when the URL has expired.
+var ErrUploadURLRejected = errors.Sentinel("backup: S3 rejected the upload url")
+
 type S3Backup struct {
 	Backup
 }
@@ -34,6 +38,7 @@ func NewS3(client remote.Client, uuid string, suuid string, ignore string) *S3Ba
 			ServerUuid: suuid,
 			Ignore:     ignore,
 			adapter:    S3BackupAdapter,
+			format:     configuredFormat(),
 		},
 	}
 }
@@ -52,37 +57,64 @@ func (s *S3Backup) WithLogContext(c map[string]interface{}) {
 }
 
 // Generate creates a new backup on the disk, moves it into the S3 bucket via
-// the provided presigned URL, and then deletes the backup from the disk.
+// the provided presigned URL, and then deletes the backup from the disk. When
+// streaming is enabled the backup is uploaded as it is created instead.
+//
+// If an upload of the backup was interrupted before it completed and the
+// archive is still on the disk, the upload is resumed from the parts which
+// were already uploaded instead.
 func (s *S3Backup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*ArchiveDetails, error) {
 	if err := s.validateIdentifier(); err != nil {
 		return nil, err
 	}
-	defer s.Remove()
-
-	a := &filesystem.Archive{
-		Filesystem: fsys,
-		Ignore:     ignore,
+	u, err := loadUpload(s.Uuid)
+	if err != nil {
+		return nil, err
+	}
+	resume := u != nil && s.resumable(u)
+	if !resume && config.Get().System.Backups.S3Streaming {
+		return s.generateStreaming(ctx, fsys, ignore)
 	}
+	defer func() {
+		// Keep the archive while the state of its upload is stored, so that an
+		// upload which was interrupted or resumed can be resumed again.
+		if !s.uploadStarted() {
+			_ = s.Remove()
+		}
+	}()
+
+	if resume {
+		s.log().WithField("path", s.Path()).WithField("uploaded_parts", len(u.Parts)).Info("resuming interrupted upload of backup")
+		s.archiveCompleted()
+	} else {
+		a := &filesystem.Archive{
+			Filesystem: fsys,
+			Format:     s.format,
+			Ignore:     ignore,
+		}
 
-	s.log().WithField("path", s.Path()).Info("creating backup for server")
-	if _, err := os.Stat(filepath.Dir(s.Path())); os.IsNotExist(err) {
-		err := os.Mkdir(filepath.Dir(s.Path()), 0o700)
+		s.log().WithField("path", s.Path()).Info("creating backup for server")
+		if _, err := os.Stat(filepath.Dir(s.Path())); os.IsNotExist(err) {
+			err := os.Mkdir(filepath.Dir(s.Path()), 0o700)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := s.createArchive(ctx, a, s.Path())
+		s.archiveCompleted()
 		if err != nil {
 			return nil, err
 		}
+		s.log().Info("created backup successfully")
 	}
-	if err := a.Create(ctx, s.Path()); err != nil {
-		return nil, err
-	}
-	s.log().Info("created backup successfully")
 
-	rc, err := os.Open(s.Path())
+	f, err := os.Open(s.Path())
 	if err != nil {
 		return nil, errors.Wrap(err, "backup: could not read archive from disk")
 	}
-	defer rc.Close()
+	defer f.Close()
 
-	parts, err := s.generateRemoteRequest(ctx, rc)
+	parts, err := s.generateRemoteRequest(ctx, f)
 	if err != nil {
 		return nil, err
 	}
@@ -93,8 +125,23 @@ func (s *S3Backup) Generate(ctx context.Context, fsys *filesystem.Filesystem, ig
 	return ad, nil
 }
 
-// Restore will read from the provided reader assuming that it is a gzipped
-// tar reader. When a file is encountered in the archive the callback function
+// ResumesUpload returns whether generating the backup only resumes an
+// interrupted upload of an archive already on the disk, in which case none of
+// the files of the server are read.
+func (s *S3Backup) ResumesUpload() bool {
+	u, err := loadUpload(s.Uuid)
+	return err == nil && u != nil && s.resumable(u)
+}
+
+// uploadStarted returns whether the state of an upload of the backup is stored,
+// meaning it can be resumed.
+func (s *S3Backup) uploadStarted() bool {
+	u, err := loadUpload(s.Uuid)
+	return err == nil && u != nil
+}
+
+// Restore will read from the provided reader assuming that it is a gzip or
+// zstd compressed tar reader. When a file is encountered in the archive the callback function
 // will be triggered. If the callback returns an error the entire process is
 // stopped, otherwise this function will run until all files have been written.
 //
@@ -107,24 +154,13 @@ func (s *S3Backup) Restore(ctx context.Context, r io.Reader, callback RestoreCal
 	if writeLimit := int64(config.Get().System.Backups.WriteLimit * 1024 * 1024); writeLimit > 0 {
 		reader = ratelimit.Reader(r, ratelimit.NewBucketWithRate(float64(writeLimit), writeLimit))
 	}
-	if err := format.Extract(ctx, reader, func(ctx context.Context, f archives.FileInfo) error {
-		r, err := f.Open()
-		if err != nil {
-			return err
-		}
-		defer r.Close()
-
-		return callback(f.NameInArchive, f.FileInfo, r)
-	}); err != nil {
-		return err
-	}
-	return nil
+	return s.extract(ctx, reader, callback)
 }
 
-// Generates the remote S3 request and begins the upload.
-func (s *S3Backup) generateRemoteRequest(ctx context.Context, rc io.ReadCloser) ([]remote.BackupPart, error) {
-	defer rc.Close()
-
+// Generates the remote S3 request and begins the upload. The state of the
+// upload is stored until it completes, so an upload which is interrupted by
+// Wings stopping can be resumed with the same upload URLs.
+func (s *S3Backup) generateRemoteRequest(ctx context.Context, f io.ReaderAt) ([]remote.BackupPart, error) {
 	s.log().Debug("attempting to get size of backup...")
 	size, err := s.Backup.Size()
 	if err != nil {
@@ -132,53 +168,84 @@ func (s *S3Backup) generateRemoteRequest(ctx context.Context, rc io.ReadCloser)
 	}
 	s.log().WithField("size", size).Debug("got size of backup")
 
-	s.log().Debug("attempting to get S3 upload urls from Panel...")
-	urls, err := s.client.GetBackupRemoteUploadURLs(context.Background(), s.Backup.Uuid, size)
+	u, err := loadUpload(s.Uuid)
 	if err != nil {
 		return nil, err
 	}
-	s.log().Debug("got S3 upload urls from the Panel")
-	s.log().WithField("parts", len(urls.Parts)).Info("attempting to upload backup to s3 endpoint...")
-
-	uploader := newS3FileUploader(rc)
-	for i, part := range urls.Parts {
-		// Get the size for the current part.
-		var partSize int64
-		if i+1 < len(urls.Parts) {
-			partSize = urls.PartSize
-		} else {
-			// This is the remaining size for the last part,
-			// there is not a minimum size limit for the last part.
-			partSize = size - (int64(i) * urls.PartSize)
+	resumed := u != nil && u.Size == size && u.Format == string(s.format)
+	if !resumed {
+		if u == nil {
+			u = &models.BackupUpload{Backup: s.Uuid}
 		}
-
-		// Attempt to upload the part.
-		etag, err := uploader.uploadPart(ctx, part, partSize)
-		if err != nil {
-			s.log().WithField("part_id", i+1).WithError(err).Warn("failed to upload part")
+		if err := s.requestUploadURLs(ctx, u, size); err != nil {
 			return nil, err
 		}
-		uploader.uploadedParts = append(uploader.uploadedParts, remote.BackupPart{
-			ETag:       etag,
-			PartNumber: i + 1,
-		})
-		s.log().WithField("part_id", i+1).Info("successfully uploaded backup part")
 	}
-	s.log().WithField("parts", len(urls.Parts)).Info("backup has been successfully uploaded")
+	s.log().WithField("parts", len(u.URLs)).WithField("uploaded_parts", len(u.Parts)).Info("attempting to upload backup to s3 endpoint...")
+
+	parts, err := s.uploadParts(ctx, u, f)
+	if err != nil && resumed && errors.Is(err, ErrUploadURLRejected) {
+		// The upload URLs expire, so an upload resumed long after it was
+		// interrupted is started again with new URLs from the archive which is
+		// still on the disk.
+		s.log().WithError(err).Warn("upload urls of interrupted backup upload were rejected, uploading backup again with new urls")
+		if err = s.requestUploadURLs(ctx, u, size); err == nil {
+			parts, err = s.uploadParts(ctx, u, f)
+		}
+	}
+	if err != nil {
+		// An upload interrupted by Wings stopping is kept so that it can be
+		// resumed, as is an upload which was already resumed since its archive
+		// is not going to be created again. Any other failure is not going to
+		// be fixed by trying again.
+		if ctx.Err() == nil && !resumed {
+			if err := DiscardUpload(s.Uuid); err != nil {
+				s.log().WithError(err).Warn("failed to discard state of failed backup upload")
+			}
+		}
+		return nil, err
+	}
+	if err := DiscardUpload(s.Uuid); err != nil {
+		s.log().WithError(err).Warn("failed to discard state of completed backup upload")
+	}
+	s.log().WithField("parts", len(parts)).Info("backup has been successfully uploaded")
+
+	return parts, nil
+}
 
-	return uploader.uploadedParts, nil
+// requestUploadURLs gets new upload URLs for the backup from the Panel and
+// stores them as the state of the upload, which starts again from the first
+// part.
+func (s *S3Backup) requestUploadURLs(ctx context.Context, u *models.BackupUpload, size int64) error {
+	s.log().Debug("attempting to get S3 upload urls from Panel...")
+	urls, err := s.client.GetBackupRemoteUploadURLs(ctx, s.Backup.Uuid, size)
+	if err != nil {
+		return err
+	}
+	s.log().Debug("got S3 upload urls from the Panel")
+	u.Server = s.ServerUuid
+	u.Format = string(s.format)
+	u.Size = size
+	u.PartSize = urls.PartSize
+	u.URLs = urls.Parts
+	u.Parts = nil
+	u.CreatedAt = time.Now().UTC()
+	return saveUpload(u)
 }
 
 type s3FileUploader struct {
-	io.ReadCloser
-	client        *http.Client
-	uploadedParts []remote.BackupPart
+	client      *http.Client
+	contentType string
 }
 
 // newS3FileUploader returns a new file uploader instance.
-func newS3FileUploader(file io.ReadCloser) *s3FileUploader {
+func newS3FileUploader(format filesystem.ArchiveFormat) *s3FileUploader {
+	contentType := "application/x-gzip"
+	if format == filesystem.ArchiveFormatTarZstd {
+		contentType = format.ContentType()
+	}
 	return &s3FileUploader{
-		ReadCloser: file,
+		contentType: contentType,
 		// We purposefully use a super high timeout on this request since we need to upload
 		// a 5GB file. This assumes at worst a 10Mbps connection for uploading. While technically
 		// you could go slower we're targeting mostly hosted servers that should have 100Mbps
@@ -199,24 +266,28 @@ func (fu *s3FileUploader) backoff(ctx context.Context) backoff.BackOffContext {
 
 // uploadPart attempts to upload a given S3 file part to the S3 system. If a
 // 5xx error is returned from the endpoint this will continue with an exponential
-// backoff to try and successfully upload the part.
+// backoff to try and successfully upload the part. The part is read from the
+// start again for every attempt, and the bytes read by the attempt are added
+// to the progress of the upload.
 //
 // Once uploaded the ETag is returned to the caller.
-func (fu *s3FileUploader) uploadPart(ctx context.Context, part string, size int64) (string, error) {
-	r, err := http.NewRequestWithContext(ctx, http.MethodPut, part, nil)
-	if err != nil {
-		return "", errors.Wrap(err, "backup: could not create request for S3")
-	}
-
-	r.ContentLength = size
-	r.Header.Add("Content-Length", strconv.Itoa(int(size)))
-	r.Header.Add("Content-Type", "application/x-gzip")
-
-	// Limit the reader to the size of the part.
-	r.Body = Reader{Reader: io.LimitReader(fu.ReadCloser, size)}
-
+func (fu *s3FileUploader) uploadPart(ctx context.Context, part string, body *io.SectionReader, progress *uploadProgress) (string, error) {
 	var etag string
-	err = backoff.Retry(func() error {
+	err := backoff.Retry(func() (err error) {
+		pr := &progressReader{r: io.NewSectionReader(body, 0, body.Size()), progress: progress}
+		defer func() {
+			if err != nil {
+				progress.add(-pr.n)
+			}
+		}()
+		r, err := http.NewRequestWithContext(ctx, http.MethodPut, part, pr)
+		if err != nil {
+			return backoff.Permanent(errors.Wrap(err, "backup: could not create request for S3"))
+		}
+		r.ContentLength = body.Size()
+		r.Header.Add("Content-Length", strconv.Itoa(int(body.Size())))
+		r.Header.Add("Content-Type", fu.contentType)
+
 		res, err := fu.client.Do(r)
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
@@ -229,14 +300,13 @@ func (fu *s3FileUploader) uploadPart(ctx context.Context, part string, size int6
 		_ = res.Body.Close()
 
 		if res.StatusCode != http.StatusOK {
-			err := errors.New(fmt.Sprintf("backup: failed to put S3 object: [HTTP/%d] %s", res.StatusCode, res.Status))
 			// Only attempt a backoff retry if this error is because of a 5xx error from
 			// the S3 endpoint. Any 4xx error should be treated as an error that a retry
 			// would not fix.
 			if res.StatusCode >= http.StatusInternalServerError {
-				return err
+				return errors.New(fmt.Sprintf("backup: failed to put S3 object: [HTTP/%d] %s", res.StatusCode, res.Status))
 			}
-			return backoff.Permanent(err)
+			return backoff.Permanent(errors.Wrapf(ErrUploadURLRejected, "[HTTP/%d] %s", res.StatusCode, res.Status))
 		}
 
 		// Get the ETag from the uploaded part, this should be sent with the
@@ -0,0 +1,334 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// ErrBackupTooLarge is returned when a streamed backup grows larger than the
+// size the upload was created for.
+var ErrBackupTooLarge = errors.Sentinel("backup: backup is larger than the size it was uploaded with")
+
+// estimateArchiveSize returns the most space an archive of the filesystem
+// could take up. Streamed backups must ask the Panel for their upload URLs
+// before their size is known, so this allows for every file being stored
+// without any compression at all, plus the tar headers and padding around
+// every file.
+func estimateArchiveSize(fsys *filesystem.Filesystem) (int64, error) {
+	usage, err := fsys.DiskUsage(false)
+	if err != nil || usage <= 0 {
+		// Disk usage checks may be disabled for the server.
+		if usage, err = fsys.DirectorySize("/"); err != nil {
+			return 0, err
+		}
+	}
+	return usage + usage/20 + fsys.CachedFiles()*1024 + 16*1024*1024, nil
+}
+
+// generateStreaming creates the backup while uploading it to S3, without ever
+// writing the entire backup to the disk.
+func (s *S3Backup) generateStreaming(ctx context.Context, fsys *filesystem.Filesystem, ignore string) (*ArchiveDetails, error) {
+	key, err := s.key()
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := estimateArchiveSize(fsys)
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to estimate size of backup")
+	}
+
+	s.log().WithField("estimated_size", estimate).Debug("attempting to get S3 upload urls from Panel...")
+	urls, err := s.client.GetBackupRemoteUploadURLs(ctx, s.Backup.Uuid, estimate)
+	if err != nil {
+		return nil, err
+	}
+	if urls.PartSize <= 0 || len(urls.Parts) == 0 {
+		return nil, errors.New("backup: Panel did not return any upload urls for the backup")
+	}
+	s.log().WithField("parts", len(urls.Parts)).Info("streaming backup to s3 endpoint...")
+
+	// One part is filled by the archive while the others are uploaded. Only hold
+	// the parts in memory if they fit, otherwise each part is written to the
+	// disk instead, which still only needs space for a few parts rather than the
+	// entire backup.
+	concurrency := uploadConcurrency()
+	var spool string
+	if urls.PartSize*int64(concurrency+1) > int64(config.Get().System.Backups.S3StreamMemory)*1024*1024 {
+		spool = config.Get().System.BackupDirectory
+	}
+	progress := startProgress(ctx, s.progress, 0)
+	defer progress.stop()
+	pw, err := newS3PartWriter(ctx, newS3FileUploader(s.format), urls, spool, concurrency, progress)
+	if err != nil {
+		return nil, err
+	}
+	defer pw.cleanup()
+
+	h := sha1.New()
+	cw := &countingWriter{w: io.MultiWriter(h, pw)}
+	a := &filesystem.Archive{
+		Filesystem: fsys,
+		Format:     s.format,
+		Ignore:     ignore,
+	}
+	err = func() error {
+		if key == nil {
+			return a.Stream(ctx, cw)
+		}
+		ew, err := newEncryptWriter(cw, key)
+		if err != nil {
+			return err
+		}
+		if err := a.Stream(ctx, ew); err != nil {
+			return err
+		}
+		return ew.Close()
+	}()
+	// The remaining parts are still being uploaded, but the files of the server
+	// have all been read.
+	s.archiveCompleted()
+	if err != nil {
+		pw.abort()
+		return nil, err
+	}
+	parts, err := pw.Close()
+	if err != nil {
+		return nil, err
+	}
+	progress.setTotal(cw.n)
+	s.log().WithField("parts", len(parts)).WithField("size", cw.n).Info("backup has been successfully streamed")
+
+	return &ArchiveDetails{
+		Checksum:     hex.EncodeToString(h.Sum(nil)),
+		ChecksumType: "sha1",
+		Size:         cw.n,
+		Parts:        parts,
+	}, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// partBuffer holds a single part of a streamed backup until it is uploaded.
+type partBuffer interface {
+	io.Writer
+	io.ReaderAt
+	Len() int64
+	Reset() error
+	Close() error
+}
+
+type memoryPart struct {
+	b []byte
+}
+
+func (m *memoryPart) Write(p []byte) (int, error) {
+	m.b = append(m.b, p...)
+	return len(p), nil
+}
+
+func (m *memoryPart) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(m.b).ReadAt(p, off)
+}
+
+func (m *memoryPart) Len() int64   { return int64(len(m.b)) }
+func (m *memoryPart) Reset() error { m.b = m.b[:0]; return nil }
+func (m *memoryPart) Close() error { m.b = nil; return nil }
+
+type filePart struct {
+	*os.File
+	n int64
+}
+
+func (f *filePart) Write(p []byte) (int, error) {
+	n, err := f.File.WriteAt(p, f.n)
+	f.n += int64(n)
+	return n, err
+}
+
+func (f *filePart) Len() int64 { return f.n }
+
+func (f *filePart) Reset() error {
+	f.n = 0
+	return f.File.Truncate(0)
+}
+
+func (f *filePart) Close() error {
+	_ = f.File.Close()
+	return os.Remove(f.File.Name())
+}
+
+type filledPart struct {
+	number int
+	buf    partBuffer
+}
+
+// s3PartWriter splits everything written to it into parts of the size asked
+// for by the Panel, uploading each part as soon as it has been filled while
+// the next one is being written.
+type s3PartWriter struct {
+	ctx      context.Context
+	cancel   context.CancelCauseFunc
+	uploader *s3FileUploader
+	urls     remote.BackupRemoteUploadResponse
+	progress *uploadProgress
+
+	buffers []partBuffer
+	free    chan partBuffer
+	filled  chan filledPart
+	wg      sync.WaitGroup
+
+	cur    partBuffer
+	number int
+	mu     sync.Mutex
+	parts  []remote.BackupPart
+}
+
+// newS3PartWriter returns a writer uploading up to concurrency parts at once to
+// the given part URLs. Parts are held in memory, unless a spool directory is
+// given in which case they are held in temporary files within it.
+func newS3PartWriter(ctx context.Context, uploader *s3FileUploader, urls remote.BackupRemoteUploadResponse, spool string, concurrency int, progress *uploadProgress) (*s3PartWriter, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &s3PartWriter{
+		ctx:      ctx,
+		cancel:   cancel,
+		uploader: uploader,
+		urls:     urls,
+		progress: progress,
+		free:     make(chan partBuffer, concurrency+1),
+		filled:   make(chan filledPart),
+	}
+	for i := 0; i < concurrency+1; i++ {
+		var buf partBuffer = &memoryPart{}
+		if spool != "" {
+			f, err := os.CreateTemp(spool, ".s3-part-*")
+			if err != nil {
+				w.cleanup()
+				cancel(err)
+				return nil, err
+			}
+			buf = &filePart{File: f}
+		}
+		w.buffers = append(w.buffers, buf)
+		w.free <- buf
+	}
+	w.wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go w.upload()
+	}
+	return w, nil
+}
+
+// upload uploads parts as they are filled.
+func (w *s3PartWriter) upload() {
+	defer w.wg.Done()
+	for p := range w.filled {
+		etag, err := w.uploader.uploadPart(w.ctx, w.urls.Parts[p.number-1], io.NewSectionReader(p.buf, 0, p.buf.Len()), w.progress)
+		if err != nil {
+			w.cancel(errors.WrapIf(err, "backup: failed to upload part"))
+			return
+		}
+		w.mu.Lock()
+		w.parts = append(w.parts, remote.BackupPart{ETag: etag, PartNumber: p.number})
+		w.mu.Unlock()
+		if err := p.buf.Reset(); err != nil {
+			w.cancel(err)
+			return
+		}
+		w.free <- p.buf
+	}
+}
+
+func (w *s3PartWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if w.cur == nil {
+			select {
+			case w.cur = <-w.free:
+			case <-w.ctx.Done():
+				return written, context.Cause(w.ctx)
+			}
+		}
+		n, err := w.cur.Write(p[:min(int64(len(p)), w.urls.PartSize-w.cur.Len())])
+		written += n
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+		if w.cur.Len() == w.urls.PartSize {
+			if err := w.send(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// send queues the current part to be uploaded.
+func (w *s3PartWriter) send() error {
+	if w.number >= len(w.urls.Parts) {
+		return errors.WithStack(ErrBackupTooLarge)
+	}
+	w.number++
+	select {
+	case w.filled <- filledPart{number: w.number, buf: w.cur}:
+		w.cur = nil
+		return nil
+	case <-w.ctx.Done():
+		return context.Cause(w.ctx)
+	}
+}
+
+// Close uploads the last part and waits for every part to be uploaded,
+// returning the uploaded parts.
+func (w *s3PartWriter) Close() ([]remote.BackupPart, error) {
+	var err error
+	if w.cur != nil && w.cur.Len() > 0 {
+		err = w.send()
+	}
+	close(w.filled)
+	w.wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	if cause := context.Cause(w.ctx); cause != nil {
+		return nil, cause
+	}
+	w.cancel(nil)
+	sort.Slice(w.parts, func(i, j int) bool { return w.parts[i].PartNumber < w.parts[j].PartNumber })
+	return w.parts, nil
+}
+
+// abort stops uploading any more parts.
+func (w *s3PartWriter) abort() {
+	w.cancel(context.Canceled)
+	close(w.filled)
+	w.wg.Wait()
+}
+
+// cleanup releases the buffers used to hold parts.
+func (w *s3PartWriter) cleanup() {
+	for _, b := range w.buffers {
+		_ = b.Close()
+	}
+}
@@ -0,0 +1,168 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+type streamTestClient struct {
+	remote.Client
+	urls func(size int64) remote.BackupRemoteUploadResponse
+}
+
+func (c *streamTestClient) GetBackupRemoteUploadURLs(_ context.Context, _ string, size int64) (remote.BackupRemoteUploadResponse, error) {
+	return c.urls(size), nil
+}
+
+func setStreamConfig(backupDir string, memory int) {
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups: config.Backups{
+				S3Streaming:    true,
+				S3StreamMemory: memory,
+			},
+		},
+	})
+}
+
+func TestS3BackupStreaming(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setStreamConfig(backupDir, 64)
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	if err := os.WriteFile(filepath.Join(serverDir, "world.dat"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var parts map[int][]byte
+	var failed map[int]bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("part"))
+		b, err := io.ReadAll(r.Body)
+		if err != nil || int64(len(b)) != r.ContentLength {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Fail the first attempt at every part to make sure it is sent again
+		// in its entirety.
+		if !failed[n] {
+			failed[n] = true
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		parts[n] = b
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d", n))
+	}))
+	defer srv.Close()
+
+	const partSize = 1024 * 1024
+	client := &streamTestClient{urls: func(size int64) remote.BackupRemoteUploadResponse {
+		if size < int64(len(data)) {
+			t.Errorf("expected the estimated size %d to cover the server files", size)
+		}
+		res := remote.BackupRemoteUploadResponse{PartSize: partSize}
+		for i := int64(0); i*partSize < size; i++ {
+			res.Parts = append(res.Parts, fmt.Sprintf("%s/?part=%d", srv.URL, i+1))
+		}
+		return res
+	}}
+
+	// The parts are held on the disk when they do not fit in memory.
+	for _, memory := range []int{64, 1} {
+		t.Run(fmt.Sprintf("memory %dMiB", memory), func(t *testing.T) {
+			parts, failed = make(map[int][]byte), make(map[int]bool)
+			setStreamConfig(backupDir, memory)
+
+			b := NewS3(client, "11111111-1111-1111-1111-111111111111", "ce6ee345-6729-4aed-8fed-c866c535a69d", "")
+			ad, err := b.Generate(context.Background(), fsys, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ad.Parts) < 3 || len(ad.Parts) != len(parts) {
+				t.Fatalf("expected the backup to be uploaded in multiple parts, got %d", len(ad.Parts))
+			}
+			sort.Slice(ad.Parts, func(i, j int) bool { return ad.Parts[i].PartNumber < ad.Parts[j].PartNumber })
+			var uploaded []byte
+			for i, p := range ad.Parts {
+				if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("etag-%d", i+1) {
+					t.Fatalf("unexpected part %+v", p)
+				}
+				uploaded = append(uploaded, parts[p.PartNumber]...)
+			}
+			sum := sha1.Sum(uploaded)
+			if ad.Size != int64(len(uploaded)) || ad.Checksum != hex.EncodeToString(sum[:]) {
+				t.Fatalf("expected details to describe the uploaded backup, got %+v", ad)
+			}
+
+			var restored []byte
+			if err := b.Restore(context.Background(), bytes.NewReader(uploaded), func(_ string, _ fs.FileInfo, r io.ReadCloser) error {
+				restored, err = io.ReadAll(r)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored, data) {
+				t.Fatal("expected the uploaded backup to contain the server files")
+			}
+
+			entries, err := os.ReadDir(backupDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".s3-part-") {
+					t.Fatalf("expected temporary part %s to be removed", e.Name())
+				}
+			}
+		})
+	}
+
+	t.Run("too large", func(t *testing.T) {
+		parts, failed = make(map[int][]byte), make(map[int]bool)
+		setStreamConfig(backupDir, 64)
+		small := &streamTestClient{urls: func(int64) remote.BackupRemoteUploadResponse {
+			return remote.BackupRemoteUploadResponse{PartSize: partSize, Parts: []string{srv.URL + "/?part=1"}}
+		}}
+		_, err := NewS3(small, "11111111-1111-1111-1111-111111111111", "ce6ee345-6729-4aed-8fed-c866c535a69d", "").Generate(context.Background(), fsys, "")
+		if !errors.Is(err, ErrBackupTooLarge) {
+			t.Fatalf("expected backup to be too large, got %v", err)
+		}
+	})
+}
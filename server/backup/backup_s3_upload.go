@@ -0,0 +1,254 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// How often the progress of an upload is reported while it is running.
+const progressInterval = 2 * time.Second
+
+// uploadConcurrency returns the number of parts uploaded to S3 at once.
+func uploadConcurrency() int {
+	return max(1, config.Get().System.Backups.S3UploadConcurrency)
+}
+
+// loadUpload returns the stored state of an upload of the backup which has not
+// been completed, or nil if there is no such upload.
+func loadUpload(uuid string) (*models.BackupUpload, error) {
+	var u models.BackupUpload
+	tx := database.Instance().Where("backup = ?", uuid).Limit(1).Find(&u)
+	if tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, nil
+	}
+	return &u, nil
+}
+
+func saveUpload(u *models.BackupUpload) error {
+	if tx := database.Instance().Save(u); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// DiscardUpload removes the stored state of an upload of the backup, meaning it
+// can no longer be resumed.
+func DiscardUpload(uuid string) error {
+	if tx := database.Instance().Where("backup = ?", uuid).Delete(&models.BackupUpload{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// ResumableUploads returns every upload of a backup to S3 which was interrupted
+// before it completed and can still be resumed. Uploads whose archive is no
+// longer on the disk are discarded.
+func ResumableUploads() ([]models.BackupUpload, error) {
+	var uploads []models.BackupUpload
+	if tx := database.Instance().Find(&uploads); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	resumable := make([]models.BackupUpload, 0, len(uploads))
+	for _, u := range uploads {
+		b := NewS3(nil, u.Backup, u.Server, "")
+		if b.resumable(&u) {
+			resumable = append(resumable, u)
+			continue
+		}
+		log.WithField("backup", u.Backup).Warn("discarding interrupted backup upload, archive is no longer on the disk")
+		if err := AbandonUpload(u); err != nil {
+			return nil, err
+		}
+	}
+	return resumable, nil
+}
+
+// AbandonUpload discards an interrupted upload and removes the archive that
+// was being uploaded from the disk.
+func AbandonUpload(u models.BackupUpload) error {
+	if err := DiscardUpload(u.Backup); err != nil {
+		return err
+	}
+	b := NewS3(nil, u.Backup, u.Server, "")
+	if format, err := filesystem.ParseArchiveFormat(u.Format); err == nil {
+		b.format = format
+	}
+	if err := b.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// resumable returns whether the upload can be resumed from the archive on the
+// disk, which must be the same archive the upload was started with. The format
+// of the backup is set to the format of the archive being uploaded.
+func (s *S3Backup) resumable(u *models.BackupUpload) bool {
+	format, err := filesystem.ParseArchiveFormat(u.Format)
+	if err != nil {
+		return false
+	}
+	previous := s.format
+	s.format = format
+	if st, err := os.Stat(s.Path()); err != nil || st.Size() != u.Size {
+		s.format = previous
+		return false
+	}
+	return true
+}
+
+// uploadParts uploads every part of the upload which has not been uploaded yet,
+// with up to the configured number of parts being uploaded at the same time.
+// The state of the upload is stored as each part completes.
+func (s *S3Backup) uploadParts(ctx context.Context, u *models.BackupUpload, f io.ReaderAt) ([]remote.BackupPart, error) {
+	progress := startProgress(ctx, s.progress, u.Size)
+	defer progress.stop()
+
+	uploaded := make(map[int]bool, len(u.Parts))
+	for _, p := range u.Parts {
+		uploaded[p.PartNumber] = true
+	}
+
+	var mu sync.Mutex
+	uploader := newS3FileUploader(s.format)
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(uploadConcurrency())
+	for i, part := range u.URLs {
+		number := i + 1
+		offset := int64(i) * u.PartSize
+		// There is not a minimum size limit for the last part, which is whatever
+		// is remaining of the backup.
+		size := u.PartSize
+		if number == len(u.URLs) {
+			size = u.Size - offset
+		}
+		if uploaded[number] {
+			progress.add(size)
+			continue
+		}
+		g.Go(func() error {
+			etag, err := uploader.uploadPart(gctx, part, io.NewSectionReader(f, offset, size), progress)
+			if err != nil {
+				s.log().WithField("part_id", number).WithError(err).Warn("failed to upload part")
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			u.Parts = append(u.Parts, models.BackupUploadPart{PartNumber: number, ETag: etag})
+			// Store a copy since the state is still being read by the other
+			// parts being uploaded.
+			state := *u
+			if err := saveUpload(&state); err != nil {
+				// The upload can continue without its state, it just cannot be
+				// resumed from this part if it is interrupted.
+				s.log().WithField("part_id", number).WithError(err).Warn("failed to store state of backup upload")
+			}
+			s.log().WithField("part_id", number).Info("successfully uploaded backup part")
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	parts := make([]remote.BackupPart, len(u.Parts))
+	for i, p := range u.Parts {
+		parts[i] = remote.BackupPart{ETag: p.ETag, PartNumber: p.PartNumber}
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// uploadProgress tracks the number of bytes of a backup that have been
+// uploaded, reporting it to a callback on an interval until stopped.
+type uploadProgress struct {
+	fn       ProgressCallback
+	total    atomic.Int64
+	uploaded atomic.Int64
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// startProgress starts reporting the progress of an upload of the given total
+// size. A total of zero means the size of the backup is not known yet. If fn
+// is nil a nil progress is returned, which is safe to use.
+func startProgress(ctx context.Context, fn ProgressCallback, total int64) *uploadProgress {
+	if fn == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	p := &uploadProgress{fn: fn, cancel: cancel, done: make(chan struct{})}
+	p.total.Store(total)
+	go func() {
+		defer close(p.done)
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		var last int64 = -1
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if n := p.uploaded.Load(); n != last {
+					last = n
+					p.fn(n, p.total.Load())
+				}
+			}
+		}
+	}()
+	return p
+}
+
+func (p *uploadProgress) add(n int64) {
+	if p != nil {
+		p.uploaded.Add(n)
+	}
+}
+
+// setTotal sets the total size of the upload once it is known.
+func (p *uploadProgress) setTotal(n int64) {
+	if p != nil {
+		p.total.Store(n)
+	}
+}
+
+// stop stops reporting the progress, reporting it one last time.
+func (p *uploadProgress) stop() {
+	if p == nil {
+		return
+	}
+	p.cancel()
+	<-p.done
+	p.fn(p.uploaded.Load(), p.total.Load())
+}
+
+// progressReader adds everything read from it to the progress of an upload.
+type progressReader struct {
+	r        io.Reader
+	progress *uploadProgress
+	n        int64
+}
+
+func (pr *progressReader) Read(b []byte) (int, error) {
+	n, err := pr.r.Read(b)
+	pr.n += int64(n)
+	pr.progress.add(int64(n))
+	return n, err
+}
@@ -0,0 +1,260 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestMain(m *testing.M) {
+	root, err := os.MkdirTemp("", "wings-backup")
+	if err != nil {
+		panic(err)
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System:              config.SystemConfiguration{RootDirectory: root},
+	})
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(root)
+	os.Exit(code)
+}
+
+func TestS3BackupResumesUpload(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups:         config.Backups{S3UploadConcurrency: 1},
+		},
+	})
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	if err := os.WriteFile(filepath.Join(serverDir, "world.dat"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wings stopping is simulated by canceling the backup while the third part
+	// is being uploaded.
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	requests := make(map[int]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("part"))
+		mu.Lock()
+		requests[n]++
+		mu.Unlock()
+		if n == 3 && ctx.Err() == nil {
+			cancel()
+		}
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return
+		}
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d", n))
+	}))
+	defer srv.Close()
+
+	const partSize = 512 * 1024
+	var requested int
+	client := &streamTestClient{urls: func(size int64) remote.BackupRemoteUploadResponse {
+		requested++
+		res := remote.BackupRemoteUploadResponse{PartSize: partSize}
+		for i := int64(0); i*partSize < size; i++ {
+			res.Parts = append(res.Parts, fmt.Sprintf("%s/?part=%d", srv.URL, i+1))
+		}
+		return res
+	}}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	b := NewS3(client, "33333333-3333-3333-3333-333333333333", suuid, "")
+	if _, err := b.Generate(ctx, fsys, ""); err == nil {
+		t.Fatal("expected the interrupted backup to fail")
+	}
+	if _, err := os.Stat(b.Path()); err != nil {
+		t.Fatalf("expected the archive of the interrupted backup to be kept: %v", err)
+	}
+	uploads, err := ResumableUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || len(uploads[0].Parts) != 2 {
+		t.Fatalf("expected the interrupted upload to be resumable after two parts, got %+v", uploads)
+	}
+
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.S3UploadConcurrency = 4
+	})
+	var uploaded, total int64
+	b = NewS3(client, "33333333-3333-3333-3333-333333333333", suuid, "")
+	if !b.ResumesUpload() {
+		t.Fatal("expected generating the backup to resume the interrupted upload")
+	}
+	b.SetProgressCallback(func(u int64, t int64) {
+		uploaded, total = u, t
+	})
+	ad, err := b.Generate(context.Background(), fsys, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requested != 1 {
+		t.Fatalf("expected the resumed upload to use the original upload urls, got %d requests", requested)
+	}
+	if requests[1] != 1 || requests[2] != 1 {
+		t.Fatalf("expected uploaded parts not to be uploaded again, got %v", requests)
+	}
+	for i, p := range ad.Parts {
+		if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("etag-%d", i+1) {
+			t.Fatalf("unexpected part %+v", p)
+		}
+	}
+	if len(ad.Parts) != len(requests) || uploaded != total || total != ad.Size {
+		t.Fatalf("expected every part to be uploaded, got %d parts and %d of %d bytes", len(ad.Parts), uploaded, total)
+	}
+	if _, err := os.Stat(b.Path()); !os.IsNotExist(err) {
+		t.Fatalf("expected the archive to be removed once uploaded: %v", err)
+	}
+	if b.ResumesUpload() {
+		t.Fatal("expected nothing to resume once the upload completed")
+	}
+	if uploads, err := ResumableUploads(); err != nil || len(uploads) != 0 {
+		t.Fatalf("expected the completed upload to be discarded, got %+v: %v", uploads, err)
+	}
+}
+
+func TestS3BackupResumesUploadWithNewURLs(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups:         config.Backups{S3UploadConcurrency: 1},
+		},
+	})
+	data := make([]byte, 3*1024*1024)
+	rand.New(rand.NewSource(5)).Read(data)
+	if err := os.WriteFile(filepath.Join(serverDir, "world.dat"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every time the Panel is asked for upload URLs it returns a new generation
+	// of them, and S3 rejects the URLs of every generation before the one it
+	// accepts.
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	accepted := 1
+	uploaded := make(map[int]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gen, _ := strconv.Atoi(r.URL.Query().Get("gen"))
+		n, _ := strconv.Atoi(r.URL.Query().Get("part"))
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if gen < accepted {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if n == 3 && ctx.Err() == nil {
+			cancel()
+		}
+		uploaded[n]++
+		w.Header().Set("ETag", fmt.Sprintf("etag-%d-%d", gen, n))
+	}))
+	defer srv.Close()
+
+	const partSize = 512 * 1024
+	var generation int
+	client := &streamTestClient{urls: func(size int64) remote.BackupRemoteUploadResponse {
+		generation++
+		res := remote.BackupRemoteUploadResponse{PartSize: partSize}
+		for i := int64(0); i*partSize < size; i++ {
+			res.Parts = append(res.Parts, fmt.Sprintf("%s/?gen=%d&part=%d", srv.URL, generation, i+1))
+		}
+		return res
+	}}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	b := NewS3(client, "44444444-4444-4444-4444-444444444444", suuid, "")
+	if _, err := b.Generate(ctx, fsys, ""); err == nil {
+		t.Fatal("expected the interrupted backup to fail")
+	}
+
+	// The URLs of the interrupted upload and the first new ones have expired by
+	// the time it is resumed, which must keep the archive to be resumed again.
+	mu.Lock()
+	accepted = 3
+	mu.Unlock()
+	b = NewS3(client, "44444444-4444-4444-4444-444444444444", suuid, "")
+	if _, err := b.Generate(context.Background(), fsys, ""); !errors.Is(err, ErrUploadURLRejected) {
+		t.Fatalf("expected the resumed upload to be rejected, got %v", err)
+	}
+	if generation != 2 {
+		t.Fatalf("expected new upload urls to be requested once, got %d requests", generation)
+	}
+	if _, err := os.Stat(b.Path()); err != nil {
+		t.Fatalf("expected the archive of the rejected upload to be kept: %v", err)
+	}
+	if !b.ResumesUpload() {
+		t.Fatal("expected the rejected upload to still be resumable")
+	}
+
+	uploaded = make(map[int]int)
+	b = NewS3(client, "44444444-4444-4444-4444-444444444444", suuid, "")
+	ad, err := b.Generate(context.Background(), fsys, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, p := range ad.Parts {
+		if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("etag-3-%d", i+1) {
+			t.Fatalf("expected every part to be uploaded again with the new urls, got %+v", p)
+		}
+	}
+	if len(ad.Parts) != len(uploaded) {
+		t.Fatalf("expected every part to be uploaded, got %d parts and %v", len(ad.Parts), uploaded)
+	}
+	if _, err := os.Stat(b.Path()); !os.IsNotExist(err) {
+		t.Fatalf("expected the archive to be removed once uploaded: %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package backup
 import (
 	"bytes"
 	"context"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -101,3 +103,59 @@ func testBackupGenerateRequiresUuidIdentifier(t *testing.T, createBackup func(st
 	}
 	t.Fatal("expected backup generation not to overwrite existing archive")
 }
+
+func TestLocalBackupZstd(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups: config.Backups{
+				Compression: "zstd",
+			},
+		},
+	})
+	if err := os.WriteFile(filepath.Join(serverDir, "file.txt"), []byte("server data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	if _, err := NewLocal(nil, "11111111-1111-1111-1111-111111111111", suuid, "").Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The backup must still be found and restored once the compression has
+	// been changed back.
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.Compression = "gzip"
+	})
+	b, st, err := LocateLocal(nil, "11111111-1111-1111-1111-111111111111", suuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(st.Name(), ".tar.zst") {
+		t.Fatalf("expected a zstd compressed backup, got %s", st.Name())
+	}
+	var restored string
+	if err := b.Restore(context.Background(), nil, func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+		c, err := io.ReadAll(r)
+		restored = file + ": " + string(c)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if restored != "file.txt: server data" {
+		t.Fatalf("unexpected restored file: %q", restored)
+	}
+}
@@ -0,0 +1,190 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// errStopWalk is returned by a callback to stop reading a backup once every
+// file that is needed from it has been found.
+var errStopWalk = errors.Sentinel("backup: stop walking backup")
+
+// CleanEntryName returns the name of an entry within a backup as a relative
+// slash-separated path, which is how paths are matched against the entries of
+// a backup. An empty string is returned for the root of the backup or for any
+// path that would escape it.
+func CleanEntryName(name string) string {
+	clean := path.Clean(strings.TrimPrefix(strings.ReplaceAll(name, "\\", "/"), "/"))
+	if clean == "." || !fs.ValidPath(clean) {
+		return ""
+	}
+	return clean
+}
+
+// walk calls fn for every entry in the backup. The contents of a file are only
+// read from the backup if fn reads them. S3 backups are read from r, while any
+// other backup is read from where it is stored on this machine.
+func walk(ctx context.Context, b BackupInterface, r io.Reader, fn RestoreCallback) error {
+	var err error
+	switch v := b.(type) {
+	case *DedupBackup:
+		// The contents of a deduplicated backup are only read from the chunk
+		// store when they are needed, so listing one only reads its manifest.
+		err = v.Restore(ctx, nil, fn)
+	case *LocalBackup:
+		if err := v.validateIdentifier(); err != nil {
+			return err
+		}
+		f, ferr := os.Open(v.Path())
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		err = v.extract(ctx, f, fn)
+	case *S3Backup:
+		if r == nil {
+			return errors.New("backup: a reader is required to read an S3 backup")
+		}
+		err = v.extract(ctx, r, fn)
+	default:
+		return errors.New("backup: backup type cannot be browsed")
+	}
+	if errors.Is(err, errStopWalk) {
+		return nil
+	}
+	return err
+}
+
+// ListFiles returns every file and directory within the backup, beneath the
+// given directory if one is provided. Only the index of the backup is read, the
+// backup is never extracted to the disk.
+//
+// Backups only contain entries for files, so the directories they are in are
+// listed as well to describe the full tree.
+func ListFiles(ctx context.Context, b BackupInterface, r io.Reader, dir string) ([]filesystem.ArchiveEntry, error) {
+	dir = CleanEntryName(dir)
+	out := []filesystem.ArchiveEntry{}
+	seen := make(map[string]bool)
+	add := func(e filesystem.ArchiveEntry) {
+		if seen[e.Name] {
+			return
+		}
+		seen[e.Name] = true
+		out = append(out, e)
+	}
+	err := walk(ctx, b, r, func(name string, info fs.FileInfo, _ io.ReadCloser) error {
+		name = CleanEntryName(name)
+		if name == "" || !withinPaths(name, []string{dir}) || name == dir {
+			return nil
+		}
+		for p := path.Dir(name); p != "." && p != dir; p = path.Dir(p) {
+			add(filesystem.ArchiveEntry{
+				Name:     p,
+				Mode:     fs.ModeDir | 0o755,
+				Modified: info.ModTime(),
+			})
+		}
+		add(filesystem.ArchiveEntry{
+			Name:     name,
+			Size:     info.Size(),
+			Mode:     info.Mode(),
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RestoreFiles calls the callback for every entry in the backup that matches
+// one of the given paths, which may be either files or directories. Reading the
+// backup stops as soon as every requested file has been found, unless a
+// directory was requested in which case the entire backup is read.
+func RestoreFiles(ctx context.Context, b BackupInterface, r io.Reader, paths []string, callback RestoreCallback) error {
+	roots := make([]string, 0, len(paths))
+	for _, p := range paths {
+		clean := CleanEntryName(p)
+		if clean == "" {
+			return errors.New("backup: invalid path to restore: " + p)
+		}
+		roots = append(roots, clean)
+	}
+	remaining := make(map[string]bool, len(roots))
+	for _, root := range roots {
+		remaining[root] = true
+	}
+	var found bool
+	err := walk(ctx, b, r, func(name string, info fs.FileInfo, rc io.ReadCloser) error {
+		name = CleanEntryName(name)
+		if name == "" || !withinPaths(name, roots) {
+			return nil
+		}
+		found = true
+		if err := callback(name, info, rc); err != nil {
+			return err
+		}
+		if remaining[name] && !info.IsDir() {
+			delete(remaining, name)
+			if len(remaining) == 0 {
+				return errStopWalk
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.WithStack(os.ErrNotExist)
+	}
+	return nil
+}
+
+// ExtractFile calls fn with the contents of a single file within the backup,
+// returning an error matching os.ErrNotExist if the backup does not contain
+// the file.
+func ExtractFile(ctx context.Context, b BackupInterface, r io.Reader, p string, fn func(info fs.FileInfo, r io.Reader) error) error {
+	p = CleanEntryName(p)
+	if p == "" {
+		return errors.WithStack(os.ErrNotExist)
+	}
+	var found bool
+	err := walk(ctx, b, r, func(name string, info fs.FileInfo, rc io.ReadCloser) error {
+		if CleanEntryName(name) != p || !info.Mode().IsRegular() {
+			return nil
+		}
+		found = true
+		if err := fn(info, rc); err != nil {
+			return err
+		}
+		return errStopWalk
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.WithStack(os.ErrNotExist)
+	}
+	return nil
+}
+
+// withinPaths returns whether the name is one of the given paths or is within
+// one of them. An empty path matches everything.
+func withinPaths(name string, paths []string) bool {
+	for _, p := range paths {
+		if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+			return true
+		}
+	}
+	return false
+}
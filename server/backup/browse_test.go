@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestBrowseBackup(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, filepath.Join(serverDir, "world", "region")} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+		},
+	})
+	files := map[string]string{
+		"server.properties":      "motd=hello",
+		"world/level.dat":        "level",
+		"world/region/r.0.0.mca": "region",
+		"worldedit/config.yml":   "unrelated",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(serverDir, name)), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(serverDir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	local := NewLocal(nil, "44444444-4444-4444-4444-444444444444", suuid, "")
+	dedup := NewDedup(nil, "55555555-5555-5555-5555-555555555555", suuid, "")
+	for _, b := range []BackupInterface{local, dedup} {
+		if _, err := b.Generate(context.Background(), fsys, ""); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := ListFiles(context.Background(), b, nil, "/world/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+		sort.Strings(names)
+		if len(names) != 3 || names[0] != "world/level.dat" || names[1] != "world/region" || names[2] != "world/region/r.0.0.mca" {
+			t.Fatalf("unexpected entries in world directory: %v", names)
+		}
+
+		restored := make(map[string]string)
+		if err := RestoreFiles(context.Background(), b, nil, []string{"world", "server.properties"}, func(file string, info fs.FileInfo, r io.ReadCloser) error {
+			if info.IsDir() {
+				return nil
+			}
+			c, err := io.ReadAll(r)
+			restored[file] = string(c)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(restored) != 3 || restored["world/region/r.0.0.mca"] != "region" || restored["server.properties"] != "motd=hello" {
+			t.Fatalf("unexpected restored files: %v", restored)
+		}
+
+		var content []byte
+		if err := ExtractFile(context.Background(), b, nil, "world/level.dat", func(_ fs.FileInfo, r io.Reader) error {
+			content, err = io.ReadAll(r)
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "level" {
+			t.Fatalf("unexpected file contents: %q", content)
+		}
+
+		err = ExtractFile(context.Background(), b, nil, "world", func(fs.FileInfo, io.Reader) error {
+			t.Fatal("expected a directory not to be extracted as a file")
+			return nil
+		})
+		if !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected extracting a directory to fail, got %v", err)
+		}
+		if err := RestoreFiles(context.Background(), b, nil, []string{"missing.txt"}, func(string, fs.FileInfo, io.ReadCloser) error {
+			return nil
+		}); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected restoring a missing file to fail, got %v", err)
+		}
+		if err := RestoreFiles(context.Background(), b, nil, []string{"../etc/passwd"}, nil); err == nil {
+			t.Fatal("expected a path outside the backup to be rejected")
+		}
+	}
+}
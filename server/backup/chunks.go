@@ -0,0 +1,478 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
+)
+
+// Chunks are cut where the content allows it rather than at fixed offsets, so
+// that inserting or removing data in a file only changes the chunks around
+// the change. These sizes are part of the storage format, changing them would
+// stop new backups from sharing chunks with existing ones.
+const (
+	minChunkSize = 256 * 1024
+	avgChunkSize = 1024 * 1024
+	maxChunkSize = 4 * 1024 * 1024
+)
+
+// Masks used to find a cut point, a cut is less likely before the average
+// chunk size and more likely after it, which keeps most chunks close to the
+// average size.
+const (
+	chunkMaskSmall = uint64(1<<22-1) << (64 - 22)
+	chunkMaskLarge = uint64(1<<18-1) << (64 - 18)
+)
+
+// gear is the table of random values used by the rolling hash. It is
+// generated from a fixed seed so that the same content is always cut at the
+// same places.
+var gear = func() (t [256]uint64) {
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range t {
+		// splitmix64
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		t[i] = z ^ (z >> 31)
+	}
+	return t
+}()
+
+// ErrChunkCorrupted is returned when a stored chunk does not match the
+// checksum it is identified by.
+var ErrChunkCorrupted = errors.Sentinel("backup: chunk is corrupted")
+
+// chunker splits the data read from a reader into content-defined chunks.
+type chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+func newChunker() *chunker {
+	return &chunker{buf: make([]byte, maxChunkSize)}
+}
+
+// Reset starts splitting the data read from r.
+func (c *chunker) Reset(r io.Reader) {
+	c.r = r
+	c.start, c.end = 0, 0
+	c.eof = false
+}
+
+// Next returns the next chunk, or io.EOF once all the data has been read. The
+// chunk is only valid until the next call to Next.
+func (c *chunker) Next() ([]byte, error) {
+	if c.end-c.start < maxChunkSize && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cutPoint returns the length of the first chunk in b.
+func cutPoint(b []byte) int {
+	n := len(b)
+	if n <= minChunkSize {
+		return n
+	}
+	n = min(n, maxChunkSize)
+	normal := min(n, avgChunkSize)
+	var h uint64
+	i := minChunkSize
+	for ; i < normal; i++ {
+		h = (h << 1) + gear[b[i]]
+		if h&chunkMaskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		h = (h << 1) + gear[b[i]]
+		if h&chunkMaskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// chunkID returns the identifier of a chunk, which is the SHA256 checksum of
+// its contents.
+func chunkID(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func isChunkID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// ChunkStore stores the chunks of deduplicated backups. Each chunk is stored
+// only once no matter how many backups reference it.
+type ChunkStore interface {
+	// Stat returns the stored size of a chunk, or an error matching
+	// os.ErrNotExist if the chunk is not stored.
+	Stat(ctx context.Context, id string) (int64, error)
+	// Put stores a chunk and returns its stored size.
+	Put(ctx context.Context, id string, data []byte) (int64, error)
+	// Get returns the contents of a chunk.
+	Get(ctx context.Context, id string) ([]byte, error)
+	// Delete removes a chunk.
+	Delete(ctx context.Context, id string) error
+	// Walk calls fn with the identifier of every stored chunk.
+	Walk(ctx context.Context, fn func(id string) error) error
+}
+
+var (
+	chunkEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	chunkDecoder, _ = zstd.NewReader(nil)
+)
+
+// LocalChunkStore stores chunks compressed with zstd in a directory on the
+// disk, using the first two characters of their identifier as a subdirectory.
+type LocalChunkStore struct {
+	root string
+}
+
+var _ ChunkStore = (*LocalChunkStore)(nil)
+
+// NewLocalChunkStore returns a chunk store using the given directory.
+func NewLocalChunkStore(root string) *LocalChunkStore {
+	return &LocalChunkStore{root: root}
+}
+
+// chunkDirectory returns the directory chunks of local deduplicated backups
+// are stored in.
+func chunkDirectory() string {
+	return filepath.Join(config.Get().System.BackupDirectory, ".chunks")
+}
+
+func (s *LocalChunkStore) path(id string) (string, error) {
+	if !isChunkID(id) {
+		return "", errors.New("backup: invalid chunk identifier: " + id)
+	}
+	return filepath.Join(s.root, id[:2], id), nil
+}
+
+func (s *LocalChunkStore) Stat(_ context.Context, id string) (int64, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (s *LocalChunkStore) Put(_ context.Context, id string, data []byte) (int64, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return 0, err
+	}
+	b := chunkEncoder.EncodeAll(data, nil)
+	// Write to a temporary file first so that a partially written chunk is
+	// never referenced by a backup.
+	f, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return 0, err
+	}
+	return int64(len(b)), nil
+}
+
+func (s *LocalChunkStore) Get(_ context.Context, id string) ([]byte, error) {
+	p, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	data, err := chunkDecoder.DecodeAll(b, nil)
+	if err != nil || chunkID(data) != id {
+		return nil, errors.WithStack(ErrChunkCorrupted)
+	}
+	return data, nil
+}
+
+func (s *LocalChunkStore) Delete(_ context.Context, id string) error {
+	p, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(p)
+}
+
+// Walk calls fn with the identifier of every stored chunk. Any temporary file
+// left behind by a chunk that was never finished being written is removed.
+func (s *LocalChunkStore) Walk(ctx context.Context, fn func(id string) error) error {
+	err := filepath.WalkDir(s.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			if info, err := d.Info(); err == nil && time.Since(info.ModTime()) > time.Hour {
+				_ = os.Remove(p)
+			}
+			return nil
+		}
+		if !isChunkID(d.Name()) {
+			return nil
+		}
+		return fn(d.Name())
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ManifestChunks returns the identifier of every chunk in the local chunk
+// store referenced by the manifest of a deduplicated backup stored at the
+// given path. Chunks stored in object storage are not returned, they can be
+// read from anywhere the backup is.
+func ManifestChunks(p string) ([]string, error) {
+	m, err := readManifest(p)
+	if err != nil {
+		return nil, err
+	}
+	if m.Storage != "" && m.Storage != chunkStorageLocal {
+		return nil, nil
+	}
+	seen := make(map[string]struct{})
+	var ids []string
+	for _, f := range m.Files {
+		for _, id := range f.Chunks {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// OpenChunk opens a chunk stored in the local chunk store, returning its
+// contents as they are stored on the disk.
+func OpenChunk(id string) (*os.File, error) {
+	p, err := NewLocalChunkStore(chunkDirectory()).path(id)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(p)
+}
+
+// ReceiveChunk stores a chunk read from r, as it was opened by OpenChunk on
+// another machine, in the local chunk store. The chunk is rejected if its
+// contents do not match its identifier.
+func ReceiveChunk(ctx context.Context, id string, r io.Reader) error {
+	store := NewLocalChunkStore(chunkDirectory())
+	if _, err := store.Stat(ctx, id); err == nil {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	// Compressing a chunk never makes it much larger, anything bigger than
+	// this is not a chunk.
+	b, err := io.ReadAll(io.LimitReader(r, 2*maxChunkSize+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > 2*maxChunkSize {
+		return errors.WithStack(ErrChunkCorrupted)
+	}
+	data, err := chunkDecoder.DecodeAll(b, nil)
+	if err != nil || chunkID(data) != id {
+		return errors.WithStack(ErrChunkCorrupted)
+	}
+	_, err = store.Put(ctx, id, data)
+	return err
+}
+
+// chunkLock prevents chunks from being pruned while a backup that may
+// reference them is being created.
+var chunkLock sync.RWMutex
+
+var (
+	pruneOnce    sync.Once
+	pruneTrigger = make(chan struct{}, 1)
+)
+
+// SchedulePrune removes any chunks which are no longer referenced by a backup
+// in the background. Calls made while a prune is waiting to run are merged
+// into it.
+func SchedulePrune() {
+	pruneOnce.Do(func() {
+		go func() {
+			for range pruneTrigger {
+				removed, err := PruneChunks(context.Background())
+				if err != nil {
+					log.WithField("error", err).Error("backup: failed to prune unreferenced chunks")
+					continue
+				}
+				if removed > 0 {
+					log.WithField("chunks", removed).Info("backup: pruned unreferenced chunks")
+				}
+			}
+		}()
+	})
+	select {
+	case pruneTrigger <- struct{}{}:
+	default:
+	}
+}
+
+// PruneChunks removes every chunk from the local chunk store that is not
+// referenced by the manifest of any deduplicated backup, returning the number
+// of chunks removed. If any manifest cannot be read nothing is removed, since
+// the chunks it references cannot be known.
+func PruneChunks(ctx context.Context) (int, error) {
+	chunkLock.Lock()
+	defer chunkLock.Unlock()
+
+	manifests, err := filepath.Glob(filepath.Join(config.Get().System.BackupDirectory, "*", "*"+manifestExtension))
+	if err != nil {
+		return 0, err
+	}
+	referenced := make(map[string]struct{})
+	for _, p := range manifests {
+		m, err := readManifest(p)
+		if err != nil {
+			return 0, errors.WrapIf(err, "backup: failed to read manifest "+p)
+		}
+		for _, f := range m.Files {
+			for _, id := range f.Chunks {
+				referenced[id] = struct{}{}
+			}
+		}
+	}
+
+	store := NewLocalChunkStore(chunkDirectory())
+	var removed int
+	err = store.Walk(ctx, func(id string) error {
+		if _, ok := referenced[id]; ok {
+			return nil
+		}
+		if err := store.Delete(ctx, id); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// schedulePruneServerChunks removes any chunks of the server stored in object
+// storage which are no longer referenced by one of its backups in the
+// background.
+func (b *DedupBackup) schedulePruneServerChunks() {
+	client, server := b.client, b.ServerId()
+	go func() {
+		removed, err := pruneServerChunks(context.Background(), client, server)
+		if err != nil {
+			log.WithFields(log.Fields{"server": server, "error": err}).Error("backup: failed to prune unreferenced chunks in object storage")
+			return
+		}
+		if removed > 0 {
+			log.WithFields(log.Fields{"server": server, "chunks": removed}).Info("backup: pruned unreferenced chunks in object storage")
+		}
+	}()
+}
+
+// pruneServerChunks removes every chunk of a server stored in object storage
+// that is not referenced by the manifest of one of its deduplicated backups,
+// returning the number of chunks removed. Nothing is removed once the server
+// has no deduplicated backups left on this node, since they may have been
+// transferred to another node along with the server.
+func pruneServerChunks(ctx context.Context, client remote.Client, server string) (int, error) {
+	chunkLock.Lock()
+	defer chunkLock.Unlock()
+
+	manifests, err := filepath.Glob(filepath.Join(config.Get().System.BackupDirectory, server, "*"+manifestExtension))
+	if err != nil || len(manifests) == 0 {
+		return 0, err
+	}
+	referenced := make(map[string]struct{})
+	for _, p := range manifests {
+		m, err := readManifest(p)
+		if err != nil {
+			return 0, errors.WrapIf(err, "backup: failed to read manifest "+p)
+		}
+		for _, f := range m.Files {
+			for _, id := range f.Chunks {
+				referenced[id] = struct{}{}
+			}
+		}
+	}
+
+	store := NewS3ChunkStore(client, server)
+	var removed int
+	err = store.Walk(ctx, func(id string) error {
+		if _, ok := referenced[id]; ok {
+			return nil
+		}
+		if err := store.Delete(ctx, id); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
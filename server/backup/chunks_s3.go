@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/remote"
+)
+
+// The storage of deduplicated backups, as configured by ChunkStorage and
+// recorded in the manifest of each backup.
+const (
+	chunkStorageLocal = "local"
+	chunkStorageS3    = "s3"
+)
+
+// S3ChunkStore stores the chunks of the deduplicated backups of a server in
+// object storage, compressed with zstd in the same way as LocalChunkStore.
+// Wings is never given credentials for the object storage, every request is
+// made to a URL presigned by the Panel, and the Panel lists the chunks that
+// are stored for the server.
+type S3ChunkStore struct {
+	client     remote.Client
+	server     string
+	httpClient *http.Client
+
+	mu     sync.Mutex
+	stored map[string]int64
+}
+
+var _ ChunkStore = (*S3ChunkStore)(nil)
+
+// NewS3ChunkStore returns a chunk store for the deduplicated backups of the
+// given server.
+func NewS3ChunkStore(client remote.Client, server string) *S3ChunkStore {
+	return &S3ChunkStore{
+		client:     client,
+		server:     server,
+		httpClient: &http.Client{Timeout: time.Minute * 5},
+	}
+}
+
+// do makes a request for a chunk to the URL presigned by the Panel for it.
+func (s *S3ChunkStore) do(ctx context.Context, method string, id string, body []byte) (*http.Response, error) {
+	if !isChunkID(id) {
+		return nil, errors.New("backup: invalid chunk identifier: " + id)
+	}
+	urls, err := s.client.GetBackupChunkURLs(ctx, s.server, method, []string{id})
+	if err != nil {
+		return nil, errors.WrapIf(err, "backup: failed to get presigned URL for chunk")
+	}
+	u, ok := urls[id]
+	if !ok {
+		return nil, errors.New("backup: panel did not return a presigned URL for chunk " + id)
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = int64(len(body))
+	}
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		_ = res.Body.Close()
+		return nil, errors.New("backup: failed to " + method + " chunk " + id + ": unexpected status code " + strconv.Itoa(res.StatusCode))
+	}
+	return res, nil
+}
+
+// Stat returns the stored size of a chunk from the chunks the Panel lists for
+// the server, which are only listed the first time this is called.
+func (s *S3ChunkStore) Stat(ctx context.Context, id string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored == nil {
+		chunks, err := s.client.GetBackupChunks(ctx, s.server)
+		if err != nil {
+			return 0, errors.WrapIf(err, "backup: failed to list stored chunks")
+		}
+		s.stored = make(map[string]int64, len(chunks))
+		for _, c := range chunks {
+			s.stored[c.ID] = c.Size
+		}
+	}
+	size, ok := s.stored[id]
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	return size, nil
+}
+
+func (s *S3ChunkStore) Put(ctx context.Context, id string, data []byte) (int64, error) {
+	b := chunkEncoder.EncodeAll(data, nil)
+	res, err := s.do(ctx, http.MethodPut, id, b)
+	if err != nil {
+		return 0, err
+	}
+	_ = res.Body.Close()
+	s.mu.Lock()
+	if s.stored != nil {
+		s.stored[id] = int64(len(b))
+	}
+	s.mu.Unlock()
+	return int64(len(b)), nil
+}
+
+func (s *S3ChunkStore) Get(ctx context.Context, id string) ([]byte, error) {
+	res, err := s.do(ctx, http.MethodGet, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	// Compressing a chunk never makes it much larger, anything bigger than
+	// this is not a chunk.
+	b, err := io.ReadAll(io.LimitReader(res.Body, 2*maxChunkSize+1))
+	if err != nil {
+		return nil, err
+	}
+	data, err := chunkDecoder.DecodeAll(b, nil)
+	if err != nil || len(b) > 2*maxChunkSize || chunkID(data) != id {
+		return nil, errors.WithStack(ErrChunkCorrupted)
+	}
+	return data, nil
+}
+
+func (s *S3ChunkStore) Delete(ctx context.Context, id string) error {
+	res, err := s.do(ctx, http.MethodDelete, id, nil)
+	if err != nil {
+		return err
+	}
+	_ = res.Body.Close()
+	s.mu.Lock()
+	delete(s.stored, id)
+	s.mu.Unlock()
+	return nil
+}
+
+// Walk calls fn with the identifier of every chunk the Panel lists for the
+// server.
+func (s *S3ChunkStore) Walk(ctx context.Context, fn func(id string) error) error {
+	chunks, err := s.client.GetBackupChunks(ctx, s.server)
+	if err != nil {
+		return errors.WrapIf(err, "backup: failed to list stored chunks")
+	}
+	for _, c := range chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !isChunkID(c.ID) {
+			continue
+		}
+		if err := fn(c.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// chunkTestClient presigns URLs for chunks stored by an in-memory object
+// storage server.
+type chunkTestClient struct {
+	remote.Client
+	url string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newChunkTestClient(t *testing.T) *chunkTestClient {
+	c := &chunkTestClient{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/")
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			c.objects[id] = b
+		case http.MethodGet:
+			b, ok := c.objects[id]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(b)
+		case http.MethodDelete:
+			delete(c.objects, id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	c.url = srv.URL
+	return c
+}
+
+func (c *chunkTestClient) GetBackupChunkURLs(_ context.Context, _ string, _ string, chunks []string) (map[string]string, error) {
+	urls := make(map[string]string)
+	for _, id := range chunks {
+		urls[id] = c.url + "/" + id
+	}
+	return urls, nil
+}
+
+func (c *chunkTestClient) GetBackupChunks(context.Context, string) ([]remote.BackupChunk, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var chunks []remote.BackupChunk
+	for id, b := range c.objects {
+		chunks = append(chunks, remote.BackupChunk{ID: id, Size: int64(len(b))})
+	}
+	return chunks, nil
+}
+
+func (c *chunkTestClient) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.objects)
+}
+
+func TestDedupBackupObjectStorage(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups:         config.Backups{ChunkStorage: "s3"},
+		},
+	})
+
+	world := make([]byte, 4*1024*1024)
+	rand.New(rand.NewSource(3)).Read(world)
+	if err := os.WriteFile(filepath.Join(serverDir, "world.dat"), world, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := newChunkTestClient(t)
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	first := NewDedup(client, "11111111-1111-1111-1111-111111111111", suuid, "")
+	if _, err := first.Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+	stored := client.count()
+	if stored == 0 {
+		t.Fatal("expected chunks to be stored in object storage")
+	}
+	if _, err := os.Stat(chunkDirectory()); !os.IsNotExist(err) {
+		t.Fatal("expected no chunks to be stored on the disk")
+	}
+	if ids, err := ManifestChunks(first.Path()); err != nil || len(ids) != 0 {
+		t.Fatalf("expected no local chunks to be referenced, got %v %v", ids, err)
+	}
+
+	// The backup is read back from object storage even once chunks are stored
+	// on the disk again.
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.ChunkStorage = "local"
+	})
+	var restored []byte
+	b, _, err := LocateDedup(client, first.Identifier(), suuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Restore(context.Background(), nil, func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+		if file == "world.dat" {
+			restored, err = io.ReadAll(r)
+			return err
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, world) {
+		t.Fatal("expected the backup to restore the original world")
+	}
+
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.ChunkStorage = "s3"
+	})
+	rand.New(rand.NewSource(4)).Read(world[:1024*1024])
+	if err := os.WriteFile(filepath.Join(serverDir, "world.dat"), world, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	second := NewDedup(client, "22222222-2222-2222-2222-222222222222", suuid, "")
+	if _, err := second.Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+	if client.count() <= stored {
+		t.Fatal("expected the changed chunks of the second backup to be stored")
+	}
+
+	// Removing the first backup only removes the chunks the second one does
+	// not reference.
+	if err := os.Remove(first.Path()); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := pruneServerChunks(context.Background(), client, suuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed == 0 {
+		t.Fatal("expected chunks only referenced by the first backup to be removed")
+	}
+	if err := second.Restore(context.Background(), nil, func(string, fs.FileInfo, io.ReadCloser) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is removed once there are no backups of the server left, since
+	// they may have been moved to another node.
+	if err := os.Remove(second.Path()); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := pruneServerChunks(context.Background(), client, suuid); err != nil || removed != 0 {
+		t.Fatalf("expected no chunks to be removed, got %d %v", removed, err)
+	}
+}
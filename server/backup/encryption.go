@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+
+	"emperror.dev/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Encrypted backups start with this header, followed by a random salt which
+// the key for the backup is derived from. The rest of the backup is split into
+// segments which are each encrypted with AES-256-GCM, so that backups can be
+// encrypted and decrypted as they are streamed.
+var encryptionHeader = []byte("WINGSENC\x01")
+
+const (
+	encryptionSaltSize = 32
+	// The size of the plaintext in each encrypted segment, every segment other
+	// than the last one is exactly this size.
+	encryptionSegmentSize = 64 * 1024
+)
+
+var (
+	// ErrMissingEncryptionKey is returned when restoring an encrypted backup
+	// without an encryption key.
+	ErrMissingEncryptionKey = errors.Sentinel("backup: backup is encrypted but no encryption key was provided")
+	// ErrDecryptionFailed is returned when an encrypted backup cannot be
+	// decrypted, either because the key is wrong or the backup was modified.
+	ErrDecryptionFailed = errors.Sentinel("backup: failed to decrypt backup, the encryption key is wrong or the backup is corrupted")
+)
+
+// ParseEncryptionKey parses a base64 encoded 256-bit encryption key. An empty
+// string returns a nil key, meaning backups are not encrypted.
+func ParseEncryptionKey(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("backup: encryption key must be 32 bytes encoded as base64")
+	}
+	return key, nil
+}
+
+// newSegmentCipher returns the cipher used for a backup, using a key derived
+// from the encryption key and the salt of the backup so that no two backups
+// are encrypted with the same key.
+func newSegmentCipher(key []byte, salt []byte) (cipher.AEAD, error) {
+	derived := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, salt, []byte("wings backup")), derived); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// segmentNonce returns the nonce for a segment. The last segment uses a
+// different nonce so that a backup which has been cut short at the end of a
+// segment cannot be decrypted.
+func segmentNonce(nonce []byte, counter uint64, last bool) []byte {
+	clear(nonce)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+type encryptWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	buf     []byte
+	out     []byte
+}
+
+// newEncryptWriter returns a writer which encrypts everything written to it
+// with the given key before writing it to w. The writer must be closed to
+// write the final segment of the backup.
+func newEncryptWriter(w io.Writer, key []byte) (io.WriteCloser, error) {
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := newSegmentCipher(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(append(append([]byte(nil), encryptionHeader...), salt...)); err != nil {
+		return nil, err
+	}
+	return &encryptWriter{
+		w:     w,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		buf:   make([]byte, 0, encryptionSegmentSize),
+		out:   make([]byte, 0, encryptionSegmentSize+aead.Overhead()),
+	}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		// A full segment is only written once more data arrives, since the
+		// last segment must be marked as such.
+		if len(e.buf) == encryptionSegmentSize {
+			if err := e.flush(false); err != nil {
+				return n, err
+			}
+		}
+		c := copy(e.buf[len(e.buf):encryptionSegmentSize], p)
+		e.buf = e.buf[:len(e.buf)+c]
+		p = p[c:]
+		n += c
+	}
+	return n, nil
+}
+
+func (e *encryptWriter) flush(last bool) error {
+	e.out = e.aead.Seal(e.out[:0], segmentNonce(e.nonce, e.counter, last), e.buf, nil)
+	e.counter++
+	e.buf = e.buf[:0]
+	_, err := e.w.Write(e.out)
+	return err
+}
+
+// Close writes the final segment, it does not close the underlying writer.
+func (e *encryptWriter) Close() error {
+	return e.flush(true)
+}
+
+type decryptReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	counter uint64
+	in      []byte
+	buf     []byte
+	done    bool
+}
+
+// NewDecryptReader returns a reader which decrypts the backup read from r if
+// it is encrypted, along with whether it was. Backups which are not encrypted
+// are read as they are, so backups created before encryption was enabled can
+// still be restored.
+func NewDecryptReader(r io.Reader, key []byte) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	if h, _ := br.Peek(len(encryptionHeader)); !bytes.Equal(h, encryptionHeader) {
+		return br, false, nil
+	}
+	if key == nil {
+		return nil, true, errors.WithStack(ErrMissingEncryptionKey)
+	}
+	if _, err := br.Discard(len(encryptionHeader)); err != nil {
+		return nil, true, err
+	}
+	salt := make([]byte, encryptionSaltSize)
+	if _, err := io.ReadFull(br, salt); err != nil {
+		return nil, true, errors.WithStack(ErrDecryptionFailed)
+	}
+	aead, err := newSegmentCipher(key, salt)
+	if err != nil {
+		return nil, true, err
+	}
+	return &decryptReader{
+		r:     br,
+		aead:  aead,
+		nonce: make([]byte, aead.NonceSize()),
+		in:    make([]byte, encryptionSegmentSize+aead.Overhead()),
+	}, true, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// next decrypts the next segment of the backup.
+func (d *decryptReader) next() error {
+	n, err := io.ReadFull(d.r, d.in)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		if err == io.EOF {
+			// The final segment was never found, so the backup has been cut
+			// short.
+			return errors.WithStack(ErrDecryptionFailed)
+		}
+		return err
+	}
+	last := err == io.ErrUnexpectedEOF
+	if !last {
+		if _, perr := d.r.Peek(1); perr == io.EOF {
+			last = true
+		}
+	}
+	d.buf, err = d.aead.Open(d.in[:0], segmentNonce(d.nonce, d.counter, last), d.in[:n], nil)
+	if err != nil {
+		return errors.WithStack(ErrDecryptionFailed)
+	}
+	d.counter++
+	d.done = last
+	return nil
+}
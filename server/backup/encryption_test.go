@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func encrypt(t *testing.T, data []byte, key []byte) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	w, err := newEncryptWriter(&b, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in uneven pieces to cross the segment boundaries.
+	for len(data) > 0 {
+		n := min(len(data), 10_000)
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func decrypt(data []byte, key []byte) ([]byte, error) {
+	r, _, err := NewDecryptReader(bytes.NewReader(data), key)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	for _, size := range []int{0, 1, encryptionSegmentSize, encryptionSegmentSize + 1, 3*encryptionSegmentSize - 7} {
+		data := make([]byte, size)
+		rand.New(rand.NewSource(int64(size))).Read(data)
+
+		enc := encrypt(t, data, key)
+		if size >= 16 && bytes.Contains(enc, data) {
+			t.Fatalf("expected %d bytes to be encrypted", size)
+		}
+		got, err := decrypt(enc, key)
+		if err != nil {
+			t.Fatalf("failed to decrypt %d bytes: %v", size, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("expected %d bytes to be decrypted back to the original", size)
+		}
+	}
+
+	data := make([]byte, 2*encryptionSegmentSize+100)
+	enc := encrypt(t, data, key)
+	if _, err := decrypt(enc, bytes.Repeat([]byte{2}, 32)); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected the wrong key to fail, got %v", err)
+	}
+	if _, err := decrypt(enc, nil); !errors.Is(err, ErrMissingEncryptionKey) {
+		t.Fatalf("expected a missing key to fail, got %v", err)
+	}
+
+	// Cutting the backup off at the end of a segment must not go unnoticed.
+	header := len(encryptionHeader) + encryptionSaltSize
+	segment := encryptionSegmentSize + 16
+	if _, err := decrypt(enc[:header+2*segment], key); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected a truncated backup to fail, got %v", err)
+	}
+	tampered := bytes.Clone(enc)
+	tampered[header+10] ^= 1
+	if _, err := decrypt(tampered, key); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected a modified backup to fail, got %v", err)
+	}
+
+	// Backups which are not encrypted are read as they are.
+	if got, err := decrypt([]byte("plain"), key); err != nil || string(got) != "plain" {
+		t.Fatalf("expected an unencrypted backup to be read as is, got %q: %v", got, err)
+	}
+}
+
+func TestLocalBackupEncryption(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	key := bytes.Repeat([]byte{3}, 32)
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+			Backups: config.Backups{
+				EncryptionKey: base64.StdEncoding.EncodeToString(key),
+			},
+		},
+	})
+	if err := os.WriteFile(filepath.Join(serverDir, "file.txt"), []byte("server data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewLocal(nil, "11111111-1111-1111-1111-111111111111", "ce6ee345-6729-4aed-8fed-c866c535a69d", "")
+	if _, err := b.Generate(context.Background(), fsys, ""); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := os.ReadFile(b.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(stored, encryptionHeader) {
+		t.Fatal("expected the backup to be encrypted on the disk")
+	}
+
+	var restored string
+	if err := b.Restore(context.Background(), nil, func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+		c, err := io.ReadAll(r)
+		restored = file + ": " + string(c)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if restored != "file.txt: server data" {
+		t.Fatalf("unexpected restored file: %q", restored)
+	}
+
+	// A key sent for the server takes priority over the key of the node.
+	b.SetEncryptionKey(bytes.Repeat([]byte{4}, 32))
+	if err := b.Restore(context.Background(), nil, func(string, fs.FileInfo, io.ReadCloser) error {
+		return nil
+	}); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("expected restoring with a different key to fail, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+)
+
+// StoredBackups returns every backup stored on this machine, oldest first.
+// Snapshots are not included.
+func StoredBackups() ([]models.BackupChecksum, error) {
+	var backups []models.BackupChecksum
+	snapshots := database.Instance().Model(&models.Snapshot{}).Select("uuid")
+	if tx := database.Instance().Where("backup NOT IN (?)", snapshots).Order("created_at").Find(&backups); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return backups, nil
+}
+
+// LocateStored finds a backup stored on this machine.
+func LocateStored(c models.BackupChecksum) (BackupInterface, error) {
+	if AdapterType(c.Adapter) == DedupBackupAdapter {
+		b, _, err := LocateDedup(nil, c.Backup, c.Server)
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	b, _, err := LocateLocal(nil, c.Backup, c.Server)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RemoveStored removes a backup stored on this machine. A backup which is
+// already gone from the disk is only forgotten.
+func RemoveStored(c models.BackupChecksum) error {
+	b, err := LocateStored(c)
+	if err == nil {
+		return b.Remove()
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if tx := database.Instance().Where("backup = ?", c.Backup).Delete(&models.BackupChecksum{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// ForgetServerBackups forgets every backup and snapshot of the server, which
+// is used once all of them have been removed from the disk at once.
+func ForgetServerBackups(suuid string) error {
+	if tx := database.Instance().Where("server = ?", suuid).Delete(&models.BackupChecksum{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	if tx := database.Instance().Where("server = ?", suuid).Delete(&models.Snapshot{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// RecordExistingBackups records every backup found in the backup directory
+// which is not already known, such as backups created before their checksums
+// were recorded. Their checksum is left empty since computing it would mean
+// reading every one of them. This must only be called while no backups are
+// being created, otherwise an incomplete backup could be recorded.
+func RecordExistingBackups() error {
+	known := make(map[string]bool)
+	backups, err := StoredBackups()
+	if err != nil {
+		return err
+	}
+	for _, b := range backups {
+		known[b.Backup] = true
+	}
+	// Archives of S3 backups waiting to have their upload resumed and snapshots
+	// are not stored backups.
+	var uploads []models.BackupUpload
+	if tx := database.Instance().Find(&uploads); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	for _, u := range uploads {
+		known[u.Backup] = true
+	}
+	var snapshots []models.Snapshot
+	if tx := database.Instance().Find(&snapshots); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	for _, s := range snapshots {
+		known[s.Uuid] = true
+	}
+
+	matches, err := filepath.Glob(filepath.Join(config.Get().System.BackupDirectory, "*", "*"))
+	if err != nil {
+		return err
+	}
+	for _, p := range matches {
+		st, err := os.Stat(p)
+		if err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		id, adapter, ok := parseStoredName(filepath.Base(p))
+		if !ok || known[id] {
+			continue
+		}
+		server := filepath.Base(filepath.Dir(p))
+		if _, err := uuid.Parse(server); err != nil {
+			continue
+		}
+		c := models.BackupChecksum{
+			Backup:    id,
+			Server:    server,
+			Adapter:   string(adapter),
+			CreatedAt: st.ModTime(),
+		}
+		if tx := database.Instance().Create(&c); tx.Error != nil {
+			return errors.WithStack(tx.Error)
+		}
+		known[id] = true
+	}
+	return nil
+}
+
+// RecordPrunedBackups stores the backups which were pruned from this machine
+// until the Panel has been notified of them.
+func RecordPrunedBackups(ids []string) error {
+	for _, id := range ids {
+		p := models.PrunedBackup{Backup: id}
+		if tx := database.Instance().Where(p).FirstOrCreate(&p); tx.Error != nil {
+			return errors.WithStack(tx.Error)
+		}
+	}
+	return nil
+}
+
+// PendingPrunedBackups returns the backups which were pruned from this machine
+// without the Panel having been notified of them yet, oldest first.
+func PendingPrunedBackups() ([]string, error) {
+	var pruned []models.PrunedBackup
+	if tx := database.Instance().Order("created_at").Find(&pruned); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	ids := make([]string, len(pruned))
+	for i, p := range pruned {
+		ids[i] = p.Backup
+	}
+	return ids, nil
+}
+
+// ForgetPrunedBackups forgets pruned backups once the Panel has been notified
+// of them.
+func ForgetPrunedBackups(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	if tx := database.Instance().Where("backup IN ?", ids).Delete(&models.PrunedBackup{}); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// parseStoredName returns the UUID and adapter of the backup stored in a file
+// with the given name.
+func parseStoredName(name string) (string, AdapterType, bool) {
+	id, ok := StoredBackupID(name)
+	if !ok {
+		return "", "", false
+	}
+	if IsManifest(name) {
+		return id, DedupBackupAdapter, true
+	}
+	return id, LocalBackupAdapter, true
+}
+
+// StorageUsed returns the disk space used by everything in the backup
+// directory, including the chunks of deduplicated backups and any archives
+// which are still being uploaded.
+func StorageUsed(ctx context.Context) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(config.Get().System.BackupDirectory, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// ExpiredBackups returns the backups which are not kept by the retention
+// policy. Each server is considered on its own. The most recent backups are
+// kept, along with the most recent backup of each of the most recent days and
+// weeks which have backups. A backup is kept if any rule keeps it, and if the
+// policy has no rules then every backup is kept.
+func ExpiredBackups(backups []models.BackupChecksum, policy config.BackupRetention) []models.BackupChecksum {
+	if policy.KeepLast <= 0 && policy.KeepDaily <= 0 && policy.KeepWeekly <= 0 {
+		return nil
+	}
+	servers := make(map[string][]models.BackupChecksum)
+	for _, b := range backups {
+		servers[b.Server] = append(servers[b.Server], b)
+	}
+
+	var expired []models.BackupChecksum
+	for _, sb := range servers {
+		sort.SliceStable(sb, func(i, j int) bool { return sb[i].CreatedAt.After(sb[j].CreatedAt) })
+		days := make(map[string]bool)
+		weeks := make(map[string]bool)
+		for i, b := range sb {
+			keep := i < policy.KeepLast
+			day := b.CreatedAt.Local().Format(time.DateOnly)
+			if !days[day] && len(days) < policy.KeepDaily {
+				days[day] = true
+				keep = true
+			}
+			year, week := b.CreatedAt.Local().ISOWeek()
+			key := fmt.Sprintf("%d-%d", year, week)
+			if !weeks[key] && len(weeks) < policy.KeepWeekly {
+				weeks[key] = true
+				keep = true
+			}
+			if !keep {
+				expired = append(expired, b)
+			}
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool { return expired[i].CreatedAt.Before(expired[j].CreatedAt) })
+	return expired
+}
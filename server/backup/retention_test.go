@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+)
+
+func TestExpiredBackups(t *testing.T) {
+	now := time.Date(2024, 6, 14, 12, 0, 0, 0, time.Local)
+	var backups []models.BackupChecksum
+	// Two backups a day for the last three weeks, for two servers.
+	for _, server := range []string{"a", "b"} {
+		for i := 0; i < 42; i++ {
+			backups = append(backups, models.BackupChecksum{
+				Backup:    fmt.Sprintf("%s-%d", server, i),
+				Server:    server,
+				CreatedAt: now.Add(-time.Duration(i) * 12 * time.Hour),
+			})
+		}
+	}
+
+	if expired := ExpiredBackups(backups, config.BackupRetention{}); len(expired) != 0 {
+		t.Fatalf("expected every backup to be kept without a retention policy, got %d expired", len(expired))
+	}
+
+	kept := func(policy config.BackupRetention) map[string]bool {
+		k := make(map[string]bool)
+		for _, b := range backups {
+			k[b.Backup] = true
+		}
+		for _, b := range ExpiredBackups(backups, policy) {
+			delete(k, b.Backup)
+		}
+		return k
+	}
+
+	k := kept(config.BackupRetention{KeepLast: 3})
+	if len(k) != 6 || !k["a-0"] || !k["a-2"] || k["a-3"] || !k["b-2"] {
+		t.Fatalf("unexpected backups kept by keep_last: %v", k)
+	}
+
+	// The most recent backup of each day is kept, which is the first of the two
+	// backups made that day.
+	k = kept(config.BackupRetention{KeepDaily: 2})
+	if len(k) != 4 || !k["a-0"] || k["a-1"] || !k["a-2"] || k["a-4"] {
+		t.Fatalf("unexpected backups kept by keep_daily: %v", k)
+	}
+
+	// The rules are combined, with the most recent backup of each of the last
+	// three weeks being kept in addition to the most recent backup.
+	k = kept(config.BackupRetention{KeepLast: 1, KeepWeekly: 3})
+	if len(k) != 6 || !k["a-0"] || !k["b-0"] {
+		t.Fatalf("unexpected backups kept by keep_weekly: %v", k)
+	}
+	for _, server := range []string{"a", "b"} {
+		weeks := make(map[string]bool)
+		for _, b := range backups {
+			if b.Server == server && k[b.Backup] {
+				year, week := b.CreatedAt.ISOWeek()
+				weeks[fmt.Sprintf("%d-%d", year, week)] = true
+			}
+		}
+		if len(weeks) != 3 {
+			t.Fatalf("expected a backup of server %s to be kept for three weeks, got %v", server, weeks)
+		}
+	}
+}
+
+func TestRecordExistingBackups(t *testing.T) {
+	backupDir := t.TempDir()
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+		},
+	})
+	suuid := "4f1b0c7e-5a7d-4c43-9d0e-2a0c7d6f3b18"
+	files := map[string]string{
+		"99999999-9999-9999-9999-999999999999.tar.gz":         "local",
+		"aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa.manifest.gz":    "dedup",
+		"bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb.tar.zst":        "uploading",
+		"not-a-backup.tar.gz":                                 "ignored",
+		"cccccccc-cccc-cccc-cccc-cccccccccccc.tar.gz.partial": "ignored",
+	}
+	if err := os.MkdirAll(filepath.Join(backupDir, suuid), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(backupDir, suuid, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upload := models.BackupUpload{Backup: "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb", Server: suuid, Format: "tar.zst", URLs: []string{}}
+	if tx := database.Instance().Create(&upload); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	defer DiscardUpload(upload.Backup)
+
+	if err := RecordExistingBackups(); err != nil {
+		t.Fatal(err)
+	}
+	// Recording them again must not record them twice.
+	if err := RecordExistingBackups(); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := StoredBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapters := make(map[string]string)
+	for _, c := range stored {
+		if c.Server == suuid {
+			adapters[c.Backup] = c.Adapter
+		}
+	}
+	if len(adapters) != 2 || adapters["99999999-9999-9999-9999-999999999999"] != "wings" || adapters["aaaaaaaa-aaaa-aaaa-aaaa-aaaaaaaaaaaa"] != "dedup" {
+		t.Fatalf("unexpected backups recorded: %v", adapters)
+	}
+
+	used, err := StorageUsed(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if used != int64(len("local")+len("dedup")+len("uploading")+2*len("ignored")) {
+		t.Fatalf("unexpected storage used: %d", used)
+	}
+
+	for _, c := range stored {
+		if c.Server == suuid {
+			if err := RemoveStored(c); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(backupDir, suuid, "99999999-9999-9999-9999-999999999999.tar.gz")); !os.IsNotExist(err) {
+		t.Fatalf("expected the removed backup to be gone from the disk: %v", err)
+	}
+	// A backup which is already gone from the disk is forgotten.
+	if err := RemoveStored(models.BackupChecksum{Backup: "dddddddd-dddd-dddd-dddd-dddddddddddd", Server: suuid, Adapter: "wings"}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = StoredBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range stored {
+		if c.Server == suuid {
+			t.Fatalf("expected every removed backup to be forgotten, got %+v", c)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+// The number of hours a snapshot is kept if the configured retention is not
+// a positive number of hours.
+const defaultSnapshotRetention = 24
+
+// CreateSnapshot backs up every file of the server as a snapshot, which is kept
+// for the configured amount of time. Snapshots are stored alongside the backups
+// of the server but are never reported to the Panel.
+func CreateSnapshot(ctx context.Context, fsys *filesystem.Filesystem, suuid string, reason string) (*models.Snapshot, error) {
+	cfg := config.Get().System.Backups.SafetySnapshots
+	id := uuid.New().String()
+	var b BackupInterface = NewDedup(nil, id, suuid, "")
+	adapter := DedupBackupAdapter
+	// The chunks of deduplicated backups are not encrypted, so snapshots are
+	// stored as local backups which are encrypted with the key of the node.
+	if AdapterType(cfg.Adapter) == LocalBackupAdapter || config.Get().System.Backups.EncryptionKey != "" {
+		b, adapter = NewLocal(nil, id, suuid, ""), LocalBackupAdapter
+	}
+	b.WithLogContext(map[string]interface{}{"snapshot": reason})
+	retention := cfg.Retention
+	if retention <= 0 {
+		retention = defaultSnapshotRetention
+	}
+
+	// The snapshot is stored before it is created so that the backup is never
+	// mistaken for one known to the Panel while it is being created.
+	snapshot := models.Snapshot{
+		Uuid:      id,
+		Server:    suuid,
+		Adapter:   string(adapter),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Duration(retention) * time.Hour),
+	}
+	if tx := database.Instance().Create(&snapshot); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	ad, err := b.Generate(ctx, fsys, "")
+	if err != nil {
+		_ = database.Instance().Delete(&snapshot)
+		return nil, errors.WrapIf(err, "backup: failed to create snapshot")
+	}
+	// Snapshots are not verified or subject to the retention policy of backups.
+	forgetChecksum(id)
+	snapshot.Size = ad.Size
+	if tx := database.Instance().Model(&snapshot).Update("size", ad.Size); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return &snapshot, nil
+}
+
+// Snapshots returns every snapshot of the server, most recent first.
+func Snapshots(suuid string) ([]models.Snapshot, error) {
+	snapshots := []models.Snapshot{}
+	if tx := database.Instance().Where("server = ?", suuid).Order("created_at DESC").Find(&snapshots); tx.Error != nil {
+		return nil, errors.WithStack(tx.Error)
+	}
+	return snapshots, nil
+}
+
+// LocateSnapshot finds a snapshot of the server and the backup it is stored as,
+// returning an error matching os.ErrNotExist if there is no such snapshot.
+func LocateSnapshot(suuid string, id string) (*models.Snapshot, BackupInterface, error) {
+	var snapshot models.Snapshot
+	tx := database.Instance().Where("uuid = ? AND server = ?", id, suuid).Limit(1).Find(&snapshot)
+	if tx.Error != nil {
+		return nil, nil, errors.WithStack(tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return nil, nil, errors.WithStack(os.ErrNotExist)
+	}
+	b, err := LocateStored(models.BackupChecksum{Backup: snapshot.Uuid, Server: snapshot.Server, Adapter: snapshot.Adapter})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &snapshot, b, nil
+}
+
+// RemoveSnapshot removes a snapshot and the backup it is stored as.
+func RemoveSnapshot(snapshot models.Snapshot) error {
+	err := RemoveStored(models.BackupChecksum{Backup: snapshot.Uuid, Server: snapshot.Server, Adapter: snapshot.Adapter})
+	if err != nil {
+		return err
+	}
+	if tx := database.Instance().Delete(&snapshot); tx.Error != nil {
+		return errors.WithStack(tx.Error)
+	}
+	return nil
+}
+
+// PruneSnapshots removes every snapshot which has expired, returning the
+// number of snapshots removed. A snapshot which cannot be removed is logged and
+// tried again the next time snapshots are pruned.
+func PruneSnapshots() (int, error) {
+	var expired []models.Snapshot
+	if tx := database.Instance().Where("expires_at < ?", time.Now()).Find(&expired); tx.Error != nil {
+		return 0, errors.WithStack(tx.Error)
+	}
+	var removed int
+	for _, s := range expired {
+		if err := RemoveSnapshot(s); err != nil {
+			log.WithFields(log.Fields{"snapshot": s.Uuid, "server": s.Server, "error": err}).Error("failed to remove expired snapshot")
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,165 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestSnapshots(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, serverDir} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(serverDir, "server.properties"), []byte("motd=hello"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suuid := "0d6c3f5e-2b8a-4f7e-9c1d-5a4b3c2d1e0f"
+
+	for _, adapter := range []AdapterType{DedupBackupAdapter, LocalBackupAdapter} {
+		c := &config.Configuration{
+			AuthenticationToken: "test-token",
+			System: config.SystemConfiguration{
+				BackupDirectory: backupDir,
+			},
+		}
+		c.System.Backups.SafetySnapshots = config.SafetySnapshots{Enabled: true, Adapter: string(adapter), Retention: 1}
+		config.Set(c)
+
+		snapshot, err := CreateSnapshot(context.Background(), fsys, suuid, "reinstall")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if snapshot.Adapter != string(adapter) || snapshot.Size == 0 || !snapshot.ExpiresAt.After(snapshot.CreatedAt) {
+			t.Fatalf("unexpected snapshot created: %+v", snapshot)
+		}
+
+		// Snapshots are not backups known to the Panel.
+		stored, err := StoredBackups()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range stored {
+			if b.Backup == snapshot.Uuid {
+				t.Fatalf("expected snapshot not to be a stored backup")
+			}
+		}
+		snapshots, err := Snapshots(suuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 1 || snapshots[0].Uuid != snapshot.Uuid {
+			t.Fatalf("unexpected snapshots of server: %+v", snapshots)
+		}
+
+		_, b, err := LocateSnapshot(suuid, snapshot.Uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		err = b.Restore(context.Background(), nil, func(file string, _ fs.FileInfo, r io.ReadCloser) error {
+			defer r.Close()
+			files = append(files, file)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 || files[0] != "server.properties" {
+			t.Fatalf("unexpected files restored from snapshot: %v", files)
+		}
+		if _, _, err := LocateSnapshot("00000000-0000-0000-0000-000000000000", snapshot.Uuid); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected the snapshot not to be found for another server, got %v", err)
+		}
+
+		// Only expired snapshots are pruned.
+		if n, err := PruneSnapshots(); err != nil || n != 0 {
+			t.Fatalf("expected no snapshots to be pruned, got %d: %v", n, err)
+		}
+		if tx := database.Instance().Model(&models.Snapshot{}).Where("uuid = ?", snapshot.Uuid).Update("expires_at", time.Now().Add(-time.Minute)); tx.Error != nil {
+			t.Fatal(tx.Error)
+		}
+		if n, err := PruneSnapshots(); err != nil || n != 1 {
+			t.Fatalf("expected the expired snapshot to be pruned, got %d: %v", n, err)
+		}
+		if _, _, err := LocateSnapshot(suuid, snapshot.Uuid); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected the pruned snapshot to be gone, got %v", err)
+		}
+	}
+
+	// Snapshots are kept for the default retention if none is configured.
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.SafetySnapshots.Retention = 0
+	})
+	snapshot, err := CreateSnapshot(context.Background(), fsys, suuid, "reinstall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retention := snapshot.ExpiresAt.Sub(snapshot.CreatedAt); retention < 23*time.Hour || retention > 25*time.Hour {
+		t.Fatalf("expected the snapshot to be kept for the default retention, got %s", retention)
+	}
+
+	// A snapshot which cannot be removed does not stop the others from being
+	// pruned.
+	broken := models.Snapshot{Uuid: "not-a-uuid", Server: suuid, Adapter: string(LocalBackupAdapter), ExpiresAt: time.Now().Add(-time.Minute)}
+	if tx := database.Instance().Create(&broken); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if tx := database.Instance().Model(&models.Snapshot{}).Where("uuid = ?", snapshot.Uuid).Update("expires_at", time.Now().Add(-time.Minute)); tx.Error != nil {
+		t.Fatal(tx.Error)
+	}
+	if n, err := PruneSnapshots(); err != nil || n != 1 {
+		t.Fatalf("expected the removable snapshot to be pruned, got %d: %v", n, err)
+	}
+	if _, _, err := LocateSnapshot(suuid, snapshot.Uuid); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the pruned snapshot to be gone, got %v", err)
+	}
+	database.Instance().Delete(&broken)
+
+	// Snapshots are stored as encrypted local backups while backups are
+	// encrypted, whatever adapter is configured for them.
+	config.Update(func(c *config.Configuration) {
+		c.System.Backups.SafetySnapshots.Adapter = string(DedupBackupAdapter)
+		c.System.Backups.EncryptionKey = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	})
+	snapshot, err = CreateSnapshot(context.Background(), fsys, suuid, "reinstall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Adapter != string(LocalBackupAdapter) {
+		t.Fatalf("expected the snapshot to be stored as a local backup, got %s", snapshot.Adapter)
+	}
+	local, b, err := LocateSnapshot(suuid, snapshot.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(b.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, encrypted, err := NewDecryptReader(f, nil); !encrypted {
+		t.Fatalf("expected the snapshot %s to be encrypted: %v", local.Uuid, err)
+	}
+}
@@ -0,0 +1,239 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/remote"
+)
+
+// ErrChecksumMismatch is returned when verifying a backup whose checksum is no
+// longer the checksum it had when it was created.
+var ErrChecksumMismatch = errors.Sentinel("backup: checksum does not match the checksum of the backup when it was created")
+
+// VerifyResult describes a backup that has been read in its entirety to
+// verify it. ChecksumVerified is false when there was no checksum to compare
+// the checksum of the backup with, in which case the backup was only verified
+// by reading every file in it.
+type VerifyResult struct {
+	Checksum         string `json:"checksum"`
+	ChecksumType     string `json:"checksum_type"`
+	ChecksumVerified bool   `json:"checksum_verified"`
+	Size             int64  `json:"size"`
+	Files            int    `json:"files"`
+}
+
+// ToRequest returns the request reporting the result of the verification to
+// the Panel, which failed if err is not nil.
+func (v *VerifyResult) ToRequest(err error) remote.BackupVerificationRequest {
+	req := remote.BackupVerificationRequest{Successful: err == nil}
+	if v != nil {
+		req.Checksum = v.Checksum
+		req.ChecksumType = v.ChecksumType
+		req.ChecksumVerified = v.ChecksumVerified
+		req.Size = v.Size
+		req.Files = v.Files
+	}
+	if err != nil {
+		req.Error = err.Error()
+	}
+	return req
+}
+
+// Verify reads the entire backup to confirm that it is still intact. The
+// checksum of the backup must match the expected checksum and every file in it
+// must be readable. If no checksum is expected, the checksum recorded when the
+// backup was created on this machine is used instead. If there is no such
+// checksum either, the result does not report the checksum as verified.
+//
+// A result is returned along with the error if the backup was read but is not
+// intact, otherwise the error means the backup could not be verified. S3
+// backups are read from r, while any other backup is read from where it is
+// stored on this machine.
+func Verify(ctx context.Context, b BackupInterface, r io.Reader, expected string) (*VerifyResult, error) {
+	if expected == "" {
+		sum, err := recordedChecksum(b.Identifier())
+		if err != nil {
+			return nil, err
+		}
+		expected = sum
+	}
+
+	res := &VerifyResult{ChecksumType: "sha1"}
+	readFile := func(name string, info fs.FileInfo, r io.ReadCloser) error {
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		n, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return errors.WrapIf(err, "backup: failed to read "+name)
+		}
+		if n != info.Size() {
+			return errors.New(fmt.Sprintf("backup: %s is %d bytes but should be %d bytes", name, n, info.Size()))
+		}
+		res.Files++
+		return nil
+	}
+
+	var err error
+	switch v := b.(type) {
+	case *DedupBackup:
+		if err := v.validateIdentifier(); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(v.Path()); err != nil {
+			return nil, err
+		}
+		// The checksum of the manifest covers the checksum of every chunk, and
+		// every chunk is checked against its own checksum as it is read.
+		err = v.verify(ctx, res, readFile)
+	case *LocalBackup:
+		if err := v.validateIdentifier(); err != nil {
+			return nil, err
+		}
+		f, ferr := os.Open(v.Path())
+		if ferr != nil {
+			return nil, ferr
+		}
+		defer f.Close()
+		err = v.verifyArchive(ctx, f, res, readFile)
+	case *S3Backup:
+		if r == nil {
+			return nil, errors.New("backup: a reader is required to verify an S3 backup")
+		}
+		err = v.verifyArchive(ctx, r, res, readFile)
+	default:
+		return nil, errors.New("backup: backup type cannot be verified")
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	res.ChecksumVerified = expected != "" && strings.EqualFold(res.Checksum, expected)
+	matches := expected == "" || res.ChecksumVerified
+	// A backup cannot be read without the key it was encrypted with. As long as
+	// its checksum still matches, failing to decrypt it is down to the key and
+	// says nothing about whether the backup itself is intact.
+	if errors.Is(err, ErrMissingEncryptionKey) || (matches && errors.Is(err, ErrDecryptionFailed)) {
+		return nil, err
+	}
+	markVerified(b.Identifier())
+
+	if !matches {
+		return res, errors.WithStack(ErrChecksumMismatch)
+	}
+	if err != nil {
+		return res, errors.WithStackIf(err)
+	}
+	return res, nil
+}
+
+// verifyArchive reads the entire archive from r, calling fn for every file in
+// it, and sets the checksum and size of the archive on the result.
+func (b *Backup) verifyArchive(ctx context.Context, r io.Reader, res *VerifyResult, fn RestoreCallback) error {
+	h := sha1.New()
+	cw := &countingWriter{w: h}
+	tr := io.TeeReader(r, cw)
+	err := b.verifyTar(ctx, tr, fn)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// The end of the tarball may be reached before the end of the archive,
+	// but the rest of the archive is still part of its checksum.
+	if _, cerr := io.Copy(io.Discard, tr); cerr != nil && err == nil {
+		err = cerr
+	}
+	res.Checksum = hex.EncodeToString(h.Sum(nil))
+	res.Size = cw.n
+	return err
+}
+
+// verifyTar reads every file in the compressed tarball read from r, and then
+// the rest of the compressed stream since its checksum is only checked once
+// the end of it is reached.
+func (b *Backup) verifyTar(ctx context.Context, r io.Reader, fn RestoreCallback) error {
+	rc, err := b.openArchive(r)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := extractTar(ctx, rc, fn); err != nil {
+		return err
+	}
+	_, err = io.Copy(io.Discard, rc)
+	return err
+}
+
+// verify reads every chunk of the backup, calling fn for every file in it, and
+// sets the checksum and size of the backup on the result.
+func (b *DedupBackup) verify(ctx context.Context, res *VerifyResult, fn RestoreCallback) error {
+	sum, err := b.Checksum()
+	if err != nil {
+		return err
+	}
+	res.Checksum = hex.EncodeToString(sum)
+	if res.Size, err = b.Size(); err != nil {
+		return err
+	}
+	return b.Restore(ctx, nil, fn)
+}
+
+// recordChecksum stores the checksum of a backup that was just created on this
+// machine so that it can later be verified against it. A failure to store it
+// only means the backup cannot be verified on a schedule.
+func (b *Backup) recordChecksum(checksum string) {
+	c := models.BackupChecksum{
+		Backup:   b.Identifier(),
+		Server:   b.ServerId(),
+		Adapter:  string(b.adapter),
+		Checksum: checksum,
+	}
+	if tx := database.Instance().Where("backup = ?", c.Backup).Delete(&models.BackupChecksum{}); tx.Error != nil {
+		b.log().WithError(tx.Error).Warn("failed to remove previous checksum of backup")
+	}
+	if tx := database.Instance().Create(&c); tx.Error != nil {
+		b.log().WithError(tx.Error).Warn("failed to store checksum of backup")
+	}
+}
+
+// forgetChecksum removes the stored checksum of a backup that was removed.
+func (b *Backup) forgetChecksum() {
+	forgetChecksum(b.Identifier())
+}
+
+func forgetChecksum(uuid string) {
+	if tx := database.Instance().Where("backup = ?", uuid).Delete(&models.BackupChecksum{}); tx.Error != nil {
+		log.WithField("backup", uuid).WithError(tx.Error).Warn("failed to remove checksum of backup")
+	}
+}
+
+// recordedChecksum returns the checksum recorded when the backup was created,
+// or an empty string if one was not recorded.
+func recordedChecksum(uuid string) (string, error) {
+	var c models.BackupChecksum
+	tx := database.Instance().Where("backup = ?", uuid).Limit(1).Find(&c)
+	if tx.Error != nil {
+		return "", errors.WithStack(tx.Error)
+	}
+	return c.Checksum, nil
+}
+
+// markVerified records that the backup was verified, whether or not it was
+// found to be intact.
+func markVerified(uuid string) {
+	tx := database.Instance().Model(&models.BackupChecksum{}).Where("backup = ?", uuid).Update("verified_at", time.Now())
+	if tx.Error != nil {
+		log.WithField("backup", uuid).WithError(tx.Error).Warn("failed to record verification of backup")
+	}
+}
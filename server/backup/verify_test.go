@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+func TestVerifyBackup(t *testing.T) {
+	root := t.TempDir()
+	backupDir := filepath.Join(root, "backups")
+	serverDir := filepath.Join(root, "server")
+	for _, dir := range []string{backupDir, filepath.Join(serverDir, "world")} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: backupDir,
+		},
+	})
+	for name, content := range map[string]string{
+		"server.properties": "motd=hello",
+		"world/level.dat":   string(bytes.Repeat([]byte("level"), 64*1024)),
+	} {
+		if err := os.WriteFile(filepath.Join(serverDir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fsys, err := filesystem.New(serverDir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	suuid := "ce6ee345-6729-4aed-8fed-c866c535a69d"
+	local := NewLocal(nil, "66666666-6666-6666-6666-666666666666", suuid, "")
+	dedup := NewDedup(nil, "77777777-7777-7777-7777-777777777777", suuid, "")
+	for _, b := range []BackupInterface{local, dedup} {
+		ad, err := b.Generate(context.Background(), fsys, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The checksum recorded when the backup was created is used when one is
+		// not provided.
+		res, err := Verify(context.Background(), b, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Checksum != ad.Checksum || !res.ChecksumVerified || res.Size != ad.Size || res.Files != 2 {
+			t.Fatalf("unexpected verification result %+v for backup %+v", res, ad)
+		}
+		var c models.BackupChecksum
+		if tx := database.Instance().Where("backup = ?", b.Identifier()).First(&c); tx.Error != nil || c.VerifiedAt == nil {
+			t.Fatalf("expected the verification of the backup to be recorded: %v", tx.Error)
+		}
+
+		if _, err := Verify(context.Background(), b, nil, "0000000000000000000000000000000000000000"); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected a different checksum to fail verification, got %v", err)
+		}
+	}
+
+	// Corrupt the middle of the archive, which breaks both its checksum and the
+	// compressed data.
+	data, err := os.ReadFile(local.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a checksum to compare against, an S3 backup is only verified by
+	// reading every file in it, which is not reported as verifying its
+	// checksum.
+	s3 := NewS3(nil, "cccccccc-cccc-cccc-cccc-cccccccccccc", suuid, "")
+	if res, err := Verify(context.Background(), s3, bytes.NewReader(data), ""); err != nil || res.ChecksumVerified || res.Files != 2 {
+		t.Fatalf("expected the checksum of the archive not to be verified, got %+v: %v", res, err)
+	}
+
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(local.Path(), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	res, err := Verify(context.Background(), local, nil, "")
+	if !errors.Is(err, ErrChecksumMismatch) || res == nil {
+		t.Fatalf("expected a corrupted backup to fail verification, got %v", err)
+	}
+
+	if res, err := Verify(context.Background(), s3, bytes.NewReader(data), ""); err == nil || res == nil {
+		t.Fatalf("expected a corrupted archive to fail verification, got %v", err)
+	}
+
+	if err := local.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := recordedChecksum(local.Identifier()); err != nil || sum != "" {
+		t.Fatalf("expected the checksum of a removed backup to be forgotten, got %q: %v", sum, err)
+	}
+	if _, err := Verify(context.Background(), local, nil, ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected verifying a removed backup to fail, got %v", err)
+	}
+}
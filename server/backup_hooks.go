@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/system"
+)
+
+// The amount of time to wait for the console output of the pre-backup commands
+// if the hooks do not set a timeout.
+const defaultBackupHookTimeout = 30 * time.Second
+
+// BackupHooks are run around the backup of a running server so that the game
+// is not writing to its files while they are being archived, such as by
+// sending "save-off" and "save-all" before the backup and "save-on" after it.
+type BackupHooks struct {
+	// Pre are the console commands sent to the server before the backup starts.
+	Pre []string `json:"pre"`
+
+	// WaitFor is the console output to wait for after sending the pre-backup
+	// commands before starting the backup, such as the line logged by the game
+	// once it has finished saving.
+	WaitFor *remote.OutputLineMatcher `json:"wait_for"`
+
+	// Timeout is the number of seconds to wait for the console output before
+	// giving up and starting the backup anyway.
+	Timeout int `json:"timeout"`
+
+	// Pause suspends the container of the server while its files are being
+	// archived, after any pre-backup commands have been sent.
+	Pause bool `json:"pause"`
+
+	// Post are the console commands sent to the server once its files have been
+	// archived, whether or not the backup succeeded.
+	Post []string `json:"post"`
+}
+
+// BackupHooks returns the hooks to run when backing up the server, which are
+// those of the server itself if it has any, otherwise those of its egg.
+func (s *Server) BackupHooks() *BackupHooks {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	if s.cfg.BackupHooks != nil {
+		return s.cfg.BackupHooks
+	}
+	return s.cfg.Egg.BackupHooks
+}
+
+// backupHookRun tracks the hooks run for a single backup, and any of them that
+// failed.
+type backupHookRun struct {
+	s      *Server
+	hooks  *BackupHooks
+	paused bool
+	once   sync.Once
+
+	mu       sync.Mutex
+	failures []string
+}
+
+// runPreBackupHooks runs the hooks of the server before a backup starts. The
+// returned run must be finished once the files of the server have been
+// archived. Hooks are only run while the server is running, a failing hook
+// does not stop the backup from being made but is reported once it completes.
+func (s *Server) runPreBackupHooks(ctx context.Context) *backupHookRun {
+	run := &backupHookRun{s: s, hooks: s.BackupHooks()}
+	if run.hooks == nil || !s.IsRunning() {
+		run.hooks = nil
+		return run
+	}
+	h := run.hooks
+
+	if len(h.Pre) > 0 {
+		var output chan []byte
+		if h.WaitFor != nil {
+			// Start listening before sending any commands so that the output of
+			// a quick command is not missed.
+			output = make(chan []byte, 16)
+			s.Sink(system.LogSink).On(output)
+			defer s.Sink(system.LogSink).Off(output)
+		}
+		for _, c := range h.Pre {
+			if err := s.Environment.SendCommand(c); err != nil {
+				run.fail(errors.WrapIf(err, "backup hook: failed to send pre-backup command"))
+				output = nil
+				break
+			}
+		}
+		if output != nil {
+			run.wait(ctx, output)
+		}
+	}
+
+	if h.Pause {
+		if err := s.Environment.Pause(ctx); err != nil {
+			run.fail(errors.WrapIf(err, "backup hook: failed to pause server"))
+		} else {
+			run.paused = true
+		}
+	}
+	return run
+}
+
+// wait waits for the console output the hooks are waiting for, or until the
+// timeout of the hooks is reached.
+func (r *backupHookRun) wait(ctx context.Context, output chan []byte) {
+	timeout := defaultBackupHookTimeout
+	if r.hooks.Timeout > 0 {
+		timeout = time.Duration(r.hooks.Timeout) * time.Second
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			r.fail(errors.WrapIf(ctx.Err(), "backup hook: stopped waiting for console output"))
+			return
+		case <-t.C:
+			r.fail(errors.New("backup hook: timed out after " + timeout.String() + " waiting for console output matching " + r.hooks.WaitFor.String()))
+			return
+		case v := <-output:
+			for _, line := range strings.Split(string(stripAnsiRegex.ReplaceAll(v, nil)), "\n") {
+				if r.hooks.WaitFor.Matches([]byte(line)) {
+					r.s.Log().WithField("match", r.hooks.WaitFor.String()).Debug("detected console output of pre-backup hooks")
+					return
+				}
+			}
+		}
+	}
+}
+
+// finish unpauses the server and sends the post-backup commands. It is safe to
+// call more than once, the hooks are only finished the first time.
+func (r *backupHookRun) finish() {
+	r.once.Do(func() {
+		if r.hooks == nil {
+			return
+		}
+		if r.paused {
+			// The server is unpaused even if the backup was canceled, otherwise it
+			// would be left frozen.
+			if err := r.s.Environment.Unpause(context.Background()); err != nil {
+				r.fail(errors.WrapIf(err, "backup hook: failed to unpause server"))
+			}
+		}
+		for _, c := range r.hooks.Post {
+			if err := r.s.Environment.SendCommand(c); err != nil {
+				r.fail(errors.WrapIf(err, "backup hook: failed to send post-backup command"))
+				break
+			}
+		}
+	})
+}
+
+func (r *backupHookRun) fail(err error) {
+	r.s.Log().WithField("error", err).Warn("failed to run backup hook")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, err.Error())
+}
+
+// Errors returns the errors of every hook that failed.
+func (r *backupHookRun) Errors() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string{}, r.failures...)
+}
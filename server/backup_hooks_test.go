@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	. "github.com/franela/goblin"
+
+	"github.com/pelican/wings/environment"
+	"github.com/pelican/wings/system"
+)
+
+// hookTestEnvironment records the commands sent to a running server, calling
+// onCommand for each of them.
+type hookTestEnvironment struct {
+	environment.ProcessEnvironment
+
+	mu        sync.Mutex
+	calls     []string
+	onCommand func(string)
+}
+
+func (e *hookTestEnvironment) record(call string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, call)
+}
+
+func (e *hookTestEnvironment) Calls() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string{}, e.calls...)
+}
+
+func (e *hookTestEnvironment) State() string { return environment.ProcessRunningState }
+
+func (e *hookTestEnvironment) SendCommand(c string) error {
+	e.record(c)
+	if e.onCommand != nil {
+		e.onCommand(c)
+	}
+	return nil
+}
+
+func (e *hookTestEnvironment) Pause(context.Context) error {
+	e.record("pause")
+	return nil
+}
+
+func (e *hookTestEnvironment) Unpause(context.Context) error {
+	e.record("unpause")
+	return nil
+}
+
+func TestBackupHooks(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("Server#runPreBackupHooks", func() {
+		var s *Server
+		var env *hookTestEnvironment
+
+		g.BeforeEach(func() {
+			var err error
+			s, err = New(nil)
+			if err != nil {
+				panic(err)
+			}
+			env = &hookTestEnvironment{}
+			s.Environment = env
+			egg := `{"backup_hooks": {"pre": ["save-off", "save-all"], "wait_for": "regex:^Saved the (game|world)$", "timeout": 1, "pause": true, "post": ["save-on"]}}`
+			if err := json.Unmarshal([]byte(egg), &s.cfg.Egg); err != nil {
+				panic(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			s.CtxCancel()
+		})
+
+		g.It("waits for the console output before pausing the server", func() {
+			env.onCommand = func(c string) {
+				if c == "save-all" {
+					go s.Sink(system.LogSink).Push([]byte("\x1b[33mSaved the game\x1b[0m"))
+				}
+			}
+
+			run := s.runPreBackupHooks(context.Background())
+			g.Assert(env.Calls()).Equal([]string{"save-off", "save-all", "pause"})
+
+			run.finish()
+			run.finish()
+			g.Assert(env.Calls()).Equal([]string{"save-off", "save-all", "pause", "unpause", "save-on"})
+			g.Assert(run.Errors()).Equal([]string{})
+		})
+
+		g.It("reports a timeout and still finishes the hooks", func() {
+			run := s.runPreBackupHooks(context.Background())
+			run.finish()
+
+			g.Assert(env.Calls()).Equal([]string{"save-off", "save-all", "pause", "unpause", "save-on"})
+			g.Assert(len(run.Errors())).Equal(1)
+		})
+
+		g.It("uses the hooks of the server instead of its egg", func() {
+			s.cfg.BackupHooks = &BackupHooks{Post: []string{"say backup complete"}}
+
+			run := s.runPreBackupHooks(context.Background())
+			run.finish()
+
+			g.Assert(env.Calls()).Equal([]string{"say backup complete"})
+		})
+	})
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/environment"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/backup"
+	"github.com/pelican/wings/server/filesystem"
+)
+
+type restoreTestEnvironment struct {
+	environment.ProcessEnvironment
+}
+
+func (restoreTestEnvironment) State() string { return environment.ProcessOfflineState }
+
+type restoreTestClient struct {
+	remote.Client
+	successful []bool
+}
+
+func (c *restoreTestClient) SendRestorationStatus(_ context.Context, _ string, successful bool) error {
+	c.successful = append(c.successful, successful)
+	return nil
+}
+
+func TestRestoreBackup(t *testing.T) {
+	g := Goblin(t)
+
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System: config.SystemConfiguration{
+			BackupDirectory: t.TempDir(),
+			Backups: config.Backups{
+				SafetySnapshots: config.SafetySnapshots{Enabled: true, Adapter: string(backup.LocalBackupAdapter)},
+			},
+		},
+	})
+
+	g.Describe("Server#RestoreBackup", func() {
+		var s *Server
+		var client *restoreTestClient
+		var dir string
+
+		g.BeforeEach(func() {
+			client = &restoreTestClient{}
+			var err error
+			s, err = New(client)
+			if err != nil {
+				panic(err)
+			}
+			s.cfg.Uuid = uuid.NewString()
+			s.Environment = restoreTestEnvironment{}
+			dir = t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "server.properties"), []byte("motd=hello"), 0o600); err != nil {
+				panic(err)
+			}
+			if s.fs, err = filesystem.New(dir, 0, nil); err != nil {
+				panic(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			s.CtxCancel()
+		})
+
+		g.It("keeps the server files if the backup cannot be opened", func() {
+			var snapshots int
+			open := func() (io.ReadCloser, error) {
+				taken, err := backup.Snapshots(s.ID())
+				g.Assert(err).IsNil()
+				snapshots = len(taken)
+				return nil, errors.New("backup link has expired")
+			}
+
+			err := s.RestoreBackup(backup.NewS3(client, uuid.NewString(), s.ID(), ""), open, true)
+			g.Assert(err != nil).IsTrue()
+			g.Assert(snapshots).Equal(1)
+			_, err = os.Stat(filepath.Join(dir, "server.properties"))
+			g.Assert(err).IsNil()
+			g.Assert(client.successful).Equal([]bool{false})
+		})
+	})
+}
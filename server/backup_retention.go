@@ -0,0 +1,222 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/backup"
+)
+
+// Backups of a server which does not exist on this node are only removed as
+// orphaned once the server has been missing every time backups were pruned,
+// at least orphanedRuns times in a row and for at least orphanedMinAge, and
+// the Panel confirms the server no longer exists. Servers which failed to load
+// when Wings booted are therefore never mistaken for deleted servers.
+const (
+	orphanedRuns   = 3
+	orphanedMinAge = time.Hour
+)
+
+// orphan tracks a server which backups are stored for on this node, but which
+// does not exist on it.
+type orphan struct {
+	runs  int
+	since time.Time
+}
+
+// ErrBackupStorageFull is returned when a backup cannot be stored on this node
+// because the backup storage limit is reached, even after pruning backups.
+var ErrBackupStorageFull = errors.Sentinel("backup storage limit is reached")
+
+// PruneBackups removes the backups stored on this node which are not kept by
+// the retention policy, then the oldest backups for as long as the storage
+// limit is exceeded. Backups of servers which no longer exist on this node are
+// only removed if configured to, but always count towards the storage limit.
+// The Panel is notified of every backup that was removed, and if it cannot be
+// notified it is notified again the next time backups are pruned.
+func (m *Manager) PruneBackups(ctx context.Context) error {
+	m.pruneMu.Lock()
+	defer m.pruneMu.Unlock()
+
+	cfg := config.Get().System.Backups
+	if n, err := backup.PruneSnapshots(); err != nil {
+		log.WithField("error", err).Warn("failed to prune expired snapshots")
+	} else if n > 0 {
+		log.WithField("snapshots", n).Info("pruned expired snapshots")
+	}
+
+	backups, err := backup.StoredBackups()
+	if err != nil {
+		return err
+	}
+
+	// The backups which were pruned but could not be stored, which the Panel
+	// is only notified of once.
+	var pruned []string
+	remove := func(c models.BackupChecksum, reason string) bool {
+		l := log.WithFields(log.Fields{"backup": c.Backup, "server": c.Server, "reason": reason})
+		if err := backup.RemoveStored(c); err != nil {
+			l.WithField("error", err).Warn("failed to prune backup")
+			return false
+		}
+		l.Info("pruned backup")
+		// The backup is stored until the Panel has been notified of it.
+		if err := backup.RecordPrunedBackups([]string{c.Backup}); err != nil {
+			l.WithField("error", err).Warn("failed to store pruned backup")
+			pruned = append(pruned, c.Backup)
+		}
+		return true
+	}
+
+	var owned, orphaned []models.BackupChecksum
+	orphans := make(map[string]orphan)
+	for _, c := range backups {
+		if _, ok := m.Get(c.Server); ok {
+			owned = append(owned, c)
+			continue
+		}
+		orphaned = append(orphaned, c)
+		if _, ok := orphans[c.Server]; !ok {
+			o, ok := m.orphans[c.Server]
+			if !ok {
+				o.since = time.Now()
+			}
+			o.runs++
+			orphans[c.Server] = o
+		}
+	}
+	// A server only counts as missing while it is missing every time backups
+	// are pruned.
+	m.orphans = orphans
+	removed := make(map[string]bool)
+	if len(orphaned) > 0 {
+		deleted := make(map[string]bool)
+		if cfg.RemoveOrphanedBackups {
+			for server, o := range orphans {
+				if o.runs >= orphanedRuns && time.Since(o.since) >= orphanedMinAge {
+					deleted[server] = m.deletedOnPanel(ctx, server)
+				}
+			}
+		}
+		var kept int
+		for _, c := range orphaned {
+			if deleted[c.Server] {
+				removed[c.Backup] = remove(c, "orphaned")
+			} else {
+				kept++
+			}
+		}
+		if kept > 0 {
+			log.WithField("backups", kept).Warn("found backups of servers which no longer exist on this node")
+		}
+	}
+	for _, c := range backup.ExpiredBackups(owned, cfg.Retention) {
+		removed[c.Backup] = remove(c, "retention")
+	}
+
+	if cfg.MaxStorage > 0 {
+		limit := cfg.MaxStorage * 1024 * 1024
+		used, err := backup.StorageUsed(ctx)
+		if err != nil {
+			return err
+		}
+		// Orphaned backups are never removed to free up space, those that could
+		// be removed already were.
+		for _, c := range owned {
+			if used <= limit {
+				break
+			}
+			if removed[c.Backup] || !remove(c, "storage limit") {
+				continue
+			}
+			removed[c.Backup] = true
+			// The chunks of a deduplicated backup are only freed once nothing
+			// references them anymore.
+			if backup.AdapterType(c.Adapter) == backup.DedupBackupAdapter {
+				if _, err := backup.PruneChunks(ctx); err != nil {
+					log.WithField("error", err).Warn("failed to prune unreferenced chunks")
+				}
+			}
+			if used, err = backup.StorageUsed(ctx); err != nil {
+				return err
+			}
+		}
+		if used > limit {
+			log.WithFields(log.Fields{"used": used, "limit": limit}).Warn("backup storage limit is exceeded with no backups left to remove")
+		}
+	}
+
+	return m.notifyPrunedBackups(ctx, pruned)
+}
+
+// deletedOnPanel returns whether the Panel confirms that the server no longer
+// exists. The server is assumed to still exist if the Panel cannot be asked.
+func (m *Manager) deletedOnPanel(ctx context.Context, server string) bool {
+	_, err := m.client.GetServerConfiguration(ctx, server)
+	if err == nil {
+		return false
+	}
+	if rerr := remote.AsRequestError(err); rerr != nil && rerr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	log.WithFields(log.Fields{"server": server, "error": err}).Warn("failed to confirm server of orphaned backups no longer exists")
+	return false
+}
+
+// notifyPrunedBackups notifies the Panel of every pruned backup it has not been
+// notified of yet, along with the given backups.
+func (m *Manager) notifyPrunedBackups(ctx context.Context, pruned []string) error {
+	pending, err := backup.PendingPrunedBackups()
+	if err != nil {
+		log.WithField("error", err).Warn("failed to retrieve pruned backups the panel was not notified of")
+	}
+	seen := make(map[string]bool, len(pending))
+	for _, id := range pending {
+		seen[id] = true
+	}
+	for _, id := range pruned {
+		if !seen[id] {
+			pending = append(pending, id)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if err := m.client.SendPrunedBackups(ctx, pending); err != nil {
+		log.WithField("error", err).Warn("failed to notify panel of pruned backups")
+		return err
+	}
+	return backup.ForgetPrunedBackups(pending)
+}
+
+// EnsureBackupStorage makes sure there is space left for another backup to be
+// stored on this node when a backup storage limit is configured, pruning
+// backups first if the limit is reached. ErrBackupStorageFull is returned if
+// there is still no space left.
+func (m *Manager) EnsureBackupStorage(ctx context.Context) error {
+	limit := config.Get().System.Backups.MaxStorage * 1024 * 1024
+	if limit <= 0 {
+		return nil
+	}
+	used, err := backup.StorageUsed(ctx)
+	if err != nil || used < limit {
+		return err
+	}
+	if err := m.PruneBackups(ctx); err != nil {
+		log.WithField("error", err).Warn("failed to prune backups before creating backup")
+	}
+	if used, err = backup.StorageUsed(ctx); err != nil {
+		return err
+	}
+	if used >= limit {
+		return ErrBackupStorageFull
+	}
+	return nil
+}
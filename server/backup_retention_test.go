@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/database"
+	"github.com/pelican/wings/internal/models"
+	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/backup"
+)
+
+func TestMain(m *testing.M) {
+	root, err := os.MkdirTemp("", "wings-server")
+	if err != nil {
+		panic(err)
+	}
+	config.Set(&config.Configuration{
+		AuthenticationToken: "test-token",
+		System:              config.SystemConfiguration{RootDirectory: root},
+	})
+	if err := database.Initialize(); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	_ = os.RemoveAll(root)
+	os.Exit(code)
+}
+
+type pruneTestClient struct {
+	remote.Client
+	fail     bool
+	notified [][]string
+	// existing are the servers which the Panel reports as still existing.
+	existing map[string]bool
+}
+
+// GetServerConfiguration reports every server which is not existing as not
+// found, as the Panel does for deleted servers.
+func (c *pruneTestClient) GetServerConfiguration(ctx context.Context, uuid string) (remote.ServerConfigurationResponse, error) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.existing[uuid] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	return remote.New(srv.URL).GetServerConfiguration(ctx, uuid)
+}
+
+func (c *pruneTestClient) SendPrunedBackups(_ context.Context, backups []string) error {
+	if c.fail {
+		return errors.New("panel is unavailable")
+	}
+	c.notified = append(c.notified, backups)
+	return nil
+}
+
+func TestPruneBackups(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("PruneBackups", func() {
+		var m *Manager
+		var client *pruneTestClient
+		var owned, orphaned string
+		var backupDir string
+
+		// storeBackup creates a local backup of 512KiB for the server, created
+		// the given number of hours ago.
+		storeBackup := func(suuid string, age int) string {
+			id := uuid.NewString()
+			dir := filepath.Join(backupDir, suuid)
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				panic(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, id+".tar.gz"), make([]byte, 512*1024), 0o600); err != nil {
+				panic(err)
+			}
+			c := models.BackupChecksum{
+				Backup:    id,
+				Server:    suuid,
+				Adapter:   string(backup.LocalBackupAdapter),
+				CreatedAt: time.Now().Add(-time.Duration(age) * time.Hour),
+			}
+			if tx := database.Instance().Create(&c); tx.Error != nil {
+				panic(tx.Error)
+			}
+			return id
+		}
+		exists := func(suuid, id string) bool {
+			_, err := os.Stat(filepath.Join(backupDir, suuid, id+".tar.gz"))
+			return err == nil
+		}
+		// markOrphaned records the server as having been missing long enough
+		// for its backups to be removed on the next run.
+		markOrphaned := func(suuid string) {
+			m.orphans = map[string]orphan{suuid: {runs: orphanedRuns - 1, since: time.Now().Add(-orphanedMinAge)}}
+		}
+		configure := func(maxStorage int64, removeOrphaned bool) {
+			config.Update(func(c *config.Configuration) {
+				c.System.BackupDirectory = backupDir
+				c.System.Backups.MaxStorage = maxStorage
+				c.System.Backups.RemoveOrphanedBackups = removeOrphaned
+			})
+		}
+
+		g.BeforeEach(func() {
+			backupDir = t.TempDir()
+			database.Instance().Where("1 = 1").Delete(&models.BackupChecksum{})
+			database.Instance().Where("1 = 1").Delete(&models.PrunedBackup{})
+
+			client = &pruneTestClient{}
+			m = NewEmptyManager(client)
+			s, err := New(client)
+			if err != nil {
+				panic(err)
+			}
+			owned, orphaned = uuid.NewString(), uuid.NewString()
+			s.cfg.Uuid = owned
+			m.Add(s)
+		})
+
+		g.It("removes the oldest backups of existing servers until the storage limit is met", func() {
+			configure(1, false)
+			o := storeBackup(orphaned, 4)
+			a := storeBackup(owned, 3)
+			b := storeBackup(owned, 2)
+			c := storeBackup(owned, 1)
+
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(exists(orphaned, o)).IsTrue()
+			g.Assert(exists(owned, a)).IsFalse()
+			g.Assert(exists(owned, b)).IsFalse()
+			g.Assert(exists(owned, c)).IsTrue()
+			g.Assert(client.notified).Equal([][]string{{a, b}})
+
+			pending, err := backup.PendingPrunedBackups()
+			g.Assert(err).IsNil()
+			g.Assert(len(pending)).Equal(0)
+		})
+
+		g.It("removes orphaned backups only if configured to", func() {
+			configure(0, false)
+			o := storeBackup(orphaned, 1)
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(exists(orphaned, o)).IsTrue()
+			g.Assert(len(client.notified)).Equal(0)
+
+			configure(0, true)
+			markOrphaned(orphaned)
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(exists(orphaned, o)).IsFalse()
+			g.Assert(client.notified).Equal([][]string{{o}})
+		})
+
+		g.It("removes orphaned backups only once the server has been missing for long enough", func() {
+			configure(0, true)
+			o := storeBackup(orphaned, 1)
+			for i := 0; i < orphanedRuns; i++ {
+				g.Assert(m.PruneBackups(context.Background())).IsNil()
+				g.Assert(exists(orphaned, o)).IsTrue()
+			}
+			g.Assert(m.orphans[orphaned].runs).Equal(orphanedRuns)
+
+			// A server which is found again starts over.
+			s, err := New(client)
+			g.Assert(err).IsNil()
+			s.cfg.Uuid = orphaned
+			m.Add(s)
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			m.Remove(func(s *Server) bool { return s.ID() == orphaned })
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(m.orphans[orphaned].runs).Equal(1)
+			g.Assert(exists(orphaned, o)).IsTrue()
+			g.Assert(len(client.notified)).Equal(0)
+		})
+
+		g.It("keeps orphaned backups of servers which still exist on the panel", func() {
+			configure(0, true)
+			o := storeBackup(orphaned, 1)
+			client.existing = map[string]bool{orphaned: true}
+			markOrphaned(orphaned)
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(exists(orphaned, o)).IsTrue()
+			g.Assert(len(client.notified)).Equal(0)
+		})
+
+		g.It("notifies the panel of pruned backups on the next run if it fails", func() {
+			configure(0, true)
+			o := storeBackup(orphaned, 1)
+			markOrphaned(orphaned)
+			client.fail = true
+			g.Assert(m.PruneBackups(context.Background()) != nil).IsTrue()
+			g.Assert(exists(orphaned, o)).IsFalse()
+
+			pending, err := backup.PendingPrunedBackups()
+			g.Assert(err).IsNil()
+			g.Assert(pending).Equal([]string{o})
+
+			client.fail = false
+			g.Assert(m.PruneBackups(context.Background())).IsNil()
+			g.Assert(client.notified).Equal([][]string{{o}})
+			pending, err = backup.PendingPrunedBackups()
+			g.Assert(err).IsNil()
+			g.Assert(len(pending)).Equal(0)
+		})
+
+		g.It("refuses new backups while the storage limit cannot be met", func() {
+			configure(1, false)
+			storeBackup(orphaned, 2)
+			storeBackup(orphaned, 1)
+			g.Assert(m.EnsureBackupStorage(context.Background())).Equal(ErrBackupStorageFull)
+
+			configure(1, true)
+			markOrphaned(orphaned)
+			g.Assert(m.EnsureBackupStorage(context.Background())).IsNil()
+			g.Assert(len(client.notified)).Equal(1)
+		})
+	})
+}
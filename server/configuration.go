@@ -16,9 +16,17 @@ type EggConfiguration struct {
 	// as a per-user denylist, this is defined at the Egg level.
 	FileDenylist []string `json:"file_denylist"`
 
+	// LogFiles are the files within the server directory that the game writes its
+	// logs to, which are followed by default when tailing logs.
+	LogFiles []string `json:"log_files"`
+
 	// Features is a map of feature identifiers to a list of console output strings
 	// that should trigger a match (e.g., for things like EULA prompts).
 	Features map[string][]string `json:"features"`
+
+	// BackupHooks are run around every backup of a server using this egg, unless
+	// the server has its own hooks.
+	BackupHooks *BackupHooks `json:"backup_hooks"`
 }
 
 func (egg *EggConfiguration) UnmarshalJSON(b []byte) (err error) {
@@ -34,6 +42,8 @@ func (egg *EggConfiguration) UnmarshalJSON(b []byte) (err error) {
 
 	egg.ID = AliasEggConfiguration.ID
 	egg.FileDenylist = AliasEggConfiguration.FileDenylist
+	egg.LogFiles = AliasEggConfiguration.LogFiles
+	egg.BackupHooks = AliasEggConfiguration.BackupHooks
 
 	return nil
 }
@@ -81,6 +91,10 @@ type Configuration struct {
 	Mounts                []Mount                 `json:"mounts"`
 	Egg                   EggConfiguration        `json:"egg,omitempty"`
 
+	// BackupHooks are run around every backup of the server instead of the hooks
+	// of its egg.
+	BackupHooks *BackupHooks `json:"backup_hooks,omitempty"`
+
 	Container struct {
 		// Defines the Docker image that will be used for this server
 		Image string `json:"image,omitempty"`
@@ -100,6 +114,21 @@ func (s *Server) DiskSpace() int64 {
 	return s.cfg.Build.DiskSpace * 1024.0 * 1024.0
 }
 
+// FileLimit returns the number of files and directories a server is allowed to
+// have, or 0 if there is no limit.
+func (s *Server) FileLimit() int64 {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	return s.cfg.Build.InodeLimit
+}
+
+// LogFiles returns the log files declared by the server's egg.
+func (s *Server) LogFiles() []string {
+	s.cfg.mu.RLock()
+	defer s.cfg.mu.RUnlock()
+	return s.cfg.Egg.LogFiles
+}
+
 func (s *Server) MemoryLimit() int64 {
 	s.cfg.mu.RLock()
 	defer s.cfg.mu.RUnlock()
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/server/filesystem"
+	"github.com/pelican/wings/server/filesystem/quotas"
+)
+
+// quotaUsageTracker reads the disk usage of a server from the project quota
+// accounting of the filesystem, which the kernel keeps accurate at all times.
+// The values are cached for the disk check interval since reading them is not
+// free, and the disk usage is read far more often than that.
+type quotaUsageTracker struct {
+	uuid     string
+	interval time.Duration
+
+	mu    sync.Mutex
+	usage cachedQuota
+	files cachedQuota
+}
+
+// cachedQuota is a value read from the quota accounting of the filesystem.
+type cachedQuota struct {
+	value   int64
+	checked time.Time
+}
+
+// get returns the cached value, reading it again using fn once it is older
+// than the given interval.
+func (c *cachedQuota) get(interval time.Duration, fn func() (int64, error)) (int64, error) {
+	if !c.checked.IsZero() && time.Since(c.checked) < interval {
+		return c.value, nil
+	}
+	v, err := fn()
+	if err != nil {
+		return 0, err
+	}
+	c.value, c.checked = v, time.Now()
+	return v, nil
+}
+
+func (q *quotaUsageTracker) Usage() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.usage.get(q.interval, func() (int64, error) {
+		return quotas.GetQuota(q.uuid)
+	})
+}
+
+func (q *quotaUsageTracker) Files() (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.files.get(q.interval, func() (int64, error) {
+		return quotas.GetFileQuota(q.uuid)
+	})
+}
+
+func (q *quotaUsageTracker) Close() error {
+	return nil
+}
+
+// configureUsageTracker sets up the tracker used to keep the disk usage of the
+// server up to date based on the configuration of the node. If the tracker
+// cannot be created the server falls back to walking its directory.
+func (s *Server) configureUsageTracker() {
+	cfg := config.Get().System
+	if cfg.Quotas.Enabled {
+		s.fs.SetUsageTracker(&quotaUsageTracker{
+			uuid:     s.ID(),
+			interval: time.Duration(cfg.DiskCheckInterval) * time.Second,
+		})
+		return
+	}
+	if cfg.DiskUsageTracker.Mode != "inotify" {
+		return
+	}
+	t, err := filesystem.NewInotifyTracker(s.Context(), s.fs, time.Duration(cfg.DiskUsageTracker.RescanInterval)*time.Second)
+	if err != nil {
+		s.Log().WithField("error", err).Warn("failed to create inotify disk usage tracker, falling back to walking the server directory")
+		return
+	}
+	s.fs.SetUsageTracker(t)
+}
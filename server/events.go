@@ -16,10 +16,14 @@ const (
 	StatsEvent                  = "stats"
 	BackupRestoreCompletedEvent = "backup restore completed"
 	BackupCompletedEvent        = "backup completed"
+	BackupProgressEvent         = "backup progress"
 	TransferLogsEvent           = "transfer logs"
 	TransferStatusEvent         = "transfer status"
 	DeletedEvent                = "deleted"
 	FeatureMatchEvent           = "feature match"
+	JobProgressEvent            = "job progress"
+	JobCompletedEvent           = "job completed"
+	DiskThresholdEvent          = "disk threshold"
 )
 
 // Events returns the server's emitter instance.
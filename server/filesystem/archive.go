@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -13,6 +14,8 @@ import (
 	"emperror.dev/errors"
 	"github.com/apex/log"
 	"github.com/juju/ratelimit"
+	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/pgzip"
 	ignore "github.com/sabhiram/go-gitignore"
 
@@ -55,10 +58,78 @@ func (p *TarProgress) Write(v []byte) (int, error) {
 	return p.p.Write(v)
 }
 
+// ArchiveFormat is the container format an Archive is written in.
+type ArchiveFormat string
+
+const (
+	// ArchiveFormatTarGz writes a gzip compressed tarball, this is the default
+	// format used when none is specified.
+	ArchiveFormatTarGz = ArchiveFormat("tar.gz")
+	// ArchiveFormatTarZstd writes a zstd compressed tarball.
+	ArchiveFormatTarZstd = ArchiveFormat("tar.zst")
+	// ArchiveFormatZip writes a deflate compressed zip archive.
+	ArchiveFormatZip = ArchiveFormat("zip")
+	// ArchiveFormatTar writes an uncompressed tarball. This is only used
+	// internally when the archive is read back as it is written, such as by
+	// deduplicated backups, and cannot be requested by a client.
+	ArchiveFormatTar = ArchiveFormat("tar")
+)
+
+// ParseArchiveFormat returns the ArchiveFormat matching the given string, an
+// empty string resolves to ArchiveFormatTarGz.
+func ParseArchiveFormat(v string) (ArchiveFormat, error) {
+	switch ArchiveFormat(strings.TrimPrefix(strings.ToLower(v), ".")) {
+	case "", ArchiveFormatTarGz, "tgz":
+		return ArchiveFormatTarGz, nil
+	case ArchiveFormatTarZstd, "tzst":
+		return ArchiveFormatTarZstd, nil
+	case ArchiveFormatZip:
+		return ArchiveFormatZip, nil
+	}
+	return "", newFilesystemError(ErrCodeUnknownArchive, nil)
+}
+
+// Extension returns the file extension, including the leading dot, for the
+// archive format.
+func (f ArchiveFormat) Extension() string {
+	switch f {
+	case ArchiveFormatZip:
+		return ".zip"
+	case ArchiveFormatTarZstd:
+		return ".tar.zst"
+	case ArchiveFormatTar:
+		return ".tar"
+	}
+	return ".tar.gz"
+}
+
+// ContentType returns the mimetype to use when sending an archive of this
+// format to a client.
+func (f ArchiveFormat) ContentType() string {
+	switch f {
+	case ArchiveFormatZip:
+		return "application/zip"
+	case ArchiveFormatTarZstd:
+		return "application/zstd"
+	case ArchiveFormatTar:
+		return "application/x-tar"
+	}
+	return "application/gzip"
+}
+
 type Archive struct {
 	// Filesystem to create the archive with.
 	Filesystem *Filesystem
 
+	// Format is the container format to write, defaults to ArchiveFormatTarGz
+	// when unset.
+	Format ArchiveFormat
+
+	// ExcludeDenylisted skips any files matching the egg's file denylist. This
+	// should be set whenever the archive is being sent back to a user rather
+	// than stored as a backup.
+	ExcludeDenylisted bool
+
 	// Ignore is a gitignore string (most likely read from a file) of files to ignore
 	// from the archive.
 	Ignore string
@@ -74,7 +145,8 @@ type Archive struct {
 	// Progress wraps the writer of the archive to pass through the progress tracker.
 	Progress *progress.Progress
 
-	w *TarProgress
+	w  *TarProgress
+	zw *zip.Writer
 }
 
 // Create creates an archive at dst with all the files defined in the
@@ -105,6 +177,47 @@ func (a *Archive) Create(ctx context.Context, dst string) error {
 	return a.Stream(ctx, writer)
 }
 
+// newCompressor returns a writer that compresses everything written to it in
+// the given format, using the compression level and number of threads which
+// are configured for backups.
+func newCompressor(w io.Writer, format ArchiveFormat) (io.WriteCloser, error) {
+	cfg := config.Get().System.Backups
+	threads := max(cfg.CompressionThreads, 1)
+	if format == ArchiveFormatTarZstd {
+		// Zstd has no level without any compression, so the fastest one is
+		// used instead.
+		level := zstd.SpeedFastest
+		switch cfg.CompressionLevel {
+		case "default":
+			level = zstd.SpeedDefault
+		case "best_compression":
+			level = zstd.SpeedBestCompression
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(threads))
+	}
+
+	// Choose which compression level to use based on the compression_level configuration option
+	var compressionLevel int
+	switch cfg.CompressionLevel {
+	case "none":
+		compressionLevel = pgzip.NoCompression
+	case "default":
+		compressionLevel = pgzip.DefaultCompression
+	case "best_compression":
+		compressionLevel = pgzip.BestCompression
+	default:
+		compressionLevel = pgzip.BestSpeed
+	}
+
+	// Create a new gzip writer around the file.
+	gw, err := pgzip.NewWriterLevel(w, compressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	_ = gw.SetConcurrency(1<<20, threads)
+	return gw, nil
+}
+
 type walkFunc func(dirfd int, name, relative string, d ufs.DirEntry) error
 
 // Stream streams the creation of the archive to the given writer.
@@ -129,46 +242,51 @@ func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 		a.Files = files
 	}
 
-	// Choose which compression level to use based on the compression_level configuration option
-	var compressionLevel int
-	switch config.Get().System.Backups.CompressionLevel {
-	case "none":
-		compressionLevel = pgzip.NoCompression
-	case "best_compression":
-		compressionLevel = pgzip.BestCompression
-	default:
-		compressionLevel = pgzip.BestSpeed
-	}
+	if a.Format == ArchiveFormatZip {
+		zw := zip.NewWriter(w)
+		defer zw.Close()
+		a.zw = zw
+	} else if a.Format == ArchiveFormatTar {
+		tw := tar.NewWriter(w)
+		defer tw.Close()
 
-	// Create a new gzip writer around the file.
-	gw, _ := pgzip.NewWriterLevel(w, compressionLevel)
-	_ = gw.SetConcurrency(1<<20, 1)
-	defer gw.Close()
+		a.w = NewTarProgress(tw, a.Progress)
+	} else {
+		cw, err := newCompressor(w, a.Format)
+		if err != nil {
+			return err
+		}
+		defer cw.Close()
 
-	// Create a new tar writer around the gzip writer.
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
+		// Create a new tar writer around the compressed writer.
+		tw := tar.NewWriter(cw)
+		defer tw.Close()
 
-	a.w = NewTarProgress(tw, a.Progress)
+		a.w = NewTarProgress(tw, a.Progress)
+	}
 
 	fs := a.Filesystem.unixFS
 
 	// If we're specifically looking for only certain files, or have requested
 	// that certain files be ignored we'll update the callback function to reflect
 	// that request.
+	var opts []walkFunc
+	if a.ExcludeDenylisted {
+		opts = append(opts, a.denylistCallback)
+	}
 	var callback walkFunc
 	if len(a.Files) == 0 && len(a.Ignore) > 0 {
 		i := ignore.CompileIgnoreLines(strings.Split(a.Ignore, "\n")...)
-		callback = a.callback(func(_ int, _, relative string, _ ufs.DirEntry) error {
+		callback = a.callback(append(opts, func(_ int, _, relative string, _ ufs.DirEntry) error {
 			if i.MatchesPath(relative) {
 				return SkipThis
 			}
 			return nil
-		})
+		})...)
 	} else if len(a.Files) > 0 {
-		callback = a.withFilesCallback()
+		callback = a.withFilesCallback(opts...)
 	} else {
-		callback = a.callback()
+		callback = a.callback(opts...)
 	}
 
 	// Open the base directory we were provided.
@@ -234,9 +352,19 @@ func (a *Archive) callback(opts ...walkFunc) walkFunc {
 
 var SkipThis = errors.New("skip this")
 
+// Skips any files that match the denylist of the filesystem. The relative path
+// given to callbacks is relative to the BaseDirectory, so it must be joined back
+// onto it before matching against the denylist.
+func (a *Archive) denylistCallback(_ int, _, relative string, _ ufs.DirEntry) error {
+	if a.Filesystem.IsIgnored(path.Join(a.BaseDirectory, relative)) != nil {
+		return SkipThis
+	}
+	return nil
+}
+
 // Pushes only files defined in the Files key to the final archive.
-func (a *Archive) withFilesCallback() walkFunc {
-	return a.callback(func(_ int, _, relative string, _ ufs.DirEntry) error {
+func (a *Archive) withFilesCallback(opts ...walkFunc) walkFunc {
+	return a.callback(append(opts, func(_ int, _, relative string, _ ufs.DirEntry) error {
 		for _, f := range a.Files {
 			// Allow exact file matches, otherwise check if file is within a parent directory.
 			//
@@ -254,7 +382,7 @@ func (a *Archive) withFilesCallback() walkFunc {
 		}
 
 		return SkipThis
-	})
+	})...)
 }
 
 // Adds a given file path to the final archive being created.
@@ -301,8 +429,17 @@ func (a *Archive) addToArchive(dirfd int, name, relative string, entry ufs.DirEn
 		header.Name = relative
 	}
 
-	// Write the tar FileInfoHeader to the archive.
-	if err := a.w.WriteHeader(header); err != nil {
+	var w io.Writer = a.w
+	if a.zw != nil {
+		w, err = a.createZipEntry(s, relative, target)
+		if err != nil {
+			return err
+		}
+		if s.Mode()&fs.ModeSymlink != 0 {
+			return nil
+		}
+	} else if err := a.w.WriteHeader(header); err != nil {
+		// Write the tar FileInfoHeader to the archive.
 		return errors.WrapIff(err, "failed to write tar#FileInfoHeader for '%s'", name)
 	}
 
@@ -335,8 +472,39 @@ func (a *Archive) addToArchive(dirfd int, name, relative string, entry ufs.DirEn
 	defer f.Close()
 
 	// Copy the file's contents to the archive using our buffer.
-	if _, err := io.CopyBuffer(a.w, io.LimitReader(f, header.Size), buf); err != nil {
+	if _, err := io.CopyBuffer(w, io.LimitReader(f, header.Size), buf); err != nil {
 		return errors.WrapIff(err, "failed to copy '%s' to archive", header.Name)
 	}
 	return nil
 }
+
+// Creates a new entry in the zip archive for the given file and returns the
+// writer its contents should be copied to. Symlinks are stored the same way
+// the Info-ZIP tools store them, with the target as the entry contents.
+func (a *Archive) createZipEntry(s ufs.FileInfo, relative, target string) (io.Writer, error) {
+	header, err := zip.FileInfoHeader(s)
+	if err != nil {
+		return nil, errors.WrapIff(err, "failed to get zip#FileInfoHeader for '%s'", relative)
+	}
+	header.Name = relative
+	header.Method = zip.Deflate
+	if s.Mode()&fs.ModeSymlink != 0 {
+		header.Method = zip.Store
+	}
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return nil, errors.WrapIff(err, "failed to write zip#FileHeader for '%s'", relative)
+	}
+	if s.Mode()&fs.ModeSymlink != 0 {
+		if _, err := io.WriteString(w, filepath.ToSlash(target)); err != nil {
+			return nil, errors.WrapIff(err, "failed to write symlink target for '%s'", relative)
+		}
+		return w, nil
+	}
+	if a.Progress != nil {
+		a.Progress.Writer = w
+		return a.Progress, nil
+	}
+	return w, nil
+}
@@ -0,0 +1,229 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	iofs "io/fs"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/mholt/archives"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// ArchiveEntry is a single file or directory contained within an archive on
+// the server's filesystem.
+type ArchiveEntry struct {
+	// Name is the full path of the entry within the archive.
+	Name     string
+	Size     int64
+	Mode     ufs.FileMode
+	Modified time.Time
+}
+
+func (e *ArchiveEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Name      string `json:"name"`
+		Modified  string `json:"modified"`
+		Mode      string `json:"mode"`
+		ModeBits  string `json:"mode_bits"`
+		Size      int64  `json:"size"`
+		Directory bool   `json:"directory"`
+		File      bool   `json:"file"`
+		Symlink   bool   `json:"symlink"`
+	}{
+		Name:      e.Name,
+		Modified:  e.Modified.Format(time.RFC3339),
+		Mode:      e.Mode.String(),
+		ModeBits:  strconv.FormatUint(uint64(e.Mode&ufs.ModePerm), 8),
+		Size:      e.Size,
+		Directory: e.Mode.IsDir(),
+		File:      !e.Mode.IsDir(),
+		Symlink:   e.Mode.Type()&ufs.ModeSymlink != 0,
+	})
+}
+
+// openArchive returns the io/fs view of the archive at the given path, wrapping
+// an unknown format in the same error DecompressFile would return.
+func (fs *Filesystem) openArchive(ctx context.Context, p string) (iofs.FS, func() error, error) {
+	fsys, archive, err := fs.archiverFileSystem(ctx, p)
+	if err != nil {
+		if errors.Is(err, archives.NoMatch) {
+			return nil, nil, newFilesystemError(ErrCodeUnknownArchive, err)
+		}
+		return nil, nil, err
+	}
+	return fsys, archive.Close, nil
+}
+
+// ListArchive returns every entry contained within the archive at the given
+// path without extracting any of it. Entries that would be ignored by the
+// denylist if extracted into the archive's directory are omitted.
+func (fs *Filesystem) ListArchive(ctx context.Context, dir string, file string) ([]ArchiveEntry, error) {
+	fsys, closeFn, err := fs.openArchive(ctx, filepath.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+
+	var out []ArchiveEntry
+	err = iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if fs.IsIgnored(filepath.Join(dir, p)) != nil {
+			if d.IsDir() {
+				return iofs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out = append(out, ArchiveEntry{
+			Name:     p,
+			Size:     info.Size(),
+			Mode:     info.Mode(),
+			Modified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExtractArchiveEntries extracts only the given entries of the archive at the
+// given path into the destination directory. Each entry may be either a file
+// or a directory, directories are extracted along with everything beneath them.
+//
+// Entries are placed into the destination using their base name, so extracting
+// "mods/plugin.jar" into "/plugins" results in "/plugins/plugin.jar" rather than
+// "/plugins/mods/plugin.jar".
+func (fs *Filesystem) ExtractArchiveEntries(ctx context.Context, dir string, file string, destination string, entries []string) error {
+	fsys, closeFn, err := fs.openArchive(ctx, filepath.Join(dir, file))
+	if err != nil {
+		return err
+	}
+	defer closeFn()
+
+	roots := make([]string, 0, len(entries))
+	for _, e := range entries {
+		clean := path.Clean(strings.TrimPrefix(filepath.ToSlash(e), "/"))
+		if clean == "." || !iofs.ValidPath(clean) {
+			return NewBadPathResolution(e, clean)
+		}
+		roots = append(roots, clean)
+	}
+
+	// Determine the total size and number of everything being extracted up
+	// front, so we don't end up with a partially extracted set of files once
+	// the server runs out of space.
+	var size, files int64
+	for _, root := range roots {
+		err := iofs.WalkDir(fsys, root, func(_ string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if !d.IsDir() && !d.Type().IsRegular() {
+				return nil
+			}
+			files++
+			if d.IsDir() {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+	if err := fs.HasSpaceFor(size); err != nil {
+		return err
+	}
+	if err := fs.HasSpaceForFiles(files); err != nil {
+		return err
+	}
+
+	for _, root := range roots {
+		err := iofs.WalkDir(fsys, root, func(p string, d iofs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+
+			rel := path.Base(root)
+			if p != root {
+				rel = path.Join(rel, strings.TrimPrefix(p, root+"/"))
+			}
+			target := filepath.Join(destination, rel)
+			// If it is ignored, just don't do anything with the entry and skip over it.
+			if fs.IsIgnored(target) != nil {
+				if d.IsDir() {
+					return iofs.SkipDir
+				}
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return wrapError(fs.mkdirAll(target, 0o755), file)
+			}
+			// Only regular files are extracted, symlinks and other special files
+			// within an archive are not something we want to recreate.
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+
+			f, err := fsys.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if err := fs.Write(target, f, info.Size(), info.Mode().Perm()); err != nil {
+				return wrapError(err, file)
+			}
+			// Update the file modification time to the one set in the archive.
+			return wrapError(fs.Chtimes(target, info.ModTime(), info.ModTime()), file)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package filesystem
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
 	iofs "io/fs"
 	"os"
@@ -11,6 +13,7 @@ import (
 
 	. "github.com/franela/goblin"
 	"github.com/mholt/archives"
+	ignore "github.com/sabhiram/go-gitignore"
 )
 
 func TestArchive_Stream(t *testing.T) {
@@ -84,6 +87,60 @@ func TestArchive_Stream(t *testing.T) {
 
 			g.Assert(files).Equal(expected)
 		})
+
+		g.It("streams a zip of a directory without denylisted files", func() {
+			fs.denylist = ignore.CompileIgnoreLines("*.secret")
+			defer func() {
+				fs.denylist = ignore.CompileIgnoreLines()
+			}()
+
+			g.Assert(fs.CreateDirectory("nested", "/test")).IsNil()
+			for _, p := range []string{"test/file.txt", "test/nested/file.txt", "test/key.secret", "other.txt"} {
+				r := strings.NewReader("hello, world!\n")
+				g.Assert(fs.Write(p, r, r.Size(), 0o644)).IsNil()
+			}
+
+			a := &Archive{
+				Filesystem:        fs,
+				Format:            ArchiveFormatZip,
+				ExcludeDenylisted: true,
+				BaseDirectory:     "/test",
+			}
+
+			var buf bytes.Buffer
+			g.Assert(a.Stream(context.Background(), &buf)).IsNil()
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			g.Assert(err).IsNil()
+
+			var files []string
+			for _, f := range zr.File {
+				files = append(files, f.Name)
+			}
+			sort.Strings(files)
+
+			g.Assert(files).Equal([]string{"file.txt", "nested/file.txt"})
+		})
+
+		g.It("streams a zstd compressed tarball that can be extracted", func() {
+			g.Assert(fs.CreateDirectory("test", "/")).IsNil()
+			r := strings.NewReader("hello, world!\n")
+			g.Assert(fs.Write("test/file.txt", r, r.Size(), 0o644)).IsNil()
+
+			a := &Archive{
+				Filesystem: fs,
+				Format:     ArchiveFormatTarZstd,
+			}
+			var buf bytes.Buffer
+			g.Assert(a.Stream(context.Background(), &buf)).IsNil()
+			g.Assert(buf.Bytes()[:4]).Equal([]byte{0x28, 0xb5, 0x2f, 0xfd})
+
+			g.Assert(fs.CreateDirectory("extracted", "/")).IsNil()
+			g.Assert(fs.ExtractStreamUnsafe(context.Background(), "/extracted", &buf)).IsNil()
+			st, err := rfs.StatServerFile("extracted/test/file.txt")
+			g.Assert(err).IsNil()
+			g.Assert(st.Size()).Equal(int64(14))
+		})
 	})
 }
 
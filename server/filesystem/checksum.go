@@ -0,0 +1,161 @@
+package filesystem
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"syscall"
+
+	"emperror.dev/errors"
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+const (
+	ChecksumSHA256 = "sha256"
+	ChecksumSHA1   = "sha1"
+	ChecksumMD5    = "md5"
+	// ChecksumXXHash is the 64-bit xxHash of the file, which is much faster to
+	// compute than the others but is not suitable for verifying untrusted files.
+	ChecksumXXHash = "xxhash"
+)
+
+// The maximum number of checksums remembered by each filesystem.
+const maxCachedChecksums = 8192
+
+// ErrUnknownChecksum is returned when a checksum is requested using an
+// algorithm that is not supported.
+var ErrUnknownChecksum = errors.Sentinel("filesystem: unknown checksum algorithm")
+
+// newChecksumHash returns a new hash for the given algorithm.
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch strings.ToLower(algorithm) {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumXXHash:
+		return xxhash.New(), nil
+	}
+	return nil, errors.WithStack(ErrUnknownChecksum)
+}
+
+// checksumKey identifies a specific version of a file. Anything writing to the
+// file will change the modification time or size, so a checksum stored under
+// this key never needs to be invalidated.
+type checksumKey struct {
+	dev       uint64
+	ino       uint64
+	mtime     int64
+	size      int64
+	algorithm string
+}
+
+// fileID returns the device and inode of a file, which together identify the
+// file regardless of the path it is found at.
+func fileID(info ufs.FileInfo) (uint64, uint64) {
+	// FileInfos produced by ufs stat calls carry a *unix.Stat_t, while those
+	// produced by (*os.File).Stat carry a *syscall.Stat_t.
+	switch st := info.Sys().(type) {
+	case *unix.Stat_t:
+		return uint64(st.Dev), uint64(st.Ino)
+	case *syscall.Stat_t:
+		return uint64(st.Dev), uint64(st.Ino)
+	}
+	return 0, 0
+}
+
+// checksumCache stores the checksums of files which have already been read.
+type checksumCache struct {
+	sync.Mutex
+	entries map[checksumKey]string
+}
+
+func (c *checksumCache) get(key checksumKey) (string, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.entries[key]
+	return v, ok
+}
+
+func (c *checksumCache) set(key checksumKey, sum string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[checksumKey]string)
+	}
+	// Entries never expire on their own, so just start over once there are too
+	// many rather than tracking which are the oldest.
+	if len(c.entries) >= maxCachedChecksums {
+		clear(c.entries)
+	}
+	c.entries[key] = sum
+}
+
+// Checksum returns the hex encoded checksum of the file at the given path using
+// the given algorithm. The file is read in chunks so that the context is able
+// to cancel hashing a large file, and the result is cached until the file is
+// changed.
+func (fs *Filesystem) Checksum(ctx context.Context, p string, algorithm string) (string, error) {
+	algorithm = strings.ToLower(algorithm)
+	h, err := newChecksumHash(algorithm)
+	if err != nil {
+		return "", err
+	}
+	// Check the type of the file before opening it, since opening a named pipe
+	// would block until something writes to it.
+	if st, err := fs.unixFS.Stat(p); err != nil {
+		return "", err
+	} else if st.IsDir() {
+		return "", errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: p})
+	} else if !st.Mode().IsRegular() {
+		return "", errors.WithStack(ufs.ErrNotRegular)
+	}
+	f, err := fs.unixFS.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	key := checksumKey{mtime: st.ModTime().UnixNano(), size: st.Size(), algorithm: algorithm}
+	key.dev, key.ino = fileID(st)
+	if sum, ok := fs.checksums.get(key); ok {
+		return sum, nil
+	}
+
+	buf := make([]byte, 128*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		n, err := f.Read(buf)
+		h.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	// Only the version of the file that was seen when opening it can be cached,
+	// anything that changed while it was being read may be missing from the sum.
+	if after, err := f.Stat(); err == nil && after.ModTime().Equal(st.ModTime()) && after.Size() == st.Size() {
+		fs.checksums.set(key, sum)
+	}
+	return sum, nil
+}
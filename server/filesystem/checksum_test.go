@@ -0,0 +1,82 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_Checksum(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("Checksum", func() {
+		g.BeforeEach(func() {
+			_ = rfs.CreateServerFile("server.properties", []byte("hello"))
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("computes checksums with each algorithm", func() {
+			for algorithm, want := range map[string]string{
+				ChecksumSHA256: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824",
+				ChecksumSHA1:   "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+				ChecksumMD5:    "5d41402abc4b2a76b9719d911017c592",
+				ChecksumXXHash: "26c7827d889f6da3",
+			} {
+				sum, err := fs.Checksum(context.Background(), "server.properties", algorithm)
+				g.Assert(err).IsNil()
+				g.Assert(sum).Equal(want)
+			}
+		})
+
+		g.It("does not return a cached checksum once the file changes", func() {
+			sum, err := fs.Checksum(context.Background(), "server.properties", "MD5")
+			g.Assert(err).IsNil()
+			g.Assert(sum).Equal("5d41402abc4b2a76b9719d911017c592")
+
+			g.Assert(os.WriteFile(filepath.Join(rfs.root, "server/server.properties"), []byte("hello world"), 0o644)).IsNil()
+			sum, err = fs.Checksum(context.Background(), "server.properties", ChecksumMD5)
+			g.Assert(err).IsNil()
+			g.Assert(sum).Equal("5eb63bbbe01eeed093cb22bb8f5acdc3")
+		})
+
+		g.It("does not return the cached checksum of another file", func() {
+			g.Assert(rfs.CreateServerFile("other.properties", []byte("world"))).IsNil()
+			mtime := time.Now().Add(-time.Hour)
+			for _, name := range []string{"server.properties", "other.properties"} {
+				g.Assert(os.Chtimes(filepath.Join(rfs.root, "server", name), mtime, mtime)).IsNil()
+			}
+
+			sum, err := fs.Checksum(context.Background(), "server.properties", ChecksumMD5)
+			g.Assert(err).IsNil()
+			g.Assert(sum).Equal("5d41402abc4b2a76b9719d911017c592")
+			sum, err = fs.Checksum(context.Background(), "other.properties", ChecksumMD5)
+			g.Assert(err).IsNil()
+			g.Assert(sum).Equal("7d793037a0760186574b0282f2f435e7")
+		})
+
+		g.It("returns an error for directories and unknown algorithms", func() {
+			g.Assert(fs.CreateDirectory("config", "/")).IsNil()
+			_, err := fs.Checksum(context.Background(), "config", ChecksumSHA256)
+			g.Assert(IsErrorCode(err, ErrCodeIsDirectory)).IsTrue()
+
+			_, err = fs.Checksum(context.Background(), "server.properties", "crc32")
+			g.Assert(errors.Is(err, ErrUnknownChecksum)).IsTrue()
+		})
+
+		g.It("stops when the context is canceled", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := fs.Checksum(ctx, "server.properties", ChecksumSHA256)
+			g.Assert(errors.Is(err, context.Canceled)).IsTrue()
+		})
+	})
+}
@@ -14,12 +14,69 @@ import (
 
 	"emperror.dev/errors"
 	"github.com/klauspost/compress/zip"
+	"github.com/klauspost/compress/zstd"
 	"github.com/mholt/archives"
 
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/progress"
 	"github.com/pelican/wings/internal/ufs"
 	"github.com/pelican/wings/server/filesystem/archiverext"
 )
 
+// progressFile wraps a file and counts every byte read from it towards the
+// given progress.
+type progressFile struct {
+	ufs.File
+	p *progress.Progress
+}
+
+func (f progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.p.Add(uint64(n))
+	return n, err
+}
+
+func (f progressFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	f.p.Add(uint64(n))
+	return n, err
+}
+
+// progressReader is the same as progressFile but for the files being read
+// from the disk when creating an archive.
+type progressReader struct {
+	iofs.File
+	p *progress.Progress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.File.Read(b)
+	r.p.Add(uint64(n))
+	return n, err
+}
+
+// ArchiveExtension returns the file extension and mimetype of the archives
+// CompressFiles creates for the requested extension, which falls back to a
+// gzip compressed tarball if the extension is not supported.
+func ArchiveExtension(extension string) (string, string) {
+	extension = strings.ToLower(strings.TrimPrefix(extension, "."))
+	switch extension {
+	case "zip":
+		return ".zip", "application/zip"
+	case "tar.gz", "tgz":
+		return ".tar.gz", "application/gzip"
+	case "tar.bz2", "tbz2":
+		return ".tar.bz2", "application/x-bzip2"
+	case "tar.xz", "txz":
+		return ".tar.xz", "application/x-xz"
+	case "tar.zst", "tzst":
+		return ".tar.zst", "application/zstd"
+	default:
+		// fallback to tar.gz
+		return ".tar.gz", "application/gzip"
+	}
+}
+
 // CompressFiles compresses all the files matching the given paths in the
 // specified directory. This function also supports passing nested paths to only
 // compress certain files and folders when working in a larger directory. This
@@ -29,7 +86,10 @@ import (
 // All paths are relative to the dir that is passed in as the first argument,
 // and the compressed file will be placed at that location named
 // `archive-{date}.tar.gz`.
-func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, extension string) (ufs.FileInfo, string, error) {
+//
+// If p is not nil it is updated with the number of uncompressed bytes read
+// from the files being compressed.
+func (fs *Filesystem) CompressFiles(ctx context.Context, dir string, name string, paths []string, extension string, p *progress.Progress) (ufs.FileInfo, string, error) {
 	var validPaths []string
 	for _, file := range paths {
 		if err := fs.IsIgnored(path.Join(dir, file)); err == nil {
@@ -42,31 +102,7 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 		return nil, "", fmt.Errorf("no valid files to compress")
 	}
 
-	// Normalize extension & assign MIME type
-	extension = strings.ToLower(strings.TrimPrefix(extension, "."))
-	var (
-		ext      string
-		mimetype string
-	)
-	switch extension {
-	case "zip":
-		ext = ".zip"
-		mimetype = "application/zip"
-	case "tar.gz", "tgz":
-		ext = ".tar.gz"
-		mimetype = "application/gzip"
-	case "tar.bz2", "tbz2":
-		ext = ".tar.bz2"
-		mimetype = "application/x-bzip2"
-	case "tar.xz", "txz":
-		ext = ".tar.xz"
-		mimetype = "application/x-xz"
-	default:
-		// fallback to tar.gz
-		ext = ".tar.gz"
-		mimetype = "application/gzip"
-	}
-
+	ext, mimetype := ArchiveExtension(extension)
 	if name == "" {
 		name = fmt.Sprintf("archive-%s%s", strings.ReplaceAll(time.Now().Format(time.RFC3339), ":", ""), ext)
 	} else {
@@ -105,12 +141,28 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 		filesMap[absolutePath] = file
 	}
 
-	ctx := context.Background()
-
 	files, err := archives.FilesFromDisk(ctx, nil, filesMap)
 	if err != nil {
 		return nil, "", err
 	}
+	if p != nil {
+		var total uint64
+		for i := range files {
+			if !files[i].Mode().IsRegular() {
+				continue
+			}
+			total += uint64(files[i].Size())
+			open := files[i].Open
+			files[i].Open = func() (iofs.File, error) {
+				f, err := open()
+				if err != nil {
+					return nil, err
+				}
+				return progressReader{File: f, p: p}, nil
+			}
+		}
+		p.SetTotal(total)
+	}
 
 	f, err := fs.unixFS.OpenFile(destPath, ufs.O_WRONLY|ufs.O_CREATE, 0o644)
 	if err != nil {
@@ -143,6 +195,16 @@ func (fs *Filesystem) CompressFiles(dir string, name string, paths []string, ext
 		if err := format.Archive(ctx, cw, files); err != nil {
 			return nil, "", err
 		}
+	case "tar.zst", "tzst":
+		format := archives.CompressedArchive{
+			Compression: archives.Zstd{
+				EncoderOptions: []zstd.EOption{zstd.WithEncoderConcurrency(max(config.Get().System.Backups.CompressionThreads, 1))},
+			},
+			Archival: archives.Tar{},
+		}
+		if err := format.Archive(ctx, cw, files); err != nil {
+			return nil, "", err
+		}
 	default: // tar.gz and fallback
 		format := archives.CompressedArchive{
 			Compression: archives.Gz{},
@@ -216,7 +278,7 @@ func (fs *Filesystem) archiverFileSystem(ctx context.Context, p string) (iofs.FS
 func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir string, file string) error {
 	// Don't waste time trying to determine this if we know the server will have the space for
 	// it since there is no limit.
-	if fs.MaxDisk() <= 0 {
+	if fs.MaxDisk() <= 0 && fs.MaxFiles() <= 0 {
 		return nil
 	}
 
@@ -230,6 +292,7 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 	defer archive.Close()
 
 	var size atomic.Int64
+	var files int64
 	return iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -240,6 +303,12 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 			// Stop walking if the context is canceled.
 			return ctx.Err()
 		default:
+			if path != "." {
+				files++
+				if err := fs.HasSpaceForFiles(files); err != nil {
+					return err
+				}
+			}
 			info, err := d.Info()
 			if err != nil {
 				return err
@@ -267,15 +336,28 @@ func (fs *Filesystem) SpaceAvailableForDecompression(ctx context.Context, dir st
 // all the files within the given archive and ensure that there is not a
 // zip-slip attack being attempted by validating that the final path is within
 // the server data directory.
-func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file string) error {
+//
+// If p is not nil it is updated with the number of bytes of the archive that
+// have been read.
+func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file string, p *progress.Progress) error {
 	f, err := fs.unixFS.Open(filepath.Join(dir, file))
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	var r ufs.File = f
+	if p != nil {
+		st, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		p.SetTotal(uint64(st.Size()))
+		r = progressFile{File: f, p: p}
+	}
+
 	// Identify the type of archive we are dealing with.
-	format, input, err := archives.Identify(ctx, filepath.Base(file), f)
+	format, input, err := archives.Identify(ctx, filepath.Base(file), r)
 	if err != nil {
 		if errors.Is(err, archives.NoMatch) {
 			return newFilesystemError(ErrCodeUnknownArchive, err)
@@ -293,7 +375,9 @@ func (fs *Filesystem) DecompressFile(ctx context.Context, dir string, file strin
 
 // ExtractStreamUnsafe .
 func (fs *Filesystem) ExtractStreamUnsafe(ctx context.Context, dir string, r io.Reader) error {
-	format, input, err := archives.Identify(ctx, "archive.tar.gz", r)
+	// The archive is identified by its contents alone, since it may have been
+	// compressed with gzip or zstd.
+	format, input, err := archives.Identify(ctx, "", r)
 	if err != nil {
 		if errors.Is(err, archives.NoMatch) {
 			return newFilesystemError(ErrCodeUnknownArchive, err)
@@ -307,6 +391,13 @@ func (fs *Filesystem) ExtractStreamUnsafe(ctx context.Context, dir string, r io.
 	})
 }
 
+// withinDirectory returns whether the path is the directory itself or is
+// beneath it, without following any links.
+func withinDirectory(dir string, p string) bool {
+	dir, p = filepath.Clean("/"+dir), filepath.Clean("/"+p)
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
 type extractStreamOptions struct {
 	// The directory to extract the archive to.
 	Directory string
@@ -331,6 +422,9 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 
 		// Strip the compression suffix
 		p := filepath.Join(opts.Directory, strings.TrimSuffix(opts.FileName, opts.Format.Extension()))
+		if !withinDirectory(opts.Directory, p) {
+			return NewBadPathResolution(opts.FileName, p)
+		}
 
 		// Make sure it's not ignored
 		if err := fs.IsIgnored(p); err != nil {
@@ -344,11 +438,20 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 		defer reader.Close()
 
 		// Open the file for creation/writing
+		_, statErr := fs.unixFS.Lstat(p)
+		if statErr != nil {
+			if err := fs.HasSpaceForFiles(1); err != nil {
+				return err
+			}
+		}
 		f, err := fs.unixFS.OpenFile(p, ufs.O_WRONLY|ufs.O_CREATE, 0o644)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
+		if statErr != nil {
+			fs.unixFS.AddFiles(1)
+		}
 
 		// Read in 4 KB chunks
 		buf := make([]byte, 4096)
@@ -387,6 +490,11 @@ func (fs *Filesystem) extractStream(ctx context.Context, opts extractStreamOptio
 	// Decompress and extract archive
 	return ex.Extract(ctx, opts.Reader, func(ctx context.Context, f archives.FileInfo) error {
 		p := filepath.Join(opts.Directory, f.NameInArchive)
+		// Permission to extract the archive is only given for its directory, so
+		// no entry may be written anywhere else.
+		if !withinDirectory(opts.Directory, p) {
+			return NewBadPathResolution(f.NameInArchive, p)
+		}
 		// If it is ignored, just don't do anything with the entry and skip over it.
 		if err := fs.IsIgnored(p); err != nil {
 			return nil
@@ -1,8 +1,12 @@
 package filesystem
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
+	"errors"
 	"os"
+	"sort"
 	"testing"
 
 	. "github.com/franela/goblin"
@@ -21,7 +25,7 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 	fs, rfs := NewFs()
 
 	g.Describe("Decompress", func() {
-		for _, ext := range []string{"zip", "rar", "tar", "tar.gz"} {
+		for _, ext := range []string{"zip", "rar", "tar", "tar.gz", "tar.zst"} {
 			g.It("can decompress a "+ext, func() {
 				// copy the file to the new FS
 				c, err := os.ReadFile("./testdata/test." + ext)
@@ -30,7 +34,7 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 				g.Assert(err).IsNil()
 
 				// decompress
-				err = fs.DecompressFile(context.Background(), "/", "test."+ext)
+				err = fs.DecompressFile(context.Background(), "/", "test."+ext, nil)
 				g.Assert(err).IsNil()
 
 				// make sure everything is where it is supposed to be
@@ -47,6 +51,86 @@ func TestFilesystem_DecompressFile(t *testing.T) {
 			})
 		}
 
+		g.It("does not extract entries outside of the directory", func() {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			content := []byte("hello")
+			g.Assert(tw.WriteHeader(&tar.Header{Name: "../other/evil.txt", Mode: 0o644, Size: int64(len(content))})).IsNil()
+			_, err := tw.Write(content)
+			g.Assert(err).IsNil()
+			g.Assert(tw.Close()).IsNil()
+			g.Assert(fs.CreateDirectory("plugins", "/")).IsNil()
+			g.Assert(rfs.CreateServerFile("plugins/evil.tar", buf.Bytes())).IsNil()
+
+			err = fs.DecompressFile(context.Background(), "/plugins", "evil.tar", nil)
+			g.Assert(IsErrorCode(err, ErrCodePathResolution)).IsTrue()
+			_, err = rfs.StatServerFile("other/evil.txt")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
+
+func TestFilesystem_ArchiveEntries(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("ListArchive", func() {
+		for _, ext := range []string{"zip", "tar", "tar.gz"} {
+			g.It("lists the entries of a "+ext, func() {
+				c, err := os.ReadFile("./testdata/test." + ext)
+				g.Assert(err).IsNil()
+				g.Assert(rfs.CreateServerFile("./test."+ext, c)).IsNil()
+
+				entries, err := fs.ListArchive(context.Background(), "/", "test."+ext)
+				g.Assert(err).IsNil()
+
+				var names []string
+				for _, e := range entries {
+					names = append(names, e.Name)
+				}
+				sort.Strings(names)
+				g.Assert(names).Equal([]string{"test", "test/inside", "test/inside/finside.txt", "test/outside.txt"})
+
+				// Nothing should have been extracted.
+				_, err = rfs.StatServerFile("test")
+				g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+			})
+		}
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+
+	g.Describe("ExtractArchiveEntries", func() {
+		g.It("extracts only the requested subtree into the destination", func() {
+			c, err := os.ReadFile("./testdata/test.zip")
+			g.Assert(err).IsNil()
+			g.Assert(rfs.CreateServerFile("./test.zip", c)).IsNil()
+
+			err = fs.ExtractArchiveEntries(context.Background(), "/", "test.zip", "/dest", []string{"test/inside"})
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("dest/inside/finside.txt")
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("dest/outside.txt")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("rejects entries that escape the archive root", func() {
+			c, err := os.ReadFile("./testdata/test.zip")
+			g.Assert(err).IsNil()
+			g.Assert(rfs.CreateServerFile("./test.zip", c)).IsNil()
+
+			err = fs.ExtractArchiveEntries(context.Background(), "/", "test.zip", "/dest", []string{"../test"})
+			g.Assert(IsErrorCode(err, ErrCodePathResolution)).IsTrue()
+		})
+
 		g.AfterEach(func() {
 			_ = fs.TruncateRootDirectory()
 		})
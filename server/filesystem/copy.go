@@ -0,0 +1,212 @@
+//go:build unix
+
+package filesystem
+
+import (
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/progress"
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// ErrCopyIntoSelf is returned when attempting to copy a directory into itself.
+var ErrCopyIntoSelf = errors.Sentinel("filesystem: cannot copy a directory into itself")
+
+// copiedDir is a directory created while copying, its mode and modification
+// time are only set once everything within it has been copied, since creating
+// files would otherwise update the time again or be prevented by the mode.
+type copiedDir struct {
+	path  string
+	mode  ufs.FileMode
+	mtime time.Time
+}
+
+// CopyTo copies the file or directory at src to dst, directories are copied
+// along with everything beneath them. The destination must not already exist,
+// but any missing parent directories will be created.
+//
+// Modes and modification times are preserved, and symlinks are copied as links
+// rather than being followed. Anything that is denylisted is skipped. The total
+// size of everything being copied is checked against the disk limit before any
+// data is written, and the progress is updated with the number of bytes copied.
+func (fs *Filesystem) CopyTo(ctx context.Context, src string, dst string, p *progress.Progress) error {
+	src = path.Clean("/" + filepath.ToSlash(src))
+	dst = path.Clean("/" + filepath.ToSlash(dst))
+	if src == "/" || dst == src || strings.HasPrefix(dst, src+"/") {
+		return ErrCopyIntoSelf
+	}
+	if err := fs.IsIgnored(src, dst); err != nil {
+		return err
+	}
+
+	dirfd, name, closeFd, err := fs.unixFS.SafePath(src)
+	defer closeFd()
+	if err != nil {
+		return err
+	}
+	if _, err := fs.unixFS.Lstatat(dirfd, name); err != nil {
+		return err
+	}
+	if _, err := fs.unixFS.Lstat(dst); err == nil {
+		return ufs.ErrExist
+	} else if !errors.Is(err, ufs.ErrNotExist) {
+		return err
+	}
+
+	// Work out the total size and number of everything being copied up front, so
+	// we don't end up with a partial copy once the server runs out of space.
+	var size, files int64
+	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if fs.IsIgnored(path.Join(src, relative)) != nil {
+			if d.IsDir() {
+				return ufs.SkipDir
+			}
+			return nil
+		}
+		files++
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := fs.unixFS.Lstatat(dirfd, name)
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return errors.WrapIf(err, "server/filesystem: copy: failed to walk source")
+	}
+	if err := fs.HasSpaceFor(size); err != nil {
+		return err
+	}
+	if err := fs.HasSpaceForFiles(files); err != nil {
+		return err
+	}
+	if p != nil {
+		p.SetTotal(uint64(size))
+	}
+
+	if err := fs.mkdirAll(path.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	var dirs []copiedDir
+	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		target := path.Join(dst, relative)
+		if fs.IsIgnored(path.Join(src, relative), target) != nil {
+			if d.IsDir() {
+				return ufs.SkipDir
+			}
+			return nil
+		}
+
+		info, err := fs.unixFS.Lstatat(dirfd, name)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			if err := fs.unixFS.Mkdir(target, 0o755); err != nil {
+				return err
+			}
+			fs.unixFS.AddFiles(1)
+			dirs = append(dirs, copiedDir{path: target, mode: info.Mode().Perm(), mtime: info.ModTime()})
+		case info.Mode().IsRegular():
+			n, err := fs.copyFileat(dirfd, name, target, info)
+			if p != nil {
+				p.Add(uint64(n))
+			}
+			if err != nil {
+				return err
+			}
+		case info.Mode()&ufs.ModeSymlink != 0:
+			// Copy the link itself rather than whatever it points to. Links are
+			// only ever resolved within the server root, so the copy is no more
+			// able to escape it than the original is.
+			buf := make([]byte, unix.PathMax)
+			n, err := unix.Readlinkat(dirfd, name, buf)
+			if err != nil {
+				return err
+			}
+			if err := fs.unixFS.Symlink(string(buf[:n]), target); err != nil {
+				return err
+			}
+			fs.unixFS.AddFiles(1)
+		default:
+			// Sockets, devices and the like are never copied.
+			return nil
+		}
+		return fs.chownFile(target)
+	})
+	if err != nil {
+		return errors.WrapIf(err, "server/filesystem: copy: failed to copy")
+	}
+
+	// Update the directories deepest first, so that setting one doesn't cause
+	// its parent to be updated after it has already been set.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := fs.unixFS.Chmod(dirs[i].path, dirs[i].mode); err != nil {
+			return err
+		}
+		if err := fs.unixFS.Chtimes(dirs[i].path, dirs[i].mtime, dirs[i].mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFileat copies the regular file at name within dirfd to target, preserving
+// its mode and modification time, and returns the number of bytes copied.
+func (fs *Filesystem) copyFileat(dirfd int, name string, target string, info ufs.FileInfo) (int64, error) {
+	source, err := fs.unixFS.OpenFileat(dirfd, name, ufs.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer source.Close()
+
+	dst, err := fs.unixFS.OpenFile(target, ufs.O_WRONLY|ufs.O_CREATE|ufs.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	fs.unixFS.AddFiles(1)
+
+	var n int64
+	if cloneFile(dst, source) {
+		n = info.Size()
+	} else {
+		// Do not use CopyBuffer here, the file implements io.ReaderFrom which
+		// uses copy_file_range where possible and ignores the buffer anyways.
+		n, err = io.Copy(dst, io.LimitReader(source, info.Size()))
+	}
+	fs.unixFS.Add(n)
+	if err != nil {
+		return n, err
+	}
+
+	if err := fs.unixFS.Chmod(target, info.Mode().Perm()); err != nil {
+		return n, err
+	}
+	return n, fs.unixFS.Chtimes(target, info.ModTime(), info.ModTime())
+}
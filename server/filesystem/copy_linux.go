@@ -0,0 +1,16 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// cloneFile attempts to reflink src into dst, sharing the underlying extents
+// rather than copying any data. This is only supported by some filesystems,
+// such as btrfs and xfs, false is returned if it could not be done.
+func cloneFile(dst, src ufs.File) bool {
+	return unix.IoctlFileClone(int(dst.Fd()), int(src.Fd())) == nil
+}
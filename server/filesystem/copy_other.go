@@ -0,0 +1,10 @@
+//go:build unix && !linux
+
+package filesystem
+
+import "github.com/pelican/wings/internal/ufs"
+
+// cloneFile is only supported on Linux, files are always copied elsewhere.
+func cloneFile(_, _ ufs.File) bool {
+	return false
+}
@@ -0,0 +1,113 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/pelican/wings/internal/progress"
+	"github.com/pelican/wings/internal/ufs"
+)
+
+func TestFilesystem_CopyTo(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("CopyTo", func() {
+		mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+		g.BeforeEach(func() {
+			write := func(p string, size int, mode ufs.FileMode) {
+				r := strings.NewReader(strings.Repeat("a", size))
+				g.Assert(fs.Write(p, r, r.Size(), mode)).IsNil()
+				g.Assert(fs.unixFS.Chtimes(p, mtime, mtime)).IsNil()
+			}
+			write("world/region/r.0.0.mca", 400, 0o644)
+			write("world/level.dat", 50, 0o600)
+			write("world/start.sh", 10, 0o755)
+			g.Assert(fs.Symlink("level.dat", "world/level.link")).IsNil()
+			g.Assert(fs.unixFS.Chtimes("world/region", mtime, mtime)).IsNil()
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("copies a directory tree preserving modes and times", func() {
+			before := fs.CachedUsage()
+			p := progress.NewProgress(0)
+			err := fs.CopyTo(context.Background(), "world", "backup/world", p)
+			g.Assert(err).IsNil()
+
+			st, err := rfs.StatServerFile("backup/world/region/r.0.0.mca")
+			g.Assert(err).IsNil()
+			g.Assert(st.Size()).Equal(int64(400))
+			g.Assert(st.ModTime().UTC()).Equal(mtime)
+
+			st, err = rfs.StatServerFile("backup/world/level.dat")
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o600))
+
+			st, err = rfs.StatServerFile("backup/world/start.sh")
+			g.Assert(err).IsNil()
+			g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o755))
+
+			st, err = rfs.StatServerFile("backup/world/region")
+			g.Assert(err).IsNil()
+			g.Assert(st.ModTime().UTC()).Equal(mtime)
+
+			link, err := os.Readlink(filepath.Join(rfs.root, "server/backup/world/level.link"))
+			g.Assert(err).IsNil()
+			g.Assert(link).Equal("level.dat")
+
+			g.Assert(p.Total()).Equal(uint64(460))
+			g.Assert(p.Written()).Equal(uint64(460))
+			g.Assert(fs.CachedUsage()).Equal(before + 460)
+		})
+
+		g.It("copies a single file", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "level.dat", nil)
+			g.Assert(err).IsNil()
+
+			st, err := rfs.StatServerFile("level.dat")
+			g.Assert(err).IsNil()
+			g.Assert(st.Size()).Equal(int64(50))
+		})
+
+		g.It("does not overwrite an existing destination", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "world/start.sh", nil)
+			g.Assert(errors.Is(err, ufs.ErrExist)).IsTrue()
+		})
+
+		g.It("does not copy a directory into itself", func() {
+			err := fs.CopyTo(context.Background(), "world", "world/nested", nil)
+			g.Assert(errors.Is(err, ErrCopyIntoSelf)).IsTrue()
+		})
+
+		g.It("checks there is space for the entire copy first", func() {
+			fs.SetDiskLimit(fs.CachedUsage() + 100)
+
+			err := fs.CopyTo(context.Background(), "world", "copy", nil)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+
+			_, err = rfs.StatServerFile("copy")
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+
+		g.It("keeps the destination within the root", func() {
+			err := fs.CopyTo(context.Background(), "world/level.dat", "../../level.dat", nil)
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("level.dat")
+			g.Assert(err).IsNil()
+			_, err = os.Stat(filepath.Join(rfs.root, "level.dat"))
+			g.Assert(errors.Is(err, os.ErrNotExist)).IsTrue()
+		})
+	})
+}
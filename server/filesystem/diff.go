@@ -0,0 +1,358 @@
+package filesystem
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"emperror.dev/errors"
+	"github.com/google/uuid"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// MaxDiffSize is the largest file that can be compared or patched, since both
+// require the entire file to be held in memory.
+const MaxDiffSize = 8 * 1024 * 1024
+
+const noNewlineMarker = "\\ No newline at end of file\n"
+
+var (
+	// ErrDiffTooLarge is returned when a file is too large to be compared or
+	// patched.
+	ErrDiffTooLarge = errors.Sentinel("filesystem: file is too large to diff")
+	// ErrInvalidPatch is returned when a patch is not a valid unified diff.
+	ErrInvalidPatch = errors.Sentinel("filesystem: invalid patch")
+	// ErrPatchConflict is returned when a patch does not match the contents of
+	// the file it is being applied to.
+	ErrPatchConflict = errors.Sentinel("filesystem: patch does not apply")
+)
+
+// Diff returns a unified diff between the two files, with the given number of
+// lines of context around each change. An empty string is returned if the
+// files are the same.
+func (fs *Filesystem) Diff(from string, to string, context int) (string, error) {
+	a, err := fs.readForDiff(from)
+	if err != nil {
+		return "", err
+	}
+	b, err := fs.readForDiff(to)
+	if err != nil {
+		return "", err
+	}
+	return UnifiedDiff(a, b, diffName(from), diffName(to), context), nil
+}
+
+// DiffWith returns a unified diff between the file and the given content, as
+// if the file were to be replaced with it.
+func (fs *Filesystem) DiffWith(p string, content []byte, context int) (string, error) {
+	if len(content) > MaxDiffSize {
+		return "", errors.WithStack(ErrDiffTooLarge)
+	}
+	a, err := fs.readForDiff(p)
+	if err != nil {
+		return "", err
+	}
+	return UnifiedDiff(a, content, diffName(p), diffName(p), context), nil
+}
+
+// Patch applies a unified diff to the file at the given path. The patched file
+// is written alongside the original and then moved over it, so the file is
+// either entirely patched or left untouched if anything goes wrong, including
+// any hunk of the patch not matching the file.
+func (fs *Filesystem) Patch(p string, patch []byte) error {
+	st, err := fs.unixFS.Stat(p)
+	if err != nil {
+		return err
+	}
+	if st.IsDir() {
+		return errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: p})
+	}
+	original, err := fs.readForDiff(p)
+	if err != nil {
+		return err
+	}
+	patched, err := ApplyPatch(original, patch)
+	if err != nil {
+		return err
+	}
+
+	tmp := path.Join(path.Dir(path.Clean("/"+filepath.ToSlash(p))), ".wings-patch-"+uuid.NewString())
+	if err := fs.Write(tmp, bytes.NewReader(patched), int64(len(patched)), st.Mode().Perm()); err != nil {
+		_ = fs.unixFS.Remove(tmp)
+		return err
+	}
+	if err := fs.unixFS.Replace(tmp, p); err != nil {
+		_ = fs.unixFS.Remove(tmp)
+		return err
+	}
+	// The original file no longer exists, so it is no longer counted.
+	fs.unixFS.Add(-st.Size())
+	fs.unixFS.AddFiles(-1)
+	return fs.Chown(p)
+}
+
+func (fs *Filesystem) readForDiff(p string) ([]byte, error) {
+	f, st, err := fs.File(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if st.Size() > MaxDiffSize {
+		return nil, errors.WithStack(ErrDiffTooLarge)
+	}
+	return io.ReadAll(io.LimitReader(f, MaxDiffSize))
+}
+
+func diffName(p string) string {
+	return path.Clean("/" + filepath.ToSlash(p))
+}
+
+// UnifiedDiff returns a unified diff between a and b, with the given number of
+// lines of context around each change. A line missing a newline at the end of
+// either is marked the same way as diff(1) does, so that the result can be
+// applied by ApplyPatch or patch(1).
+func UnifiedDiff(a []byte, b []byte, fromName string, toName string, context int) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	al, bl := splitLines(a), splitLines(b)
+	m := difflib.NewMatcherWithJunk(al, bl, false, nil)
+
+	var w strings.Builder
+	fmt.Fprintf(&w, "--- %s\n+++ %s\n", fromName, toName)
+	for _, group := range m.GetGroupedOpCodes(context) {
+		first, last := group[0], group[len(group)-1]
+		fmt.Fprintf(&w, "@@ -%s +%s @@\n", formatRange(first.I1, last.I2), formatRange(first.J1, last.J2))
+		for _, c := range group {
+			switch c.Tag {
+			case 'e':
+				writeLines(&w, ' ', al[c.I1:c.I2])
+			case 'r':
+				writeLines(&w, '-', al[c.I1:c.I2])
+				writeLines(&w, '+', bl[c.J1:c.J2])
+			case 'd':
+				writeLines(&w, '-', al[c.I1:c.I2])
+			case 'i':
+				writeLines(&w, '+', bl[c.J1:c.J2])
+			}
+		}
+	}
+	return w.String()
+}
+
+// formatRange formats a range of lines for a hunk header, using the same rules
+// as diff(1) where an empty range refers to the line before it.
+func formatRange(start, stop int) string {
+	begin, length := start+1, stop-start
+	if length == 1 {
+		return strconv.Itoa(begin)
+	}
+	if length == 0 {
+		begin--
+	}
+	return fmt.Sprintf("%d,%d", begin, length)
+}
+
+func writeLines(w *strings.Builder, prefix byte, lines []string) {
+	for _, l := range lines {
+		w.WriteByte(prefix)
+		w.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			w.WriteString("\n" + noNewlineMarker)
+		}
+	}
+}
+
+// splitLines splits b into lines, keeping the newline at the end of each.
+func splitLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		lines = append(lines, string(b[:i]))
+		b = b[i:]
+	}
+	return lines
+}
+
+type hunk struct {
+	// start is the index of the first line of the hunk in the original.
+	start int
+	old   []string
+	new   []string
+}
+
+// parsePatch parses the hunks of a unified diff for a single file, anything
+// before the first hunk such as the file names is ignored.
+func parsePatch(patch []byte) ([]hunk, error) {
+	var hunks []hunk
+	var h *hunk
+	var oldLeft, newLeft int
+	// kind is the type of the previous line in the hunk, which a missing newline
+	// marker applies to.
+	var kind byte
+	for _, l := range splitLines(patch) {
+		if strings.HasPrefix(l, "\\") {
+			if h != nil && (kind == ' ' || kind == '-') {
+				h.old[len(h.old)-1] = strings.TrimSuffix(h.old[len(h.old)-1], "\n")
+			}
+			if h != nil && (kind == ' ' || kind == '+') {
+				h.new[len(h.new)-1] = strings.TrimSuffix(h.new[len(h.new)-1], "\n")
+			}
+			continue
+		}
+		if h == nil || (oldLeft == 0 && newLeft == 0) {
+			if h != nil && strings.HasPrefix(l, "--- ") {
+				return nil, errors.Wrap(ErrInvalidPatch, "patch contains changes to more than one file")
+			}
+			if !strings.HasPrefix(l, "@@ ") {
+				continue
+			}
+			oldStart, oldLen, _, newLen, err := parseHunkHeader(l)
+			if err != nil {
+				return nil, err
+			}
+			start := oldStart - 1
+			if oldLen == 0 {
+				// An empty range refers to the line before the one being added.
+				start = oldStart
+			}
+			hunks = append(hunks, hunk{start: start})
+			h = &hunks[len(hunks)-1]
+			oldLeft, newLeft, kind = oldLen, newLen, 0
+			continue
+		}
+		// Some editors remove the trailing space from empty context lines.
+		if l == "\n" {
+			l = " \n"
+		}
+		kind = l[0]
+		switch kind {
+		case ' ':
+			h.old = append(h.old, l[1:])
+			h.new = append(h.new, l[1:])
+			oldLeft--
+			newLeft--
+		case '-':
+			h.old = append(h.old, l[1:])
+			oldLeft--
+		case '+':
+			h.new = append(h.new, l[1:])
+			newLeft--
+		default:
+			return nil, errors.Wrapf(ErrInvalidPatch, "unexpected line in hunk: %q", strings.TrimSpace(l))
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, errors.Wrap(ErrInvalidPatch, "hunk is longer than its header")
+		}
+	}
+	if len(hunks) == 0 {
+		return nil, errors.Wrap(ErrInvalidPatch, "no hunks found")
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return nil, errors.Wrap(ErrInvalidPatch, "hunk is shorter than its header")
+	}
+	return hunks, nil
+}
+
+// parseHunkHeader parses a hunk header such as "@@ -1,3 +1,4 @@".
+func parseHunkHeader(l string) (oldStart, oldLen, newStart, newLen int, err error) {
+	fields := strings.Fields(l)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0, 0, 0, errors.Wrapf(ErrInvalidPatch, "invalid hunk header: %q", strings.TrimSpace(l))
+	}
+	if oldStart, oldLen, err = parseRange(fields[1][1:]); err != nil {
+		return
+	}
+	newStart, newLen, err = parseRange(fields[2][1:])
+	return
+}
+
+func parseRange(s string) (start, length int, err error) {
+	length = 1
+	before, after, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(before); err != nil || start < 0 {
+		return 0, 0, errors.Wrapf(ErrInvalidPatch, "invalid hunk range: %q", s)
+	}
+	if ok {
+		if length, err = strconv.Atoi(after); err != nil || length < 0 {
+			return 0, 0, errors.Wrapf(ErrInvalidPatch, "invalid hunk range: %q", s)
+		}
+	}
+	return start, length, nil
+}
+
+// ApplyPatch applies a unified diff to the original content and returns the
+// result. Each hunk must match the original exactly, although it may be found
+// a number of lines away from where the patch says it should be in case the
+// file has had lines added or removed elsewhere. ErrPatchConflict is returned
+// if any hunk cannot be found.
+func ApplyPatch(original []byte, patch []byte) ([]byte, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(original)
+	var out []string
+	// pos is the index of the first line in the original that has not been
+	// copied to the output yet, and offset is how far the previous hunk was
+	// found from where it was expected.
+	var pos, offset int
+	for i, h := range hunks {
+		at, ok := findHunk(lines, h.old, h.start+offset, pos)
+		if !ok {
+			return nil, errors.Wrapf(ErrPatchConflict, "hunk %d does not match the file", i+1)
+		}
+		offset = at - h.start
+		out = append(out, lines[pos:at]...)
+		out = append(out, h.new...)
+		pos = at + len(h.old)
+	}
+	out = append(out, lines[pos:]...)
+
+	var b bytes.Buffer
+	for i, l := range out {
+		// Any line that ends up in the middle of the file needs a newline, even
+		// if it was the last line of the original.
+		if i < len(out)-1 && !strings.HasSuffix(l, "\n") {
+			return nil, errors.Wrap(ErrPatchConflict, "patch leaves a line without a newline in the middle of the file")
+		}
+		b.WriteString(l)
+	}
+	return b.Bytes(), nil
+}
+
+// findHunk returns the index of the lines matching old in lines that is
+// closest to want, without starting before min.
+func findHunk(lines []string, old []string, want int, min int) (int, bool) {
+	for d := 0; ; d++ {
+		before, after := want-d, want+d
+		if before < min && after+len(old) > len(lines) {
+			return 0, false
+		}
+		if after >= min && after+len(old) <= len(lines) && linesEqual(lines[after:after+len(old)], old) {
+			return after, true
+		}
+		if d > 0 && before >= min && before+len(old) <= len(lines) && linesEqual(lines[before:before+len(old)], old) {
+			return before, true
+		}
+	}
+}
+
+func linesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,116 @@
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("UnifiedDiff", func() {
+		g.It("returns nothing for identical content", func() {
+			g.Assert(UnifiedDiff([]byte("a\nb\n"), []byte("a\nb\n"), "a", "b", 3)).Equal("")
+		})
+
+		g.It("formats hunks the same way as diff(1)", func() {
+			d := UnifiedDiff([]byte("a\nb\nc\n"), []byte("a\nB\nc\nd"), "/a.txt", "/b.txt", 1)
+			g.Assert(d).Equal("--- /a.txt\n+++ /b.txt\n@@ -1,3 +1,4 @@\n a\n-b\n+B\n c\n+d\n\\ No newline at end of file\n")
+		})
+	})
+
+	g.Describe("ApplyPatch", func() {
+		g.It("applies a diff to the content it was made from", func() {
+			for _, c := range [][2]string{
+				{"a\nb\nc\n", "a\nB\nc\nd"},
+				{"a\nb", "a\nb\n"},
+				{"", "new file\n"},
+				{"one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\n", "zero\none\ntwo\nthree\nfive\nsix\nseven\n8\n"},
+			} {
+				d := UnifiedDiff([]byte(c[0]), []byte(c[1]), "a", "b", 1)
+				b, err := ApplyPatch([]byte(c[0]), []byte(d))
+				g.Assert(err).IsNil()
+				g.Assert(string(b)).Equal(c[1])
+			}
+		})
+
+		g.It("finds hunks that have moved", func() {
+			d := UnifiedDiff([]byte("a\nb\nc\n"), []byte("a\nB\nc\n"), "a", "b", 1)
+			b, err := ApplyPatch([]byte("x\ny\na\nb\nc\n"), []byte(d))
+			g.Assert(err).IsNil()
+			g.Assert(string(b)).Equal("x\ny\na\nB\nc\n")
+		})
+
+		g.It("returns a conflict if a hunk does not match", func() {
+			d := UnifiedDiff([]byte("a\nb\nc\n"), []byte("a\nB\nc\n"), "a", "b", 1)
+			_, err := ApplyPatch([]byte("a\nx\nc\n"), []byte(d))
+			g.Assert(errors.Is(err, ErrPatchConflict)).IsTrue()
+		})
+
+		g.It("rejects invalid patches", func() {
+			_, err := ApplyPatch([]byte("a\n"), []byte("not a patch\n"))
+			g.Assert(errors.Is(err, ErrInvalidPatch)).IsTrue()
+
+			_, err = ApplyPatch([]byte("a\n"), []byte("@@ -1,2 +1,2 @@\n-a\n+b\n"))
+			g.Assert(errors.Is(err, ErrInvalidPatch)).IsTrue()
+		})
+	})
+}
+
+func TestFilesystem_Patch(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("Patch", func() {
+		g.BeforeEach(func() {
+			_ = rfs.CreateServerFile("config.yml", []byte("a: 1\nb: 2\n"))
+			_ = rfs.CreateServerFile("other.yml", []byte("a: 1\nb: 3\n"))
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("diffs two files", func() {
+			d, err := fs.Diff("config.yml", "/other.yml", 3)
+			g.Assert(err).IsNil()
+			g.Assert(d).Equal("--- /config.yml\n+++ /other.yml\n@@ -1,2 +1,2 @@\n a: 1\n-b: 2\n+b: 3\n")
+		})
+
+		g.It("patches a file in place", func() {
+			g.Assert(os.Chmod(filepath.Join(rfs.root, "server/config.yml"), 0o600)).IsNil()
+			d, err := fs.DiffWith("config.yml", []byte("a: 1\nb: 4\n"), 3)
+			g.Assert(err).IsNil()
+
+			err = fs.Patch("config.yml", []byte(d))
+			g.Assert(err).IsNil()
+
+			f, st, err := fs.File("config.yml")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("a: 1\nb: 4\n")
+			g.Assert(st.Mode().Perm()).Equal(os.FileMode(0o600))
+
+			entries, err := os.ReadDir(filepath.Join(rfs.root, "server"))
+			g.Assert(err).IsNil()
+			g.Assert(len(entries)).Equal(2)
+		})
+
+		g.It("leaves the file untouched on a conflict", func() {
+			d, err := fs.Diff("other.yml", "config.yml", 3)
+			g.Assert(err).IsNil()
+
+			err = fs.Patch("config.yml", []byte(d))
+			g.Assert(errors.Is(err, ErrPatchConflict)).IsTrue()
+
+			f, _, err := fs.File("config.yml")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("a: 1\nb: 2\n")
+		})
+	})
+}
@@ -51,6 +51,32 @@ func (fs *Filesystem) SetDiskLimit(i int64) {
 	fs.unixFS.SetLimit(i)
 }
 
+// MaxFiles returns the maximum number of files and directories that this
+// Filesystem instance is allowed to contain. A value of zero is unlimited.
+func (fs *Filesystem) MaxFiles() int64 {
+	return fs.unixFS.FileLimit()
+}
+
+// SetFileLimit sets the file count limit for this Filesystem instance.
+func (fs *Filesystem) SetFileLimit(i int64) {
+	fs.unixFS.SetFileLimit(i)
+}
+
+// CachedFiles returns the cached number of files and directories within the
+// filesystem. Like CachedUsage this should not be relied on for critical checks.
+func (fs *Filesystem) CachedFiles() int64 {
+	return fs.unixFS.Files()
+}
+
+// HasSpaceForFiles returns an error if creating the given number of files or
+// directories would put the filesystem over its file count limit.
+func (fs *Filesystem) HasSpaceForFiles(n int64) error {
+	if !fs.unixFS.CanFitFiles(n) {
+		return newFilesystemError(ErrCodeFileLimit, nil)
+	}
+	return nil
+}
+
 // The same concept as HasSpaceAvailable however this will return an error if there is
 // no space, rather than a boolean value.
 func (fs *Filesystem) HasSpaceErr(allowStaleValue bool) error {
@@ -110,6 +136,26 @@ func (fs *Filesystem) DiskUsage(allowStaleValue bool) (int64, error) {
 		return 0, nil
 	}
 
+	// If a tracker is keeping the usage up to date there is no need to walk the
+	// directory at all, only fall back to doing so if the tracker is unable to
+	// provide a value.
+	if t := fs.UsageTracker(); t != nil {
+		size, err := t.Usage()
+		if err == nil {
+			if fc, ok := t.(FileCounter); ok {
+				if files, err := fc.Files(); err == nil {
+					fs.unixFS.SetFiles(files)
+				}
+			}
+			fs.unixFS.SetUsage(size)
+			fs.lastLookupTime.Set(time.Now())
+			return size, nil
+		}
+		if !errors.Is(err, ErrUsageTrackerNotReady) {
+			log.WithField("root", fs.Path()).WithField("error", err).Warn("failed to get disk usage from tracker, falling back to walking the directory")
+		}
+	}
+
 	if !fs.lastLookupTime.Get().After(time.Now().Add(time.Second * fs.diskCheckInterval * -1)) {
 		// If we are now allowing a stale response go ahead  and perform the lookup and return the fresh
 		// value. This is a blocking operation to the calling process.
@@ -148,7 +194,7 @@ func (fs *Filesystem) updateCachedDiskUsage() (int64, error) {
 	// will have effectively no impact), or there is nothing in the cache, in which case we need to
 	// grab the size of their data directory. This is a taxing operation, so we want to store it in
 	// the cache once we've gotten it.
-	size, err := fs.DirectorySize("/")
+	size, files, err := fs.directoryUsage("/")
 
 	// Always cache the size, even if there is an error. We want to always return that value
 	// so that we don't cause an endless loop of determining the disk size if there is a temporary
@@ -156,28 +202,41 @@ func (fs *Filesystem) updateCachedDiskUsage() (int64, error) {
 	fs.lastLookupTime.Set(time.Now())
 
 	fs.unixFS.SetUsage(size)
+	fs.unixFS.SetFiles(files)
 
 	return size, err
 }
 
 // DirectorySize calculates the size of a directory and its descendants.
 func (fs *Filesystem) DirectorySize(root string) (int64, error) {
+	size, _, err := fs.directoryUsage(root)
+	return size, err
+}
+
+// directoryUsage calculates the size of a directory and its descendants, along
+// with the number of files and directories beneath it.
+func (fs *Filesystem) directoryUsage(root string) (int64, int64, error) {
 	dirfd, name, closeFd, err := fs.unixFS.SafePath(root)
 	defer closeFd()
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	var hardLinks []uint64
 
 	var size atomic.Int64
-	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, _ string, d ufs.DirEntry, err error) error {
+	var files atomic.Int64
+	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
 		if err != nil {
 			return errors.Wrap(err, "walkdirat err")
 		}
 
-		// Only calculate the size of regular files.
+		// Only calculate the size of regular files, but count everything other
+		// than the directory being walked towards the number of files.
 		if !d.Type().IsRegular() {
+			if relative != "." {
+				files.Add(1)
+			}
 			return nil
 		}
 
@@ -198,12 +257,57 @@ func (fs *Filesystem) DirectorySize(root string) (int64, error) {
 		}
 
 		size.Add(info.Size())
+		files.Add(1)
 		return nil
 	})
-	return size.Load(), errors.WrapIf(err, "server/filesystem: directorysize: failed to walk directory")
+	return size.Load(), files.Load(), errors.WrapIf(err, "server/filesystem: directorysize: failed to walk directory")
+}
+
+// SetDiskGrace allows writes to exceed the disk space limit by up to the given
+// number of bytes until the given time. Passing a zero time ends any current
+// grace period.
+func (fs *Filesystem) SetDiskGrace(until time.Time, limit int64) {
+	if until.IsZero() {
+		fs.graceUntil.Store(0)
+		fs.graceLimit.Store(0)
+		return
+	}
+	fs.graceLimit.Store(limit)
+	fs.graceUntil.Store(until.UnixNano())
+}
+
+// InDiskGrace returns true if the filesystem is currently in a grace period
+// where writes are allowed to exceed the disk space limit.
+func (fs *Filesystem) InDiskGrace() bool {
+	until := fs.graceUntil.Load()
+	return until != 0 && time.Now().UnixNano() < until
+}
+
+// CanFitInGrace returns true if the filesystem is in a grace period and the
+// given number of bytes can be written without exceeding the disk space limit
+// by more than the grace period allows.
+func (fs *Filesystem) CanFitInGrace(size int64) bool {
+	if !fs.InDiskGrace() {
+		return false
+	}
+	limit := fs.MaxDisk()
+	if limit <= 0 {
+		return fs.unixFS.CanFit(size)
+	}
+	usage := fs.unixFS.Usage()
+	if usage == -1 || size < 0 {
+		return true
+	}
+	return usage+size <= limit+fs.graceLimit.Load()
 }
 
 func (fs *Filesystem) HasSpaceFor(size int64) error {
+	if fs.InDiskGrace() {
+		if !fs.CanFitInGrace(size) {
+			return newFilesystemError(ErrCodeDiskSpace, nil)
+		}
+		return nil
+	}
 	if !fs.unixFS.CanFit(size) {
 		return newFilesystemError(ErrCodeDiskSpace, nil)
 	}
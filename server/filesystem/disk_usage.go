@@ -0,0 +1,237 @@
+//go:build unix
+
+package filesystem
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// The maximum number of usage trees to keep cached for a single server at once.
+const maxCachedUsageTrees = 8
+
+// UsageNode is a single file or directory in a disk usage breakdown returned
+// by UsageTree.
+type UsageNode struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Directory bool   `json:"directory"`
+	// Size is the total size of the file, or every file beneath the directory.
+	Size int64 `json:"size"`
+	// Files is the total number of regular files beneath the directory.
+	Files int64 `json:"files"`
+	// Children are the largest entries within the directory, sorted by size.
+	Children []*UsageNode `json:"children,omitempty"`
+	// Omitted and OmittedSize hold the number and combined size of the entries
+	// dropped from Children, either because they fell outside the top-N limit
+	// or because they were deeper than the requested depth.
+	Omitted     int   `json:"omitted,omitempty"`
+	OmittedSize int64 `json:"omitted_size,omitempty"`
+}
+
+type usageTreeKey struct {
+	root  string
+	depth int
+	limit int
+}
+
+type usageTreeEntry struct {
+	node *UsageNode
+	time time.Time
+}
+
+// usageTreeCache stores recently computed usage trees, this lives alongside the
+// usageLookupTime so that the trees expire at the same rate as the overall
+// disk usage value does.
+type usageTreeCache struct {
+	sync.Mutex
+	entries map[usageTreeKey]usageTreeEntry
+}
+
+func (c *usageTreeCache) get(key usageTreeKey, ttl time.Duration) (*UsageNode, bool) {
+	c.Lock()
+	defer c.Unlock()
+	v, ok := c.entries[key]
+	if !ok || time.Since(v.time) > ttl {
+		return nil, false
+	}
+	return v.node, true
+}
+
+func (c *usageTreeCache) set(key usageTreeKey, node *UsageNode, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[usageTreeKey]usageTreeEntry)
+	}
+	if len(c.entries) >= maxCachedUsageTrees {
+		for k, v := range c.entries {
+			if time.Since(v.time) > ttl {
+				delete(c.entries, k)
+			}
+		}
+		// Every entry is still fresh, just start over rather than tracking
+		// which entry is the oldest.
+		if len(c.entries) >= maxCachedUsageTrees {
+			clear(c.entries)
+		}
+	}
+	c.entries[key] = usageTreeEntry{node: node, time: time.Now()}
+}
+
+// UsageTree returns a breakdown of the disk space used beneath the given root
+// directory. Directories are expanded up to the given depth, and only the
+// largest limit children of each directory are returned. A limit of zero or
+// less returns every child.
+//
+// Results are cached for the disk check interval of the server, and computing
+// the tree for the root of the server will also refresh the cached disk usage.
+func (fs *Filesystem) UsageTree(root string, depth int, limit int) (*UsageNode, error) {
+	root = path.Clean("/" + filepath.ToSlash(root))
+	if err := fs.IsIgnored(root); err != nil {
+		return nil, err
+	}
+
+	ttl := time.Second * fs.diskCheckInterval
+	key := usageTreeKey{root: root, depth: depth, limit: limit}
+	if n, ok := fs.usageTrees.get(key, ttl); ok {
+		return n, nil
+	}
+
+	n, err := fs.usageTree(root, depth)
+	if err != nil {
+		return nil, err
+	}
+	n.prune(limit)
+
+	if root == "/" {
+		fs.mu.Lock()
+		fs.unixFS.SetUsage(n.Size)
+		fs.lastLookupTime.Set(time.Now())
+		fs.mu.Unlock()
+	}
+	if ttl > 0 {
+		fs.usageTrees.set(key, n, ttl)
+	}
+	return n, nil
+}
+
+// usageTree walks the root directory and builds the full tree up to the given
+// depth. Anything deeper than the depth is only counted towards the totals of
+// its closest ancestor in the tree.
+func (fs *Filesystem) usageTree(root string, depth int) (*UsageNode, error) {
+	dirfd, name, closeFd, err := fs.unixFS.SafePath(root)
+	defer closeFd()
+	if err != nil {
+		return nil, err
+	}
+
+	tree := &UsageNode{Name: path.Base(root), Path: root, Directory: true}
+	nodes := map[string]*UsageNode{".": tree}
+	hardLinks := make(map[uint64]struct{})
+	err = fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			return errors.Wrap(err, "walkdirat err")
+		}
+		if relative == "." {
+			if !d.IsDir() {
+				return ufs.ErrNotDirectory
+			}
+			return nil
+		}
+
+		parent := path.Dir(relative)
+		level := strings.Count(relative, "/") + 1
+		if d.IsDir() {
+			if level <= depth {
+				n := &UsageNode{Name: d.Name(), Path: path.Join(root, relative), Directory: true}
+				nodes[relative] = n
+				nodes[parent].Children = append(nodes[parent].Children, n)
+			} else if p, ok := nodes[parent]; ok {
+				p.Omitted++
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := fs.unixFS.Lstatat(dirfd, name)
+		if err != nil {
+			return errors.Wrap(err, "lstatat err")
+		}
+		size := info.Size()
+		if st, ok := info.Sys().(*unix.Stat_t); ok && st.Nlink > 1 {
+			// Don't count the size of hard links more than once.
+			if _, ok := hardLinks[st.Ino]; ok {
+				size = 0
+			} else {
+				hardLinks[st.Ino] = struct{}{}
+			}
+		}
+
+		if level <= depth {
+			nodes[parent].Children = append(nodes[parent].Children, &UsageNode{
+				Name:  d.Name(),
+				Path:  path.Join(root, relative),
+				Size:  size,
+				Files: 1,
+			})
+		} else if p, ok := nodes[parent]; ok {
+			p.Omitted++
+		}
+		for p := parent; ; p = path.Dir(p) {
+			if n, ok := nodes[p]; ok {
+				n.Size += size
+				n.Files++
+			}
+			if p == "." {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapIf(err, "server/filesystem: usagetree: failed to walk directory")
+	}
+	return tree, nil
+}
+
+// prune sorts the children of the node by size and removes all but the
+// largest limit of them, recording what was removed in the omitted totals.
+func (n *UsageNode) prune(limit int) {
+	if !n.Directory {
+		return
+	}
+	sort.SliceStable(n.Children, func(i, j int) bool {
+		return n.Children[i].Size > n.Children[j].Size
+	})
+
+	// Anything omitted for being too deep is not part of Children, so work out
+	// its size from the difference before dropping anything else.
+	var childSize int64
+	for _, c := range n.Children {
+		childSize += c.Size
+	}
+	n.OmittedSize = n.Size - childSize
+
+	if limit > 0 && len(n.Children) > limit {
+		for _, c := range n.Children[limit:] {
+			n.Omitted++
+			n.OmittedSize += c.Size
+		}
+		n.Children = n.Children[:limit]
+	}
+	for _, c := range n.Children {
+		c.prune(limit)
+	}
+}
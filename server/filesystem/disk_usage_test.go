@@ -0,0 +1,72 @@
+package filesystem
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_UsageTree(t *testing.T) {
+	g := Goblin(t)
+	fs, _ := NewFs()
+
+	g.Describe("UsageTree", func() {
+		g.BeforeEach(func() {
+			write := func(p string, size int) {
+				r := strings.NewReader(strings.Repeat("a", size))
+				g.Assert(fs.Write(p, r, r.Size(), 0o644)).IsNil()
+			}
+			write("world/region/r.0.0.mca", 400)
+			write("world/region/r.0.1.mca", 300)
+			write("world/level.dat", 50)
+			write("plugins/a.jar", 100)
+			write("plugins/b.jar", 20)
+			write("server.properties", 10)
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("totals sizes and file counts for the tree", func() {
+			n, err := fs.UsageTree("/", 1, 0)
+			g.Assert(err).IsNil()
+			g.Assert(n.Size).Equal(int64(880))
+			g.Assert(n.Files).Equal(int64(6))
+			g.Assert(len(n.Children)).Equal(3)
+
+			g.Assert(n.Children[0].Name).Equal("world")
+			g.Assert(n.Children[0].Size).Equal(int64(750))
+			g.Assert(n.Children[0].Files).Equal(int64(3))
+			// The region directory is deeper than requested, so it is only
+			// reflected in the totals of the world directory.
+			g.Assert(len(n.Children[0].Children)).Equal(0)
+			g.Assert(n.Children[0].Omitted).Equal(2)
+			g.Assert(n.Children[0].OmittedSize).Equal(int64(750))
+
+			g.Assert(fs.CachedUsage()).Equal(int64(880))
+		})
+
+		g.It("limits the number of children returned", func() {
+			n, err := fs.UsageTree("/", 2, 1)
+			g.Assert(err).IsNil()
+			g.Assert(len(n.Children)).Equal(1)
+			g.Assert(n.Omitted).Equal(2)
+			g.Assert(n.OmittedSize).Equal(int64(130))
+
+			world := n.Children[0]
+			g.Assert(len(world.Children)).Equal(1)
+			g.Assert(world.Children[0].Name).Equal("region")
+			g.Assert(world.Children[0].Size).Equal(int64(700))
+		})
+
+		g.It("returns a subtree", func() {
+			n, err := fs.UsageTree("/plugins", 1, 0)
+			g.Assert(err).IsNil()
+			g.Assert(n.Path).Equal("/plugins")
+			g.Assert(n.Size).Equal(int64(120))
+			g.Assert(n.Children[0].Name).Equal("a.jar")
+		})
+	})
+}
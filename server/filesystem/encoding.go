@@ -0,0 +1,287 @@
+package filesystem
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"emperror.dev/errors"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	// EncodingLatin1 is used for text which is not valid UTF-8. Windows-1252 is
+	// a superset of ISO-8859-1 which is what most "Latin-1" files really are.
+	EncodingLatin1 = "windows-1252"
+	// EncodingBinary is reported for files which do not appear to be text, these
+	// are never converted.
+	EncodingBinary = "binary"
+
+	LineEndingLF   = "lf"
+	LineEndingCRLF = "crlf"
+)
+
+// The number of bytes read from the start of a file when detecting the format
+// of the text within it.
+const textFormatSampleSize = 64 * 1024
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+var (
+	// ErrUnknownEncoding is returned when an encoding is requested by a name
+	// that is not recognized.
+	ErrUnknownEncoding = errors.Sentinel("filesystem: unknown text encoding")
+	// ErrUnknownLineEnding is returned when a line ending other than "lf" or
+	// "crlf" is requested.
+	ErrUnknownLineEnding = errors.Sentinel("filesystem: unknown line ending")
+	// ErrUnencodableText is returned when text contains characters that cannot
+	// be represented in the encoding it is being converted to.
+	ErrUnencodableText = errors.Sentinel("filesystem: text cannot be represented in the requested encoding")
+)
+
+// TextFormat is the encoding and line endings used by a text file.
+type TextFormat struct {
+	Encoding string `json:"encoding"`
+	// LineEnding is empty if the line endings are not known, such as for text
+	// with only a single line, in which case they are never changed.
+	LineEnding string `json:"line_ending"`
+	// BOM is true if the text starts with a byte order mark.
+	BOM bool `json:"bom"`
+}
+
+// DetectTextFormat determines the format of the given text. A byte order mark
+// is always trusted, otherwise UTF-16 is detected by the pattern of null bytes
+// it contains, and anything else that is not valid UTF-8 is assumed to be
+// Latin-1. Text containing null bytes that is not UTF-16 is reported as binary.
+//
+// Only part of a file may be given, in which case a character cut off at the
+// end of it is ignored.
+func DetectTextFormat(b []byte) TextFormat {
+	var f TextFormat
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		f = TextFormat{Encoding: EncodingUTF8, BOM: true}
+	case bytes.HasPrefix(b, bomUTF16LE):
+		f = TextFormat{Encoding: EncodingUTF16LE, BOM: true}
+	case bytes.HasPrefix(b, bomUTF16BE):
+		f = TextFormat{Encoding: EncodingUTF16BE, BOM: true}
+	default:
+		f.Encoding = detectEncoding(b)
+	}
+	if f.Encoding == EncodingBinary {
+		return f
+	}
+	if text, err := f.decode(b); err == nil {
+		lf := bytes.Count(text, []byte("\n"))
+		crlf := bytes.Count(text, []byte("\r\n"))
+		if crlf > lf-crlf {
+			f.LineEnding = LineEndingCRLF
+		} else if lf > 0 {
+			f.LineEnding = LineEndingLF
+		}
+	}
+	return f
+}
+
+func detectEncoding(b []byte) string {
+	// Text that is mostly ASCII encoded as UTF-16 will have a null byte in every
+	// other position, which is never the case for any other text.
+	var even, odd int
+	for i := 0; i+1 < len(b); i += 2 {
+		if b[i] == 0 {
+			even++
+		}
+		if b[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := len(b) / 2
+	if pairs > 0 {
+		if odd > pairs/4 && even == 0 {
+			return EncodingUTF16LE
+		}
+		if even > pairs/4 && odd == 0 {
+			return EncodingUTF16BE
+		}
+	}
+	if bytes.IndexByte(b, 0) != -1 {
+		return EncodingBinary
+	}
+	// Trim a character which may have been cut off at the end of the sample.
+	for i := 0; i < utf8.UTFMax-1 && len(b) > 0; i++ {
+		if r, _ := utf8.DecodeLastRune(b); r != utf8.RuneError {
+			break
+		}
+		b = b[:len(b)-1]
+	}
+	if utf8.Valid(b) {
+		return EncodingUTF8
+	}
+	return EncodingLatin1
+}
+
+// lookupEncoding returns the encoding with the given name, which may be any of
+// the names or labels defined by the WHATWG encoding standard, along with the
+// canonical name of the encoding.
+func lookupEncoding(name string) (encoding.Encoding, string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case EncodingUTF8:
+		return unicode.UTF8, name, nil
+	case EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM), name, nil
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM), name, nil
+	}
+	e, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, "", errors.WithStack(ErrUnknownEncoding)
+	}
+	if n, err := htmlindex.Name(e); err == nil {
+		name = n
+	}
+	return e, name, nil
+}
+
+// NewTextFormat returns the format for the given encoding and line ending,
+// returning an error if either is not known. The canonical name of the
+// encoding is used in the returned format.
+func NewTextFormat(name string, lineEnding string) (TextFormat, error) {
+	f := TextFormat{LineEnding: strings.ToLower(lineEnding)}
+	if f.LineEnding != "" && f.LineEnding != LineEndingLF && f.LineEnding != LineEndingCRLF {
+		return f, errors.WithStack(ErrUnknownLineEnding)
+	}
+	if strings.EqualFold(name, EncodingBinary) {
+		f.Encoding = EncodingBinary
+		return f, nil
+	}
+	_, n, err := lookupEncoding(name)
+	if err != nil {
+		return f, err
+	}
+	f.Encoding = n
+	return f, nil
+}
+
+// Decode converts text in this format to UTF-8, removing any byte order mark.
+// The line endings are left as they are. Binary content is returned unchanged.
+func (f TextFormat) Decode(b []byte) ([]byte, error) {
+	if f.BOM {
+		for _, bom := range [][]byte{bomUTF8, bomUTF16LE, bomUTF16BE} {
+			if bytes.HasPrefix(b, bom) {
+				b = b[len(bom):]
+				break
+			}
+		}
+	}
+	return f.decode(b)
+}
+
+func (f TextFormat) decode(b []byte) ([]byte, error) {
+	if f.Encoding == EncodingBinary || f.Encoding == EncodingUTF8 {
+		return b, nil
+	}
+	e, _, err := lookupEncoding(f.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	return e.NewDecoder().Bytes(b)
+}
+
+// Encode converts UTF-8 text to this format, replacing the line endings and
+// adding a byte order mark if the format has one. Binary content is returned
+// unchanged.
+func (f TextFormat) Encode(b []byte) ([]byte, error) {
+	if f.Encoding == EncodingBinary {
+		return b, nil
+	}
+	switch f.LineEnding {
+	case LineEndingLF:
+		b = bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n"))
+	case LineEndingCRLF:
+		b = bytes.ReplaceAll(bytes.ReplaceAll(b, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	}
+	if f.Encoding != EncodingUTF8 {
+		e, _, err := lookupEncoding(f.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		if b, err = e.NewEncoder().Bytes(b); err != nil {
+			return nil, errors.WithStack(ErrUnencodableText)
+		}
+	}
+	if f.BOM {
+		switch f.Encoding {
+		case EncodingUTF8:
+			b = append(append([]byte{}, bomUTF8...), b...)
+		case EncodingUTF16LE:
+			b = append(append([]byte{}, bomUTF16LE...), b...)
+		case EncodingUTF16BE:
+			b = append(append([]byte{}, bomUTF16BE...), b...)
+		}
+	}
+	return b, nil
+}
+
+// TextFormat detects the format of the text within the file at the given path
+// using the start of the file.
+func (fs *Filesystem) TextFormat(p string) (TextFormat, error) {
+	f, err := fs.unixFS.Open(p)
+	if err != nil {
+		return TextFormat{}, err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(io.LimitReader(f, textFormatSampleSize))
+	if err != nil {
+		return TextFormat{}, err
+	}
+	return DetectTextFormat(b), nil
+}
+
+// WriteText writes UTF-8 text to the file at the given path, converting it to
+// the encoding and line endings of the existing file so that editing it does not
+// change them. Either may be overridden by passing a non-empty encoding or line
+// ending. Content which is not valid UTF-8 is written as it is unless a format
+// is explicitly requested.
+func (fs *Filesystem) WriteText(p string, text []byte, encoding string, lineEnding string, mode ufs.FileMode) error {
+	// Any problem reading the existing file, such as it being a directory, is
+	// left to be reported when writing to it.
+	format, err := fs.TextFormat(p)
+	if err != nil {
+		format = TextFormat{Encoding: EncodingUTF8}
+	}
+	if encoding != "" || lineEnding != "" {
+		f, err := NewTextFormat(pick(encoding, format.Encoding), pick(lineEnding, format.LineEnding))
+		if err != nil {
+			return err
+		}
+		// Only keep the byte order mark when the encoding has not changed.
+		f.BOM = format.BOM && f.Encoding == format.Encoding
+		format = f
+	} else if !utf8.Valid(text) {
+		format = TextFormat{Encoding: EncodingBinary}
+	}
+	if text, err = format.Encode(text); err != nil {
+		return err
+	}
+	return fs.Write(p, bytes.NewReader(text), int64(len(text)), mode)
+}
+
+func pick(v string, fallback string) string {
+	if v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,128 @@
+package filesystem
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/franela/goblin"
+)
+
+func TestDetectTextFormat(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("DetectTextFormat", func() {
+		g.It("detects UTF-8 text", func() {
+			f := DetectTextFormat([]byte("motd=Hello wörld\nmax-players=20\n"))
+			g.Assert(f).Equal(TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingLF})
+		})
+
+		g.It("detects byte order marks", func() {
+			f := DetectTextFormat([]byte("\xef\xbb\xbfkey=value\r\n"))
+			g.Assert(f).Equal(TextFormat{Encoding: EncodingUTF8, LineEnding: LineEndingCRLF, BOM: true})
+
+			f = DetectTextFormat([]byte("\xff\xfek\x00=\x00v\x00\r\x00\n\x00"))
+			g.Assert(f).Equal(TextFormat{Encoding: EncodingUTF16LE, LineEnding: LineEndingCRLF, BOM: true})
+		})
+
+		g.It("detects UTF-16 without a byte order mark", func() {
+			f := DetectTextFormat([]byte("\x00k\x00e\x00y\x00\n"))
+			g.Assert(f.Encoding).Equal(EncodingUTF16BE)
+			g.Assert(f.LineEnding).Equal(LineEndingLF)
+		})
+
+		g.It("falls back to Latin-1 for text that is not UTF-8", func() {
+			f := DetectTextFormat([]byte("name=J\xe9r\xf4me"))
+			g.Assert(f).Equal(TextFormat{Encoding: EncodingLatin1})
+		})
+
+		g.It("ignores a character cut off at the end", func() {
+			f := DetectTextFormat([]byte("wörld")[:2])
+			g.Assert(f.Encoding).Equal(EncodingUTF8)
+		})
+
+		g.It("detects binary content", func() {
+			f := DetectTextFormat([]byte{0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x01, 0x00, 0x00, 0x00})
+			g.Assert(f).Equal(TextFormat{Encoding: EncodingBinary})
+		})
+	})
+
+	g.Describe("TextFormat", func() {
+		g.It("round trips text", func() {
+			for _, f := range []TextFormat{
+				{Encoding: EncodingUTF16LE, LineEnding: LineEndingCRLF, BOM: true},
+				{Encoding: EncodingLatin1, LineEnding: LineEndingLF},
+			} {
+				b, err := f.Encode([]byte("Jérôme\nline two\n"))
+				g.Assert(err).IsNil()
+				g.Assert(DetectTextFormat(b)).Equal(f)
+
+				text, err := f.Decode(b)
+				g.Assert(err).IsNil()
+				g.Assert(string(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")))).Equal("Jérôme\nline two\n")
+			}
+		})
+
+		g.It("returns an error for text that cannot be encoded", func() {
+			_, err := TextFormat{Encoding: EncodingLatin1}.Encode([]byte("雪"))
+			g.Assert(err).IsNotNil()
+		})
+
+		g.It("resolves encoding labels", func() {
+			f, err := NewTextFormat("latin1", "")
+			g.Assert(err).IsNil()
+			g.Assert(f.Encoding).Equal(EncodingLatin1)
+
+			_, err = NewTextFormat("utf-9", "")
+			g.Assert(err).IsNotNil()
+			_, err = NewTextFormat(EncodingUTF8, "cr")
+			g.Assert(err).IsNotNil()
+		})
+	})
+}
+
+func TestFilesystem_WriteText(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	g.Describe("WriteText", func() {
+		g.It("keeps the encoding and line endings of an existing file", func() {
+			err := rfs.CreateServerFile("server.cfg", []byte("\xff\xfea\x00\r\x00\n\x00"))
+			g.Assert(err).IsNil()
+
+			err = fs.WriteText("server.cfg", []byte("é\nb\n"), "", "", 0o644)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("server.cfg")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("\xff\xfe\xe9\x00\r\x00\n\x00b\x00\r\x00\n\x00")
+		})
+
+		g.It("converts to the requested format", func() {
+			err := rfs.CreateServerFile("server.cfg", []byte("a\r\n"))
+			g.Assert(err).IsNil()
+
+			err = fs.WriteText("server.cfg", []byte("é\r\n"), "latin1", "lf", 0o644)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("server.cfg")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("\xe9\n")
+		})
+
+		g.It("writes new files as they are", func() {
+			err := fs.WriteText("new.txt", []byte("a\r\nb\n"), "", "", 0o644)
+			g.Assert(err).IsNil()
+
+			f, _, err := fs.File("new.txt")
+			g.Assert(err).IsNil()
+			defer f.Close()
+			g.Assert(getFileContent(f)).Equal("a\r\nb\n")
+		})
+
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+	})
+}
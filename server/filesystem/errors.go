@@ -15,6 +15,7 @@ type ErrorCode string
 const (
 	ErrCodeIsDirectory    ErrorCode = "E_ISDIR"
 	ErrCodeDiskSpace      ErrorCode = "E_NODISK"
+	ErrCodeFileLimit      ErrorCode = "E_NOINODES"
 	ErrCodeUnknownArchive ErrorCode = "E_UNKNFMT"
 	ErrCodePathResolution ErrorCode = "E_BADPATH"
 	ErrCodeDenylistFile   ErrorCode = "E_DENYLIST"
@@ -56,6 +57,8 @@ func (e *Error) Error() string {
 		return fmt.Sprintf("filesystem: cannot perform action: [%s] is a directory", e.resolved)
 	case ErrCodeDiskSpace:
 		return "filesystem: not enough disk space"
+	case ErrCodeFileLimit:
+		return "filesystem: file limit reached"
 	case ErrCodeUnknownArchive:
 		return "filesystem: unknown archive format"
 	case ErrCodeDenylistFile:
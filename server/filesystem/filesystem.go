@@ -1,9 +1,11 @@
 package filesystem
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"slices"
 	"strconv"
@@ -26,7 +28,14 @@ type Filesystem struct {
 
 	mu                sync.RWMutex
 	lastLookupTime    *usageLookupTime
+	usageTrees        *usageTreeCache
+	checksums         *checksumCache
+	trackerMu         sync.RWMutex
+	tracker           UsageTracker
 	lookupInProgress  atomic.Bool
+	watches           atomic.Int32
+	graceUntil        atomic.Int64
+	graceLimit        atomic.Int64
 	diskCheckInterval time.Duration
 	denylist          *ignore.GitIgnore
 
@@ -49,6 +58,8 @@ func New(root string, size int64, denylist []string) (*Filesystem, error) {
 
 		diskCheckInterval: time.Duration(config.Get().System.DiskCheckInterval),
 		lastLookupTime:    &usageLookupTime{},
+		usageTrees:        &usageTreeCache{},
+		checksums:         &checksumCache{},
 		denylist:          ignore.CompileIgnoreLines(denylist...),
 	}, nil
 }
@@ -98,16 +109,28 @@ func (fs *Filesystem) UnixFS() *ufs.UnixFS {
 func (fs *Filesystem) Touch(p string, flag int) (ufs.File, error) {
 	var currentSize int64
 	st, err := fs.unixFS.Stat(p)
+	exists := err == nil
 	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
 		return nil, err
 	} else if err == nil && !st.IsDir() {
 		currentSize = st.Size()
 	}
+	if !exists {
+		if err := fs.HasSpaceForFiles(1 + fs.missingDirs(filepath.Dir(p))); err != nil {
+			return nil, err
+		}
+		if err := fs.mkdirAll(filepath.Dir(p), 0o755); err != nil {
+			return nil, err
+		}
+	}
 
 	file, err := fs.unixFS.Touch(p, flag, 0o644)
 	if err != nil {
 		return nil, err
 	}
+	if !exists {
+		fs.unixFS.AddFiles(1)
+	}
 	return newQuotaFile(fs, file, currentSize), nil
 }
 
@@ -155,6 +178,7 @@ func (fs *Filesystem) Writefile(p string, r io.Reader) error {
 func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileMode) error {
 	var currentSize int64
 	st, err := fs.unixFS.Stat(p)
+	exists := err == nil
 	if err != nil && !errors.Is(err, ufs.ErrNotExist) {
 		return errors.Wrap(err, "server/filesystem: writefile: failed to stat file")
 	} else if err == nil {
@@ -164,6 +188,11 @@ func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileM
 		}
 		currentSize = st.Size()
 	}
+	if !exists {
+		if err := fs.HasSpaceForFiles(1 + fs.missingDirs(filepath.Dir(p))); err != nil {
+			return err
+		}
+	}
 
 	// Check that the new size we're writing to the disk can fit. If there is currently
 	// a file we'll subtract that current file size from the size of the buffer to determine
@@ -188,6 +217,9 @@ func (fs *Filesystem) Write(p string, r io.Reader, newSize int64, mode ufs.FileM
 		return err
 	}
 	defer file.Close()
+	if !exists {
+		fs.unixFS.AddFiles(1)
+	}
 
 	if newSize == 0 {
 		// Subtract the previous size of the file if the new size is 0.
@@ -238,7 +270,11 @@ func (fs *Filesystem) chownFile(name string) error {
 // every directory it creates to the server user so they are not left owned by
 // the user Wings runs as.
 func (fs *Filesystem) mkdirAll(p string, mode ufs.FileMode) error {
+	if err := fs.HasSpaceForFiles(fs.missingDirs(p)); err != nil {
+		return err
+	}
 	created, err := fs.unixFS.MkdirAll(p, mode)
+	fs.unixFS.AddFiles(int64(len(created)))
 	if err != nil {
 		return err
 	}
@@ -250,6 +286,25 @@ func (fs *Filesystem) mkdirAll(p string, mode ufs.FileMode) error {
 	return nil
 }
 
+// missingDirs returns the number of directories that creating the directory p
+// along with its parents would create. It is only counted when the filesystem
+// has a file limit, since otherwise the number is never needed.
+func (fs *Filesystem) missingDirs(p string) int64 {
+	if fs.MaxFiles() <= 0 {
+		return 0
+	}
+	var n int64
+	for dir := filepath.Clean(p); ; dir = filepath.Dir(dir) {
+		if _, err := fs.unixFS.Lstat(dir); !errors.Is(err, ufs.ErrNotExist) {
+			return n
+		}
+		n++
+		if filepath.Dir(dir) == dir {
+			return n
+		}
+	}
+}
+
 // Chown recursively iterates over a file or directory and sets the permissions on all of the
 // underlying files. Iterate over all of the files and directories. If it is a file just
 // go ahead and perform the chown operation. Otherwise dig deeper into the directory until
@@ -337,61 +392,36 @@ func (fs *Filesystem) findCopySuffix(dirfd int, name, extension string) (string,
 	return name + suffix + extension, nil
 }
 
-// Copy copies a given file to the same location and appends a suffix to the
-// file to indicate that it has been copied.
+// Copy copies a given file or directory to the same location and appends a
+// suffix to the name to indicate that it has been copied.
 func (fs *Filesystem) Copy(p string) error {
 	dirfd, name, closeFd, err := fs.unixFS.SafePath(p)
 	defer closeFd()
 	if err != nil {
 		return err
 	}
-	source, err := fs.unixFS.OpenFileat(dirfd, name, ufs.O_RDONLY, 0)
+	info, err := fs.unixFS.Lstatat(dirfd, name)
 	if err != nil {
 		return err
 	}
-	defer source.Close()
-	info, err := source.Stat()
-	if err != nil {
-		return err
-	}
-	if info.IsDir() || !info.Mode().IsRegular() {
-		// If this is a directory or not a regular file, just throw a not-exist error
+	if !info.IsDir() && !info.Mode().IsRegular() {
+		// If this is not a regular file or directory, just throw a not-exist error
 		// since anything calling this function should understand what that means.
 		return ufs.ErrNotExist
 	}
-	currentSize := info.Size()
-
-	// Check that copying this file wouldn't put the server over its limit.
-	if err := fs.HasSpaceFor(currentSize); err != nil {
-		return err
-	}
 
 	base := info.Name()
-	extension := fs.Ext(base)
+	var extension string
+	if !info.IsDir() {
+		extension = fs.Ext(base)
+	}
 	baseName := strings.TrimSuffix(base, extension)
 
 	newName, err := fs.findCopySuffix(dirfd, baseName, extension)
 	if err != nil {
 		return err
 	}
-	dst, err := fs.unixFS.OpenFileat(dirfd, newName, ufs.O_WRONLY|ufs.O_CREATE, info.Mode())
-	if err != nil {
-		return err
-	}
-	defer dst.Close()
-	
-	// Do not use CopyBuffer here, it is wasteful as the file implements
-	// io.ReaderFrom, which causes it to not use the buffer anyways.
-	n, err := io.Copy(dst, io.LimitReader(source, currentSize))
-	fs.unixFS.Add(n)
-
-	if !fs.isTest {
-		if err := fs.unixFS.Lchownat(dirfd, newName, config.Get().System.User.Uid, config.Get().System.User.Gid); err != nil {
-			return err
-		}
-	}
-	// Return the error from io.Copy.
-	return err
+	return fs.CopyTo(context.Background(), p, path.Join(path.Dir(path.Clean("/"+p)), newName), nil)
 }
 
 func (fs *Filesystem) Ext(n string) string {
@@ -423,13 +453,13 @@ func (fs *Filesystem) TruncateRootDirectory() error {
 	if err != nil {
 		return err
 	}
-	var limit int64
-	if fs.isTest {
-		limit = 0
-	} else {
+	var limit, fileLimit int64
+	if !fs.isTest {
 		limit = fs.unixFS.Limit()
+		fileLimit = fs.unixFS.FileLimit()
 	}
 	fs.unixFS = ufs.NewQuota(unixFS, limit)
+	fs.unixFS.SetFileLimit(fileLimit)
 	return nil
 }
 
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	. "github.com/franela/goblin"
@@ -269,6 +270,72 @@ func TestFilesystem_Writefile(t *testing.T) {
 			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
 		})
 
+		g.It("can exceed the disk limits during a grace period", func() {
+			fs.SetDiskLimit(1024)
+			fs.unixFS.SetUsage(1024)
+
+			r := bytes.NewReader([]byte("test file content"))
+			fs.SetDiskGrace(time.Now().Add(time.Minute), 1024)
+			err := fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+
+			// Writes that would exceed the limit by more than the grace period
+			// allows are refused.
+			b := make([]byte, 1024)
+			err = fs.Write("test3.txt", bytes.NewReader(b), int64(len(b)), 0o644)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+
+			r = bytes.NewReader([]byte("test file content"))
+			fs.SetDiskGrace(time.Now().Add(-time.Second), 1024)
+			err = fs.Write("test2.txt", r, r.Size(), 0o644)
+			g.Assert(IsErrorCode(err, ErrCodeDiskSpace)).IsTrue()
+		})
+
+		g.It("cannot create more files than the file limit", func() {
+			fs.SetFileLimit(2)
+
+			r := bytes.NewReader([]byte("test file content"))
+			err := fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+			err = fs.CreateDirectory("dir", "/")
+			g.Assert(err).IsNil()
+			g.Assert(fs.CachedFiles()).Equal(int64(2))
+
+			r = bytes.NewReader([]byte("test file content"))
+			err = fs.Write("test2.txt", r, r.Size(), 0o644)
+			g.Assert(IsErrorCode(err, ErrCodeFileLimit)).IsTrue()
+
+			// Replacing the contents of an existing file does not create a new one.
+			r = bytes.NewReader([]byte("new content"))
+			err = fs.Write("test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+
+			err = fs.Delete("test.txt")
+			g.Assert(err).IsNil()
+			g.Assert(fs.CachedFiles()).Equal(int64(1))
+
+			r = bytes.NewReader([]byte("test file content"))
+			err = fs.Write("test2.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+		})
+
+		g.It("counts every missing parent directory towards the file limit", func() {
+			fs.SetFileLimit(3)
+			defer fs.SetFileLimit(0)
+
+			r := bytes.NewReader([]byte("test file content"))
+			err := fs.Write("a/b/c/test.txt", r, r.Size(), 0o644)
+			g.Assert(IsErrorCode(err, ErrCodeFileLimit)).IsTrue()
+
+			r = bytes.NewReader([]byte("test file content"))
+			err = fs.Write("a/b/test.txt", r, r.Size(), 0o644)
+			g.Assert(err).IsNil()
+			g.Assert(fs.CachedFiles()).Equal(int64(3))
+
+			err = fs.CreateDirectory("d", "a/c")
+			g.Assert(IsErrorCode(err, ErrCodeFileLimit)).IsTrue()
+		})
+
 		g.It("cannot write a file whose claimed size overflows the quota check", func() {
 			fs.SetDiskLimit(1024)
 			fs.unixFS.SetUsage(1)
@@ -487,13 +554,17 @@ func TestFilesystem_Copy(t *testing.T) {
 			g.Assert(errors.Is(err, ufs.ErrBadPathResolution)).IsTrue("err is not ErrBadPathResolution")
 		})
 
-		g.It("should return an error if the source is a directory", func() {
+		g.It("should create a copy of a directory", func() {
 			err := os.Mkdir(filepath.Join(rfs.root, "server/dir"), 0o755)
 			g.Assert(err).IsNil()
+			err = rfs.CreateServerFileFromString("dir/source.txt", "test content")
+			g.Assert(err).IsNil()
 
 			err = fs.Copy("dir")
-			g.Assert(err).IsNotNil()
-			g.Assert(errors.Is(err, ufs.ErrNotExist)).IsTrue("err is not ErrNotExist")
+			g.Assert(err).IsNil()
+
+			_, err = rfs.StatServerFile("dir copy/source.txt")
+			g.Assert(err).IsNil()
 		})
 
 		g.It("should return an error if there is not space to copy the file", func() {
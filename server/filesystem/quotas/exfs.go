@@ -41,11 +41,11 @@ var (
 	projectsTemplate = template.Must(template.New("projects").Parse(projectsTemplateSrc))
 )
 
-// setQuota sets the quota in bytes for the specified server uuid
+// setQuota sets the quota in bytes and inodes for the specified server uuid
 // A limit of 0 is treated as unlimited by xfs and ext4
-func (q exfsProject) setQuota(byteLimit uint64) (err error) {
+func (q exfsProject) setQuota(byteLimit uint64, fileLimit uint64) (err error) {
 	serverDirPath := filepath.Join(q.BasePath, q.Name)
-	log.WithFields(log.Fields{"server_path": serverDirPath, "limit_bytes": byteLimit}).Debug("setting quota")
+	log.WithFields(log.Fields{"server_path": serverDirPath, "limit_bytes": byteLimit, "limit_files": fileLimit}).Debug("setting quota")
 	serverProject, err := fsquota.LookupProject(q.Name)
 	if err != nil {
 		return
@@ -54,6 +54,7 @@ func (q exfsProject) setQuota(byteLimit uint64) (err error) {
 	limits := fsquota.Limits{}
 
 	limits.Bytes.SetHard(byteLimit)
+	limits.Files.SetHard(fileLimit)
 
 	if _, err = fsquota.SetProjectQuota(serverDirPath, serverProject, limits); err != nil {
 		return
@@ -65,19 +66,31 @@ func (q exfsProject) setQuota(byteLimit uint64) (err error) {
 
 // getQuota gets the specified quotas and usage of a specified server uuid
 func (q exfsProject) getQuota() (bytesUsed int64, err error) {
-	serverProject, err := fsquota.LookupProject(q.Name)
+	projInfo, err := q.getInfo()
 	if err != nil {
 		return -1, err
 	}
 
-	projInfo, err := fsquota.GetProjectInfo(q.BasePath, serverProject)
+	// converts the uint64 to int64.
+	// This should only be an issue in the terms of exabytes...
+	return int64(projInfo.BytesUsed), nil
+}
+
+// getFileQuota gets the number of inodes used by a specified server uuid
+func (q exfsProject) getFileQuota() (filesUsed int64, err error) {
+	projInfo, err := q.getInfo()
 	if err != nil {
 		return -1, err
 	}
+	return int64(projInfo.FilesUsed), nil
+}
 
-	// converts the uint64 to int64.
-	// This should only be an issue in the terms of exabytes...
-	return int64(projInfo.BytesUsed), nil
+func (q exfsProject) getInfo() (*fsquota.Info, error) {
+	serverProject, err := fsquota.LookupProject(q.Name)
+	if err != nil {
+		return nil, err
+	}
+	return fsquota.GetProjectInfo(q.BasePath, serverProject)
 }
 
 // enableEXFSQuota enables quotas on a specified directory
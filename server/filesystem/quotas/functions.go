@@ -89,21 +89,25 @@ func AddQuota(serverID int, serverUUID string) (err error) {
 	return errors.New("failed to set a quota")
 }
 
-// SetQuota configures quotas for a specified server
-// When the limit is set to a negative number it is set to 0
+// SetQuota configures the byte and file quotas for a specified server
+// When a limit is set to a negative number it is set to 0
 // 0 is treated as unlimited.
-func SetQuota(limit int64, serverUUID string) (err error) {
+func SetQuota(limit int64, fileLimit int64, serverUUID string) (err error) {
 	log.WithField("server", serverUUID).Debug("setting quota")
 	if limit < 0 {
 		log.WithField("requested_limit", limit).Error("quota limit cannot be negative, setting to zero")
 		limit = 0
 	}
+	if fileLimit < 0 {
+		log.WithField("requested_file_limit", fileLimit).Error("quota file limit cannot be negative, setting to zero")
+		fileLimit = 0
+	}
 	if t := fsType.Load(); t == FSEXT4 || t == FSXFS {
 		fsProject, err := getProject(serverUUID)
 		if err != nil {
 			return err
 		}
-		return fsProject.setQuota(uint64(limit))
+		return fsProject.setQuota(uint64(limit), uint64(fileLimit))
 	}
 	return
 }
@@ -120,6 +124,18 @@ func GetQuota(serverUUID string) (used int64, err error) {
 	return
 }
 
+// GetFileQuota gets the number of files used by a specified server
+func GetFileQuota(serverUUID string) (used int64, err error) {
+	if t := fsType.Load(); t == FSEXT4 || t == FSXFS {
+		fsProject, err := getProject(serverUUID)
+		if err != nil {
+			return used, err
+		}
+		return fsProject.getFileQuota()
+	}
+	return
+}
+
 // DelQuota removes a server from the configured quotas
 func DelQuota(serverUUID string) (err error) {
 	if t := fsType.Load(); t == FSEXT4 || t == FSXFS {
@@ -21,7 +21,7 @@ func AddQuota(serverID int, serverUUID string) error {
 }
 
 // SetQuota is unsupported on macOS.
-func SetQuota(limit int64, serverUUID string) error {
+func SetQuota(limit int64, fileLimit int64, serverUUID string) error {
 	return errUnsupported
 }
 
@@ -30,6 +30,11 @@ func GetQuota(serverUUID string) (int64, error) {
 	return 0, errUnsupported
 }
 
+// GetFileQuota is unsupported on macOS.
+func GetFileQuota(serverUUID string) (int64, error) {
+	return 0, errUnsupported
+}
+
 // DelQuota is unsupported on macOS.
 func DelQuota(serverUUID string) error {
 	return errUnsupported
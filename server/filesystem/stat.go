@@ -14,6 +14,9 @@ import (
 type Stat struct {
 	ufs.FileInfo
 	Mimetype string
+	// Checksum is only set when it has been explicitly requested, since it
+	// requires reading the entire file.
+	Checksum string
 }
 
 func (s *Stat) MarshalJSON() ([]byte, error) {
@@ -28,6 +31,7 @@ func (s *Stat) MarshalJSON() ([]byte, error) {
 		File      bool   `json:"file"`
 		Symlink   bool   `json:"symlink"`
 		Mime      string `json:"mime"`
+		Checksum  string `json:"checksum,omitempty"`
 	}{
 		Name:     s.Name(),
 		Created:  s.CTime().Format(time.RFC3339),
@@ -40,6 +44,7 @@ func (s *Stat) MarshalJSON() ([]byte, error) {
 		File:      !s.IsDir(),
 		Symlink:   s.Mode().Type()&ufs.ModeSymlink != 0,
 		Mime:      s.Mimetype,
+		Checksum:  s.Checksum,
 	})
 }
 
@@ -0,0 +1,290 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// MaxTailLines is the most lines that can be returned from the end of a file
+// when it starts being followed.
+const MaxTailLines = 1000
+
+const (
+	// How often a followed file is checked for new content.
+	tailPollInterval = 250 * time.Millisecond
+	// Lines longer than this are split into multiple lines.
+	maxTailLineLength = 16 * 1024
+	// The most that is read from a followed file at once.
+	maxTailRead = 1024 * 1024
+)
+
+// TailEvent is a set of lines read from a file being followed.
+type TailEvent struct {
+	Lines []string `json:"lines,omitempty"`
+	// Reset is true when the file was truncated or replaced, such as by a log
+	// being rotated, in which case the lines are from the start of the file.
+	Reset bool `json:"reset,omitempty"`
+}
+
+// statRegular returns information about a file, ensuring it is a regular file
+// so that opening it will never block.
+func (fs *Filesystem) statRegular(p string) (ufs.FileInfo, error) {
+	st, err := fs.unixFS.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return nil, errors.WithStack(&Error{code: ErrCodeIsDirectory, resolved: p})
+	}
+	if !st.Mode().IsRegular() {
+		return nil, errors.WithStack(ufs.ErrNotRegular)
+	}
+	return st, nil
+}
+
+// LastLines returns up to n lines from the end of the file at the given path.
+func (fs *Filesystem) LastLines(p string, n int) ([]string, error) {
+	if _, err := fs.statRegular(p); err != nil {
+		return nil, err
+	}
+	f, err := fs.unixFS.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	lines, _, err := lastLines(f, n)
+	return lines, err
+}
+
+// lastLines returns up to n complete lines from the end of the file, along with
+// the offset of the end of the last complete line. Anything after that is a
+// line which is still being written.
+func lastLines(f ufs.File, n int) ([]string, int64, error) {
+	if n > MaxTailLines {
+		n = MaxTailLines
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	end := st.Size()
+	// Never read more than the lines could possibly take up, so that a file
+	// without any newlines is not read in its entirety.
+	start := max(0, end-int64(n+1)*maxTailLineLength)
+	buf := make([]byte, end-start)
+	if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	// Only complete lines are returned, a partial line at the end will be read
+	// once it has been finished.
+	i := bytes.LastIndexByte(buf, '\n')
+	if i == -1 {
+		return nil, start, nil
+	}
+	end = start + int64(i) + 1
+	buf = buf[:i+1]
+	lines := splitTailLines(buf)
+	// The first line may have been cut off by where the reading started.
+	if start > 0 && len(lines) > 0 {
+		lines = lines[1:]
+	}
+	if n <= 0 {
+		return nil, end, nil
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, end, nil
+}
+
+// splitTailLines splits complete lines, removing the line endings and splitting
+// any which are too long.
+func splitTailLines(b []byte) []string {
+	var lines []string
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n')
+		if i == -1 {
+			i = len(b)
+		}
+		line := bytes.TrimSuffix(b[:i], []byte("\r"))
+		for len(line) > maxTailLineLength {
+			lines = append(lines, string(line[:maxTailLineLength]))
+			line = line[maxTailLineLength:]
+		}
+		lines = append(lines, string(line))
+		b = b[min(i+1, len(b)):]
+	}
+	return lines
+}
+
+// tail is a file being followed by Tail.
+type tail struct {
+	fs   *Filesystem
+	path string
+	out  chan TailEvent
+
+	f        ufs.File
+	dev, ino uint64
+	offset   int64
+	partial  []byte
+	buf      []byte
+}
+
+// Tail follows the file at the given path in the same way as "tail -F", sending
+// the last lines of the file and then any lines added to it on the returned
+// channel until the context is canceled. If the file is truncated, or replaced
+// by another file such as when a log is rotated, the new file is followed from
+// the start. A file that does not exist yet is waited for.
+func (fs *Filesystem) Tail(ctx context.Context, p string, lines int) (<-chan TailEvent, error) {
+	t := &tail{fs: fs, path: p, out: make(chan TailEvent)}
+	var last []string
+	if err := t.open(); err == nil {
+		if last, t.offset, err = lastLines(t.f, lines); err != nil {
+			_ = t.f.Close()
+			return nil, err
+		}
+	} else if !errors.Is(err, ufs.ErrNotExist) {
+		return nil, err
+	}
+	go t.run(ctx, last)
+	return t.out, nil
+}
+
+func (t *tail) run(ctx context.Context, last []string) {
+	defer close(t.out)
+	defer func() {
+		if t.f != nil {
+			_ = t.f.Close()
+		}
+	}()
+
+	if len(last) > 0 && !t.send(ctx, TailEvent{Lines: last}) {
+		return
+	}
+
+	ticker := time.NewTicker(tailPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, ev := range t.poll() {
+				if !t.send(ctx, ev) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (t *tail) send(ctx context.Context, ev TailEvent) bool {
+	select {
+	case t.out <- ev:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// open opens the file being followed, replacing any that is already open.
+func (t *tail) open() error {
+	if _, err := t.fs.statRegular(t.path); err != nil {
+		return err
+	}
+	f, err := t.fs.unixFS.Open(t.path)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if t.f != nil {
+		_ = t.f.Close()
+	}
+	t.f = f
+	t.dev, t.ino = fileID(st)
+	t.offset = 0
+	t.partial = nil
+	return nil
+}
+
+// poll checks the file for any changes, returning the lines that have been
+// added to it since the last time it was checked. Lines still in a file that
+// has been replaced are sent before the lines from the new file.
+func (t *tail) poll() []TailEvent {
+	var events []TailEvent
+	add := func(reset bool, lines []string) {
+		if reset || len(lines) > 0 {
+			events = append(events, TailEvent{Lines: lines, Reset: reset})
+		}
+	}
+	st, err := t.fs.statRegular(t.path)
+	switch {
+	case err != nil:
+		// The file has been removed or replaced by something else, finish
+		// reading whatever was written to it and then wait for it to return.
+		if t.f != nil {
+			add(false, t.read())
+			_ = t.f.Close()
+			t.f = nil
+		}
+	case t.f == nil:
+		if t.open() == nil {
+			add(true, t.read())
+		}
+	default:
+		if dev, ino := fileID(st); dev != t.dev || ino != t.ino {
+			add(false, t.read())
+			if t.open() == nil {
+				add(true, t.read())
+			}
+		} else if st.Size() < t.offset {
+			t.offset = 0
+			t.partial = nil
+			add(true, t.read())
+		} else {
+			add(false, t.read())
+		}
+	}
+	return events
+}
+
+// read returns the complete lines which have been written to the open file
+// since the last read.
+func (t *tail) read() []string {
+	if t.buf == nil {
+		t.buf = make([]byte, 64*1024)
+	}
+	b := t.partial
+	for total := 0; total < maxTailRead; {
+		n, err := t.f.ReadAt(t.buf, t.offset)
+		t.offset += int64(n)
+		total += n
+		b = append(b, t.buf[:n]...)
+		if err != nil || n < len(t.buf) {
+			break
+		}
+	}
+	i := bytes.LastIndexByte(b, '\n')
+	if i == -1 {
+		// Keep a line which has not been finished yet, unless it is already
+		// too long to ever be sent as a single line.
+		if len(b) >= maxTailLineLength {
+			t.partial = nil
+			return splitTailLines(b)
+		}
+		t.partial = b
+		return nil
+	}
+	t.partial = append([]byte(nil), b[i+1:]...)
+	return splitTailLines(b[:i+1])
+}
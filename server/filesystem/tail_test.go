@@ -0,0 +1,117 @@
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_Tail(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	// next waits for the next event from a tail, failing if none arrives.
+	next := func(ch <-chan TailEvent) TailEvent {
+		select {
+		case ev := <-ch:
+			return ev
+		case <-time.After(5 * time.Second):
+			g.Fail("timed out waiting for tail event")
+		}
+		return TailEvent{}
+	}
+	appendTo := func(name string, s string) {
+		f, err := os.OpenFile(filepath.Join(rfs.root, "server", name), os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+		g.Assert(err).IsNil()
+		_, err = f.WriteString(s)
+		g.Assert(err).IsNil()
+		g.Assert(f.Close()).IsNil()
+	}
+
+	g.Describe("Tail", func() {
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("returns the last lines of a file", func() {
+			g.Assert(rfs.CreateServerFile("latest.log", []byte("one\r\ntwo\nthree\nfour"))).IsNil()
+			lines, err := fs.LastLines("latest.log", 2)
+			g.Assert(err).IsNil()
+			g.Assert(lines).Equal([]string{"two", "three"})
+		})
+
+		g.It("splits long lines", func() {
+			g.Assert(splitTailLines([]byte(strings.Repeat("a", maxTailLineLength+1) + "\n"))).Equal([]string{strings.Repeat("a", maxTailLineLength), "a"})
+		})
+
+		g.It("follows lines added to a file", func() {
+			g.Assert(rfs.CreateServerFile("latest.log", []byte("one\ntwo\nthr"))).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Tail(ctx, "latest.log", 1)
+			g.Assert(err).IsNil()
+			g.Assert(next(ch)).Equal(TailEvent{Lines: []string{"two"}})
+
+			appendTo("latest.log", "ee\nfour\n")
+			g.Assert(next(ch)).Equal(TailEvent{Lines: []string{"three", "four"}})
+		})
+
+		g.It("follows a file from the start when it is truncated", func() {
+			g.Assert(rfs.CreateServerFile("latest.log", []byte("one\ntwo\n"))).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Tail(ctx, "latest.log", 0)
+			g.Assert(err).IsNil()
+
+			g.Assert(os.WriteFile(filepath.Join(rfs.root, "server/latest.log"), []byte("new\n"), 0o644)).IsNil()
+			// The file may be seen after being truncated but before being written.
+			ev := next(ch)
+			g.Assert(ev.Reset).IsTrue()
+			if len(ev.Lines) == 0 {
+				ev = next(ch)
+			}
+			g.Assert(ev.Lines).Equal([]string{"new"})
+		})
+
+		g.It("follows a file when it is rotated", func() {
+			g.Assert(rfs.CreateServerFile("latest.log", []byte(""))).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Tail(ctx, "latest.log", 10)
+			g.Assert(err).IsNil()
+
+			appendTo("latest.log", "old\n")
+			g.Assert(os.Rename(filepath.Join(rfs.root, "server/latest.log"), filepath.Join(rfs.root, "server/old.log"))).IsNil()
+			appendTo("latest.log", "rotated\n")
+
+			ev := next(ch)
+			if !ev.Reset {
+				g.Assert(ev).Equal(TailEvent{Lines: []string{"old"}})
+				ev = next(ch)
+			}
+			g.Assert(ev).Equal(TailEvent{Lines: []string{"rotated"}, Reset: true})
+		})
+
+		g.It("waits for a file that does not exist", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Tail(ctx, "logs/latest.log", 10)
+			g.Assert(err).IsNil()
+
+			g.Assert(fs.CreateDirectory("logs", "/")).IsNil()
+			appendTo("logs/latest.log", "started\n")
+			g.Assert(next(ch)).Equal(TailEvent{Lines: []string{"started"}, Reset: true})
+		})
+
+		g.It("returns an error for directories", func() {
+			g.Assert(fs.CreateDirectory("logs", "/")).IsNil()
+			_, err := fs.Tail(context.Background(), "logs", 10)
+			g.Assert(IsErrorCode(err, ErrCodeIsDirectory)).IsTrue()
+		})
+	})
+}
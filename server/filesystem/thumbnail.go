@@ -0,0 +1,325 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+
+	"github.com/pelican/wings/config"
+)
+
+// MaxThumbnailSize is the largest width or height a thumbnail can be created
+// with.
+const MaxThumbnailSize = 1024
+
+const (
+	// Images larger than this are never decoded.
+	maxThumbnailSourceSize = 64 * 1024 * 1024
+	// Images with more pixels than this are never decoded, since the decoded
+	// image is held in memory at four bytes per pixel.
+	maxThumbnailPixels = 25_000_000
+	// How long a cached thumbnail is kept after it was last used.
+	thumbnailCacheTTL = 7 * 24 * time.Hour
+	// How often old thumbnails are removed from the cache.
+	thumbnailCleanupInterval = time.Hour
+)
+
+var (
+	// ErrUnsupportedImage is returned when a thumbnail is requested for a file
+	// that is not an image in one of the supported formats.
+	ErrUnsupportedImage = errors.Sentinel("filesystem: file is not a supported image")
+	// ErrImageTooLarge is returned when an image is too large to be decoded.
+	ErrImageTooLarge = errors.Sentinel("filesystem: image is too large to create a thumbnail of")
+)
+
+// thumbnailSlots limits how many images are decoded at once across every
+// server, since decoding is where nearly all the memory and CPU is used.
+var thumbnailSlots = make(chan struct{}, 2)
+
+// lastThumbnailCleanup is the unix time at which old thumbnails were last
+// removed from the cache.
+var lastThumbnailCleanup atomic.Int64
+
+// thumbnailCacheUsed is the number of bytes used by the thumbnail cache as of
+// the last cleanup, plus every thumbnail cached since then.
+var thumbnailCacheUsed atomic.Int64
+
+// thumbnailCleaning is set while thumbnails are being removed from the cache.
+var thumbnailCleaning atomic.Bool
+
+// ImageInfo describes an image file.
+type ImageInfo struct {
+	Format string `json:"format"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Thumbnail is a smaller copy of an image.
+type Thumbnail struct {
+	// Image describes the original image, not the thumbnail.
+	Image    ImageInfo
+	Mimetype string
+	Data     []byte
+}
+
+// ImageInfo returns the format and dimensions of the image at the given path,
+// without decoding the entire image.
+func (fs *Filesystem) ImageInfo(p string) (ImageInfo, error) {
+	if _, err := fs.statRegular(p); err != nil {
+		return ImageInfo{}, err
+	}
+	f, err := fs.unixFS.Open(p)
+	if err != nil {
+		return ImageInfo{}, err
+	}
+	defer f.Close()
+	return decodeImageInfo(f)
+}
+
+func decodeImageInfo(r io.Reader) (ImageInfo, error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return ImageInfo{}, errors.WithStack(ErrUnsupportedImage)
+	}
+	return ImageInfo{Format: format, Width: cfg.Width, Height: cfg.Height}, nil
+}
+
+// Thumbnail returns a copy of the image at the given path scaled down to fit
+// within a square of the given size. Images are never scaled up. Thumbnails
+// are cached outside the server directory until the image is changed, so they
+// do not count towards the disk space used by the server.
+//
+// PNG, JPEG, GIF, WebP and BMP images are supported, only the first frame of
+// an animated image is used.
+func (fs *Filesystem) Thumbnail(ctx context.Context, p string, size int) (Thumbnail, error) {
+	if size < 1 || size > MaxThumbnailSize {
+		size = MaxThumbnailSize
+	}
+	st, err := fs.statRegular(p)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	if st.Size() > maxThumbnailSourceSize {
+		return Thumbnail{}, errors.WithStack(ErrImageTooLarge)
+	}
+	f, err := fs.unixFS.Open(p)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	defer f.Close()
+	if st, err = f.Stat(); err != nil {
+		return Thumbnail{}, err
+	}
+
+	info, err := decodeImageInfo(f)
+	if err != nil {
+		return Thumbnail{}, err
+	}
+	if info.Width*info.Height > maxThumbnailPixels {
+		return Thumbnail{}, errors.WithStack(ErrImageTooLarge)
+	}
+	t := Thumbnail{Image: info}
+
+	dev, ino := fileID(st)
+	key := fmt.Sprintf("%d:%d:%d:%d:%d", dev, ino, st.ModTime().UnixNano(), st.Size(), size)
+	if t.Mimetype, t.Data = fs.cachedThumbnail(key); t.Data != nil {
+		return t, nil
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+		defer func() { <-thumbnailSlots }()
+	case <-ctx.Done():
+		return Thumbnail{}, ctx.Err()
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Thumbnail{}, err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return Thumbnail{}, errors.WithStack(ErrUnsupportedImage)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.BiLinear.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+		src = dst
+	}
+
+	var buf bytes.Buffer
+	// Images without any transparency are much smaller as a JPEG.
+	if o, ok := src.(interface{ Opaque() bool }); ok && o.Opaque() {
+		t.Mimetype = "image/jpeg"
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+	} else {
+		t.Mimetype = "image/png"
+		err = png.Encode(&buf, src)
+	}
+	if err != nil {
+		return Thumbnail{}, errors.Wrap(err, "server/filesystem: failed to encode thumbnail")
+	}
+	t.Data = buf.Bytes()
+	fs.cacheThumbnail(key, t.Mimetype, t.Data)
+	return t, nil
+}
+
+// thumbnailDirectory returns the directory the thumbnails for this filesystem
+// are cached in, or an empty string if they are not cached.
+func (fs *Filesystem) thumbnailDirectory() string {
+	dir := config.Get().System.ThumbnailDirectory
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, filepath.Base(fs.Path()))
+}
+
+func thumbnailName(key string, mimetype string) string {
+	sum := sha256.Sum256([]byte(key))
+	ext := ".png"
+	if mimetype == "image/jpeg" {
+		ext = ".jpg"
+	}
+	return hex.EncodeToString(sum[:]) + ext
+}
+
+func (fs *Filesystem) cachedThumbnail(key string) (string, []byte) {
+	dir := fs.thumbnailDirectory()
+	if dir == "" {
+		return "", nil
+	}
+	for _, mimetype := range []string{"image/jpeg", "image/png"} {
+		p := filepath.Join(dir, thumbnailName(key, mimetype))
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		// Keep thumbnails that are still being used from being removed.
+		now := time.Now()
+		_ = os.Chtimes(p, now, now)
+		return mimetype, b
+	}
+	return "", nil
+}
+
+// cacheThumbnail stores a thumbnail in the cache, any error doing so is only
+// logged since the thumbnail can always be created again.
+func (fs *Filesystem) cacheThumbnail(key string, mimetype string, b []byte) {
+	dir := fs.thumbnailDirectory()
+	if dir == "" {
+		return
+	}
+	err := func() error {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		// Write to a temporary file first so that a partially written thumbnail
+		// is never read from the cache.
+		tmp, err := os.CreateTemp(dir, ".thumbnail-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.Write(b); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if err := tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), filepath.Join(dir, thumbnailName(key, mimetype)))
+	}()
+	if err != nil {
+		log.WithField("directory", dir).WithField("error", err).Warn("failed to cache thumbnail")
+		return
+	}
+
+	// Clean up the cache every so often, or as soon as it grows past its limit.
+	now := time.Now()
+	limit := config.Get().System.ThumbnailCacheSize * 1024 * 1024
+	used := thumbnailCacheUsed.Add(int64(len(b)))
+	due := now.Sub(time.Unix(lastThumbnailCleanup.Load(), 0)) > thumbnailCleanupInterval
+	if (due || (limit > 0 && used > limit)) && thumbnailCleaning.CompareAndSwap(false, true) {
+		lastThumbnailCleanup.Store(now.Unix())
+		go func() {
+			defer thumbnailCleaning.Store(false)
+			cleanThumbnailCache(config.Get().System.ThumbnailDirectory, now.Add(-thumbnailCacheTTL), limit)
+		}()
+	}
+}
+
+// cleanThumbnailCache removes every cached thumbnail that has not been used
+// since the given time, then the least recently used thumbnails until the
+// cache is no larger than the limit. A limit of 0 leaves the size unbounded.
+func cleanThumbnailCache(root string, before time.Time, limit int64) {
+	type cached struct {
+		path string
+		used time.Time
+		size int64
+	}
+	var files []cached
+	var total int64
+	_ = filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if info.ModTime().Before(before) {
+			_ = os.Remove(p)
+			return nil
+		}
+		files = append(files, cached{path: p, used: info.ModTime(), size: info.Size()})
+		total += info.Size()
+		return nil
+	})
+	if limit > 0 && total > limit {
+		// Thumbnails are touched whenever they are used, so the oldest ones
+		// are the least recently used.
+		sort.Slice(files, func(i, j int) bool { return files[i].used.Before(files[j].used) })
+		for _, f := range files {
+			if total <= limit {
+				break
+			}
+			if err := os.Remove(f.path); err == nil || errors.Is(err, os.ErrNotExist) {
+				total -= f.size
+			}
+		}
+	}
+	thumbnailCacheUsed.Store(total)
+}
+
+// RemoveThumbnails removes every cached thumbnail for the filesystem.
+func (fs *Filesystem) RemoveThumbnails() error {
+	dir := fs.thumbnailDirectory()
+	if dir == "" || dir == filepath.Clean(config.Get().System.ThumbnailDirectory) {
+		return nil
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,106 @@
+package filesystem
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_Thumbnail(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	createImage := func(name string, w, h int, c color.Color) {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for x := 0; x < w; x++ {
+			for y := 0; y < h; y++ {
+				img.Set(x, y, c)
+			}
+		}
+		var buf bytes.Buffer
+		g.Assert(png.Encode(&buf, img)).IsNil()
+		g.Assert(rfs.CreateServerFile(name, buf.Bytes())).IsNil()
+	}
+
+	g.Describe("Thumbnail", func() {
+		g.AfterEach(func() {
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("returns the dimensions of an image", func() {
+			createImage("image.png", 40, 20, color.White)
+			info, err := fs.ImageInfo("image.png")
+			g.Assert(err).IsNil()
+			g.Assert(info).Equal(ImageInfo{Format: "png", Width: 40, Height: 20})
+		})
+
+		g.It("scales an image down to fit the size", func() {
+			createImage("image.png", 400, 100, color.White)
+			th, err := fs.Thumbnail(context.Background(), "image.png", 100)
+			g.Assert(err).IsNil()
+			g.Assert(th.Image).Equal(ImageInfo{Format: "png", Width: 400, Height: 100})
+			g.Assert(th.Mimetype).Equal("image/jpeg")
+
+			img, format, err := image.Decode(bytes.NewReader(th.Data))
+			g.Assert(err).IsNil()
+			g.Assert(format).Equal("jpeg")
+			g.Assert(img.Bounds().Dx()).Equal(100)
+			g.Assert(img.Bounds().Dy()).Equal(25)
+		})
+
+		g.It("does not scale up small images", func() {
+			createImage("image.png", 10, 30, color.Transparent)
+			th, err := fs.Thumbnail(context.Background(), "image.png", 256)
+			g.Assert(err).IsNil()
+			g.Assert(th.Mimetype).Equal("image/png")
+
+			img, err := png.Decode(bytes.NewReader(th.Data))
+			g.Assert(err).IsNil()
+			g.Assert(img.Bounds()).Equal(image.Rect(0, 0, 10, 30))
+		})
+
+		g.It("returns an error for files that are not images", func() {
+			g.Assert(rfs.CreateServerFile("file.txt", []byte("hello"))).IsNil()
+			_, err := fs.Thumbnail(context.Background(), "file.txt", 256)
+			g.Assert(errors.Is(err, ErrUnsupportedImage)).IsTrue()
+		})
+
+		g.It("returns an error for directories", func() {
+			g.Assert(fs.CreateDirectory("images", "/")).IsNil()
+			_, err := fs.ImageInfo("images")
+			g.Assert(IsErrorCode(err, ErrCodeIsDirectory)).IsTrue()
+		})
+	})
+
+	g.Describe("cleanThumbnailCache", func() {
+		g.It("removes expired and then the least recently used thumbnails", func() {
+			dir := t.TempDir()
+			now := time.Now()
+			for name, age := range map[string]time.Duration{"expired": 8 * 24 * time.Hour, "old": 3 * time.Hour, "recent": 2 * time.Hour, "new": time.Hour} {
+				p := filepath.Join(dir, name)
+				g.Assert(os.WriteFile(p, make([]byte, 10), 0o600)).IsNil()
+				g.Assert(os.Chtimes(p, now.Add(-age), now.Add(-age))).IsNil()
+			}
+
+			cleanThumbnailCache(dir, now.Add(-thumbnailCacheTTL), 20)
+
+			var left []string
+			entries, err := os.ReadDir(dir)
+			g.Assert(err).IsNil()
+			for _, e := range entries {
+				left = append(left, e.Name())
+			}
+			g.Assert(left).Equal([]string{"new", "recent"})
+			g.Assert(thumbnailCacheUsed.Load()).Equal(int64(20))
+		})
+	})
+}
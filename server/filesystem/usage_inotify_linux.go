@@ -0,0 +1,386 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// The events watched on every directory within the filesystem, these are the
+// only events which are able to change the amount of disk space being used.
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR | unix.IN_DONT_FOLLOW | unix.IN_EXCL_UNLINK
+
+// How often directories that have seen changes are re-read to update the usage.
+// Batching these avoids re-reading a directory for every single write made to
+// a file within it.
+const inotifyFlushInterval = time.Second
+
+// trackedDir is a single directory being watched by an inotifyTracker.
+type trackedDir struct {
+	wd int
+	// size is the combined size of the regular files directly within this
+	// directory, files in subdirectories are tracked by their own entry.
+	size int64
+	// files is the number of entries of any type directly within this
+	// directory.
+	files int64
+}
+
+type inotifyTracker struct {
+	fs     *Filesystem
+	f      *os.File
+	fd     int
+	rescan time.Duration
+
+	mu      sync.Mutex
+	ready   bool
+	err     error
+	total   int64
+	files   int64
+	watches map[int]string
+	dirs    map[string]*trackedDir
+	dirty   map[string]struct{}
+}
+
+// NewInotifyTracker returns a UsageTracker which uses inotify to watch every
+// directory of the filesystem, updating the usage of only the directories that
+// change. The whole filesystem is walked once when the tracker starts, and then
+// again every rescan interval to correct any drift, such as from hard links or
+// dropped events. A rescan interval of zero disables the periodic rescans.
+//
+// The tracker stops when the context is canceled or it is closed. If a watch
+// cannot be added, most often because fs.inotify.max_user_watches has been
+// reached, the tracker stops and returns the error from Usage so that the
+// Filesystem falls back to walking the directory.
+func NewInotifyTracker(ctx context.Context, fs *Filesystem, rescan time.Duration) (UsageTracker, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "server/filesystem: inotify: failed to initialize")
+	}
+	t := &inotifyTracker{
+		fs: fs,
+		// Using a non-blocking descriptor allows the runtime poller to manage
+		// it, which means closing the file will also unblock any pending read.
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		rescan:  rescan,
+		watches: make(map[int]string),
+		dirs:    make(map[string]*trackedDir),
+		dirty:   make(map[string]struct{}),
+	}
+	go t.run(ctx)
+	return t, nil
+}
+
+// Usage returns the tracked disk usage.
+func (t *inotifyTracker) Usage() (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return 0, t.err
+	}
+	if !t.ready {
+		return 0, ErrUsageTrackerNotReady
+	}
+	return t.total, nil
+}
+
+// Files returns the tracked number of files and directories.
+func (t *inotifyTracker) Files() (int64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return 0, t.err
+	}
+	if !t.ready {
+		return 0, ErrUsageTrackerNotReady
+	}
+	return t.files, nil
+}
+
+// Close stops the tracker.
+func (t *inotifyTracker) Close() error {
+	return t.f.Close()
+}
+
+func (t *inotifyTracker) run(ctx context.Context) {
+	defer t.f.Close()
+
+	if err := t.scan(); err != nil {
+		t.fail(err)
+		return
+	}
+
+	events := make(chan []byte)
+	go func() {
+		defer close(events)
+		for {
+			buf := make([]byte, 64*1024)
+			n, err := t.f.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case events <- buf[:n]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	flush := time.NewTicker(inotifyFlushInterval)
+	defer flush.Stop()
+	var rescan <-chan time.Time
+	if t.rescan > 0 {
+		r := time.NewTicker(t.rescan)
+		defer r.Stop()
+		rescan = r.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case buf, ok := <-events:
+			if !ok {
+				// The file was closed, either by Close or a read error.
+				return
+			}
+			if err := t.handle(buf); err != nil {
+				t.fail(err)
+				return
+			}
+		case <-flush.C:
+			t.flush()
+		case <-rescan:
+			if err := t.scan(); err != nil {
+				t.fail(err)
+				return
+			}
+		}
+	}
+}
+
+func (t *inotifyTracker) fail(err error) {
+	log.WithField("root", t.fs.Path()).WithField("error", err).Warn("stopping inotify disk usage tracker")
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+}
+
+// handle processes a buffer of raw inotify events.
+func (t *inotifyTracker) handle(buf []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		start := off + unix.SizeofInotifyEvent
+		off = start + int(ev.Len)
+		if off > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[start:off]), "\x00")
+
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// Events were dropped, the only way to be accurate again is to
+			// start over.
+			return t.scanLocked()
+		}
+
+		dir, ok := t.watches[int(ev.Wd)]
+		if !ok {
+			continue
+		}
+		if ev.Mask&unix.IN_IGNORED != 0 {
+			// The directory itself was removed.
+			t.removeLocked(dir)
+			continue
+		}
+
+		rel := path.Join(dir, name)
+		// The parent is always re-read so that its number of entries is
+		// updated, whether or not the entry is a directory.
+		t.dirty[dir] = struct{}{}
+		if ev.Mask&unix.IN_ISDIR != 0 {
+			switch {
+			case ev.Mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+				t.removeLocked(rel)
+			case ev.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+				// Anything could have been moved in with the directory, so
+				// walk all of it rather than just adding a watch.
+				added, files, err := t.walkLocked(rel, t.dirs)
+				if err != nil {
+					return err
+				}
+				t.total += added
+				t.files += files
+			}
+		}
+	}
+	return nil
+}
+
+// flush re-reads every directory that has seen changes since the last flush.
+func (t *inotifyTracker) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for dir := range t.dirty {
+		delete(t.dirty, dir)
+		d, ok := t.dirs[dir]
+		if !ok {
+			continue
+		}
+		var size int64
+		st, err := t.fs.ReadDirStat(dir)
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) {
+				t.removeLocked(dir)
+				continue
+			}
+			log.WithField("root", t.fs.Path()).WithField("directory", dir).WithField("error", err).Debug("failed to read directory for disk usage")
+			continue
+		}
+		for _, s := range st {
+			if s.Mode().IsRegular() {
+				size += s.Size()
+			}
+		}
+		t.total += size - d.size
+		d.size = size
+		t.files += int64(len(st)) - d.files
+		d.files = int64(len(st))
+	}
+}
+
+// scan walks the entire filesystem, replacing all the tracked directories.
+func (t *inotifyTracker) scan() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.scanLocked()
+}
+
+func (t *inotifyTracker) scanLocked() error {
+	dirs := make(map[string]*trackedDir)
+	if _, _, err := t.walkLocked(".", dirs); err != nil {
+		return err
+	}
+	// Remove the watches for any directories which no longer exist, those that
+	// do still exist returned the same descriptor when walking.
+	for wd, dir := range t.watches {
+		if _, ok := dirs[dir]; !ok {
+			_, _ = unix.InotifyRmWatch(t.fd, uint32(wd))
+			delete(t.watches, wd)
+		}
+	}
+
+	var total, files int64
+	for _, d := range dirs {
+		total += d.size
+		files += d.files
+	}
+	t.dirs = dirs
+	t.total = total
+	t.files = files
+	clear(t.dirty)
+	t.ready = true
+	return nil
+}
+
+// walkLocked walks the given directory and everything beneath it, adding a
+// watch to each directory and storing the result in dirs. The change in size
+// and number of files of the directories in dirs is returned.
+func (t *inotifyTracker) walkLocked(root string, dirs map[string]*trackedDir) (int64, int64, error) {
+	dirfd, name, closeFd, err := t.fs.unixFS.SafePath(root)
+	defer closeFd()
+	if err != nil {
+		if errors.Is(err, ufs.ErrNotExist) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var added, files int64
+	err = t.fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			// Files being removed while walking are expected, those will be
+			// handled by their own events.
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel := path.Join(root, relative)
+		// The root is counted by its parent when that is re-read instead.
+		if rel != root {
+			if p, ok := dirs[path.Dir(rel)]; ok {
+				p.files++
+				files++
+			}
+		}
+		if d.IsDir() {
+			wd, err := unix.InotifyAddWatch(t.fd, filepath.Join(t.fs.Path(), rel), inotifyMask)
+			if err != nil {
+				if errors.Is(err, unix.ENOENT) {
+					return ufs.SkipDir
+				}
+				return errors.Wrapf(err, "server/filesystem: inotify: failed to watch %s", rel)
+			}
+			t.watches[wd] = rel
+			if old, ok := dirs[rel]; ok {
+				added -= old.size
+				files -= old.files
+			}
+			dirs[rel] = &trackedDir{wd: wd}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := t.fs.unixFS.Lstatat(dirfd, name)
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if p, ok := dirs[path.Dir(rel)]; ok {
+			p.size += info.Size()
+			added += info.Size()
+		}
+		return nil
+	})
+	return added, files, err
+}
+
+// removeLocked stops tracking the given directory and everything beneath it.
+func (t *inotifyTracker) removeLocked(dir string) {
+	for rel, d := range t.dirs {
+		if dir != "." && rel != dir && !strings.HasPrefix(rel, dir+"/") {
+			continue
+		}
+		t.total -= d.size
+		t.files -= d.files
+		delete(t.dirs, rel)
+		delete(t.dirty, rel)
+		if w, ok := t.watches[d.wd]; ok && w == rel {
+			_, _ = unix.InotifyRmWatch(t.fd, uint32(d.wd))
+			delete(t.watches, d.wd)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/franela/goblin"
+)
+
+func TestFilesystem_InotifyTracker(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	// waitForUsage polls the tracker until it reports the expected usage, or
+	// gives up and returns whatever the last value was.
+	waitForUsage := func(tracker UsageTracker, expected int64) int64 {
+		var v int64
+		for i := 0; i < 50; i++ {
+			v, _ = tracker.Usage()
+			if v == expected {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		return v
+	}
+
+	g.Describe("InotifyTracker", func() {
+		g.AfterEach(func() {
+			fs.SetUsageTracker(nil)
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("tracks files written outside of the filesystem", func() {
+			g.Assert(rfs.CreateServerFile("existing.txt", []byte(strings.Repeat("a", 100)))).IsNil()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			tracker, err := NewInotifyTracker(ctx, fs, 0)
+			g.Assert(err).IsNil()
+			fs.SetUsageTracker(tracker)
+
+			g.Assert(waitForUsage(tracker, 100)).Equal(int64(100))
+
+			p := filepath.Join(rfs.root, "server", "nested", "deeper")
+			g.Assert(os.MkdirAll(p, 0o755)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(p, "file.txt"), []byte(strings.Repeat("b", 50)), 0o644)).IsNil()
+			g.Assert(waitForUsage(tracker, 150)).Equal(int64(150))
+
+			g.Assert(os.RemoveAll(filepath.Join(rfs.root, "server", "nested"))).IsNil()
+			g.Assert(waitForUsage(tracker, 100)).Equal(int64(100))
+
+			size, err := fs.DiskUsage(false)
+			g.Assert(err).IsNil()
+			g.Assert(size).Equal(int64(100))
+		})
+	})
+}
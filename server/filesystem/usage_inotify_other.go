@@ -0,0 +1,16 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"context"
+	"time"
+
+	"emperror.dev/errors"
+)
+
+// NewInotifyTracker is only supported on Linux, on all other systems the disk
+// usage is determined by walking the directory.
+func NewInotifyTracker(_ context.Context, _ *Filesystem, _ time.Duration) (UsageTracker, error) {
+	return nil, errors.New("server/filesystem: inotify usage tracking is only supported on Linux")
+}
@@ -0,0 +1,51 @@
+package filesystem
+
+import (
+	"emperror.dev/errors"
+)
+
+// ErrUsageTrackerNotReady is returned by a UsageTracker that has not finished
+// determining the initial usage of the filesystem yet.
+var ErrUsageTrackerNotReady = errors.Sentinel("filesystem: usage tracker is not ready")
+
+// UsageTracker keeps the disk usage of a Filesystem up to date without needing
+// to walk the entire directory every time the disk check interval elapses.
+//
+// If a tracker returns an error from Usage the Filesystem falls back to walking
+// the directory as it would without a tracker.
+type UsageTracker interface {
+	// Usage returns the current disk usage of the filesystem in bytes.
+	Usage() (int64, error)
+	// Close stops the tracker and releases any resources held by it.
+	Close() error
+}
+
+// FileCounter is implemented by a UsageTracker that is also able to report the
+// number of files and directories within the filesystem. Trackers that do not
+// implement it leave the file count to be updated as files are created and
+// removed through the Filesystem.
+type FileCounter interface {
+	Files() (int64, error)
+}
+
+// UsageTracker returns the tracker keeping the disk usage of the filesystem up
+// to date, or nil if the usage is determined by periodically walking it.
+func (fs *Filesystem) UsageTracker() UsageTracker {
+	fs.trackerMu.RLock()
+	defer fs.trackerMu.RUnlock()
+	return fs.tracker
+}
+
+// SetUsageTracker sets the tracker used to keep the disk usage of the filesystem
+// up to date, closing any tracker that was previously set. Passing nil returns
+// the filesystem to periodically walking the directory.
+func (fs *Filesystem) SetUsageTracker(t UsageTracker) {
+	fs.trackerMu.Lock()
+	previous := fs.tracker
+	fs.tracker = t
+	fs.trackerMu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+}
@@ -0,0 +1,56 @@
+package filesystem
+
+import (
+	"time"
+
+	"emperror.dev/errors"
+)
+
+const (
+	FileChangeCreate = "create"
+	FileChangeModify = "modify"
+	FileChangeDelete = "delete"
+	FileChangeRename = "rename"
+	// FileChangeOverflow is sent when changes were lost because they happened
+	// faster than they could be read, the directory should be read again.
+	FileChangeOverflow = "overflow"
+)
+
+// MaxWatchDepth is the deepest a single watch is able to look beneath the
+// directory being watched.
+const MaxWatchDepth = 5
+
+const (
+	// The number of watches that can be running for each server at a time.
+	maxWatchesPerServer = 16
+	// The number of directories that can be watched by a single watch.
+	maxWatchedDirectories = 1024
+	// How long changes are collected for before being sent, so that a file
+	// being written to does not result in a change for every write.
+	watchDebounce = 250 * time.Millisecond
+	// The number of changes that are collected before they are sent, even if
+	// the debounce has not passed yet.
+	maxPendingChanges = 512
+)
+
+var (
+	// ErrInvalidWatchDepth is returned when a watch is started with a depth
+	// outside of the supported range.
+	ErrInvalidWatchDepth = errors.Sentinel("filesystem: watch depth must be between 1 and 5")
+	// ErrTooManyWatches is returned when a server already has the maximum
+	// number of watches running.
+	ErrTooManyWatches = errors.Sentinel("filesystem: too many directories are being watched for this server")
+	// ErrTooManyWatchedDirectories is returned when a watch would need to
+	// watch more directories than it is able to.
+	ErrTooManyWatchedDirectories = errors.Sentinel("filesystem: directory contains too many directories to watch")
+)
+
+// FileChange is a single change to a file or directory seen by a watch. Paths
+// are relative to the root of the server.
+type FileChange struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// From is the previous path of a file that was renamed.
+	From      string `json:"from,omitempty"`
+	Directory bool   `json:"directory"`
+}
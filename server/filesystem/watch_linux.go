@@ -0,0 +1,359 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"emperror.dev/errors"
+	"github.com/apex/log"
+	"golang.org/x/sys/unix"
+
+	"github.com/pelican/wings/internal/ufs"
+)
+
+// The events watched on every directory of a watch.
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_MOVE_SELF | unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+// fileWatch is a single watch on a directory, it is only ever used by the
+// goroutine started by Watch so none of it needs to be locked.
+type fileWatch struct {
+	fs    *Filesystem
+	f     *os.File
+	fd    int
+	root  string
+	depth int
+	out   chan []FileChange
+
+	watches map[int]string
+	// pending are the changes waiting to be sent, and index is the position of
+	// the most recent change to each path within it.
+	pending []FileChange
+	index   map[string]int
+	// moves are directories and files that have been moved away from a path,
+	// by the cookie of the event. If nothing is moved to a watched path with
+	// the same cookie before the changes are sent, they were moved out of the
+	// watch and are reported as deleted.
+	moves map[uint32]FileChange
+}
+
+// Watch watches the given directory, and the directories beneath it up to the
+// given depth, for files being created, modified, deleted or renamed. A depth
+// of one only watches the directory itself. Changes are collected for a short
+// time before being sent as a batch on the returned channel, which is closed
+// once the context is canceled or the directory is deleted.
+//
+// Changes to paths matching the denylist are never reported. Only a limited
+// number of watches can be running for each server at a time, and only a
+// limited number of directories can be watched by each one.
+func (fs *Filesystem) Watch(ctx context.Context, dir string, depth int) (<-chan []FileChange, error) {
+	if depth < 1 || depth > MaxWatchDepth {
+		return nil, errors.WithStack(ErrInvalidWatchDepth)
+	}
+	if fs.watches.Add(1) > maxWatchesPerServer {
+		fs.watches.Add(-1)
+		return nil, errors.WithStack(ErrTooManyWatches)
+	}
+
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		fs.watches.Add(-1)
+		return nil, errors.Wrap(err, "server/filesystem: watch: failed to initialize inotify")
+	}
+	w := &fileWatch{
+		fs:      fs,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		fd:      fd,
+		root:    path.Clean(strings.TrimLeft(dir, "/")),
+		depth:   depth,
+		out:     make(chan []FileChange),
+		watches: make(map[int]string),
+		index:   make(map[string]int),
+		moves:   make(map[uint32]FileChange),
+	}
+	if w.root == "" {
+		w.root = "."
+	}
+	if err := w.add(w.root); err != nil {
+		_ = w.f.Close()
+		fs.watches.Add(-1)
+		return nil, err
+	}
+	// The root is always the first thing walked, so it can only be missing if
+	// it is not a directory.
+	if len(w.watches) == 0 {
+		_ = w.f.Close()
+		fs.watches.Add(-1)
+		return nil, errors.WithStack(ufs.ErrNotDirectory)
+	}
+	go w.run(ctx)
+	return w.out, nil
+}
+
+func (w *fileWatch) run(ctx context.Context) {
+	// The watch is released before the channel is closed, so that anything
+	// waiting for the channel to close can immediately start another one.
+	defer close(w.out)
+	defer w.fs.watches.Add(-1)
+	defer w.f.Close()
+
+	events := make(chan []byte)
+	go func() {
+		defer close(events)
+		for {
+			buf := make([]byte, 64*1024)
+			n, err := w.f.Read(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case events <- buf[:n]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	flush := time.NewTicker(watchDebounce)
+	defer flush.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case buf, ok := <-events:
+			if !ok {
+				return
+			}
+			if done := w.handle(buf); done || len(w.pending) >= maxPendingChanges {
+				if !w.flush(ctx) || done {
+					return
+				}
+			}
+		case <-flush.C:
+			if !w.flush(ctx) {
+				return
+			}
+		}
+	}
+}
+
+// flush sends all the pending changes, returning false if the context was
+// canceled before they could be sent.
+func (w *fileWatch) flush(ctx context.Context) bool {
+	for cookie, c := range w.moves {
+		delete(w.moves, cookie)
+		c.Action = FileChangeDelete
+		w.push(c)
+	}
+	changes := make([]FileChange, 0, len(w.pending))
+	for _, c := range w.pending {
+		if c.Action != "" {
+			changes = append(changes, c)
+		}
+	}
+	w.pending = w.pending[:0]
+	clear(w.index)
+	if len(changes) == 0 {
+		return true
+	}
+	select {
+	case w.out <- changes:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handle processes a buffer of raw inotify events, returning true if the root
+// directory of the watch no longer exists.
+func (w *fileWatch) handle(buf []byte) bool {
+	for off := 0; off+unix.SizeofInotifyEvent <= len(buf); {
+		ev := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+		start := off + unix.SizeofInotifyEvent
+		off = start + int(ev.Len)
+		if off > len(buf) {
+			break
+		}
+		name := strings.TrimRight(string(buf[start:off]), "\x00")
+
+		if ev.Mask&unix.IN_Q_OVERFLOW != 0 {
+			// Changes were dropped, so the only thing that can be done is to
+			// tell the client to read the directory again.
+			w.push(FileChange{Action: FileChangeOverflow, Path: displayPath(w.root), Directory: true})
+			continue
+		}
+		dir, ok := w.watches[int(ev.Wd)]
+		if !ok {
+			continue
+		}
+		// The paths of everything beneath the root would no longer be correct
+		// once it has been moved, so treat that the same as it being deleted.
+		// Anything else being moved is handled by the event on its parent.
+		if ev.Mask&(unix.IN_IGNORED|unix.IN_MOVE_SELF) != 0 {
+			if dir == w.root {
+				w.push(FileChange{Action: FileChangeDelete, Path: displayPath(w.root), Directory: true})
+				return true
+			}
+			if ev.Mask&unix.IN_IGNORED != 0 {
+				delete(w.watches, int(ev.Wd))
+			}
+			continue
+		}
+
+		rel := path.Join(dir, name)
+		if w.fs.IsIgnored(displayPath(rel)) != nil {
+			continue
+		}
+		isDir := ev.Mask&unix.IN_ISDIR != 0
+		change := FileChange{Path: displayPath(rel), Directory: isDir}
+		switch {
+		case ev.Mask&unix.IN_CREATE != 0:
+			change.Action = FileChangeCreate
+			if isDir {
+				w.addLogged(rel)
+			}
+		case ev.Mask&(unix.IN_MODIFY|unix.IN_CLOSE_WRITE) != 0:
+			if isDir {
+				continue
+			}
+			change.Action = FileChangeModify
+		case ev.Mask&unix.IN_DELETE != 0:
+			change.Action = FileChangeDelete
+		case ev.Mask&unix.IN_MOVED_FROM != 0:
+			if isDir {
+				w.remove(rel)
+			}
+			w.moves[ev.Cookie] = change
+			continue
+		case ev.Mask&unix.IN_MOVED_TO != 0:
+			change.Action = FileChangeCreate
+			if from, ok := w.moves[ev.Cookie]; ok {
+				delete(w.moves, ev.Cookie)
+				change.Action = FileChangeRename
+				change.From = from.Path
+			}
+			if isDir {
+				w.addLogged(rel)
+			}
+		default:
+			continue
+		}
+		w.push(change)
+	}
+	return false
+}
+
+// push adds a change to be sent, combining it with any pending change to the
+// same path so that a file being written to many times only results in one
+// change being sent.
+func (w *fileWatch) push(c FileChange) {
+	if i, ok := w.index[c.Path]; ok {
+		prev := w.pending[i]
+		switch {
+		case c.Action == FileChangeModify && (prev.Action == FileChangeCreate || prev.Action == FileChangeModify || prev.Action == FileChangeRename):
+			return
+		case c.Action == FileChangeDelete && prev.Action == FileChangeCreate:
+			// The file never existed as far as the client knows.
+			w.pending[i].Action = ""
+			delete(w.index, c.Path)
+			return
+		}
+		w.pending[i].Action = ""
+	}
+	w.index[c.Path] = len(w.pending)
+	w.pending = append(w.pending, c)
+}
+
+// addLogged adds watches for a directory that has been created or moved into
+// the watch, logging rather than returning any error so that the rest of the
+// watch continues to work.
+func (w *fileWatch) addLogged(dir string) {
+	if err := w.add(dir); err != nil {
+		log.WithField("root", w.fs.Path()).WithField("directory", dir).WithField("error", err).Debug("failed to watch directory for changes")
+	}
+}
+
+// add watches the given directory and every directory beneath it that is
+// within the depth of the watch.
+func (w *fileWatch) add(dir string) error {
+	if w.level(dir) >= w.depth {
+		return nil
+	}
+	dirfd, name, closeFd, err := w.fs.unixFS.SafePath(dir)
+	defer closeFd()
+	if err != nil {
+		if errors.Is(err, ufs.ErrNotExist) && dir != w.root {
+			return nil
+		}
+		return err
+	}
+	return w.fs.unixFS.WalkDirat(dirfd, name, func(dirfd int, name, relative string, d ufs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, ufs.ErrNotExist) && relative != "." {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel := path.Join(dir, relative)
+		if w.level(rel) >= w.depth || (rel != w.root && w.fs.IsIgnored(displayPath(rel)) != nil) {
+			return ufs.SkipDir
+		}
+		if len(w.watches) >= maxWatchedDirectories {
+			return errors.WithStack(ErrTooManyWatchedDirectories)
+		}
+		// Watch the directory through the descriptor opened for it, rather than
+		// its path, so that a symlink swapped in for any part of the path can
+		// never cause something outside the server to be watched.
+		fd, err := unix.Openat(dirfd, name, unix.O_PATH|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err != nil {
+			if errors.Is(err, unix.ENOENT) {
+				return ufs.SkipDir
+			}
+			return errors.Wrapf(err, "server/filesystem: watch: failed to open %s", rel)
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, "/proc/self/fd/"+strconv.Itoa(fd), watchMask)
+		_ = unix.Close(fd)
+		if err != nil {
+			return errors.Wrapf(err, "server/filesystem: watch: failed to watch %s", rel)
+		}
+		w.watches[wd] = rel
+		return nil
+	})
+}
+
+// remove stops watching the given directory and everything beneath it.
+func (w *fileWatch) remove(dir string) {
+	for wd, rel := range w.watches {
+		if rel == dir || strings.HasPrefix(rel, dir+"/") {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.watches, wd)
+		}
+	}
+}
+
+// level returns how many directories beneath the root of the watch the given
+// directory is.
+func (w *fileWatch) level(dir string) int {
+	if dir == w.root {
+		return 0
+	}
+	rel := strings.TrimPrefix(dir, w.root+"/")
+	if w.root == "." {
+		rel = dir
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+func displayPath(rel string) string {
+	return path.Clean("/" + rel)
+}
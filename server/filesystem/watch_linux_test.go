@@ -0,0 +1,148 @@
+//go:build linux
+
+package filesystem
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+	ignore "github.com/sabhiram/go-gitignore"
+)
+
+func TestFilesystem_Watch(t *testing.T) {
+	g := Goblin(t)
+	fs, rfs := NewFs()
+
+	// collect reads changes until nothing new has arrived for a short time.
+	collect := func(ch <-chan []FileChange) []FileChange {
+		var changes []FileChange
+		for {
+			select {
+			case c, ok := <-ch:
+				if !ok {
+					return changes
+				}
+				changes = append(changes, c...)
+			case <-time.After(watchDebounce * 3):
+				return changes
+			}
+		}
+	}
+
+	g.Describe("Watch", func() {
+		g.AfterEach(func() {
+			fs.denylist = ignore.CompileIgnoreLines()
+			_ = fs.TruncateRootDirectory()
+		})
+
+		g.It("reports changes to files", func() {
+			g.Assert(rfs.CreateServerFile("old.txt", []byte("a"))).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Watch(ctx, "/", 1)
+			g.Assert(err).IsNil()
+
+			root := filepath.Join(rfs.root, "server")
+			g.Assert(os.WriteFile(filepath.Join(root, "new.txt"), []byte("b"), 0o644)).IsNil()
+			g.Assert(os.Rename(filepath.Join(root, "old.txt"), filepath.Join(root, "renamed.txt"))).IsNil()
+			g.Assert(collect(ch)).Equal([]FileChange{
+				{Action: FileChangeCreate, Path: "/new.txt"},
+				{Action: FileChangeRename, Path: "/renamed.txt", From: "/old.txt"},
+			})
+
+			g.Assert(os.WriteFile(filepath.Join(root, "new.txt"), []byte("c"), 0o644)).IsNil()
+			g.Assert(os.Remove(filepath.Join(root, "renamed.txt"))).IsNil()
+			g.Assert(collect(ch)).Equal([]FileChange{
+				{Action: FileChangeModify, Path: "/new.txt"},
+				{Action: FileChangeDelete, Path: "/renamed.txt"},
+			})
+		})
+
+		g.It("only watches directories within the depth", func() {
+			g.Assert(fs.CreateDirectory("deep", "/config/nested")).IsNil()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Watch(ctx, "/config", 2)
+			g.Assert(err).IsNil()
+
+			root := filepath.Join(rfs.root, "server/config")
+			g.Assert(os.WriteFile(filepath.Join(root, "nested/a.txt"), []byte("a"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "nested/deep/b.txt"), []byte("b"), 0o644)).IsNil()
+			g.Assert(collect(ch)).Equal([]FileChange{
+				{Action: FileChangeCreate, Path: "/config/nested/a.txt"},
+			})
+		})
+
+		g.It("does not report changes to denied files", func() {
+			fs.denylist = ignore.CompileIgnoreLines("*.secret")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			ch, err := fs.Watch(ctx, "/", 1)
+			g.Assert(err).IsNil()
+
+			root := filepath.Join(rfs.root, "server")
+			g.Assert(os.WriteFile(filepath.Join(root, "token.secret"), []byte("a"), 0o644)).IsNil()
+			g.Assert(os.WriteFile(filepath.Join(root, "public.txt"), []byte("b"), 0o644)).IsNil()
+			g.Assert(collect(ch)).Equal([]FileChange{
+				{Action: FileChangeCreate, Path: "/public.txt"},
+			})
+		})
+
+		g.It("ends the watch when the directory is deleted", func() {
+			g.Assert(fs.CreateDirectory("config", "/")).IsNil()
+			ch, err := fs.Watch(context.Background(), "/config", 1)
+			g.Assert(err).IsNil()
+
+			g.Assert(os.Remove(filepath.Join(rfs.root, "server/config"))).IsNil()
+			g.Assert(collect(ch)).Equal([]FileChange{
+				{Action: FileChangeDelete, Path: "/config", Directory: true},
+			})
+			_, ok := <-ch
+			g.Assert(ok).IsFalse()
+		})
+
+		g.It("releases the watch before closing the channel", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			var watches []<-chan []FileChange
+			for i := 0; i < maxWatchesPerServer; i++ {
+				ch, err := fs.Watch(ctx, "/", 1)
+				g.Assert(err).IsNil()
+				watches = append(watches, ch)
+			}
+
+			cancel()
+			for range watches[0] {
+			}
+			g.Assert(fs.watches.Load() < maxWatchesPerServer).IsTrue()
+			for _, ch := range watches[1:] {
+				for range ch {
+				}
+			}
+			g.Assert(fs.watches.Load()).Equal(int32(0))
+		})
+
+		g.It("limits the number of watches for a server", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for i := 0; i < maxWatchesPerServer; i++ {
+				_, err := fs.Watch(ctx, "/", 1)
+				g.Assert(err).IsNil()
+			}
+			_, err := fs.Watch(ctx, "/", 1)
+			g.Assert(errors.Is(err, ErrTooManyWatches)).IsTrue()
+		})
+
+		g.It("returns an error for files", func() {
+			g.Assert(rfs.CreateServerFile("file.txt", []byte("a"))).IsNil()
+			watches := fs.watches.Load()
+			_, err := fs.Watch(context.Background(), "/file.txt", 1)
+			g.Assert(err).IsNotNil()
+			g.Assert(fs.watches.Load()).Equal(watches)
+		})
+	})
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"context"
+
+	"emperror.dev/errors"
+)
+
+// Watch is only supported on Linux.
+func (fs *Filesystem) Watch(_ context.Context, _ string, _ int) (<-chan []FileChange, error) {
+	return nil, errors.New("server/filesystem: watching for changes is only supported on Linux")
+}
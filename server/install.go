@@ -89,6 +89,12 @@ func (s *Server) Reinstall() error {
 		}
 	}
 
+	// The installation script is free to delete or overwrite any file of the
+	// server, so keep a copy of them around in case it was not meant to.
+	if err := s.TakeSafetySnapshot(s.Context(), SnapshotReinstall); err != nil {
+		return errors.WrapIf(err, "install")
+	}
+
 	s.Log().Info("syncing server state with remote source before executing re-installation process")
 	if err := s.Sync(); err != nil {
 		return errors.WrapIf(err, "install: failed to sync server state with Panel")
@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"emperror.dev/errors"
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+
+	"github.com/pelican/wings/internal/progress"
+)
+
+// JobType is the kind of operation being performed by a background job.
+type JobType string
+
+const (
+	JobCopy       = JobType("copy")
+	JobRename     = JobType("rename")
+	JobCompress   = JobType("compress")
+	JobDecompress = JobType("decompress")
+	JobChmod      = JobType("chmod")
+	JobPull       = JobType("pull")
+	JobRestore    = JobType("restore")
+	JobVerify     = JobType("verify")
+)
+
+// JobStatus is the current state of a background job.
+type JobStatus string
+
+const (
+	JobRunning   = JobStatus("running")
+	JobCompleted = JobStatus("completed")
+	JobFailed    = JobStatus("failed")
+	JobCanceled  = JobStatus("canceled")
+)
+
+const (
+	// The maximum number of jobs that may be running for a single server at once.
+	maxRunningJobs = 5
+	// How often progress events are published for a running job.
+	jobProgressInterval = time.Second
+	// How long a finished job is kept around so that its final state can be
+	// fetched by anyone who missed the websocket event.
+	jobRetention = time.Minute * 10
+)
+
+// ErrTooManyJobs is returned when a server already has the maximum number of
+// background jobs running.
+var ErrTooManyJobs = errors.Sentinel("server: too many running jobs")
+
+// JobFunc performs the work of a job. Progress should be reported through the
+// given progress tracker, and the function should return as soon as possible
+// once the context is canceled. The returned value describes the outcome of
+// the job, such as the file created by it, and is returned along with the job
+// once it completes.
+type JobFunc func(ctx context.Context, p *progress.Progress) (interface{}, error)
+
+// Job is a long-running file operation being performed in the background for
+// a server.
+type Job struct {
+	Identifier string
+	Type       JobType
+
+	progress *progress.Progress
+	cancel   context.CancelFunc
+
+	mu       sync.RWMutex
+	status   JobStatus
+	err      error
+	started  time.Time
+	finished time.Time
+	result   interface{}
+}
+
+// Progress returns the progress tracker for the job.
+func (j *Job) Progress() *progress.Progress {
+	return j.progress
+}
+
+// Status returns the current status of the job.
+func (j *Job) Status() JobStatus {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.status
+}
+
+// Cancel cancels the job if it is still running.
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) MarshalJSON() ([]byte, error) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	var e string
+	if j.err != nil {
+		e = j.err.Error()
+	}
+	var finished *time.Time
+	if !j.finished.IsZero() {
+		finished = &j.finished
+	}
+	return json.Marshal(struct {
+		Identifier string      `json:"identifier"`
+		Type       JobType     `json:"type"`
+		Status     JobStatus   `json:"status"`
+		Error      string      `json:"error,omitempty"`
+		Processed  uint64      `json:"processed"`
+		Total      uint64      `json:"total"`
+		Result     interface{} `json:"result,omitempty"`
+		StartedAt  time.Time   `json:"started_at"`
+		FinishedAt *time.Time  `json:"finished_at"`
+	}{
+		Identifier: j.Identifier,
+		Type:       j.Type,
+		Status:     j.status,
+		Error:      e,
+		Processed:  j.progress.Written(),
+		Total:      j.progress.Total(),
+		Result:     j.result,
+		StartedAt:  j.started,
+		FinishedAt: finished,
+	})
+}
+
+// JobManager tracks the background jobs for a single server.
+type JobManager struct {
+	mu     sync.Mutex
+	server *Server
+	jobs   map[string]*Job
+}
+
+// Jobs returns the background job manager for the server.
+func (s *Server) Jobs() *JobManager {
+	s.jobsLocker.Lock()
+	defer s.jobsLocker.Unlock()
+
+	if s.jobs == nil {
+		s.jobs = &JobManager{server: s, jobs: make(map[string]*Job)}
+	}
+
+	return s.jobs
+}
+
+// Start starts running the given function as a background job, returning the
+// job immediately. Progress events are published over the server event bus
+// while it runs, followed by a completed event once it has finished.
+func (m *JobManager) Start(t JobType, fn JobFunc) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var running int
+	for _, j := range m.jobs {
+		if j.Status() == JobRunning {
+			running++
+		}
+	}
+	if running >= maxRunningJobs {
+		return nil, ErrTooManyJobs
+	}
+
+	ctx, cancel := context.WithCancel(m.server.Context())
+	j := &Job{
+		Identifier: uuid.Must(uuid.NewRandom()).String(),
+		Type:       t,
+		progress:   progress.NewProgress(0),
+		cancel:     cancel,
+		status:     JobRunning,
+		started:    time.Now(),
+	}
+	m.jobs[j.Identifier] = j
+
+	go m.run(ctx, j, fn)
+
+	return j, nil
+}
+
+// Get returns the job with the given identifier.
+func (m *JobManager) Get(id string) (*Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	return j, ok
+}
+
+// List returns all the jobs that are running or recently finished for the
+// server, oldest first.
+func (m *JobManager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		out = append(out, j)
+	}
+	sort.Slice(out, func(i, k int) bool {
+		return out[i].started.Before(out[k].started)
+	})
+	return out
+}
+
+func (m *JobManager) run(ctx context.Context, j *Job, fn JobFunc) {
+	defer j.cancel()
+
+	var result interface{}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		result, err = fn(ctx, j.progress)
+		done <- err
+	}()
+
+	ticker := time.NewTicker(jobProgressInterval)
+	defer ticker.Stop()
+
+	var err error
+out:
+	for {
+		select {
+		case <-ticker.C:
+			m.server.Events().Publish(JobProgressEvent, j)
+		case err = <-done:
+			break out
+		}
+	}
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	j.result = result
+	switch {
+	case err == nil:
+		j.status = JobCompleted
+	case errors.Is(err, context.Canceled) || ctx.Err() != nil:
+		j.status = JobCanceled
+	default:
+		j.status = JobFailed
+		j.err = err
+	}
+	j.mu.Unlock()
+
+	if err != nil && j.Status() == JobFailed {
+		m.server.Log().WithField("job", j.Identifier).WithField("type", j.Type).WithField("error", err).Warn("background job failed")
+	}
+	m.server.Events().Publish(JobCompletedEvent, j)
+
+	time.AfterFunc(jobRetention, func() {
+		m.mu.Lock()
+		delete(m.jobs, j.Identifier)
+		m.mu.Unlock()
+	})
+}
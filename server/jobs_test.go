@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"emperror.dev/errors"
+	. "github.com/franela/goblin"
+
+	"github.com/pelican/wings/internal/progress"
+)
+
+func waitForJob(j *Job) JobStatus {
+	for i := 0; i < 200; i++ {
+		if st := j.Status(); st != JobRunning {
+			return st
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return j.Status()
+}
+
+func TestJobs(t *testing.T) {
+	g := Goblin(t)
+
+	g.Describe("JobManager", func() {
+		var s *Server
+
+		g.BeforeEach(func() {
+			var err error
+			s, err = New(nil)
+			if err != nil {
+				panic(err)
+			}
+		})
+
+		g.AfterEach(func() {
+			s.CtxCancel()
+		})
+
+		g.It("runs a job to completion and stores the result", func() {
+			j, err := s.Jobs().Start(JobCopy, func(_ context.Context, p *progress.Progress) (interface{}, error) {
+				p.SetTotal(2)
+				p.Add(2)
+				return "done", nil
+			})
+			g.Assert(err).IsNil()
+			g.Assert(waitForJob(j)).Equal(JobCompleted)
+			g.Assert(j.Progress().Written()).Equal(uint64(2))
+
+			found, ok := s.Jobs().Get(j.Identifier)
+			g.Assert(ok).IsTrue()
+			g.Assert(found.result).Equal("done")
+			g.Assert(s.Jobs().List()).Equal([]*Job{j})
+		})
+
+		g.It("marks a job as failed when it returns an error", func() {
+			j, err := s.Jobs().Start(JobChmod, func(context.Context, *progress.Progress) (interface{}, error) {
+				return nil, errors.New("test error")
+			})
+			g.Assert(err).IsNil()
+			g.Assert(waitForJob(j)).Equal(JobFailed)
+			g.Assert(j.err.Error()).Equal("test error")
+		})
+
+		g.It("cancels a running job", func() {
+			j, err := s.Jobs().Start(JobCompress, func(ctx context.Context, _ *progress.Progress) (interface{}, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+			g.Assert(err).IsNil()
+			j.Cancel()
+			g.Assert(waitForJob(j)).Equal(JobCanceled)
+		})
+
+		g.It("limits the number of running jobs", func() {
+			block := func(ctx context.Context, _ *progress.Progress) (interface{}, error) {
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			for i := 0; i < maxRunningJobs; i++ {
+				_, err := s.Jobs().Start(JobRename, block)
+				g.Assert(err).IsNil()
+			}
+			_, err := s.Jobs().Start(JobRename, block)
+			g.Assert(errors.Is(err, ErrTooManyJobs)).IsTrue()
+		})
+	})
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
@@ -10,7 +11,9 @@ import (
 
 	"github.com/apex/log"
 
+	"github.com/pelican/wings/config"
 	"github.com/pelican/wings/events"
+	"github.com/pelican/wings/internal/models"
 	"github.com/pelican/wings/system"
 
 	"github.com/pelican/wings/environment"
@@ -27,6 +30,19 @@ type diskSpaceLimiter struct {
 	o      sync.Once
 	mu     sync.Mutex
 	server *Server
+	// grace is the timer that stops the server once its grace period for being
+	// over the disk space limit has expired.
+	grace *time.Timer
+	// warned holds the warning thresholds that the server has already crossed.
+	warned map[int]bool
+}
+
+// DiskThresholdPayload is sent over the websocket when a server crosses one of
+// the configured disk usage warning thresholds.
+type DiskThresholdPayload struct {
+	Threshold int   `json:"threshold"`
+	Usage     int64 `json:"usage"`
+	Limit     int64 `json:"limit"`
 }
 
 type FeatureMatchPayload struct {
@@ -36,30 +52,124 @@ type FeatureMatchPayload struct {
 }
 
 func newDiskLimiter(s *Server) *diskSpaceLimiter {
-	return &diskSpaceLimiter{server: s}
+	return &diskSpaceLimiter{server: s, warned: make(map[int]bool)}
 }
 
 // Reset the disk space limiter status.
 func (dsl *diskSpaceLimiter) Reset() {
 	dsl.mu.Lock()
 	dsl.o = sync.Once{}
+	dsl.stopGraceLocked()
 	dsl.mu.Unlock()
 }
 
+// Check compares the disk usage of the server against its limit, sending any
+// threshold warnings that have been crossed and triggering the limiter if the
+// server has exceeded the limit.
+func (dsl *diskSpaceLimiter) Check() {
+	fs := dsl.server.Filesystem()
+	if !fs.HasSpaceAvailable(true) {
+		dsl.Trigger()
+	} else {
+		dsl.mu.Lock()
+		if dsl.grace != nil {
+			dsl.o = sync.Once{}
+			dsl.stopGraceLocked()
+			dsl.server.PublishConsoleOutputFromDaemon("Server is back within the assigned disk space limit.")
+		}
+		dsl.mu.Unlock()
+	}
+	dsl.checkThresholds(fs.CachedUsage(), fs.MaxDisk())
+}
+
 // Trigger the disk space limiter which will attempt to stop a running server instance within
-// 15 seconds, and terminate it forcefully if it does not stop.
+// 15 seconds, and terminate it forcefully if it does not stop. If a grace period is configured
+// the server is instead allowed to keep running, and writing files, until the grace period
+// expires, and is only stopped if it is still over the limit at that point.
 //
 // This function is only executed one time, so whenever a server is marked as booting the limiter
 // should be reset, so it can properly be triggered as needed.
 func (dsl *diskSpaceLimiter) Trigger() {
 	dsl.o.Do(func() {
-		dsl.server.PublishConsoleOutputFromDaemon("Server is exceeding the assigned disk space limit, stopping process now.")
-		if err := dsl.server.Environment.WaitForStop(dsl.server.Context(), time.Minute, true); err != nil {
-			dsl.server.Log().WithField("error", err).Error("failed to stop server after exceeding space limit!")
+		grace := time.Duration(config.Get().System.DiskThresholds.GracePeriod) * time.Second
+		if grace <= 0 {
+			dsl.stop()
+			return
 		}
+
+		dsl.mu.Lock()
+		defer dsl.mu.Unlock()
+		limit := config.Get().System.DiskThresholds.GraceLimit * 1024 * 1024
+		dsl.server.Filesystem().SetDiskGrace(time.Now().Add(grace), limit)
+		dsl.grace = time.AfterFunc(grace, dsl.expire)
+		dsl.server.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server is exceeding the assigned disk space limit, it will be stopped in %s unless disk space is freed.", grace))
 	})
 }
 
+// expire is called once the grace period has passed, stopping the server if it
+// is still over the disk space limit.
+func (dsl *diskSpaceLimiter) expire() {
+	dsl.mu.Lock()
+	dsl.stopGraceLocked()
+	dsl.mu.Unlock()
+
+	if dsl.server.Filesystem().HasSpaceAvailable(false) {
+		dsl.mu.Lock()
+		dsl.o = sync.Once{}
+		dsl.mu.Unlock()
+		return
+	}
+	dsl.stop()
+}
+
+func (dsl *diskSpaceLimiter) stop() {
+	dsl.server.PublishConsoleOutputFromDaemon("Server is exceeding the assigned disk space limit, stopping process now.")
+	if err := dsl.server.Environment.WaitForStop(dsl.server.Context(), time.Minute, true); err != nil {
+		dsl.server.Log().WithField("error", err).Error("failed to stop server after exceeding space limit!")
+	}
+}
+
+func (dsl *diskSpaceLimiter) stopGraceLocked() {
+	if dsl.grace != nil {
+		dsl.grace.Stop()
+		dsl.grace = nil
+	}
+	dsl.server.Filesystem().SetDiskGrace(time.Time{}, 0)
+}
+
+// checkThresholds sends a warning for every configured threshold the usage has
+// crossed since the last check. Thresholds the usage has fallen back below are
+// cleared so that they are warned about again if they are crossed once more.
+func (dsl *diskSpaceLimiter) checkThresholds(usage int64, limit int64) {
+	if limit <= 0 {
+		return
+	}
+
+	dsl.mu.Lock()
+	defer dsl.mu.Unlock()
+	for _, t := range config.Get().System.DiskThresholds.Warnings {
+		if t <= 0 || t > 100 {
+			continue
+		}
+		if usage*100 < int64(t)*limit {
+			delete(dsl.warned, t)
+			continue
+		}
+		if dsl.warned[t] {
+			continue
+		}
+		dsl.warned[t] = true
+
+		dsl.server.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server has used %d%% of the assigned disk space limit (%s of %s).", t, system.FormatBytes(usage), system.FormatBytes(limit)))
+		dsl.server.Events().Publish(DiskThresholdEvent, DiskThresholdPayload{Threshold: t, Usage: usage, Limit: limit})
+		dsl.server.SaveActivity(dsl.server.NewRequestActivity("", "127.0.0.1"), ActivityDiskThreshold, models.ActivityMeta{
+			"threshold": t,
+			"usage":     usage,
+			"limit":     limit,
+		})
+	}
+}
+
 // processConsoleOutputEvent handles output from a server's Docker container
 // and runs through different limiting logic to ensure that spam console output
 // does not cause negative effects to the system. This will also monitor the
@@ -117,11 +227,11 @@ func (s *Server) StartEventListeners() {
 								return
 							}
 							s.resources.UpdateStats(stats.Data)
-							// If there is no disk space available at this point, trigger the server
-							// disk limiter logic which will start to stop the running instance.
-							if !s.Filesystem().HasSpaceAvailable(true) {
-								limit.Trigger()
-							}
+							// Check the disk usage against the limit, warning about any thresholds
+							// that have been crossed, and triggering the server disk limiter logic
+							// which will start to stop the running instance if there is no disk
+							// space available at this point.
+							limit.Check()
 							s.Events().Publish(StatsEvent, s.Proc())
 						}
 					case environment.StateChangeEvent:
@@ -26,6 +26,12 @@ type Manager struct {
 	mu      sync.RWMutex
 	client  remote.Client
 	servers []*Server
+
+	// pruneMu prevents backups from being pruned more than once at a time.
+	pruneMu sync.Mutex
+	// orphans are the servers which backups were found for when backups were
+	// last pruned, but which do not exist on this node.
+	orphans map[string]orphan
 }
 
 // NewManager returns a new server manager instance. This will boot up all the
@@ -200,6 +206,7 @@ func (m *Manager) InitServer(data remote.ServerConfigurationResponse) (*Server,
 	if err != nil {
 		return nil, errors.WithStackIf(err)
 	}
+	s.fs.SetFileLimit(s.FileLimit())
 
 	// if quotas are enabled ensure quotas are configured
 	if config.Get().System.Quotas.Enabled {
@@ -207,6 +214,7 @@ func (m *Manager) InitServer(data remote.ServerConfigurationResponse) (*Server,
 			return nil, errors.WithStackIf(err)
 		}
 	}
+	s.configureUsageTracker()
 
 	// Right now we only support a Docker based environment, so I'm going to hard code
 	// this logic in. When we're ready to support other environment we'll need to make
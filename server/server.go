@@ -23,6 +23,7 @@ import (
 	"github.com/pelican/wings/environment"
 	"github.com/pelican/wings/events"
 	"github.com/pelican/wings/remote"
+	"github.com/pelican/wings/server/backup"
 	"github.com/pelican/wings/server/filesystem"
 	"github.com/pelican/wings/system"
 )
@@ -77,6 +78,10 @@ type Server struct {
 	wsBag       *WebsocketBag
 	wsBagLocker sync.Mutex
 
+	// Tracks long-running file operations being performed in the background.
+	jobs       *JobManager
+	jobsLocker sync.Mutex
+
 	sinks map[system.SinkName]*system.SinkPool
 
 	logSink     *system.SinkPool
@@ -271,11 +276,12 @@ func (s *Server) Sync() error {
 	// Update the disk space limits for the server whenever the configuration for
 	// it changes.
 	if config.Get().System.Quotas.Enabled {
-		if err = quotas.SetQuota(s.DiskSpace(), s.ID()); err != nil {
+		if err = quotas.SetQuota(s.DiskSpace(), s.FileLimit(), s.ID()); err != nil {
 			return err
 		}
 	} else {
 		s.fs.SetDiskLimit(s.DiskSpace())
+		s.fs.SetFileLimit(s.FileLimit())
 	}
 
 	s.SyncWithEnvironment()
@@ -487,5 +493,14 @@ func (s *Server) RemoveAllServerBackups() error {
 	if sp == config.Get().System.BackupDirectory {
 		return errors.New("invalid server, cannot delete backup dir")
 	}
-	return os.RemoveAll(sp)
+	if err := os.RemoveAll(sp); err != nil {
+		return err
+	}
+	if err := backup.ForgetServerBackups(s.ID()); err != nil {
+		return err
+	}
+	// Remove any chunks that were only referenced by deduplicated backups of
+	// this server.
+	backup.SchedulePrune()
+	return nil
 }
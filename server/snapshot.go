@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+
+	"emperror.dev/errors"
+
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/server/backup"
+)
+
+// The reasons a safety snapshot of a server's files is taken.
+const (
+	SnapshotReinstall = "reinstall"
+	SnapshotRestore   = "restore"
+)
+
+// TakeSafetySnapshot backs up every file of the server before they are deleted
+// or overwritten, if safety snapshots are enabled on this node. An error is
+// returned if the snapshot could not be taken, in which case the operation
+// that would have replaced the files must not continue.
+func (s *Server) TakeSafetySnapshot(ctx context.Context, reason string) error {
+	if !config.Get().System.Backups.SafetySnapshots.Enabled {
+		return nil
+	}
+	s.Log().WithField("reason", reason).Info("taking safety snapshot of server files")
+	s.Events().Publish(DaemonMessageEvent, "Taking a safety snapshot of the server files...")
+	snapshot, err := backup.CreateSnapshot(ctx, s.Filesystem(), s.ID(), reason)
+	if err != nil {
+		s.Events().Publish(DaemonMessageEvent, "Failed to take a safety snapshot of the server files.")
+		return errors.WrapIf(err, "server: failed to take safety snapshot")
+	}
+	s.Log().WithField("snapshot", snapshot.Uuid).Info("took safety snapshot of server files")
+	return nil
+}
+
+// RestoreSnapshot replaces every file of the server with the files of one of
+// its snapshots. The server is stopped before it is restored, and a snapshot
+// of the files being replaced is taken first if safety snapshots are enabled.
+func (s *Server) RestoreSnapshot(ctx context.Context, id string) error {
+	_, b, err := backup.LocateSnapshot(s.ID(), id)
+	if err != nil {
+		return err
+	}
+
+	s.Config().SetSuspended(true)
+	defer s.Config().SetSuspended(false)
+	if err := s.waitForRestoreStop(); err != nil {
+		return err
+	}
+	if err := s.TakeSafetySnapshot(ctx, SnapshotRestore); err != nil {
+		return errors.WrapIf(err, "server/snapshot: restore")
+	}
+	if err := s.Filesystem().TruncateRootDirectory(); err != nil {
+		return errors.WrapIf(err, "server/snapshot: restore: failed to delete server files")
+	}
+
+	s.Log().WithField("snapshot", id).Info("restoring server files from snapshot")
+	return errors.WithStackIf(b.Restore(ctx, nil, s.restoreFile))
+}
@@ -9,11 +9,11 @@ import (
 	"mime/multipart"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/apex/log"
 	"github.com/pelican/wings/config"
 	"github.com/pelican/wings/internal/progress"
+	"github.com/pelican/wings/server/backup"
 	"github.com/pelican/wings/server/filesystem"
 )
 
@@ -58,15 +58,18 @@ func (a *Archive) StreamBackups(ctx context.Context, mp *multipart.Writer) error
 	// Create a set of backup UUIDs for quick lookup
 	backupSet := make(map[string]bool)
 	for _, uuid := range a.transfer.BackupUUIDs {
-		backupSet[uuid+".tar.gz"] = true // Backup files are stored as UUID.tar.gz
+		backupSet[uuid] = true
 	}
 
+	// Backups are stored as an archive in any of the backup formats, or as the
+	// manifest of a deduplicated backup.
 	var backupsToTransfer []os.DirEntry
 	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".tar.gz") {
-			if backupSet[entry.Name()] {
-				backupsToTransfer = append(backupsToTransfer, entry)
-			}
+		if entry.IsDir() {
+			continue
+		}
+		if id, ok := backup.StoredBackupID(entry.Name()); ok && backupSet[id] {
+			backupsToTransfer = append(backupsToTransfer, entry)
 		}
 	}
 
@@ -129,6 +132,66 @@ func (a *Archive) StreamBackups(ctx context.Context, mp *multipart.Writer) error
 	}
 
 	a.transfer.Log().WithField("count", totalBackups).Debug("finished streaming backups")
+
+	// The chunks of deduplicated backups are sent after their manifests, so
+	// that they are never pruned on the target as being unreferenced.
+	var manifests []string
+	for _, entry := range backupsToTransfer {
+		if backup.IsManifest(entry.Name()) {
+			manifests = append(manifests, filepath.Join(backupPath, entry.Name()))
+		}
+	}
+	return a.streamChunks(ctx, mp, manifests)
+}
+
+// streamChunks sends every chunk referenced by the manifests of deduplicated
+// backups. Chunks are identified by the checksum of their contents, so no
+// separate checksum is sent for them.
+func (a *Archive) streamChunks(ctx context.Context, mp *multipart.Writer, manifests []string) error {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, p := range manifests {
+		chunks, err := backup.ManifestChunks(p)
+		if err != nil {
+			return fmt.Errorf("failed to read backup manifest %s: %w", p, err)
+		}
+		for _, id := range chunks {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	a.transfer.SendMessage(fmt.Sprintf("Starting transfer of %d deduplicated backup chunks", len(ids)))
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := streamChunk(mp, id); err != nil {
+			return err
+		}
+	}
+	a.transfer.Log().WithField("count", len(ids)).Debug("finished streaming backup chunks")
+	return nil
+}
+
+func streamChunk(mp *multipart.Writer, id string) error {
+	f, err := backup.OpenChunk(id)
+	if err != nil {
+		return fmt.Errorf("failed to open backup chunk %s: %w", id, err)
+	}
+	defer f.Close()
+	part, err := mp.CreateFormFile("chunk_"+id, id)
+	if err != nil {
+		return fmt.Errorf("failed to create form file for backup chunk %s: %w", id, err)
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		return fmt.Errorf("failed to stream backup chunk %s: %w", id, err)
+	}
 	return nil
 }
 
@@ -186,12 +249,27 @@ func NewArchive(t *Transfer, size uint64) *Archive {
 	return &Archive{
 		archive: &filesystem.Archive{
 			Filesystem: t.Server.Filesystem(),
+			Format:     transferFormat(),
 			Progress:   progress.NewProgress(size),
 		},
 		transfer: t,
 	}
 }
 
+// transferFormat returns the format archives are sent to the target node in.
+// The target node detects the format from the contents of the archive.
+func transferFormat() filesystem.ArchiveFormat {
+	if config.Get().System.Transfers.Compression == "zstd" {
+		return filesystem.ArchiveFormatTarZstd
+	}
+	return filesystem.ArchiveFormatTarGz
+}
+
+// Format returns the format of the archive.
+func (a *Archive) Format() filesystem.ArchiveFormat {
+	return a.archive.Format
+}
+
 // Stream returns a reader that can be used to stream the contents of the archive.
 func (a *Archive) Stream(ctx context.Context, w io.Writer) error {
 	return a.archive.Stream(ctx, w)
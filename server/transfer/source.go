@@ -92,7 +92,7 @@ func (t *Transfer) PushArchiveToTarget(url, token string, backups []string) ([]b
 		mainHasher := sha256.New()
 		mainTee := io.TeeReader(src, mainHasher)
 
-		dest, err := mp.CreateFormFile("archive", "archive.tar.gz")
+		dest, err := mp.CreateFormFile("archive", "archive"+a.Format().Extension())
 		if err != nil {
 			errChan <- errors.New("failed to create form file")
 			return
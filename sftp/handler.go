@@ -1,8 +1,10 @@
 package sftp
 
 import (
+	"context"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -13,26 +15,27 @@ import (
 	"golang.org/x/crypto/ssh"
 
 	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/server"
 	"github.com/pelican/wings/server/filesystem"
 )
 
 const (
-	PermissionFileRead        = "file.read"
-	PermissionFileReadContent = "file.read-content"
-	PermissionFileCreate      = "file.create"
-	PermissionFileUpdate      = "file.update"
-	PermissionFileDelete      = "file.delete"
+	PermissionFileRead        = acl.FileRead
+	PermissionFileReadContent = acl.FileReadContent
+	PermissionFileCreate      = acl.FileCreate
+	PermissionFileUpdate      = acl.FileUpdate
+	PermissionFileDelete      = acl.FileDelete
 )
 
 type Handler struct {
-	mu          sync.Mutex
-	server      *server.Server
-	fs          *filesystem.Filesystem
-	events      *eventHandler
-	permissions []string
-	logger      *log.Entry
-	ro          bool
+	mu     sync.Mutex
+	server *server.Server
+	fs     *filesystem.Filesystem
+	events *eventHandler
+	policy *acl.Policy
+	logger *log.Entry
+	ro     bool
 }
 
 type quotaWriterAt struct {
@@ -46,7 +49,7 @@ func (w quotaWriterAt) WriteAt(p []byte, off int64) (int, error) {
 	}
 
 	n, err := w.WriterAt.WriteAt(p, off)
-	if filesystem.IsErrorCode(err, filesystem.ErrCodeDiskSpace) {
+	if filesystem.IsErrorCode(err, filesystem.ErrCodeDiskSpace) || filesystem.IsErrorCode(err, filesystem.ErrCodeFileLimit) {
 		return n, ErrSSHQuotaExceeded
 	}
 	return n, err
@@ -67,6 +70,14 @@ func NewHandler(sc *ssh.ServerConn, srv *server.Server) (*Handler, error) {
 		return nil, errors.New("sftp: mismatched Wings and Panel versions — Panel 1.10 is required for this version of Wings.")
 	}
 
+	var grants []acl.Grant
+	if v := sc.Permissions.Extensions["grants"]; v != "" {
+		var err error
+		if grants, err = acl.ParseGrants(v); err != nil {
+			return nil, err
+		}
+	}
+
 	events := eventHandler{
 		ip:     sc.RemoteAddr().String(),
 		user:   uuid,
@@ -74,12 +85,12 @@ func NewHandler(sc *ssh.ServerConn, srv *server.Server) (*Handler, error) {
 	}
 
 	return &Handler{
-		permissions: strings.Split(sc.Permissions.Extensions["permissions"], ","),
-		server:      srv,
-		fs:          srv.Filesystem(),
-		events:      &events,
-		ro:          config.Get().System.Sftp.ReadOnly,
-		logger:      log.WithFields(log.Fields{"subsystem": "sftp", "user": uuid, "ip": sc.RemoteAddr()}),
+		policy: acl.New(strings.Split(sc.Permissions.Extensions["permissions"], ","), grants),
+		server: srv,
+		fs:     srv.Filesystem(),
+		events: &events,
+		ro:     config.Get().System.Sftp.ReadOnly,
+		logger: log.WithFields(log.Fields{"subsystem": "sftp", "user": uuid, "ip": sc.RemoteAddr()}),
 	}, nil
 }
 
@@ -98,7 +109,7 @@ func (h *Handler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 	// Check first if the user can actually open and view a file. This permission is named
 	// really poorly, but it is checking if they can read. There is an addition permission,
 	// "save-files" which determines if they can write that file.
-	if !h.can(PermissionFileReadContent) {
+	if !h.can(PermissionFileReadContent, request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 	h.mu.Lock()
@@ -125,7 +136,7 @@ func (h *Handler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	l := h.logger.WithField("source", request.Filepath)
 	// If the user doesn't have enough space left on the server it should respond with an
 	// error since we won't be letting them write this file to the disk.
-	if !h.fs.HasSpaceAvailable(false) {
+	if !h.fs.HasSpaceAvailable(false) && !h.fs.CanFitInGrace(0) {
 		return nil, ErrSSHQuotaExceeded
 	}
 
@@ -149,11 +160,14 @@ func (h *Handler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	// Confirm the user has permission to perform this action BEFORE calling Touch, otherwise
 	// you'll potentially create a file on the system and then fail out because of user
 	// permission checking after the fact.
-	if !h.can(permission) {
+	if !h.can(permission, request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 	f, err := h.fs.Touch(request.Filepath, os.O_RDWR|os.O_TRUNC)
 	if err != nil {
+		if filesystem.IsErrorCode(err, filesystem.ErrCodeFileLimit) {
+			return nil, ErrSSHQuotaExceeded
+		}
 		l.WithField("flags", request.Flags).WithField("error", err).Error("failed to open existing file on system")
 		return nil, sftp.ErrSSHFxFailure
 	}
@@ -187,7 +201,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	// Allows a user to make changes to the permissions of a given file or directory
 	// on their server using their SFTP client.
 	case "Setstat":
-		if !h.can(PermissionFileUpdate) {
+		if !h.can(PermissionFileUpdate, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		mode := request.Attributes().FileMode().Perm()
@@ -209,7 +223,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		break
 	// Support renaming a file (aka Move).
 	case "Rename":
-		if !h.can(PermissionFileUpdate) {
+		if !h.can(PermissionFileUpdate, request.Filepath, request.Target) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		if err := h.fs.Rename(request.Filepath, request.Target); err != nil {
@@ -221,11 +235,35 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		}
 		h.events.MustLog(server.ActivitySftpRename, FileAction{Entity: request.Filepath, Target: request.Target})
 		break
+	// Hard link requests are handled as a copy of the file or directory. Hard links
+	// would share a single inode between both paths which breaks the disk usage
+	// accounting, and this is the only request SFTP clients can make that is able
+	// to duplicate anything on the server without downloading and uploading it.
+	case "Link":
+		if !h.can(PermissionFileReadContent, request.Filepath) || !h.can(PermissionFileCreate, request.Target) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		if err := h.fs.IsIgnored(request.Target); err != nil {
+			return err
+		}
+		if err := h.fs.CopyTo(context.Background(), request.Filepath, request.Target, nil); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return sftp.ErrSSHFxNoSuchFile
+			}
+			if filesystem.IsErrorCode(err, filesystem.ErrCodeDiskSpace) || filesystem.IsErrorCode(err, filesystem.ErrCodeFileLimit) {
+				return ErrSSHQuotaExceeded
+			}
+			l.WithField("error", err).Error("failed to copy file")
+			return sftp.ErrSSHFxFailure
+		}
+		h.events.MustLog(server.ActivitySftpCopy, FileAction{Entity: request.Filepath, Target: request.Target})
+		// CopyTo has already set the owner of everything it created.
+		return sftp.ErrSSHFxOk
 	// Handle deletion of a directory. This will properly delete all of the files and
 	// folders within that directory if it is not already empty (unlike a lot of SFTP
 	// clients that must delete each file individually).
 	case "Rmdir":
-		if !h.can(PermissionFileDelete) {
+		if !h.can(PermissionFileDelete, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		p := filepath.Clean(request.Filepath)
@@ -237,12 +275,15 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		return sftp.ErrSSHFxOk
 	// Handle requests to create a new Directory.
 	case "Mkdir":
-		if !h.can(PermissionFileCreate) {
+		if !h.can(PermissionFileCreate, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		name := strings.Split(filepath.Clean(request.Filepath), "/")
 		p := strings.Join(name[0:len(name)-1], "/")
 		if err := h.fs.CreateDirectory(name[len(name)-1], p); err != nil {
+			if filesystem.IsErrorCode(err, filesystem.ErrCodeFileLimit) {
+				return ErrSSHQuotaExceeded
+			}
 			l.WithField("error", err).Error("failed to create directory")
 			return sftp.ErrSSHFxFailure
 		}
@@ -251,9 +292,39 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 	// Support creating symlinks between files. The source and target must resolve within
 	// the server home directory.
 	case "Symlink":
-		if !h.can(PermissionFileCreate) {
+		if !h.can(PermissionFileCreate, request.Target) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
+		// Don't allow a link to be used to reach files the user has not been granted
+		// access to from the location of the link. The target of the link is passed
+		// through as-is by the client, so relative targets are resolved from the
+		// directory containing the link and absolute ones from the server root.
+		target := request.Filepath
+		if path.IsAbs(target) {
+			target = path.Clean(target)
+		} else {
+			target = path.Join(path.Dir(request.Target), target)
+		}
+		if !h.policy.CanLink(request.Target, target) {
+			return sftp.ErrSSHFxPermissionDenied
+		}
+		// Existing symlinks along either path could lead somewhere else entirely, so
+		// the grants must also cover where the link and its target really end up.
+		if h.policy.Scoped() {
+			link, err := h.fs.UnixFS().RealPath(request.Target)
+			if err != nil {
+				return sftp.ErrSSHFxPermissionDenied
+			}
+			if !path.IsAbs(request.Filepath) {
+				target = path.Join(path.Dir(link), request.Filepath)
+			}
+			if target, err = h.fs.UnixFS().RealPath(target); err != nil {
+				return sftp.ErrSSHFxPermissionDenied
+			}
+			if !h.can(PermissionFileCreate, link) || !h.policy.CanLink(link, target) {
+				return sftp.ErrSSHFxPermissionDenied
+			}
+		}
 		if err := h.fs.Symlink(request.Filepath, request.Target); err != nil {
 			l.WithField("target", request.Target).WithField("error", err).Error("failed to create symlink")
 			return sftp.ErrSSHFxFailure
@@ -261,7 +332,7 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 		break
 	// Called when deleting a file.
 	case "Remove":
-		if !h.can(PermissionFileDelete) {
+		if !h.can(PermissionFileDelete, request.Filepath) {
 			return sftp.ErrSSHFxPermissionDenied
 		}
 		if err := h.fs.Delete(request.Filepath); err != nil {
@@ -294,7 +365,9 @@ func (h *Handler) Filecmd(request *sftp.Request) error {
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
 func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
-	if !h.can(PermissionFileRead) {
+	// Directories leading to the paths a user has been granted access to can be
+	// listed and viewed, but only the entries that are visible will be returned.
+	if !h.canSee(request.Filepath) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
@@ -305,6 +378,15 @@ func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 			h.logger.WithField("source", request.Filepath).WithField("error", err).Error("error while listing directory")
 			return nil, sftp.ErrSSHFxFailure
 		}
+		if h.policy.Scoped() {
+			visible := entries[:0]
+			for _, e := range entries {
+				if h.policy.Visible(path.Join(request.Filepath, e.Name())) {
+					visible = append(visible, e)
+				}
+			}
+			entries = visible
+		}
 		return ListerAt(entries), nil
 	case "Stat":
 		st, err := h.fs.Stat(request.Filepath)
@@ -321,20 +403,23 @@ func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 	}
 }
 
-// Determines if a user has permission to perform a specific action on the SFTP server. These
-// permissions are defined and returned by the Panel API.
-func (h *Handler) can(permission string) bool {
+// Determines if a user has permission to perform a specific action on all of the given
+// paths on the SFTP server. These permissions are defined and returned by the Panel API,
+// and may be limited to specific paths.
+func (h *Handler) can(permission string, paths ...string) bool {
 	if h.server.IsSuspended() || h.server.IsInProtectedState() {
 		return false
 	}
-	for _, p := range h.permissions {
-		// If we match the permission specifically, or the user has been granted the "*"
-		// permission because they're an admin, let them through.
-		if p == permission || p == "*" {
-			return true
-		}
+	return h.policy.Check(permission, paths...) == nil
+}
+
+// Determines if a user can list or stat the given path, which is also allowed for the
+// directories leading to any paths they have been granted access to.
+func (h *Handler) canSee(p string) bool {
+	if h.server.IsSuspended() || h.server.IsInProtectedState() {
+		return false
 	}
-	return false
+	return h.policy.Visible(p)
 }
 
 func (h *Handler) User() string {
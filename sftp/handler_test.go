@@ -3,11 +3,17 @@ package sftp
 import (
 	"errors"
 	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/apex/log"
 	pkgsftp "github.com/pkg/sftp"
 
+	"github.com/pelican/wings/config"
+	"github.com/pelican/wings/internal/acl"
 	"github.com/pelican/wings/server"
+	"github.com/pelican/wings/server/filesystem"
 )
 
 type writeAtFunc func([]byte, int64) (int, error)
@@ -50,8 +56,8 @@ func TestHandlerDeniesAccessWhenServerIsInProtectedState(t *testing.T) {
 			tt.set(srv)
 
 			h := Handler{
-				server:      srv,
-				permissions: []string{"*"},
+				server: srv,
+				policy: acl.New([]string{"*"}, nil),
 			}
 
 			if h.can(PermissionFileCreate) {
@@ -137,4 +143,123 @@ func TestWriterForwardsWritesWhenServerIsAvailable(t *testing.T) {
 	if n != 4 {
 		t.Fatalf("expected forwarded byte count, got %d", n)
 	}
+}
+
+// newScopedHandler returns a handler for a user who may only access the plugins
+// directory of a server containing a plugin and a config file.
+func newScopedHandler(t *testing.T) (*Handler, string) {
+	t.Helper()
+	config.Set(&config.Configuration{AuthenticationToken: "test-token"})
+	root := t.TempDir()
+	for _, f := range []string{"plugins/plugin.jar", "config/secret.txt"} {
+		if err := os.MkdirAll(filepath.Join(root, filepath.Dir(f)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, f), []byte("content"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fs, err := filesystem.New(root, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := server.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		server: srv,
+		fs:     fs,
+		logger: log.WithField("test", t.Name()),
+		policy: acl.New(nil, []acl.Grant{{
+			Path:        "/plugins",
+			Permissions: []string{acl.FileRead, acl.FileReadContent, acl.FileCreate, acl.FileUpdate, acl.FileDelete},
+		}}),
+	}, root
+}
+
+// symlinkRequest returns a request to create a link at the given path, with
+// the target of the link left as it was sent by the client.
+func symlinkRequest(target string, link string) *pkgsftp.Request {
+	r := pkgsftp.NewRequest("Symlink", link)
+	r.Filepath = target
+	r.Target = link
+	return r
+}
+
+func TestScopedHandlerSymlink(t *testing.T) {
+	h, root := newScopedHandler(t)
+
+	for _, target := range []string{"/config", "/config/secret.txt", "../config", "/plugins/../config"} {
+		r := symlinkRequest(target, "/plugins/link")
+		if err := h.Filecmd(r); !errors.Is(err, pkgsftp.ErrSSHFxPermissionDenied) {
+			t.Fatalf("expected a link to %s to be denied, got %v", target, err)
+		}
+		if _, err := os.Lstat(filepath.Join(root, "plugins/link")); !os.IsNotExist(err) {
+			t.Fatalf("expected no link to be created for %s", target)
+		}
+	}
+
+	for _, target := range []string{"/plugins/plugin.jar", "plugin.jar"} {
+		r := symlinkRequest(target, "/plugins/link")
+		if err := h.Filecmd(r); err != nil && !errors.Is(err, pkgsftp.ErrSSHFxOk) {
+			t.Fatalf("expected a link to %s to be allowed, got %v", target, err)
+		}
+		if err := os.Remove(filepath.Join(root, "plugins/link")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := symlinkRequest("/plugins/plugin.jar", "/config/link")
+	if err := h.Filecmd(r); !errors.Is(err, pkgsftp.ErrSSHFxPermissionDenied) {
+		t.Fatalf("expected a link outside of the grant to be denied, got %v", err)
+	}
+}
+
+func TestScopedHandlerSymlinkThroughLink(t *testing.T) {
+	h, root := newScopedHandler(t)
+	// A link created by someone with full access to the server, leading out of
+	// the directory the scoped user has been granted.
+	if err := os.Symlink("../config", filepath.Join(root, "plugins/cfg")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, req := range []*pkgsftp.Request{
+		symlinkRequest("cfg/secret.txt", "/plugins/link"),
+		symlinkRequest("/plugins/cfg/secret.txt", "/plugins/link"),
+		symlinkRequest("/plugins/plugin.jar", "/plugins/cfg/link"),
+	} {
+		if err := h.Filecmd(req); !errors.Is(err, pkgsftp.ErrSSHFxPermissionDenied) {
+			t.Fatalf("expected a link at %s to %s to be denied, got %v", req.Target, req.Filepath, err)
+		}
+	}
+	for _, f := range []string{"plugins/link", "config/link"} {
+		if _, err := os.Lstat(filepath.Join(root, f)); !os.IsNotExist(err) {
+			t.Fatalf("expected no link to be created at %s", f)
+		}
+	}
+}
+
+func TestScopedHandlerFilelist(t *testing.T) {
+	h, _ := newScopedHandler(t)
+
+	lister, err := h.Filelist(pkgsftp.NewRequest("List", "/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make([]os.FileInfo, 10)
+	n, _ := lister.ListAt(entries, 0)
+	if n != 1 || entries[0].Name() != "plugins" {
+		t.Fatalf("expected only the plugins directory to be listed, got %d entries", n)
+	}
+
+	if _, err := h.Filelist(pkgsftp.NewRequest("List", "/config")); !errors.Is(err, pkgsftp.ErrSSHFxPermissionDenied) {
+		t.Fatalf("expected listing a directory outside of the grant to be denied, got %v", err)
+	}
+	if _, err := h.Filelist(pkgsftp.NewRequest("Stat", "/config/secret.txt")); !errors.Is(err, pkgsftp.ErrSSHFxPermissionDenied) {
+		t.Fatalf("expected a stat outside of the grant to be denied, got %v", err)
+	}
+	if _, err := h.Filelist(pkgsftp.NewRequest("Stat", "/plugins/plugin.jar")); err != nil {
+		t.Fatal(err)
+	}
 }
\ No newline at end of file
@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"io"
 	"net"
@@ -251,6 +252,13 @@ func (c *SFTPServer) makeCredentialsRequest(conn ssh.ConnMetadata, t remote.Sftp
 			"permissions": strings.Join(resp.Permissions, ","),
 		},
 	}
+	if len(resp.Grants) > 0 {
+		b, err := json.Marshal(resp.Grants)
+		if err != nil {
+			return nil, errors.Wrap(err, "sftp: failed to encode permission grants")
+		}
+		permissions.Extensions["grants"] = string(b)
+	}
 
 	return &permissions, nil
 }